@@ -0,0 +1,95 @@
+// export cached tiles of a provider to an MBTiles or PMTiles file
+// 将地图源的缓存瓦片导出为 MBTiles 或 PMTiles 文件
+//
+//	go run ./cmd/export -c config.yaml -map open_street_map_standard -minzoom 0 -maxzoom 14 -bbox 113,22.5,114,23.5 -o osm.mbtiles
+//	go run ./cmd/export -c config.yaml -map open_street_map_standard -o osm.pmtiles
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/export"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	var (
+		configPath string
+		mapID      string
+		output     string
+		format     string
+		bboxStr    string
+		minZoom    int
+		maxZoom    int
+	)
+	flag.StringVar(&configPath, "c", "config.yaml", "config file path")
+	flag.StringVar(&mapID, "map", "", "map id to export (required)")
+	flag.StringVar(&output, "o", "", "output file path, default is <map>.<format>")
+	flag.StringVar(&format, "format", "", "mbtiles or pmtiles, default is inferred from the output extension, or mbtiles")
+	flag.StringVar(&bboxStr, "bbox", "", "WGS84 bbox minLon,minLat,maxLon,maxLat, default is all cached tiles")
+	flag.IntVar(&minZoom, "minzoom", 0, "min zoom, default is the map min zoom")
+	flag.IntVar(&maxZoom, "maxzoom", 0, "max zoom, default is the map max zoom")
+	flag.Parse()
+
+	if mapID == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(output), ".")
+		if format != "pmtiles" {
+			format = "mbtiles"
+		}
+	}
+	exportFn := export.MBTiles
+	switch format {
+	case "mbtiles":
+	case "pmtiles":
+		exportFn = export.PMTiles
+	default:
+		logger.Fatalf("unknown format %q, expected mbtiles or pmtiles", format)
+	}
+	if output == "" {
+		output = mapID + "." + format
+	}
+
+	if err := config.InitConfig(configPath); err != nil {
+		logger.Fatalf("init config failed: %v", err)
+	}
+	cfg := config.GetConfig()
+
+	// custom providers declared in config
+	if err := mapprovider.RegisterProviderDefinitions(cfg.Providers, cfg.LayerOptions()); err != nil {
+		logger.Fatalf("register providers failed: %v", err)
+	}
+	provider, ok := mapprovider.GetRegistry().GetProvider(mapID)
+	if !ok {
+		logger.Fatalf("tile map source %s not found", mapID)
+	}
+
+	options := export.Options{
+		CachePath: cfg.Cache.Path,
+		Metadata:  provider.GetMapMetadata(),
+		CacheID:   mapprovider.CacheID(provider),
+		MinZoom:   minZoom,
+		MaxZoom:   maxZoom,
+	}
+	if bboxStr != "" {
+		bbox, err := mapprovider.ParseBBox(bboxStr)
+		if err != nil {
+			logger.Fatalf("invalid bbox: %v", err)
+		}
+		options.BBox = &bbox
+	}
+
+	result, err := exportFn(output, options)
+	if err != nil {
+		logger.Fatalf("export %s failed: %v", mapID, err)
+	}
+	fmt.Printf("exported %d tiles (z%d-%d) of %s to %s\n", result.Tiles, result.MinZoom, result.MaxZoom, mapID, output)
+}
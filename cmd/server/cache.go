@@ -0,0 +1,70 @@
+package main
+
+import (
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/utils"
+)
+
+const bytesPerMB = 1024 * 1024
+
+// newCache builds the tile cache described by the config
+// newCache 根据配置构建瓦片缓存
+func newCache(cacheConfig *config.CacheConfig) utils.Cacher {
+	return withMemoryTier(newDiskCache(cacheConfig), cacheConfig.MemoryMB)
+}
+
+// newDiskCache builds the disk tier, bounded if any size limit is configured
+func newDiskCache(cacheConfig *config.CacheConfig) utils.Cacher {
+	pathCache := &utils.PathMapCache{CachePath: cacheConfig.Path}
+	if !cacheConfig.IsBounded() {
+		return pathCache
+	}
+
+	limit, providerLimits := cacheLimits(cacheConfig)
+	return utils.NewEvictingCache(pathCache, cacheConfig.EvictionPolicy, limit, providerLimits)
+}
+
+// withMemoryTier layers an in-memory tier over the disk cache if memoryMB is positive
+// withMemoryTier 当 memoryMB 为正数时在磁盘缓存之上叠加内存层
+func withMemoryTier(disk utils.Cacher, memoryMB int64) utils.Cacher {
+	if memoryMB <= 0 {
+		// release the memory tier the disk tier was reporting evictions to
+		if notifier, ok := disk.(utils.EvictionNotifier); ok {
+			notifier.OnEvict(nil)
+		}
+		return disk
+	}
+	return utils.NewTieredCache(utils.NewMemoryCache(memoryMB*bytesPerMB), disk)
+}
+
+// diskTier returns the disk tier of the cache
+func diskTier(cacher utils.Cacher) utils.Cacher {
+	if tieredCache, ok := cacher.(*utils.TieredCache); ok {
+		return tieredCache.Disk
+	}
+	return cacher
+}
+
+// cacheLimits converts the config size limits to cache limits
+func cacheLimits(cacheConfig *config.CacheConfig) (utils.CacheLimit, map[string]utils.CacheLimit) {
+	limit := utils.CacheLimit{
+		MaxBytes: cacheConfig.MaxSizeMB * bytesPerMB,
+		MaxFiles: cacheConfig.MaxFiles,
+	}
+
+	providerLimits := make(map[string]utils.CacheLimit, len(cacheConfig.Providers))
+	for _, provider := range cacheConfig.Providers {
+		providerLimits[provider.ID] = utils.CacheLimit{
+			MaxBytes: provider.MaxSizeMB * bytesPerMB,
+			MaxFiles: provider.MaxFiles,
+		}
+	}
+	return limit, providerLimits
+}
+
+// closeCache stops the background workers of the cache
+func closeCache(cacher utils.Cacher) {
+	if evictingCache, ok := diskTier(cacher).(*utils.EvictingCache); ok {
+		evictingCache.Close()
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/export"
 	"go-map-proxy/internal/handler"
 	"go-map-proxy/internal/middleware"
+	"go-map-proxy/internal/seed"
 	"go-map-proxy/internal/utils"
 	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
 	"go-map-proxy/pkg/request"
 	"net/http"
 	"os"
@@ -40,25 +43,35 @@ func init() {
 	if err := config.InitConfig(configPath); err != nil {
 		logger.Fatalf("init config failed: %v", err)
 	}
+	cfg := config.GetConfig()
 
-	// init map cache
-	utils.NewPathMapCache(config.Cfg.Cache.Path)
-
-	// init logger
+	// init logger before the cache, which logs its index rebuild and eviction
 	logger.InitLogger(&logger.LoggerCfg{
-		EnableFile: config.Cfg.Log.EnableFile,
-		LogLevel:   config.Cfg.Log.Level,
-		LogPath:    config.Cfg.Log.FilePath,
+		EnableFile: cfg.Log.EnableFile,
+		LogLevel:   cfg.Log.Level,
+		LogPath:    cfg.Log.FilePath,
 	})
 
+	// init map cache
+	utils.SetCache(newCache(&cfg.Cache))
+
 	// init http default client
 	request.InitDefaultHTTPClient(&request.HTTPClientConfig{
-		Timeout:      time.Duration(config.Cfg.HTTPClient.Timeout) * time.Second,
-		Proxy:        config.Cfg.HTTPClient.Proxy,
+		Timeout:      time.Duration(cfg.HTTPClient.Timeout) * time.Second,
+		Proxy:        cfg.HTTPClient.Proxy,
 		FollowDirect: true,
 	})
 
-	fmt.Printf("config: %+v\n", config.Cfg)
+	// register custom providers declared in config
+	if err := mapprovider.RegisterProviderDefinitions(cfg.Providers, cfg.LayerOptions()); err != nil {
+		logger.Fatalf("register providers failed: %v", err)
+	}
+
+	// apply config changes on reload
+	registerReloadListeners()
+
+	// the config holds the admin token and provider headers, e.g. api keys, do not print it
+	logger.Infof("config %s loaded, %d custom providers", configPath, len(cfg.Providers))
 }
 
 func StartServer() {
@@ -69,15 +82,35 @@ func StartServer() {
 	// register middlewares
 	middleware.RegisterMiddleware(e)
 
+	// resume seeding jobs saved next to the cache directory
+	seedManager := seed.NewManager(seed.StateDir(config.GetConfig().Cache.Path))
+	if err := seedManager.Resume(); err != nil {
+		logger.Errorf("resume seed jobs failed: %v", err)
+	}
+
+	// exported files are kept in a temporary directory until downloaded and removed
+	exportDir, err := os.MkdirTemp("", "go-map-proxy-export-")
+	if err != nil {
+		logger.Fatalf("create export directory failed: %v", err)
+	}
+	exportManager := export.NewManager(exportDir)
+
 	// register handlers
-	handler.RegisterHandlers(e)
+	handler.RegisterHandlers(e, seedManager, exportManager)
 
 	// graceful shutdown
 	// listen exit signal
 	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	address := fmt.Sprintf("%s:%d", config.Cfg.Server.Host, config.Cfg.Server.Port)
+	// hot reload config on file change and SIGHUP
+	if err := config.WatchConfig(); err != nil {
+		logger.Errorf("watch config file failed, reload with SIGHUP or the admin api: %v", err)
+	}
+	go watchReloadSignal(signalCtx)
+
+	cfg := config.GetConfig()
+	address := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
 	// start server
 	go func() {
@@ -96,6 +129,12 @@ func StartServer() {
 	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
+
+	// save seeding jobs, they are resumed on next start
+	seedManager.Close()
+	if err := exportManager.Close(); err != nil {
+		logger.Errorf("remove export directory failed: %v", err)
+	}
 }
 
 func main() {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"go-map-proxy/pkg/request"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// registerReloadListeners registers the listeners which swap providers, http client and cache on config reload.
+// Each listener builds its new snapshot and swaps it in the commit, after all listeners succeed.
+// registerReloadListeners 注册配置重载监听器，用于替换地图源、HTTP 客户端和缓存，
+// 每个监听器先构建新快照，所有监听器成功后才在 Commit 中替换
+func registerReloadListeners() {
+	// provider registry
+	config.OnConfigChange(func(oldConf, newConf *config.Config) (config.Change, error) {
+		if reflect.DeepEqual(oldConf.Providers, newConf.Providers) && reflect.DeepEqual(oldConf.LayerOptions(), newConf.LayerOptions()) {
+			return config.Change{}, nil
+		}
+		registry, err := mapprovider.NewRegistry(newConf.Providers, newConf.LayerOptions())
+		if err != nil {
+			return config.Change{}, err
+		}
+		return config.Change{
+			Commit: func() {
+				// the old providers are closed after the requests holding them are done
+				mapprovider.ReplaceRegistry(registry)
+				logger.Infof("reload config: provider registry swapped, %d providers", len(registry.MapSourceSlice))
+			},
+			Discard: registry.Close,
+		}, nil
+	})
+
+	// http client proxy and timeout
+	config.OnConfigChange(func(oldConf, newConf *config.Config) (config.Change, error) {
+		if oldConf.HTTPClient == newConf.HTTPClient {
+			return config.Change{}, nil
+		}
+		client := request.NewHTTPClient(&request.HTTPClientConfig{
+			Timeout:      time.Duration(newConf.HTTPClient.Timeout) * time.Second,
+			Proxy:        newConf.HTTPClient.Proxy,
+			FollowDirect: true,
+		})
+		return config.Change{
+			Commit: func() {
+				request.SetDefaultHTTPClient(client)
+				logger.Infof("reload config: http client swapped, proxy: %q", config.RedactURL(newConf.HTTPClient.Proxy))
+			},
+		}, nil
+	})
+
+	// cache path, size limits, eviction policy and memory tier, `enable`, `max_age` and ttl are read per request
+	config.OnConfigChange(func(oldConf, newConf *config.Config) (config.Change, error) {
+		oldCache := utils.GetCache()
+
+		if oldConf.Cache.Path == newConf.Cache.Path && oldConf.Cache.IsBounded() == newConf.Cache.IsBounded() {
+			// keep the disk tier and update the limits in place
+			// 保留磁盘层并原地更新限制
+			disk := diskTier(oldCache)
+			return config.Change{
+				Commit: func() {
+					if evictingCache, ok := disk.(*utils.EvictingCache); ok {
+						evictingCache.SetPolicy(newConf.Cache.EvictionPolicy)
+						evictingCache.SetLimits(cacheLimits(&newConf.Cache))
+					}
+					if oldConf.Cache.MemoryMB != newConf.Cache.MemoryMB {
+						utils.SetCache(withMemoryTier(disk, newConf.Cache.MemoryMB))
+						logger.Infof("reload config: memory cache tier swapped, size: %d MB", newConf.Cache.MemoryMB)
+					}
+				},
+			}, nil
+		}
+
+		cache := newCache(&newConf.Cache)
+		return config.Change{
+			Commit: func() {
+				utils.SetCache(cache)
+				closeCache(oldCache)
+				logger.Infof("reload config: cache swapped, path: %s, bounded: %t", newConf.Cache.Path, newConf.Cache.IsBounded())
+			},
+			Discard: func() { closeCache(cache) },
+		}, nil
+	})
+}
+
+// watchReloadSignal reloads the config on SIGHUP until ctx is done
+// watchReloadSignal 收到 SIGHUP 时重载配置，直到 ctx 结束
+func watchReloadSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Infof("received SIGHUP, reloading config")
+			_, _ = config.Reload()
+		}
+	}
+}
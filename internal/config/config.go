@@ -2,8 +2,13 @@ package config
 
 import (
 	"fmt"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -19,6 +24,78 @@ type CacheConfig struct {
 	Enable bool   `json:"enable" yaml:"enable" mapstructure:"enable"`
 	Path   string `json:"path" yaml:"path" mapstructure:"path"`
 	MaxAge int    `json:"max_age" yaml:"max_age" mapstructure:"max_age"`
+
+	// size limit of the whole cache, 0 means unlimited.
+	// tiles picked by the eviction policy are evicted when exceeded
+	MaxSizeMB int64 `json:"max_size_mb" yaml:"max_size_mb" mapstructure:"max_size_mb"`
+	MaxFiles  int64 `json:"max_files" yaml:"max_files" mapstructure:"max_files"`
+	// lru (least recently used, default) or lfu (least frequently used)
+	EvictionPolicy utils.EvictionPolicy `json:"eviction_policy" yaml:"eviction_policy" mapstructure:"eviction_policy"`
+
+	// size of the in-memory hot tile tier in front of the disk cache, 0 disables it
+	MemoryMB int64 `json:"memory_mb" yaml:"memory_mb" mapstructure:"memory_mb"`
+
+	// tile expiration in seconds, 0 means never expire
+	TTL int `json:"ttl" yaml:"ttl" mapstructure:"ttl"`
+	// seconds after ttl in which expired tiles are served while refreshed in background
+	StaleWhileRevalidate int `json:"stale_while_revalidate" yaml:"stale_while_revalidate" mapstructure:"stale_while_revalidate"`
+	// seconds after ttl in which expired tiles are served when the upstream fails
+	StaleIfError int `json:"stale_if_error" yaml:"stale_if_error" mapstructure:"stale_if_error"`
+
+	// per provider cache settings, size limits and expiration
+	Providers []CacheProviderConfig `json:"providers" yaml:"providers" mapstructure:"providers"`
+}
+
+// CacheProviderConfig overrides cache settings for one provider, nil fields inherit the cache settings
+type CacheProviderConfig struct {
+	ID        string `json:"id" yaml:"id" mapstructure:"id"`
+	MaxSizeMB int64  `json:"max_size_mb" yaml:"max_size_mb" mapstructure:"max_size_mb"`
+	MaxFiles  int64  `json:"max_files" yaml:"max_files" mapstructure:"max_files"`
+
+	TTL                  *int `json:"ttl,omitempty" yaml:"ttl" mapstructure:"ttl"`
+	StaleWhileRevalidate *int `json:"stale_while_revalidate,omitempty" yaml:"stale_while_revalidate" mapstructure:"stale_while_revalidate"`
+	StaleIfError         *int `json:"stale_if_error,omitempty" yaml:"stale_if_error" mapstructure:"stale_if_error"`
+}
+
+// IsBounded reports whether any size limit is configured
+func (cacheConfig *CacheConfig) IsBounded() bool {
+	if cacheConfig.MaxSizeMB > 0 || cacheConfig.MaxFiles > 0 {
+		return true
+	}
+	for _, provider := range cacheConfig.Providers {
+		if provider.MaxSizeMB > 0 || provider.MaxFiles > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns the expiration policy of the provider, virtual @2x layers follow their base provider
+// Policy 返回地图源的缓存过期策略，虚拟 @2x 图层沿用其原地图源的策略
+func (cacheConfig *CacheConfig) Policy(providerID string) utils.CachePolicy {
+	providerID = strings.TrimSuffix(providerID, mapprovider.RetinaSuffix)
+	ttl, staleWhileRevalidate, staleIfError := cacheConfig.TTL, cacheConfig.StaleWhileRevalidate, cacheConfig.StaleIfError
+
+	for _, provider := range cacheConfig.Providers {
+		if provider.ID != providerID {
+			continue
+		}
+		if provider.TTL != nil {
+			ttl = *provider.TTL
+		}
+		if provider.StaleWhileRevalidate != nil {
+			staleWhileRevalidate = *provider.StaleWhileRevalidate
+		}
+		if provider.StaleIfError != nil {
+			staleIfError = *provider.StaleIfError
+		}
+	}
+
+	return utils.CachePolicy{
+		TTL:                  time.Duration(ttl) * time.Second,
+		StaleWhileRevalidate: time.Duration(staleWhileRevalidate) * time.Second,
+		StaleIfError:         time.Duration(staleIfError) * time.Second,
+	}
 }
 
 type LogConfig struct {
@@ -32,71 +109,171 @@ type HTTPClientConfig struct {
 	Proxy   string `json:"proxy" yaml:"proxy" mapstructure:"proxy"`
 }
 
+type AdminConfig struct {
+	// token required by admin api (`Authorization: Bearer <token>` or `?token=<token>`)
+	// admin api only accepts requests from localhost if token is empty
+	Token string `json:"token" yaml:"token" mapstructure:"token"`
+}
+
+type SeedConfig struct {
+	// seeding jobs whose bbox is covered by more tiles are rejected, 0 means unlimited
+	MaxTiles int64 `json:"max_tiles" yaml:"max_tiles" mapstructure:"max_tiles"`
+}
+
 type Config struct {
 	Server     ServerConfig     `json:"server" yaml:"server" mapstructure:"server"`
 	Cache      CacheConfig      `json:"cache" yaml:"cache" mapstructure:"cache"`
 	Log        LogConfig        `json:"log" yaml:"log" mapstructure:"log"`
 	HTTPClient HTTPClientConfig `json:"http_client" yaml:"http_client" mapstructure:"http_client"`
+	Admin      AdminConfig      `json:"admin" yaml:"admin" mapstructure:"admin"`
+	Seed       SeedConfig       `json:"seed" yaml:"seed" mapstructure:"seed"`
+
+	// custom tile map providers, registered after the built-in providers
+	Providers []mapprovider.ProviderDefinition `json:"providers" yaml:"providers" mapstructure:"providers"`
+
+	// resampling kernels of GCJ02/BD09 corrected providers, e.g. the built-in amap_road
+	Resampling []mapprovider.ResamplingDefinition `json:"resampling" yaml:"resampling" mapstructure:"resampling"`
+	// ids of 512px providers served as 256px tiles cut from their 512px tiles
+	NormalizeTileSize []string `json:"normalize_tile_size" yaml:"normalize_tile_size" mapstructure:"normalize_tile_size"`
+	// providers served beyond their max zoom with tiles upscaled from the native max zoom
+	Overzoom []mapprovider.OverzoomDefinition `json:"overzoom" yaml:"overzoom" mapstructure:"overzoom"`
+	// WGS84 providers also served in the GCJ02 or BD09 tile grid as `<id>@gcj02` or `<id>@bd09`
+	Reproject []mapprovider.ReprojectDefinition `json:"reproject" yaml:"reproject" mapstructure:"reproject"`
 }
 
-var Cfg *Config
+// LayerOptions returns the options adjusting the registered providers
+func (conf *Config) LayerOptions() mapprovider.LayerOptions {
+	return mapprovider.LayerOptions{
+		Resampling:        conf.Resampling,
+		NormalizeTileSize: conf.NormalizeTileSize,
+		Overzoom:          conf.Overzoom,
+		Reproject:         conf.Reproject,
+	}
+}
 
-func InitConfig(configPath string) error {
+// current config snapshot, swapped atomically on reload
+// 当前配置快照，重载时原子替换
+var currentConfig atomic.Pointer[Config]
+
+// GetConfig returns the current config snapshot.
+// Handlers should call it once per request and keep the returned pointer,
+// the snapshot is never modified after it is published.
+// GetConfig 返回当前配置快照，处理器应在每个请求中只获取一次并持有该指针，快照发布后不会被修改
+func GetConfig() *Config {
+	return currentConfig.Load()
+}
+
+// path of the config file, set once by InitConfig
+var configPath string
+
+// newViper returns a viper instance with the defaults, every load reads the file into a new instance
+// so that a reload does not modify the state read by a previous one
+// newViper 返回设置了默认值的 viper 实例，每次加载都读入新实例，重载不会修改之前读取的状态
+func newViper(path string) *viper.Viper {
+	v := viper.New()
 	// viper can recognize config file type automatically.
 
 	// set config type
-	// viper.SetConfigType("yaml")
-	// viper.SetConfigType("json")
-	// viper.SetConfigType("toml")
-	// viper.SetConfigFile("env")
+	// v.SetConfigType("yaml")
+	// v.SetConfigType("json")
+	// v.SetConfigType("toml")
+	// v.SetConfigFile("env")
 
 	// set default server config
-	viper.SetDefault("server.host", "0.0.0.0")
-	viper.SetDefault("server.port", 8076)
+	v.SetDefault("server.host", "0.0.0.0")
+	v.SetDefault("server.port", 8076)
 
 	// set default cache config
-	viper.SetDefault("cache.path", "./cache")
-	viper.SetDefault("cache.enable", true)
-	viper.SetDefault("cache.max_age", 3600)
+	v.SetDefault("cache.path", "./cache")
+	v.SetDefault("cache.enable", true)
+	v.SetDefault("cache.max_age", 3600)
+	v.SetDefault("cache.max_size_mb", 0)
+	v.SetDefault("cache.max_files", 0)
+	v.SetDefault("cache.eviction_policy", "lru")
+	v.SetDefault("cache.memory_mb", 0)
+	v.SetDefault("cache.ttl", 0)
+	v.SetDefault("cache.stale_while_revalidate", 86400)
+	v.SetDefault("cache.stale_if_error", 604800)
 
 	// set default log config
-	viper.SetDefault("log.level", "debug")
-	viper.SetDefault("log.enable_file", false)
-	viper.SetDefault("log.file_path", "")
+	v.SetDefault("log.level", "debug")
+	v.SetDefault("log.enable_file", false)
+	v.SetDefault("log.file_path", "")
 
 	// set default http client config
-	viper.SetDefault("http_client.timeout", 10)
-	viper.SetDefault("http_client.proxy", "")
+	v.SetDefault("http_client.timeout", 10)
+	v.SetDefault("http_client.proxy", "")
+
+	// set default admin config
+	v.SetDefault("admin.token", "")
+
+	// set default seed config
+	v.SetDefault("seed.max_tiles", 1000000)
 
 	// load config in environment variable
-	viper.AutomaticEnv()
+	v.AutomaticEnv()
 
-	// read config file
-	file, err := os.OpenFile(configPath, os.O_RDONLY, 0644)
-	if err != nil {
+	// get file extension name and set config type
+	v.SetConfigType(strings.TrimPrefix(filepath.Ext(path), "."))
+	v.SetConfigFile(path)
+	return v
+}
+
+func InitConfig(path string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// check config file
+	if _, err := os.Stat(path); err != nil {
 		// create default config file
-		if err := viper.SafeWriteConfigAs(configPath); err != nil {
-			fmt.Printf("create default config file %s failed: %v\n", configPath, err)
+		if err := newViper(path).SafeWriteConfigAs(path); err != nil {
+			fmt.Printf("create default config file %s failed: %v\n", path, err)
 		}
-		return fmt.Errorf("open config file %s failed: %w, create default config now", configPath, err)
+		return fmt.Errorf("open config file %s failed: %w, create default config now", path, err)
 	}
+	configPath = path
 
-	// get file extension name and set config type
-	ext := filepath.Ext(configPath)
-	viper.SetConfigType(ext[1:])
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	currentConfig.Store(conf)
+	return nil
+
+}
+
+// loadConfig reads, unmarshals and validates the config file, it does not publish the result.
+// It must be called with reloadMu held.
+// loadConfig 读取、解析并校验配置文件，但不发布结果，调用时需持有 reloadMu
+func loadConfig() (*Config, error) {
+	v := newViper(configPath)
 
 	// load config file
-	if err := viper.ReadConfig(file); err != nil {
-		return fmt.Errorf("viper load config file %s failed: %w", configPath, err)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("viper load config file %s failed: %w", configPath, err)
+	}
+
+	// provider size limits were read from `cache.provider_limits` before they moved to `cache.providers`,
+	// do not silently run the cache unbounded
+	if v.IsSet("cache.provider_limits") {
+		return nil, fmt.Errorf("invalid config file %s: cache.provider_limits is renamed to cache.providers", configPath)
 	}
 
 	var conf Config
 
-	if err := viper.Unmarshal(&conf); err != nil {
-		return fmt.Errorf("viper unmarshal config file %s failed: %w", configPath, err)
+	if err := v.Unmarshal(&conf); err != nil {
+		return nil, fmt.Errorf("viper unmarshal config file %s failed: %w", configPath, err)
 	}
 
-	Cfg = &conf
-	return nil
+	if err := conf.Cache.EvictionPolicy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cache.eviction_policy in config file %s: %w", configPath, err)
+	}
+
+	// validate custom providers
+	if err := mapprovider.ValidateProviderDefinitions(conf.Providers, mapprovider.BuiltinProviderIDs()); err != nil {
+		return nil, fmt.Errorf("invalid providers in config file %s:\n%w", configPath, err)
+	}
 
+	return &conf, nil
 }
@@ -0,0 +1,266 @@
+// Hot reload of the config file: file watch, SIGHUP and admin api all end up in Reload
+// 配置文件热重载：文件监听、SIGHUP 信号和管理接口最终都调用 Reload
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Change is a config change prepared by a listener. Commit publishes it, Discard releases what was built
+// when another listener fails. Both may be nil.
+// Change 是监听器准备好的配置变更，Commit 发布变更，其他监听器失败时由 Discard 释放已构建的资源，两者均可为 nil
+type Change struct {
+	Commit  func()
+	Discard func()
+}
+
+// ChangeListener builds the snapshots of a new config without publishing them, e.g. a new provider registry.
+// Returning an error aborts the reload and keeps the old config.
+// ChangeListener 根据新配置构建快照但不发布，返回错误将中止重载并保留旧配置
+type ChangeListener func(oldConf, newConf *Config) (Change, error)
+
+var (
+	reloadMu  sync.Mutex
+	listeners []ChangeListener
+)
+
+// keys which can not be applied without restart
+// 需要重启才能生效的配置项
+var restartRequiredPrefixes = []string{"server.", "log."}
+
+// OnConfigChange registers a listener which is called in registration order on every reload
+// OnConfigChange 注册监听器，每次重载时按注册顺序调用
+func OnConfigChange(listener ChangeListener) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listeners = append(listeners, listener)
+}
+
+// Reload re-reads the config file, applies it through the listeners and publishes it
+// Reload 重新读取配置文件，通过监听器应用并发布
+func Reload() (changes []string, err error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	newConf, err := loadConfig()
+	if err != nil {
+		logger.Errorf("reload config failed, keep the old config: %v", err)
+		return nil, err
+	}
+
+	oldConf := GetConfig()
+	changes = Diff(oldConf, newConf)
+	if len(changes) == 0 {
+		logger.Infof("reload config: nothing changed")
+		return changes, nil
+	}
+
+	// build every snapshot first, publish them only after all listeners succeed,
+	// so that a failing listener does not leave the new config half applied
+	// 先构建所有快照，全部成功后再发布，避免某个监听器失败时新配置只生效一部分
+	prepared := make([]Change, 0, len(listeners))
+	for _, listener := range listeners {
+		change, err := listener(oldConf, newConf)
+		if err != nil {
+			for _, change := range prepared {
+				if change.Discard != nil {
+					change.Discard()
+				}
+			}
+			logger.Errorf("apply config failed, keep the old config: %v", err)
+			return nil, err
+		}
+		prepared = append(prepared, change)
+	}
+	for _, change := range prepared {
+		if change.Commit != nil {
+			change.Commit()
+		}
+	}
+	currentConfig.Store(newConf)
+
+	for _, change := range changes {
+		logger.Infof("reload config: %s", change)
+		for _, prefix := range restartRequiredPrefixes {
+			if strings.HasPrefix(change, prefix) {
+				logger.Warnf("reload config: %s requires restart to take effect", strings.SplitN(change, ":", 2)[0])
+			}
+		}
+	}
+	return changes, nil
+}
+
+// WatchConfig reloads the config when the config file changes. The file is only read by Reload under reloadMu,
+// the directory is watched since editors and config maps replace the file instead of writing it.
+// WatchConfig 在配置文件变化时重载配置。文件只由 Reload 在 reloadMu 下读取，
+// 监听所在目录是因为编辑器和 ConfigMap 会替换文件而不是写入
+func WatchConfig() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	configFile := filepath.Clean(configPath)
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != configFile || event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				logger.Infof("config file %s changed (%s), reloading", event.Name, event.Op)
+				_, _ = Reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("watch config file %s error: %v", configFile, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Diff returns human readable changes between two configs, e.g. `cache.max_age: 3600 -> 7200`.
+// Providers are compared by id. The changes are logged and returned by the admin api, so secrets are masked:
+// the admin token and provider header values are reported as changed without their values,
+// the credentials of proxy and provider urls are redacted.
+// Diff 返回两个配置之间可读的差异，地图源按 ID 比较。差异会被记录日志并由管理接口返回，因此会隐藏密钥：
+// 管理令牌和地图源请求头只报告变化而不显示值，代理和地图源 URL 中的凭据会被替换
+func Diff(oldConf, newConf *Config) []string {
+	if oldConf == nil || newConf == nil {
+		return []string{"config: initialized"}
+	}
+
+	oldFlat, errOld := flattenConfig(oldConf)
+	newFlat, errNew := flattenConfig(newConf)
+	if err := errors.Join(errOld, errNew); err != nil {
+		return []string{fmt.Sprintf("config: changed (diff failed: %v)", err)}
+	}
+
+	var changes []string
+	for key, newValue := range newFlat {
+		oldValue, ok := oldFlat[key]
+		switch {
+		case ok && reflect.DeepEqual(oldValue, newValue):
+		case isSecretKey(key) && !ok:
+			changes = append(changes, fmt.Sprintf("%s: added", key))
+		case isSecretKey(key):
+			changes = append(changes, fmt.Sprintf("%s: changed", key))
+		case !ok:
+			changes = append(changes, fmt.Sprintf("%s: added %v", key, displayValue(newValue)))
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", key, displayValue(oldValue), displayValue(newValue)))
+		}
+	}
+	for key, oldValue := range oldFlat {
+		if _, ok := newFlat[key]; ok {
+			continue
+		}
+		if isSecretKey(key) {
+			changes = append(changes, fmt.Sprintf("%s: removed", key))
+		} else {
+			changes = append(changes, fmt.Sprintf("%s: removed %v", key, displayValue(oldValue)))
+		}
+	}
+
+	slices.Sort(changes)
+	return changes
+}
+
+// isSecretKey reports whether the flattened key holds a secret whose value is never shown
+func isSecretKey(key string) bool {
+	if key == "admin.token" {
+		return true
+	}
+	// provider headers are flattened to `providers.<id>.headers.<name>`, e.g. Authorization and api keys
+	// 地图源请求头展开为 `providers.<id>.headers.<name>`，例如 Authorization 和 API key
+	return strings.HasPrefix(key, "providers.") && strings.Contains(key, ".headers.")
+}
+
+// displayValue returns the value with the credentials of urls redacted
+func displayValue(value any) any {
+	if text, ok := value.(string); ok {
+		return RedactURL(text)
+	}
+	return value
+}
+
+// RedactURL replaces the userinfo of the url, e.g. the credentials of a proxy, other strings are returned as is
+// RedactURL 替换 URL 中的用户信息（例如代理凭据），其他字符串原样返回
+func RedactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	parsed.User = url.User("xxxxx")
+	return parsed.String()
+}
+
+// flatten config to `section.key` values, providers are keyed by `providers.<id>.<key>`,
+// provider headers by `providers.<id>.headers.<name>`
+func flattenConfig(conf *Config) (map[string]any, error) {
+	raw, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]any
+	if err := json.Unmarshal(raw, &sections); err != nil {
+		return nil, err
+	}
+
+	flat := make(map[string]any)
+	for section, value := range sections {
+		if section == "providers" {
+			continue
+		}
+		if fields, ok := value.(map[string]any); ok {
+			for key, fieldValue := range fields {
+				flat[section+"."+key] = fieldValue
+			}
+		} else {
+			flat[section] = value
+		}
+	}
+
+	for _, provider := range conf.Providers {
+		raw, err := json.Marshal(provider)
+		if err != nil {
+			return nil, err
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+		for key, fieldValue := range fields {
+			if headers, ok := fieldValue.(map[string]any); ok && key == "headers" {
+				for name, headerValue := range headers {
+					flat["providers."+provider.ID+".headers."+name] = headerValue
+				}
+				continue
+			}
+			flat["providers."+provider.ID+"."+key] = fieldValue
+		}
+	}
+	return flat, nil
+}
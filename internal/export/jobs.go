@@ -0,0 +1,171 @@
+package export
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("export job not found")
+
+// max number of exports running at the same time, each reads the whole cache range
+const maxRunningJobs = 2
+
+type JobStatus string
+
+const (
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// JobInfo is the state of an export job, the file can be downloaded once done
+type JobInfo struct {
+	ID         string     `json:"id"`
+	MapID      string     `json:"map_id"`
+	Format     string     `json:"format"`
+	Status     JobStatus  `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Result     *Result    `json:"result,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Exporter writes the tiles selected by the options to the output file, e.g. MBTiles or PMTiles
+type Exporter func(output string, options Options) (*Result, error)
+
+// Manager runs exports in background and keeps their files in a directory until they are removed,
+// so that exporting a whole cache does not block a request
+// Manager 在后台运行导出任务并将文件保存在目录中直到被删除，导出整个缓存时不会阻塞请求
+type Manager struct {
+	dir string
+
+	mu   sync.Mutex
+	jobs map[string]*JobInfo
+}
+
+func NewManager(dir string) *Manager {
+	return &Manager{dir: dir, jobs: make(map[string]*JobInfo)}
+}
+
+// Start starts the export in background, at most maxRunningJobs exports run at the same time
+// Start 在后台开始导出，同时运行的导出任务最多 maxRunningJobs 个
+func (manager *Manager) Start(mapID, format string, exporter Exporter, options Options) (JobInfo, error) {
+	if err := os.MkdirAll(manager.dir, 0o755); err != nil {
+		return JobInfo{}, err
+	}
+
+	manager.mu.Lock()
+	running := 0
+	for _, job := range manager.jobs {
+		if job.Status == JobRunning {
+			running++
+		}
+	}
+	if running >= maxRunningJobs {
+		manager.mu.Unlock()
+		return JobInfo{}, fmt.Errorf("%d exports are running, retry when one is done", running)
+	}
+	job := &JobInfo{ID: newJobID(), MapID: mapID, Format: format, Status: JobRunning, CreatedAt: time.Now()}
+	manager.jobs[job.ID] = job
+	info := *job
+	manager.mu.Unlock()
+
+	go func() {
+		result, err := exporter(manager.output(info), options)
+		finishedAt := time.Now()
+
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		job.FinishedAt = &finishedAt
+		if err != nil {
+			logger.Errorf("Export %s of %s error: %v", format, mapID, err)
+			job.Status = JobFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = JobDone
+		job.Result = result
+	}()
+	return info, nil
+}
+
+// output returns the file of the job
+func (manager *Manager) output(info JobInfo) string {
+	return filepath.Join(manager.dir, info.ID+"."+info.Format)
+}
+
+func (manager *Manager) Get(id string) (JobInfo, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	job, ok := manager.jobs[id]
+	if !ok {
+		return JobInfo{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return *job, nil
+}
+
+// List returns all jobs, newest first
+func (manager *Manager) List() []JobInfo {
+	manager.mu.Lock()
+	infos := make([]JobInfo, 0, len(manager.jobs))
+	for _, job := range manager.jobs {
+		infos = append(infos, *job)
+	}
+	manager.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b JobInfo) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return infos
+}
+
+// File returns the exported file of a done job
+func (manager *Manager) File(id string) (JobInfo, string, error) {
+	info, err := manager.Get(id)
+	if err != nil {
+		return info, "", err
+	}
+	if info.Status != JobDone {
+		return info, "", fmt.Errorf("export job %s is %s", id, info.Status)
+	}
+	return info, manager.output(info), nil
+}
+
+// Remove deletes a finished job and its file
+func (manager *Manager) Remove(id string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	job, ok := manager.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if job.Status == JobRunning {
+		return fmt.Errorf("export job %s is running", id)
+	}
+	if err := os.Remove(manager.output(*job)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(manager.jobs, id)
+	return nil
+}
+
+// Close removes the directory of the exported files, running exports fail
+func (manager *Manager) Close() error {
+	return os.RemoveAll(manager.dir)
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
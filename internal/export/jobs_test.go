@@ -0,0 +1,76 @@
+package export
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	manager := NewManager(t.TempDir())
+	release := make(chan struct{})
+	exporter := func(output string, options Options) (*Result, error) {
+		<-release
+		if options.MinZoom < 0 {
+			return nil, errors.New("export failed")
+		}
+		return &Result{Tiles: 1}, os.WriteFile(output, []byte("tiles"), 0o644)
+	}
+
+	done, err := manager.Start("colors", "mbtiles", exporter, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := manager.Start("colors", "pmtiles", exporter, Options{MinZoom: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Start("colors", "mbtiles", exporter, Options{}); err == nil {
+		t.Error("expected running job limit error")
+	}
+	if _, _, err := manager.File(done.ID); err == nil {
+		t.Error("expected error downloading a running job")
+	}
+	if err := manager.Remove(done.ID); err == nil {
+		t.Error("expected error removing a running job")
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		doneInfo, _ := manager.Get(done.ID)
+		failedInfo, _ := manager.Get(failed.ID)
+		if doneInfo.Status != JobRunning && failedInfo.Status != JobRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("export jobs are not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if info, _ := manager.Get(failed.ID); info.Status != JobFailed || info.Error != "export failed" {
+		t.Errorf("unexpected failed job %+v", info)
+	}
+	info, path, err := manager.File(done.ID)
+	if err != nil || info.Status != JobDone || info.Result.Tiles != 1 {
+		t.Fatalf("unexpected done job %+v: %v", info, err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "tiles" {
+		t.Errorf("unexpected export file: %q, %v", data, err)
+	}
+	if len(manager.List()) != 2 {
+		t.Errorf("unexpected jobs %v", manager.List())
+	}
+
+	if err := manager.Remove(done.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("export file is not removed: %v", err)
+	}
+	if _, err := manager.Get(done.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("expected job not found, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Export cached tiles of a provider to an MBTiles or PMTiles file.
+// Tiles are read from the path map cache layout `<cache path>/<map id>/<z>/<x>/<y>.<ext>`, or from any Cacher.
+// 将地图源的缓存瓦片导出为 MBTiles 或 PMTiles 文件，瓦片从路径缓存目录 `<cache path>/<map id>/<z>/<x>/<y>.<ext>` 或任意 Cacher 读取
+
+package export
+
+import (
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mbtiles"
+	"os"
+	"strconv"
+)
+
+// MBTiles exports the cached tiles to the output file, the file is not created if no tile matches
+// MBTiles 将缓存瓦片导出到输出文件，没有匹配的瓦片时不会创建文件
+func MBTiles(output string, options Options) (*Result, error) {
+	src, err := newSource(options)
+	if err != nil {
+		return nil, err
+	}
+	metadata := src.metadata
+	format, err := mbtiles.Format(string(metadata.ContentType))
+	if err != nil {
+		return nil, err
+	}
+	refs, err := src.tiles()
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := mbtiles.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("create mbtiles %s error: %w", output, err)
+	}
+
+	result, err := src.export(refs, writer.WriteTile)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	bounds := result.Bounds
+	values := map[string]string{
+		"name":        metadata.Name,
+		"description": fmt.Sprintf("%s (%s), exported by go-map-proxy", metadata.Name, metadata.ID),
+		"format":      format,
+		"type":        "baselayer",
+		"version":     "1.0",
+		"minzoom":     strconv.Itoa(result.MinZoom),
+		"maxzoom":     strconv.Itoa(result.MaxZoom),
+		"bounds":      fmt.Sprintf("%f,%f,%f,%f", bounds[0], bounds[1], bounds[2], bounds[3]),
+		"center":      fmt.Sprintf("%f,%f,%d", (bounds[0]+bounds[2])/2, (bounds[1]+bounds[3])/2, result.MinZoom),
+	}
+	if metadata.Attribution != "" {
+		values["attribution"] = metadata.Attribution
+	}
+	for name, value := range values {
+		if err := writer.SetMetadata(name, value); err != nil {
+			writer.Abort()
+			return nil, fmt.Errorf("write mbtiles metadata error: %w", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		os.Remove(output)
+		return nil, fmt.Errorf("close mbtiles %s error: %w", output, err)
+	}
+
+	logger.Infof("Export %d tiles of %s (z%d-%d) to %s", result.Tiles, metadata.ID, result.MinZoom, result.MaxZoom, output)
+	return result, nil
+}
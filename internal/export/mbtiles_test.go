@@ -0,0 +1,84 @@
+package export
+
+import (
+	"database/sql"
+	"go-map-proxy/pkg/mapprovider"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func writeCachedTile(t *testing.T, cachePath, key string) {
+	t.Helper()
+	path := filepath.Join(cachePath, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMBTiles(t *testing.T) {
+	cachePath := t.TempDir()
+	for _, key := range []string{
+		"fake/0/0/0.png",
+		"fake/1/1/0.png",
+		"fake/1/0/1.png",
+		"fake/2/3/0.png",
+		// other format and other map are ignored
+		"fake/1/0/0.jpeg",
+		"other/1/0/0.png",
+	} {
+		writeCachedTile(t, cachePath, key)
+	}
+
+	output := filepath.Join(t.TempDir(), "fake.mbtiles")
+	metadata := &mapprovider.TileMapMetadata{Name: "Fake", ID: "fake", MinZoom: 0, MaxZoom: 2}
+	// north-east quarter of the world
+	bbox := mapprovider.BBox{1, 1, 180, 85}
+	result, err := MBTiles(output, Options{CachePath: cachePath, Metadata: metadata, BBox: &bbox})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tiles != 3 || result.MinZoom != 0 || result.MaxZoom != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	db, err := sql.Open("sqlite", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// XYZ 1/1/0 is stored as TMS row 1
+	var data string
+	if err := db.QueryRow(`SELECT tile_data FROM tiles WHERE zoom_level = 1 AND tile_column = 1 AND tile_row = 1`).Scan(&data); err != nil || data != "fake/1/1/0.png" {
+		t.Errorf("unexpected tile 1/1/0: %q %v", data, err)
+	}
+	// XYZ 1/0/1 is outside the bbox
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM tiles WHERE zoom_level = 1 AND tile_column = 0`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("expected tiles outside bbox to be skipped, got %d %v", count, err)
+	}
+
+	for name, expected := range map[string]string{
+		"name":    "Fake",
+		"format":  "png",
+		"minzoom": "0",
+		"maxzoom": "2",
+		"bounds":  "1.000000,1.000000,180.000000,85.000000",
+	} {
+		var value string
+		if err := db.QueryRow(`SELECT value FROM metadata WHERE name = ?`, name).Scan(&value); err != nil || value != expected {
+			t.Errorf("metadata %s: expected %q, got %q %v", name, expected, value, err)
+		}
+	}
+
+	// nothing cached in the zoom range
+	if _, err := MBTiles(output, Options{CachePath: cachePath, Metadata: metadata, MinZoom: 2, MaxZoom: 2, BBox: &mapprovider.BBox{-180, -85, -1, -1}}); err == nil {
+		t.Error("expected error for empty export")
+	}
+}
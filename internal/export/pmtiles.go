@@ -0,0 +1,74 @@
+package export
+
+import (
+	"cmp"
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/pmtiles"
+	"slices"
+)
+
+// PMTiles exports the cached tiles to a PMTiles archive, the file is not created if no tile matches.
+// Tiles are written in Hilbert tile id order so that the archive is clustered, identical tiles are stored once.
+// PMTiles 将缓存瓦片导出为 PMTiles 归档，没有匹配的瓦片时不会创建文件；
+// 瓦片按希尔伯特瓦片 ID 顺序写入以生成聚簇归档，相同内容的瓦片只存储一次
+func PMTiles(output string, options Options) (*Result, error) {
+	src, err := newSource(options)
+	if err != nil {
+		return nil, err
+	}
+	metadata := src.metadata
+	tileType, err := pmtiles.TileTypeOf(string(metadata.ContentType))
+	if err != nil {
+		return nil, err
+	}
+	refs, err := src.tiles()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(refs, func(a, b tileRef) int {
+		return cmp.Compare(
+			pmtiles.ZxyToID(uint8(a.z), uint32(a.x), uint32(a.y)),
+			pmtiles.ZxyToID(uint8(b.z), uint32(b.x), uint32(b.y)),
+		)
+	})
+
+	writer, err := pmtiles.NewWriter(output)
+	if err != nil {
+		return nil, fmt.Errorf("create pmtiles %s error: %w", output, err)
+	}
+
+	result, err := src.export(refs, writer.WriteTile)
+	if err != nil {
+		writer.Abort()
+		return nil, err
+	}
+
+	bounds := result.Bounds
+	header := pmtiles.Header{
+		TileType:   tileType,
+		MinZoom:    uint8(result.MinZoom),
+		MaxZoom:    uint8(result.MaxZoom),
+		Bounds:     bounds,
+		CenterZoom: uint8(result.MinZoom),
+		CenterLon:  (bounds[0] + bounds[2]) / 2,
+		CenterLat:  (bounds[1] + bounds[3]) / 2,
+	}
+	values := map[string]any{
+		"name":        metadata.Name,
+		"description": fmt.Sprintf("%s (%s), exported by go-map-proxy", metadata.Name, metadata.ID),
+		"type":        "baselayer",
+		"version":     "1.0",
+		"tile_size":   int(metadata.MapSize),
+	}
+	if metadata.Attribution != "" {
+		values["attribution"] = metadata.Attribution
+	}
+	if err := writer.Finalize(header, values); err != nil {
+		return nil, fmt.Errorf("write pmtiles %s error: %w", output, err)
+	}
+
+	logger.Infof("Export %d tiles of %s (z%d-%d) to %s", result.Tiles, metadata.ID, result.MinZoom, result.MaxZoom, output)
+	return result, nil
+}
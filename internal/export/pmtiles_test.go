@@ -0,0 +1,77 @@
+package export
+
+import (
+	"errors"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
+	"go-map-proxy/pkg/pmtiles"
+	"path/filepath"
+	"testing"
+)
+
+// memoryCacher is a Cacher without a directory layout
+type memoryCacher map[string][]byte
+
+func (cacher memoryCacher) GetCache(key string) ([]byte, error) {
+	value, ok := cacher[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return value, nil
+}
+
+func (cacher memoryCacher) SetCache(key string, value []byte) error {
+	cacher[key] = value
+	return nil
+}
+
+func TestPMTiles(t *testing.T) {
+	cacher := memoryCacher{}
+	for _, zxy := range [][3]int{{0, 0, 0}, {1, 1, 0}, {1, 0, 1}, {2, 3, 0}, {2, 2, 1}} {
+		cacher.SetCache(utils.TileCacheKey("fake", zxy[0], zxy[1], zxy[2], "image/png"), []byte("same"))
+	}
+	cacher.SetCache("fake/2/0/0.png", []byte("other"))
+
+	output := filepath.Join(t.TempDir(), "fake.pmtiles")
+	metadata := &mapprovider.TileMapMetadata{Name: "Fake", ID: "fake", MinZoom: 0, MaxZoom: 2}
+	result, err := PMTiles(output, Options{Cacher: cacher, Metadata: metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tiles != 6 || result.MinZoom != 0 || result.MaxZoom != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	reader, err := pmtiles.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	header := reader.Header()
+	if header.TileType != pmtiles.TileTypePNG || header.AddressedTiles != 6 || header.TileContents != 2 || header.MaxZoom != 2 {
+		t.Errorf("unexpected header: %+v", header)
+	}
+	if reader.Metadata()["name"] != "Fake" {
+		t.Errorf("unexpected metadata: %v", reader.Metadata())
+	}
+	if data, err := reader.Tile(2, 0, 0); err != nil || string(data) != "other" {
+		t.Errorf("unexpected tile 2/0/0: %q %v", data, err)
+	}
+	if data, err := reader.Tile(1, 1, 0); err != nil || string(data) != "same" {
+		t.Errorf("unexpected tile 1/1/0: %q %v", data, err)
+	}
+
+	// the directory layout gives the same archive
+	cachePath := t.TempDir()
+	writeCachedTile(t, cachePath, "fake/1/1/0.png")
+	bbox := mapprovider.BBox{1, 1, 180, 85}
+	if result, err := PMTiles(output, Options{CachePath: cachePath, Metadata: metadata, BBox: &bbox}); err != nil || result.Tiles != 1 {
+		t.Errorf("unexpected directory export: %+v %v", result, err)
+	}
+
+	// too many keys to look up without a bbox
+	if _, err := PMTiles(output, Options{Cacher: cacher, Metadata: metadata, MinZoom: 0, MaxZoom: 14}); err == nil {
+		t.Error("expected error for too many candidates")
+	}
+}
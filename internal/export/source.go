@@ -0,0 +1,250 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// max number of candidate tiles enumerated from a Cacher, a bbox is required above it
+// 从 Cacher 枚举的候选瓦片数上限，超过时需要指定范围框
+const maxCacherCandidates = 1 << 22
+
+// Options selects the tiles to export, zero MinZoom and MaxZoom export the provider zoom range
+// Options 选择要导出的瓦片，MinZoom 和 MaxZoom 为零时导出地图源的缩放范围
+type Options struct {
+	// cache root directory of the path map cache layout, used if Cacher is nil
+	CachePath string
+	// read tiles from any Cacher by enumerating the keys of the zoom range and bbox
+	Cacher   utils.Cacher
+	Metadata *mapprovider.TileMapMetadata
+	// cache namespace of the tiles, the metadata id if empty
+	CacheID string
+
+	MinZoom int
+	MaxZoom int
+	// only tiles intersecting the bbox are exported if set
+	BBox *mapprovider.BBox
+}
+
+// Result is the summary of an export
+type Result struct {
+	Tiles   int64            `json:"tiles"`
+	MinZoom int              `json:"min_zoom"`
+	MaxZoom int              `json:"max_zoom"`
+	Bounds  mapprovider.BBox `json:"bounds"`
+}
+
+func newResult() *Result {
+	return &Result{
+		MinZoom: math.MaxInt,
+		MaxZoom: math.MinInt,
+		Bounds:  mapprovider.BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+	}
+}
+
+// add records an exported tile
+func (result *Result) add(z, x, y int) {
+	result.Tiles++
+	result.MinZoom = min(result.MinZoom, z)
+	result.MaxZoom = max(result.MaxZoom, z)
+	result.Bounds = result.Bounds.Union(mapprovider.TileBBox(x, y, z))
+}
+
+// clampBounds limits the bounds of the exported tiles to the requested bbox
+func (result *Result) clampBounds(bbox *mapprovider.BBox) {
+	if bbox == nil {
+		return
+	}
+	result.Bounds = mapprovider.BBox{
+		math.Max(result.Bounds[0], bbox[0]), math.Max(result.Bounds[1], bbox[1]),
+		math.Min(result.Bounds[2], bbox[2]), math.Min(result.Bounds[3], bbox[3]),
+	}
+}
+
+// tileRef is a cached tile to export, load returns errTileMissing if a Cacher has no such tile
+// tileRef 是待导出的缓存瓦片，Cacher 中没有该瓦片时 load 返回 errTileMissing
+type tileRef struct {
+	z, x, y int
+	load    func() ([]byte, error)
+}
+
+var errTileMissing = errors.New("tile is not cached")
+
+// source is the resolved export request
+type source struct {
+	options       Options
+	metadata      *mapprovider.TileMapMetadata
+	minZoom       int
+	maxZoom       int
+	fileExtension string
+}
+
+// newSource validates the options and applies the provider defaults
+func newSource(options Options) (*source, error) {
+	metadata := options.Metadata.GetMetadataWithDefaults()
+	_, fileExtension, _ := strings.Cut(string(metadata.ContentType), "/")
+
+	minZoom, maxZoom := options.MinZoom, options.MaxZoom
+	if minZoom == 0 && maxZoom == 0 {
+		minZoom, maxZoom = metadata.MinZoom, metadata.MaxZoom
+	}
+	if minZoom > maxZoom {
+		return nil, fmt.Errorf("min_zoom %d is greater than max_zoom %d", minZoom, maxZoom)
+	}
+	if options.BBox != nil {
+		if err := options.BBox.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.CacheID == "" {
+		options.CacheID = metadata.ID
+	}
+
+	if options.Cacher == nil {
+		mapPath := filepath.Join(options.CachePath, options.CacheID)
+		if _, err := os.Stat(mapPath); err != nil {
+			return nil, fmt.Errorf("no cached tiles of %s: %w", metadata.ID, err)
+		}
+	}
+
+	return &source{
+		options:       options,
+		metadata:      metadata,
+		minZoom:       minZoom,
+		maxZoom:       maxZoom,
+		fileExtension: fileExtension,
+	}, nil
+}
+
+// tiles returns the tiles to export: the cached files of the directory,
+// or every key of the zoom range and bbox if reading from a Cacher
+// tiles 返回要导出的瓦片：目录中的缓存文件，或从 Cacher 读取时缩放范围和范围框内的所有键
+func (src *source) tiles() ([]tileRef, error) {
+	var refs []tileRef
+
+	if src.options.Cacher != nil {
+		var candidates int64
+		for z := src.minZoom; z <= src.maxZoom; z++ {
+			minX, minY, maxX, maxY := src.tileRange(z)
+			candidates += int64(maxX-minX+1) * int64(maxY-minY+1)
+			if candidates > maxCacherCandidates {
+				return nil, fmt.Errorf("too many tiles to look up in zoom [%d, %d] (over %d), set a smaller bbox or zoom range", src.minZoom, src.maxZoom, maxCacherCandidates)
+			}
+		}
+
+		cacher, contentType := src.options.Cacher, string(src.metadata.ContentType)
+		for z := src.minZoom; z <= src.maxZoom; z++ {
+			minX, minY, maxX, maxY := src.tileRange(z)
+			for x := minX; x <= maxX; x++ {
+				for y := minY; y <= maxY; y++ {
+					key := utils.TileCacheKey(src.options.CacheID, z, x, y, contentType)
+					refs = append(refs, tileRef{z: z, x: x, y: y, load: func() ([]byte, error) {
+						data, err := cacher.GetCache(key)
+						if err != nil || len(data) == 0 {
+							return nil, errTileMissing
+						}
+						return data, nil
+					}})
+				}
+			}
+		}
+		return refs, nil
+	}
+
+	mapPath := filepath.Join(src.options.CachePath, src.options.CacheID)
+	for z := src.minZoom; z <= src.maxZoom; z++ {
+		err := forEachCachedTile(filepath.Join(mapPath, strconv.Itoa(z)), z, src.fileExtension, src.options.BBox, func(x, y int, path string) error {
+			refs = append(refs, tileRef{z: z, x: x, y: y, load: func() ([]byte, error) {
+				return os.ReadFile(path)
+			}})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+func (src *source) tileRange(z int) (minX, minY, maxX, maxY int) {
+	if src.options.BBox != nil {
+		return src.options.BBox.TileRange(z)
+	}
+	return 0, 0, (1 << z) - 1, (1 << z) - 1
+}
+
+// export loads the tiles in order and writes them, missing tiles of a Cacher are skipped
+// export 按顺序加载并写入瓦片，跳过 Cacher 中不存在的瓦片
+func (src *source) export(refs []tileRef, write func(z, x, y int, data []byte) error) (*Result, error) {
+	result := newResult()
+	for _, ref := range refs {
+		data, err := ref.load()
+		if errors.Is(err, errTileMissing) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := write(ref.z, ref.x, ref.y, data); err != nil {
+			return nil, fmt.Errorf("write tile %d/%d/%d error: %w", ref.z, ref.x, ref.y, err)
+		}
+		result.add(ref.z, ref.x, ref.y)
+	}
+
+	if result.Tiles == 0 {
+		return nil, fmt.Errorf("no cached tiles of %s in zoom [%d, %d]", src.metadata.ID, src.minZoom, src.maxZoom)
+	}
+	result.clampBounds(src.options.BBox)
+	return result, nil
+}
+
+// forEachCachedTile calls fn for every `<x>/<y>.<ext>` file under the zoom directory, filtered by the bbox
+// forEachCachedTile 对缩放级别目录下的每个 `<x>/<y>.<ext>` 文件调用 fn，并按范围框过滤
+func forEachCachedTile(zoomPath string, z int, fileExtension string, bbox *mapprovider.BBox, fn func(x, y int, path string) error) error {
+	minX, minY, maxX, maxY := 0, 0, (1<<z)-1, (1<<z)-1
+	if bbox != nil {
+		minX, minY, maxX, maxY = bbox.TileRange(z)
+	}
+
+	columns, err := os.ReadDir(zoomPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		x, err := strconv.Atoi(column.Name())
+		if err != nil || !column.IsDir() || x < minX || x > maxX {
+			continue
+		}
+
+		rows, err := os.ReadDir(filepath.Join(zoomPath, column.Name()))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			name, ext, ok := strings.Cut(row.Name(), ".")
+			if !ok || ext != fileExtension || row.IsDir() {
+				continue
+			}
+			y, err := strconv.Atoi(name)
+			if err != nil || y < minY || y > maxY {
+				continue
+			}
+			if err := fn(x, y, filepath.Join(zoomPath, column.Name(), row.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
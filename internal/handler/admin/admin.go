@@ -0,0 +1,52 @@
+// admin handler
+// 管理接口处理器
+package admin
+
+import (
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/model"
+	"go-map-proxy/internal/utils"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReloadConfig reloads the config file and returns the applied changes
+// ReloadConfig 重载配置文件并返回已应用的变更
+func ReloadConfig(c echo.Context) error {
+	changes, err := config.Reload()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, model.BaseAPIResponse[any]{
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Reload config error: %v", err),
+			Data:    nil,
+		})
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[[]string]{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("Reload config success, %d changes", len(changes)),
+		Data:    changes,
+	})
+}
+
+// CacheStats returns the statistics of the tile cache, e.g. usage and eviction counts
+// CacheStats 返回瓦片缓存的统计信息，例如占用和淘汰次数
+func CacheStats(c echo.Context) error {
+	cache := utils.GetCache()
+	reporter, ok := cache.(utils.StatsReporter)
+	if !ok {
+		return c.JSON(http.StatusOK, model.BaseAPIResponse[any]{
+			Code:    http.StatusOK,
+			Message: fmt.Sprintf("Cache %T has no statistics", cache),
+			Data:    nil,
+		})
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[any]{
+		Code:    http.StatusOK,
+		Message: "Get cache stats success",
+		Data:    reporter.Stats(),
+	})
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/export"
+	"go-map-proxy/internal/model"
+	"go-map-proxy/pkg/mapprovider"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ExportHandler exposes the export jobs, exports run in background and are downloaded once done
+// ExportHandler 提供导出任务接口，导出在后台运行，完成后下载
+type ExportHandler struct {
+	manager *export.Manager
+}
+
+func NewExportHandler(manager *export.Manager) *ExportHandler {
+	return &ExportHandler{manager: manager}
+}
+
+// ExportMBTiles starts exporting the cached tiles of a map to an MBTiles file
+// e.g. `POST /admin/export/mbtiles/open_street_map_standard/?min_zoom=0&max_zoom=14&bbox=113,22.5,114,23.5`
+// ExportMBTiles 开始将地图的缓存瓦片导出为 MBTiles 文件
+func (handler *ExportHandler) ExportMBTiles(c echo.Context) error {
+	return handler.start(c, "mbtiles", export.MBTiles)
+}
+
+// ExportPMTiles starts exporting the cached tiles of a map to a PMTiles archive
+// e.g. `POST /admin/export/pmtiles/open_street_map_standard/?min_zoom=0&max_zoom=14&bbox=113,22.5,114,23.5`
+// ExportPMTiles 开始将地图的缓存瓦片导出为 PMTiles 归档
+func (handler *ExportHandler) ExportPMTiles(c echo.Context) error {
+	return handler.start(c, "pmtiles", export.PMTiles)
+}
+
+// start parses the export options and starts the export job in the format
+func (handler *ExportHandler) start(c echo.Context, format string, exporter export.Exporter) error {
+	mapType := c.Param("mapType")
+	provider, ok := mapprovider.GetRegistry().GetProvider(mapType)
+	if !ok {
+		return exportError(c, http.StatusNotFound, fmt.Errorf("tile map source %s not found", mapType))
+	}
+
+	options := export.Options{
+		CachePath: config.GetConfig().Cache.Path,
+		Metadata:  provider.GetMapMetadata(),
+		CacheID:   mapprovider.CacheID(provider),
+	}
+	var err error
+	if minZoom := c.QueryParam("min_zoom"); minZoom != "" {
+		if options.MinZoom, err = strconv.Atoi(minZoom); err != nil {
+			return exportError(c, http.StatusBadRequest, fmt.Errorf("invalid min_zoom: %w", err))
+		}
+	}
+	if maxZoom := c.QueryParam("max_zoom"); maxZoom != "" {
+		if options.MaxZoom, err = strconv.Atoi(maxZoom); err != nil {
+			return exportError(c, http.StatusBadRequest, fmt.Errorf("invalid max_zoom: %w", err))
+		}
+	}
+	if bboxStr := c.QueryParam("bbox"); bboxStr != "" {
+		bbox, err := mapprovider.ParseBBox(bboxStr)
+		if err != nil {
+			return exportError(c, http.StatusBadRequest, err)
+		}
+		options.BBox = &bbox
+	}
+
+	job, err := handler.manager.Start(mapType, format, exporter, options)
+	if err != nil {
+		return exportError(c, http.StatusBadRequest, err)
+	}
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[export.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Create export job success",
+		Data:    job,
+	})
+}
+
+// ListJobs returns all export jobs
+func (handler *ExportHandler) ListJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[[]export.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Get export jobs success",
+		Data:    handler.manager.List(),
+	})
+}
+
+// GetJob returns the status of an export job
+func (handler *ExportHandler) GetJob(c echo.Context) error {
+	job, err := handler.manager.Get(c.Param("id"))
+	if err != nil {
+		return exportJobError(c, err)
+	}
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[export.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Get export job success",
+		Data:    job,
+	})
+}
+
+// DownloadJob serves the file of a done export job as attachment
+func (handler *ExportHandler) DownloadJob(c echo.Context) error {
+	job, path, err := handler.manager.File(c.Param("id"))
+	if err != nil {
+		return exportJobError(c, err)
+	}
+	return c.Attachment(path, job.MapID+"."+job.Format)
+}
+
+// RemoveJob deletes a finished export job and its file
+func (handler *ExportHandler) RemoveJob(c echo.Context) error {
+	if err := handler.manager.Remove(c.Param("id")); err != nil {
+		return exportJobError(c, err)
+	}
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[any]{
+		Code:    http.StatusOK,
+		Message: "Remove export job success",
+		Data:    nil,
+	})
+}
+
+func exportJobError(c echo.Context, err error) error {
+	code := http.StatusBadRequest
+	if errors.Is(err, export.ErrJobNotFound) {
+		code = http.StatusNotFound
+	}
+	return exportError(c, code, err)
+}
+
+func exportError(c echo.Context, code int, err error) error {
+	return c.JSON(code, model.BaseAPIResponse[any]{
+		Code:    code,
+		Message: fmt.Sprintf("Export error: %v", err),
+		Data:    nil,
+	})
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/model"
+	"go-map-proxy/internal/seed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SeedHandler exposes the tile seeding jobs
+// SeedHandler 提供瓦片预热任务接口
+type SeedHandler struct {
+	manager *seed.Manager
+}
+
+func NewSeedHandler(manager *seed.Manager) *SeedHandler {
+	return &SeedHandler{manager: manager}
+}
+
+// CreateJob starts a seeding job
+// e.g. `{"provider_id": "open_street_map_standard", "min_zoom": 0, "max_zoom": 12, "bbox": [113.0, 22.5, 114.0, 23.5]}`
+func (handler *SeedHandler) CreateJob(c echo.Context) error {
+	var request seed.JobRequest
+	if err := c.Bind(&request); err != nil {
+		return c.JSON(http.StatusBadRequest, model.BaseAPIResponse[any]{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Invalid seed job request: %v", err),
+			Data:    nil,
+		})
+	}
+
+	job, err := handler.manager.Create(request)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.BaseAPIResponse[any]{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("Create seed job error: %v", err),
+			Data:    nil,
+		})
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[seed.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Create seed job success",
+		Data:    job.Info(),
+	})
+}
+
+// ListJobs returns all seeding jobs
+func (handler *SeedHandler) ListJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[[]seed.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Get seed jobs success",
+		Data:    handler.manager.List(),
+	})
+}
+
+// GetJob returns the progress of a seeding job
+func (handler *SeedHandler) GetJob(c echo.Context) error {
+	job, err := handler.manager.Get(c.Param("id"))
+	if err != nil {
+		return jobError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[seed.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Get seed job success",
+		Data:    job.Info(),
+	})
+}
+
+// CancelJob stops a running seeding job
+func (handler *SeedHandler) CancelJob(c echo.Context) error {
+	job, err := handler.manager.Cancel(c.Param("id"))
+	if err != nil {
+		return jobError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[seed.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Cancel seed job success",
+		Data:    job.Info(),
+	})
+}
+
+// RetryJob fetches the failed tiles of a stopped seeding job again
+func (handler *SeedHandler) RetryJob(c echo.Context) error {
+	job, err := handler.manager.Retry(c.Param("id"))
+	if err != nil {
+		return jobError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, model.BaseAPIResponse[seed.JobInfo]{
+		Code:    http.StatusOK,
+		Message: "Retry seed job success",
+		Data:    job.Info(),
+	})
+}
+
+func jobError(c echo.Context, err error) error {
+	code := http.StatusBadRequest
+	if errors.Is(err, seed.ErrJobNotFound) {
+		code = http.StatusNotFound
+	}
+	return c.JSON(code, model.BaseAPIResponse[any]{
+		Code:    code,
+		Message: err.Error(),
+		Data:    nil,
+	})
+}
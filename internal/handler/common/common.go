@@ -22,3 +22,11 @@ func Index(c echo.Context) error {
 func HealthCheck(c echo.Context) error {
 	return c.String(http.StatusOK, "go-map-proxy server is healthy!")
 }
+
+// RequestBaseURL returns the `scheme://host` the client used to reach the server,
+// used to build absolute urls in capabilities documents.
+// The scheme honours `X-Forwarded-Proto` of reverse proxies.
+// RequestBaseURL 返回客户端访问服务器使用的 `scheme://host`，用于在能力文档中生成绝对地址
+func RequestBaseURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host
+}
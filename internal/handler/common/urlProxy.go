@@ -15,7 +15,7 @@ import (
 // http://example.com/proxy?url=http://example.com
 func URLProxy(c echo.Context) error {
 	// init http client
-	HTTPClient := request.DefaultHTTPClient
+	HTTPClient := request.GetDefaultHTTPClient()
 
 	proxyUrl := c.QueryParam("url")
 	if proxyUrl == "" {
@@ -1,16 +1,22 @@
 package handler
 
 import (
+	"go-map-proxy/internal/export"
+	"go-map-proxy/internal/handler/admin"
 	"go-map-proxy/internal/handler/common"
 	"go-map-proxy/internal/handler/geeprotocol"
+	"go-map-proxy/internal/handler/ogc"
+	"go-map-proxy/internal/handler/staticmap"
 	"go-map-proxy/internal/handler/tilemap"
+	"go-map-proxy/internal/middleware"
+	"go-map-proxy/internal/seed"
 	"go-map-proxy/pkg/mapprovider"
 	"go-map-proxy/pkg/request"
 
 	"github.com/labstack/echo/v4"
 )
 
-func RegisterHandlers(echo *echo.Echo) {
+func RegisterHandlers(echo *echo.Echo, seedManager *seed.Manager, exportManager *export.Manager) {
 	// Register all handlers here
 	// e.g. echo.GET("/", common.Index)
 
@@ -26,11 +32,57 @@ func RegisterHandlers(echo *echo.Echo) {
 	tilemapGroup := echo.Group("/map/")
 	tilemapGroup.GET("list/", tilemap.TileMapSourceList)
 	tilemapGroup.Any(":mapType/:z/:x/:y/", tilemap.TileMapHandler)
+	tilemapGroup.GET(":mapType/tilejson.json/", tilemap.TileJSONHandler)
 	tilemapGroup.GET("testpage/", tilemap.TileMapTestPageHandler)
 
+	// static map images with GeoJSON overlays, the overlay may be the POST body
+	// 带 GeoJSON 叠加要素的静态地图图片，叠加要素可以作为 POST 请求体
+	echo.GET("/static/:mapType/", staticmap.StaticMapHandler)
+	echo.POST("/static/:mapType/", staticmap.StaticMapHandler)
+
+	// OGC WMTS service, KVP and RESTful
+	// OGC WMTS 服务，支持 KVP 和 RESTful 编码
+	echo.GET("/wmts/", ogc.WMTSHandler)
+	echo.GET("/wmts/1.0.0/WMTSCapabilities.xml/", ogc.WMTSCapabilitiesHandler)
+	echo.GET("/wmts/rest/:layer/:tileMatrixSet/:tileMatrix/:tileRow/:tileCol/", ogc.WMTSRestTileHandler)
+
+	// OGC WMS service, map images of any extent composed from tiles
+	// OGC WMS 服务，由瓦片合成任意范围的地图图片
+	echo.GET("/wms/", ogc.WMSHandler)
+
+	// OSGeo TMS service, y axis from south
+	// OSGeo TMS 服务，y 轴自南向北
+	echo.GET("/tms/1.0.0/", ogc.TMSServiceHandler)
+	echo.GET("/tms/1.0.0/:mapType/", ogc.TMSTileMapHandler)
+	echo.GET("/tms/1.0.0/:mapType/:z/:x/:y/", ogc.TMSTileHandler)
+
+	// admin api
+	adminGroup := echo.Group("/admin/", middleware.AdminAuthMiddleware())
+	adminGroup.POST("reload/", admin.ReloadConfig)
+	adminGroup.GET("cache/stats/", admin.CacheStats)
+
+	// tile seeding jobs
+	// 瓦片预热任务
+	seedHandler := admin.NewSeedHandler(seedManager)
+	adminGroup.POST("seed/", seedHandler.CreateJob)
+	adminGroup.GET("seed/", seedHandler.ListJobs)
+	adminGroup.GET("seed/:id/", seedHandler.GetJob)
+	adminGroup.DELETE("seed/:id/", seedHandler.CancelJob)
+	adminGroup.POST("seed/:id/retry/", seedHandler.RetryJob)
+
+	// export cached tiles in background, the files are downloaded once done
+	// 在后台导出缓存瓦片，完成后下载文件
+	exportHandler := admin.NewExportHandler(exportManager)
+	adminGroup.POST("export/mbtiles/:mapType/", exportHandler.ExportMBTiles)
+	adminGroup.POST("export/pmtiles/:mapType/", exportHandler.ExportPMTiles)
+	adminGroup.GET("export/jobs/", exportHandler.ListJobs)
+	adminGroup.GET("export/jobs/:id/", exportHandler.GetJob)
+	adminGroup.GET("export/jobs/:id/download/", exportHandler.DownloadJob)
+	adminGroup.DELETE("export/jobs/:id/", exportHandler.RemoveJob)
+
 	// init GEE provider and handler
 	// 初始化 GEE 提供者和处理器
-	geeProvider := mapprovider.NewGoogleEarthEngineProvider(request.DefaultHTTPClient, "")
+	geeProvider := mapprovider.NewGoogleEarthEngineProvider(request.GetDefaultHTTPClient(), "")
 	geeHandler := geeprotocol.NewGEEHandler(geeProvider)
 
 	// GEE protocol proxy server
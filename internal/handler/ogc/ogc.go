@@ -0,0 +1,84 @@
+// OGC and OSGeo web map service protocols (WMTS, WMS, TMS ...) served from the registered tile map providers
+// 基于已注册瓦片地图源提供的 OGC 和 OSGeo 网络地图服务协议（WMTS、WMS、TMS 等）
+package ogc
+
+import (
+	"encoding/xml"
+	"go-map-proxy/pkg/mapprovider"
+	"math"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OWS exception codes
+const (
+	exceptionMissingParameter    = "MissingParameterValue"
+	exceptionInvalidParameter    = "InvalidParameterValue"
+	exceptionOperationNotSupport = "OperationNotSupported"
+	exceptionTileOutOfRange      = "TileOutOfRange"
+)
+
+// half of the Web Mercator world width, in meters
+const webMercatorHalfWorld = 20037508.342789244
+
+// kvpParams are the query parameters of an OGC KVP request, parameter names are case-insensitive
+// kvpParams 是 OGC KVP 请求的查询参数，参数名不区分大小写
+type kvpParams map[string]string
+
+func newKVPParams(c echo.Context) kvpParams {
+	params := make(kvpParams)
+	for name, values := range c.QueryParams() {
+		if len(values) > 0 {
+			params[strings.ToUpper(name)] = values[0]
+		}
+	}
+	return params
+}
+
+type owsExceptionReport struct {
+	XMLName   xml.Name       `xml:"ows:ExceptionReport"`
+	XmlnsOWS  string         `xml:"xmlns:ows,attr"`
+	Version   string         `xml:"version,attr"`
+	Exception []owsException `xml:"ows:Exception"`
+}
+
+type owsException struct {
+	ExceptionCode string `xml:"exceptionCode,attr"`
+	Locator       string `xml:"locator,attr,omitempty"`
+	ExceptionText string `xml:"ows:ExceptionText"`
+}
+
+// owsError writes an OWS exception report, `locator` is the name of the faulty parameter
+// owsError 写出 OWS 异常报告，locator 为出错的参数名
+func owsError(c echo.Context, status int, code, locator, text string) error {
+	return writeXML(c, status, &owsExceptionReport{
+		XmlnsOWS:  "http://www.opengis.net/ows/1.1",
+		Version:   "1.1.0",
+		Exception: []owsException{{ExceptionCode: code, Locator: locator, ExceptionText: text}},
+	})
+}
+
+func writeXML(c echo.Context, status int, document any) error {
+	data, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, echo.MIMEApplicationXMLCharsetUTF8, append([]byte(xml.Header), data...))
+}
+
+// tileInRange reports whether the tile is inside the zoom range of the provider and the tile matrix
+func tileInRange(metadata *mapprovider.TileMapMetadata, z, x, y int) bool {
+	if z < metadata.MinZoom || z > metadata.MaxZoom {
+		return false
+	}
+	return x >= 0 && y >= 0 && x < 1<<z && y < 1<<z
+}
+
+// scaleDenominator returns the OGC scale denominator of Web Mercator tiles at zoom z,
+// based on the standardized rendering pixel size of 0.28 mm
+// scaleDenominator 返回 z 级别 Web Mercator 瓦片的 OGC 比例尺分母，基于 0.28 毫米的标准像素大小
+func scaleDenominator(z int, tileSize int) float64 {
+	resolution := 2 * webMercatorHalfWorld / float64(tileSize) / math.Exp2(float64(z))
+	return resolution / 0.00028
+}
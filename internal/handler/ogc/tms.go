@@ -0,0 +1,184 @@
+package ogc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go-map-proxy/internal/handler/common"
+	"go-map-proxy/internal/handler/tilemap"
+	"go-map-proxy/internal/tileservice"
+	"go-map-proxy/pkg/mapprovider"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OSGeo TMS 1.0.0 service: TileMapService and TileMap resources, tiles with y axis from south
+// ref: https://wiki.osgeo.org/wiki/Tile_Map_Service_Specification
+
+// TMSServiceHandler returns the TileMapService resource listing all registered providers
+// e.g. `/tms/1.0.0/`
+// TMSServiceHandler 返回列出所有已注册地图源的 TileMapService 资源
+func TMSServiceHandler(c echo.Context) error {
+	return writeXML(c, http.StatusOK, newTMSService(mapprovider.GetRegistry(), common.RequestBaseURL(c)))
+}
+
+// TMSTileMapHandler returns the TileMap resource of a provider
+// e.g. `/tms/1.0.0/open_street_map_standard/`
+// TMSTileMapHandler 返回地图源的 TileMap 资源
+func TMSTileMapHandler(c echo.Context) error {
+	mapType := c.Param("mapType")
+	provider, ok := mapprovider.GetRegistry().GetProvider(mapType)
+	if !ok {
+		return tmsError(c, http.StatusNotFound, fmt.Sprintf("tile map %s not found", mapType))
+	}
+	return writeXML(c, http.StatusOK, newTMSTileMap(provider.GetMapMetadata().GetMetadataWithDefaults(), common.RequestBaseURL(c)))
+}
+
+// TMSTileHandler serves the tile with TMS coordinates, y is flipped to the XYZ row of the provider
+// format: /tms/1.0.0/:mapType/:z/:x/:y.:ext
+// TMSTileHandler 按 TMS 坐标返回瓦片，y 翻转为地图源的 XYZ 行号
+func TMSTileHandler(c echo.Context) error {
+	mapType := c.Param("mapType")
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	provider, ok := registry.GetProvider(mapType)
+	if !ok {
+		return tmsError(c, http.StatusNotFound, fmt.Sprintf("tile map %s not found", mapType))
+	}
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+
+	tileY, ext, _ := strings.Cut(c.Param("y"), ".")
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.Atoi(c.Param("x"))
+	y, errY := strconv.Atoi(tileY)
+	if errZ != nil || errX != nil || errY != nil {
+		return tmsError(c, http.StatusBadRequest, "tile coordinates must be integers")
+	}
+	if _, metadataExt, _ := strings.Cut(string(metadata.ContentType), "/"); ext != "" && ext != metadataExt {
+		return tmsError(c, http.StatusBadRequest, fmt.Sprintf("tile extension %s is not supported by %s, expected %s", ext, mapType, metadataExt))
+	}
+
+	x, y, z = mapprovider.TMSToGoogleXY(x, y, z)
+	if !tileInRange(metadata, z, x, y) {
+		return tmsError(c, http.StatusNotFound, fmt.Sprintf("tile is out of the range of %s (zoom %d-%d)", mapType, metadata.MinZoom, metadata.MaxZoom))
+	}
+
+	return tilemap.ServeTile(c, &tileservice.TileMapPathParam{MapType: mapType, X: x, Y: y, Z: z})
+}
+
+type tmsServerError struct {
+	XMLName xml.Name `xml:"TileMapServerError"`
+	Message string   `xml:"Message"`
+}
+
+func tmsError(c echo.Context, status int, message string) error {
+	return writeXML(c, status, &tmsServerError{Message: message})
+}
+
+type tmsService struct {
+	XMLName  xml.Name     `xml:"TileMapService"`
+	Version  string       `xml:"version,attr"`
+	Services string       `xml:"services,attr"`
+	Title    string       `xml:"Title"`
+	Abstract string       `xml:"Abstract"`
+	TileMaps []tmsMapLink `xml:"TileMaps>TileMap"`
+}
+
+type tmsMapLink struct {
+	Title   string `xml:"title,attr"`
+	SRS     string `xml:"srs,attr"`
+	Profile string `xml:"profile,attr"`
+	Href    string `xml:"href,attr"`
+}
+
+type tmsTileMap struct {
+	XMLName        xml.Name `xml:"TileMap"`
+	Version        string   `xml:"version,attr"`
+	TileMapService string   `xml:"tilemapservice,attr"`
+	Title          string   `xml:"Title"`
+	Abstract       string   `xml:"Abstract"`
+	SRS            string   `xml:"SRS"`
+	// meters, fixed-point
+	BoundingBox struct {
+		MinX string `xml:"minx,attr"`
+		MinY string `xml:"miny,attr"`
+		MaxX string `xml:"maxx,attr"`
+		MaxY string `xml:"maxy,attr"`
+	} `xml:"BoundingBox"`
+	Origin struct {
+		X string `xml:"x,attr"`
+		Y string `xml:"y,attr"`
+	} `xml:"Origin"`
+	TileFormat struct {
+		Width     int    `xml:"width,attr"`
+		Height    int    `xml:"height,attr"`
+		MimeType  string `xml:"mime-type,attr"`
+		Extension string `xml:"extension,attr"`
+	} `xml:"TileFormat"`
+	TileSets struct {
+		Profile string       `xml:"profile,attr"`
+		TileSet []tmsTileSet `xml:"TileSet"`
+	} `xml:"TileSets"`
+}
+
+type tmsTileSet struct {
+	Href          string  `xml:"href,attr"`
+	UnitsPerPixel float64 `xml:"units-per-pixel,attr"`
+	Order         int     `xml:"order,attr"`
+}
+
+// newTMSService builds the TileMapService resource, one TileMap link per provider in registry order
+func newTMSService(registry *mapprovider.Registry, baseURL string) *tmsService {
+	service := &tmsService{
+		Version:  "1.0.0",
+		Services: baseURL + "/tms/",
+		Title:    "go-map-proxy",
+		Abstract: "Tile map providers of go-map-proxy",
+	}
+	for _, kv := range registry.MapSourceSlice {
+		metadata := kv.Value.GetMapMetadata().GetMetadataWithDefaults()
+		service.TileMaps = append(service.TileMaps, tmsMapLink{
+			Title:   metadata.Name,
+			SRS:     "EPSG:3857",
+			Profile: "global-mercator",
+			Href:    fmt.Sprintf("%s/tms/1.0.0/%s/", baseURL, metadata.ID),
+		})
+	}
+	return service
+}
+
+// newTMSTileMap builds the TileMap resource of a provider: the Web Mercator square with the origin
+// at the south-west corner, and one TileSet per zoom level of the provider
+// newTMSTileMap 构建地图源的 TileMap 资源：原点位于西南角的 Web Mercator 正方形，地图源每个缩放级别一个 TileSet
+func newTMSTileMap(metadata *mapprovider.TileMapMetadata, baseURL string) *tmsTileMap {
+	tileMap := &tmsTileMap{
+		Version:        "1.0.0",
+		TileMapService: baseURL + "/tms/1.0.0/",
+		Title:          metadata.Name,
+		Abstract:       fmt.Sprintf("%s (%s), coordinate type %s", metadata.Name, metadata.ID, metadata.CoordinateType),
+		SRS:            "EPSG:3857",
+	}
+	minXY, maxXY := fmt.Sprintf("%.8f", -webMercatorHalfWorld), fmt.Sprintf("%.8f", webMercatorHalfWorld)
+	tileMap.BoundingBox.MinX, tileMap.BoundingBox.MinY = minXY, minXY
+	tileMap.BoundingBox.MaxX, tileMap.BoundingBox.MaxY = maxXY, maxXY
+	tileMap.Origin.X, tileMap.Origin.Y = minXY, minXY
+
+	_, ext, _ := strings.Cut(string(metadata.ContentType), "/")
+	tileMap.TileFormat.Width = int(metadata.MapSize)
+	tileMap.TileFormat.Height = int(metadata.MapSize)
+	tileMap.TileFormat.MimeType = string(metadata.ContentType)
+	tileMap.TileFormat.Extension = ext
+
+	tileMap.TileSets.Profile = "global-mercator"
+	for z := metadata.MinZoom; z <= metadata.MaxZoom; z++ {
+		tileMap.TileSets.TileSet = append(tileMap.TileSets.TileSet, tmsTileSet{
+			Href:          fmt.Sprintf("%s/tms/1.0.0/%s/%d", baseURL, metadata.ID, z),
+			UnitsPerPixel: 2 * webMercatorHalfWorld / float64(metadata.MapSize) / math.Exp2(float64(z)),
+			Order:         z,
+		})
+	}
+	return tileMap
+}
@@ -0,0 +1,77 @@
+package ogc
+
+import (
+	"encoding/xml"
+	"go-map-proxy/pkg/mapprovider"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestTMSTileMap(t *testing.T) {
+	metadata := (&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 2, MaxZoom: 4, MapSize: mapprovider.MapSize512}).GetMetadataWithDefaults()
+	data, err := xml.Marshal(newTMSTileMap(metadata, "http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		Origin struct {
+			X string `xml:"x,attr"`
+		} `xml:"Origin"`
+		TileFormat struct {
+			Width     int    `xml:"width,attr"`
+			Extension string `xml:"extension,attr"`
+		} `xml:"TileFormat"`
+		TileSets []struct {
+			Href          string  `xml:"href,attr"`
+			UnitsPerPixel float64 `xml:"units-per-pixel,attr"`
+			Order         int     `xml:"order,attr"`
+		} `xml:"TileSets>TileSet"`
+	}
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Origin.X != "-20037508.34278924" || parsed.TileFormat.Width != 512 || parsed.TileFormat.Extension != "png" {
+		t.Errorf("unexpected tile map: %s", data)
+	}
+	if len(parsed.TileSets) != 3 || parsed.TileSets[0].Order != 2 || parsed.TileSets[0].Href != "http://example.com/tms/1.0.0/roads/2" {
+		t.Fatalf("unexpected tile sets: %+v", parsed.TileSets)
+	}
+	// 512px tiles at zoom 2: 40075016.69 m / 2048 px
+	if upp := parsed.TileSets[0].UnitsPerPixel; upp < 19567.87 || upp > 19567.88 {
+		t.Errorf("unexpected units per pixel %f", upp)
+	}
+}
+
+func TestTMSTileHandlerErrors(t *testing.T) {
+	registry := newTestRegistry(&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 2, MaxZoom: 5})
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+
+	e := echo.New()
+	for _, tc := range []struct {
+		mapType, z, x, y string
+		status           int
+	}{
+		{"rails", "3", "1", "1.png", http.StatusNotFound},
+		{"roads", "3", "a", "1.png", http.StatusBadRequest},
+		{"roads", "3", "1", "1.jpeg", http.StatusBadRequest},
+		{"roads", "1", "0", "0.png", http.StatusNotFound},
+		{"roads", "3", "1", "8.png", http.StatusNotFound},
+	} {
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), recorder)
+		c.SetParamNames("mapType", "z", "x", "y")
+		c.SetParamValues(tc.mapType, tc.z, tc.x, tc.y)
+		if err := TMSTileHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != tc.status || !strings.Contains(recorder.Body.String(), "<TileMapServerError>") {
+			t.Errorf("%+v: unexpected response %d %s", tc, recorder.Code, recorder.Body.String())
+		}
+	}
+}
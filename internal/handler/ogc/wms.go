@@ -0,0 +1,579 @@
+package ogc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/handler/common"
+	"go-map-proxy/internal/handler/tilemap"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WMS 1.3.0 service: GetCapabilities and GetMap, map images of any extent are composed from the tiles of the providers
+// ref: https://www.ogc.org/standard/wms/ (OGC 06-042)
+
+const wmsVersion = "1.3.0"
+
+// supported CRS, EPSG:4326 has the latitude first in WMS 1.3.0 while CRS:84 has the longitude first
+// 支持的坐标参考系，WMS 1.3.0 中 EPSG:4326 纬度在前，CRS:84 经度在前
+const (
+	crsWebMercator = "EPSG:3857"
+	crsWGS84       = "EPSG:4326"
+	crsCRS84       = "CRS:84"
+)
+
+var wmsCRSs = []string{crsWebMercator, crsWGS84, crsCRS84}
+
+// slots of concurrent GetMap renders, bounding the memory of canvases and mosaics
+// GetMap 并发渲染槽位，限制画布和镶嵌图占用的内存
+var wmsRenderSlots = make(chan struct{}, wmsMaxConcurrentRenders)
+
+var wmsFormats = []string{"image/png", "image/jpeg"}
+
+const (
+	// max width and height of GetMap images
+	wmsMaxImageSize = 4096
+	// max pixels of the tile mosaic of one layer relative to the image pixels, the zoom is lowered until the mosaic fits.
+	// A 2x2 tile mosaic is always allowed, since a small image may straddle tile boundaries
+	// 单个图层瓦片镶嵌图的最大像素数相对图片像素数的倍数，超出时降低缩放级别；始终允许 2x2 瓦片，小图片也可能跨越瓦片边界
+	wmsMaxMosaicPixelRatio = 4
+	// GetMap images rendered at the same time, other requests wait for a slot
+	wmsMaxConcurrentRenders = 4
+	// quality of JPEG images
+	wmsJPEGQuality = 90
+)
+
+// WMS exception codes
+const (
+	wmsExceptionInvalidFormat   = "InvalidFormat"
+	wmsExceptionInvalidCRS      = "InvalidCRS"
+	wmsExceptionLayerNotDefined = "LayerNotDefined"
+	wmsExceptionStyleNotDefined = "StyleNotDefined"
+)
+
+// WMSHandler handles the KVP requests
+// e.g. `/wms?SERVICE=WMS&REQUEST=GetCapabilities`
+// `/wms?SERVICE=WMS&VERSION=1.3.0&REQUEST=GetMap&LAYERS=open_street_map_standard&STYLES=&CRS=EPSG:4326&BBOX=39.8,116.3,40.0,116.5&WIDTH=800&HEIGHT=800&FORMAT=image/png`
+// `cache=false` skips the tile cache like the tile map handler
+// WMSHandler 处理 KVP 编码的请求，cache=false 与瓦片处理器一样跳过瓦片缓存
+func WMSHandler(c echo.Context) error {
+	params := newKVPParams(c)
+
+	if service := params["SERVICE"]; service != "" && !strings.EqualFold(service, "WMS") {
+		return wmsError(c, http.StatusBadRequest, exceptionInvalidParameter, "service", fmt.Sprintf("service %s is not supported", service))
+	}
+
+	switch request := params["REQUEST"]; {
+	case request == "":
+		return wmsError(c, http.StatusBadRequest, exceptionMissingParameter, "request", "request is required")
+	case strings.EqualFold(request, "GetCapabilities"):
+		return writeXML(c, http.StatusOK, newWMSCapabilities(mapprovider.GetRegistry(), common.RequestBaseURL(c)))
+	case strings.EqualFold(request, "GetMap"):
+		return wmsGetMap(c, params)
+	default:
+		return wmsError(c, http.StatusBadRequest, exceptionOperationNotSupport, "request", fmt.Sprintf("request %s is not supported", request))
+	}
+}
+
+// wmsGetMap renders the layers in order over the background and writes the encoded image
+// wmsGetMap 按顺序将图层绘制到背景上并写出编码后的图片
+func wmsGetMap(c echo.Context, params kvpParams) error {
+	request, err := parseWMSMapRequest(params)
+	if err != nil {
+		return writeWMSRequestError(c, err)
+	}
+
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	providers := make([]mapprovider.TileMapProvider, 0, len(request.layers))
+	for _, layer := range request.layers {
+		provider, ok := registry.GetProvider(layer)
+		if !ok {
+			return wmsError(c, http.StatusBadRequest, wmsExceptionLayerNotDefined, "layers", fmt.Sprintf("layer %s not found", layer))
+		}
+		providers = append(providers, provider)
+	}
+
+	select {
+	case wmsRenderSlots <- struct{}{}:
+		defer func() { <-wmsRenderSlots }()
+	case <-c.Request().Context().Done():
+		return c.Request().Context().Err()
+	}
+
+	useCache := params["CACHE"] != "false"
+	img := request.newCanvas()
+	for i, provider := range providers {
+		metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+		grid, err := request.tileGrid(metadata)
+		if err != nil {
+			return writeWMSRequestError(c, err)
+		}
+
+		mapType := request.layers[i]
+		if err := renderWMSLayer(img, request, grid, tilemap.ProviderTileFetcher(provider, mapType, useCache)); err != nil {
+			logger.Errorf("Render WMS layer %s error: %v", mapType, err)
+			return wmsError(c, http.StatusInternalServerError, "", "layers", fmt.Sprintf("render layer %s error: %v", mapType, err))
+		}
+	}
+
+	data, err := encodeWMSImage(img, request.format)
+	if err != nil {
+		return wmsError(c, http.StatusInternalServerError, "", "format", fmt.Sprintf("encode image error: %v", err))
+	}
+	return c.Blob(http.StatusOK, request.format, data)
+}
+
+type wmsExceptionReport struct {
+	XMLName   xml.Name       `xml:"ServiceExceptionReport"`
+	Xmlns     string         `xml:"xmlns,attr"`
+	Version   string         `xml:"version,attr"`
+	Exception []wmsException `xml:"ServiceException"`
+}
+
+type wmsException struct {
+	Code    string `xml:"code,attr,omitempty"`
+	Locator string `xml:"locator,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// wmsError writes a WMS service exception report, `locator` is the name of the faulty parameter
+// wmsError 写出 WMS 服务异常报告，locator 为出错的参数名
+func wmsError(c echo.Context, status int, code, locator, text string) error {
+	return writeXML(c, status, &wmsExceptionReport{
+		Xmlns:     "http://www.opengis.net/ogc",
+		Version:   wmsVersion,
+		Exception: []wmsException{{Code: code, Locator: locator, Text: text}},
+	})
+}
+
+// wmsRequestError is an invalid parameter of a GetMap request
+type wmsRequestError struct {
+	code    string
+	locator string
+	text    string
+}
+
+func (err *wmsRequestError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", err.code, err.locator, err.text)
+}
+
+func writeWMSRequestError(c echo.Context, err error) error {
+	var requestErr *wmsRequestError
+	if errors.As(err, &requestErr) {
+		return wmsError(c, http.StatusBadRequest, requestErr.code, requestErr.locator, requestErr.text)
+	}
+	return wmsError(c, http.StatusBadRequest, exceptionInvalidParameter, "", err.Error())
+}
+
+// wmsMapRequest is a parsed GetMap request
+type wmsMapRequest struct {
+	layers []string
+	crs    string
+	// min x, min y, max x, max y with x to the east and y to the north, i.e. lon/lat for EPSG:4326
+	// 最小 x、最小 y、最大 x、最大 y，x 向东、y 向北，即 EPSG:4326 为经度/纬度
+	bbox        [4]float64
+	width       int
+	height      int
+	format      string
+	transparent bool
+	bgColor     color.RGBA
+}
+
+// parseWMSMapRequest checks the GetMap parameters, the layers are not looked up
+// parseWMSMapRequest 校验 GetMap 参数，不查找图层是否存在
+func parseWMSMapRequest(params kvpParams) (*wmsMapRequest, error) {
+	if version := params["VERSION"]; version != "" && version != wmsVersion {
+		return nil, &wmsRequestError{exceptionInvalidParameter, "version", fmt.Sprintf("version %s is not supported, expected %s", version, wmsVersion)}
+	}
+	for _, name := range []string{"LAYERS", "CRS", "BBOX", "WIDTH", "HEIGHT", "FORMAT"} {
+		if params[name] == "" {
+			return nil, &wmsRequestError{exceptionMissingParameter, strings.ToLower(name), name + " is required"}
+		}
+	}
+
+	request := &wmsMapRequest{
+		layers:  strings.Split(params["LAYERS"], ","),
+		crs:     strings.ToUpper(params["CRS"]),
+		format:  params["FORMAT"],
+		bgColor: color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+
+	for _, style := range strings.Split(params["STYLES"], ",") {
+		if style != "" && !strings.EqualFold(style, "default") {
+			return nil, &wmsRequestError{wmsExceptionStyleNotDefined, "styles", fmt.Sprintf("style %s is not defined", style)}
+		}
+	}
+
+	if request.crs == "EPSG:900913" {
+		request.crs = crsWebMercator
+	}
+	if !slices.Contains(wmsCRSs, request.crs) {
+		return nil, &wmsRequestError{wmsExceptionInvalidCRS, "crs", fmt.Sprintf("crs %s is not supported, expected one of %s", params["CRS"], strings.Join(wmsCRSs, ", "))}
+	}
+
+	values := strings.Split(params["BBOX"], ",")
+	if len(values) != 4 {
+		return nil, &wmsRequestError{exceptionInvalidParameter, "bbox", "bbox must be minx,miny,maxx,maxy"}
+	}
+	for i, value := range values {
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, &wmsRequestError{exceptionInvalidParameter, "bbox", fmt.Sprintf("bbox value %s is not a number", value)}
+		}
+		request.bbox[i] = number
+	}
+	if request.crs == crsWGS84 {
+		// axis order of EPSG:4326 is lat,lon
+		request.bbox = [4]float64{request.bbox[1], request.bbox[0], request.bbox[3], request.bbox[2]}
+	}
+	if request.bbox[0] >= request.bbox[2] || request.bbox[1] >= request.bbox[3] {
+		return nil, &wmsRequestError{exceptionInvalidParameter, "bbox", "bbox min values must be less than max values"}
+	}
+
+	for _, size := range []struct {
+		name  string
+		value *int
+	}{{"width", &request.width}, {"height", &request.height}} {
+		number, err := strconv.Atoi(params[strings.ToUpper(size.name)])
+		if err != nil || number <= 0 || number > wmsMaxImageSize {
+			return nil, &wmsRequestError{exceptionInvalidParameter, size.name, fmt.Sprintf("%s must be an integer between 1 and %d", size.name, wmsMaxImageSize)}
+		}
+		*size.value = number
+	}
+
+	if request.format == "image/jpg" {
+		request.format = "image/jpeg"
+	}
+	if !slices.Contains(wmsFormats, request.format) {
+		return nil, &wmsRequestError{wmsExceptionInvalidFormat, "format", fmt.Sprintf("format %s is not supported, expected one of %s", request.format, strings.Join(wmsFormats, ", "))}
+	}
+
+	request.transparent = strings.EqualFold(params["TRANSPARENT"], "true")
+	if bgColor := params["BGCOLOR"]; bgColor != "" {
+		rgb, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(bgColor), "0x"), 16, 32)
+		if err != nil || rgb > 0xffffff {
+			return nil, &wmsRequestError{exceptionInvalidParameter, "bgcolor", "bgcolor must be a hexadecimal 0xRRGGBB value"}
+		}
+		request.bgColor = color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}
+	}
+
+	return request, nil
+}
+
+// newCanvas returns the image filled with the background, transparent PNG images have no background
+// newCanvas 返回填充了背景色的图片，透明 PNG 图片没有背景
+func (request *wmsMapRequest) newCanvas() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, request.width, request.height))
+	if !request.transparent || request.format != "image/png" {
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: request.bgColor}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// mercatorXY projects the CRS coordinate to Web Mercator meters,
+// ok is false for latitudes outside the Web Mercator world
+// mercatorXY 将坐标参考系坐标投影为 Web Mercator 米，纬度超出 Web Mercator 范围时 ok 为 false
+func (request *wmsMapRequest) mercatorXY(x, y float64) (mx, my float64, ok bool) {
+	if request.crs == crsWebMercator {
+		return x, y, true
+	}
+	if y > mapprovider.MaxMercatorLat || y < -mapprovider.MaxMercatorLat {
+		return 0, 0, false
+	}
+	return x * webMercatorHalfWorld / 180, math.Log(math.Tan((90+y)*math.Pi/360)) * webMercatorHalfWorld / math.Pi, true
+}
+
+// mercatorBounds returns the Web Mercator extent of the bbox, latitudes are clamped to the Web Mercator world
+func (request *wmsMapRequest) mercatorBounds() [4]float64 {
+	minY, maxY := request.bbox[1], request.bbox[3]
+	if request.crs != crsWebMercator {
+		minY = max(minY, -mapprovider.MaxMercatorLat)
+		maxY = min(maxY, mapprovider.MaxMercatorLat)
+	}
+	minMX, minMY, _ := request.mercatorXY(request.bbox[0], minY)
+	maxMX, maxMY, _ := request.mercatorXY(request.bbox[2], maxY)
+	return [4]float64{minMX, minMY, maxMX, maxMY}
+}
+
+// pixelMercator returns the Web Mercator position of the center of the output pixel
+// pixelMercator 返回输出像素中心的 Web Mercator 坐标
+func (request *wmsMapRequest) pixelMercator(px, py int) (mx, my float64, ok bool) {
+	x := request.bbox[0] + (float64(px)+0.5)*(request.bbox[2]-request.bbox[0])/float64(request.width)
+	y := request.bbox[3] - (float64(py)+0.5)*(request.bbox[3]-request.bbox[1])/float64(request.height)
+	return request.mercatorXY(x, y)
+}
+
+// zoom returns the lowest zoom whose tiles are at least as detailed as the output pixels,
+// clamped to the zoom range of the provider
+// zoom 返回瓦片精度不低于输出像素的最小缩放级别，并限制在地图源的缩放范围内
+func (request *wmsMapRequest) zoom(metadata *mapprovider.TileMapMetadata) int {
+	bounds := request.mercatorBounds()
+	resolution := (bounds[2] - bounds[0]) / float64(request.width)
+	// tiles up to about 3% coarser than the output pixels are accepted, so that rounded bboxes
+	// do not fetch 4 times the tiles of the next zoom
+	z := int(math.Ceil(math.Log2(2*webMercatorHalfWorld/float64(metadata.MapSize)/resolution) - 0.05))
+	return min(max(z, metadata.MinZoom), metadata.MaxZoom)
+}
+
+// wmsTileGrid is the range of source tiles covering a request, x may exceed the world and wraps around
+// wmsTileGrid 是覆盖请求范围的源瓦片范围，x 可以超出世界范围并环绕
+type wmsTileGrid struct {
+	z, tileSize            int
+	minX, minY, maxX, maxY int
+}
+
+func (grid wmsTileGrid) count() int {
+	return (grid.maxX - grid.minX + 1) * (grid.maxY - grid.minY + 1)
+}
+
+// pixels returns the pixels of the mosaic of the grid tiles
+func (grid wmsTileGrid) pixels() int {
+	return grid.count() * grid.tileSize * grid.tileSize
+}
+
+// worldPixel converts Web Mercator meters to the pixel position in the world image at the grid zoom
+func (grid wmsTileGrid) worldPixel(mx, my float64) (wx, wy float64) {
+	worldSize := float64(grid.tileSize) * math.Exp2(float64(grid.z))
+	return (mx + webMercatorHalfWorld) / (2 * webMercatorHalfWorld) * worldSize, (webMercatorHalfWorld - my) / (2 * webMercatorHalfWorld) * worldSize
+}
+
+// tileGrid returns the tiles of the provider covering the request, the zoom is lowered
+// while their mosaic exceeds wmsMaxMosaicPixelRatio times the image pixels
+// tileGrid 返回覆盖请求范围的地图源瓦片，镶嵌图像素数超过图片像素数的 wmsMaxMosaicPixelRatio 倍时降低缩放级别
+func (request *wmsMapRequest) tileGrid(metadata *mapprovider.TileMapMetadata) (wmsTileGrid, error) {
+	bounds := request.mercatorBounds()
+	tileSize := int(metadata.MapSize)
+	maxPixels := max(wmsMaxMosaicPixelRatio*request.width*request.height, 4*tileSize*tileSize)
+	for z := request.zoom(metadata); ; z-- {
+		grid := wmsTileGrid{z: z, tileSize: tileSize}
+		minWX, minWY := grid.worldPixel(bounds[0], bounds[3])
+		maxWX, maxWY := grid.worldPixel(bounds[2], bounds[1])
+		last := 1<<z - 1
+		// the max edge belongs to the previous tile when it is on a tile boundary
+		grid.minX = int(math.Floor(minWX / float64(grid.tileSize)))
+		grid.maxX = max(int(math.Ceil(maxWX/float64(grid.tileSize)))-1, grid.minX)
+		grid.minY = min(max(int(math.Floor(minWY/float64(grid.tileSize))), 0), last)
+		grid.maxY = min(max(int(math.Ceil(maxWY/float64(grid.tileSize)))-1, grid.minY), last)
+
+		if grid.pixels() <= maxPixels {
+			return grid, nil
+		}
+		if z <= metadata.MinZoom {
+			return grid, &wmsRequestError{exceptionInvalidParameter, "bbox",
+				fmt.Sprintf("bbox covers %d tiles of layer %s at its min zoom %d, max %d pixels", grid.count(), metadata.ID, z, maxPixels)}
+		}
+	}
+}
+
+// renderWMSLayer stitches the tiles of the grid into a mosaic and draws it over img,
+// each output pixel samples the mosaic bilinearly at its Web Mercator position and is blended over img in place
+// renderWMSLayer 将网格内的瓦片拼接为镶嵌图后绘制到 img 上，每个输出像素在其 Web Mercator 位置对镶嵌图进行双线性采样并原地叠加到 img
+func renderWMSLayer(img *image.RGBA, request *wmsMapRequest, grid wmsTileGrid, fetch tilemap.TileFetcher) error {
+	mosaic, err := tilemap.Mosaic(grid.z, grid.minX, grid.minY, grid.maxX, grid.maxY, grid.tileSize, fetch)
+	if err != nil {
+		return err
+	}
+
+	worldHeight := float64(grid.tileSize) * math.Exp2(float64(grid.z))
+	originX, originY := float64(grid.minX*grid.tileSize), float64(grid.minY*grid.tileSize)
+	for py := 0; py < request.height; py++ {
+		for px := 0; px < request.width; px++ {
+			mx, my, ok := request.pixelMercator(px, py)
+			if !ok {
+				continue
+			}
+			wx, wy := grid.worldPixel(mx, my)
+			if wy < 0 || wy >= worldHeight {
+				continue
+			}
+			// pixel centers are at half pixel positions
+			img.SetRGBA(px, py, over(bilinear(mosaic, wx-originX-0.5, wy-originY-0.5), img.RGBAAt(px, py)))
+		}
+	}
+	return nil
+}
+
+// over composites the alpha-premultiplied src over dst with the arithmetic of draw.Over
+// over 使用与 draw.Over 相同的算法将预乘 alpha 的 src 叠加到 dst 上
+func over(src, dst color.RGBA) color.RGBA {
+	const m = 1<<16 - 1
+	a := (m - uint32(src.A)*0x101) * 0x101
+	blend := func(s, d uint8) uint8 {
+		return uint8((uint32(d)*a/m + uint32(s)*0x101) >> 8)
+	}
+	return color.RGBA{R: blend(src.R, dst.R), G: blend(src.G, dst.G), B: blend(src.B, dst.B), A: blend(src.A, dst.A)}
+}
+
+// bilinear samples the image at the position, positions outside the image are clamped to its edge.
+// RGBA colors are alpha-premultiplied, so the channels are interpolated independently.
+// bilinear 在指定位置对图片进行双线性采样，图片外的位置取边缘像素；RGBA 颜色已预乘 alpha，各通道独立插值
+func bilinear(img *image.RGBA, fx, fy float64) color.RGBA {
+	bounds := img.Bounds()
+	x0, y0 := math.Floor(fx), math.Floor(fy)
+	ax, ay := fx-x0, fy-y0
+	clampX := func(x int) int { return min(max(x, bounds.Min.X), bounds.Max.X-1) }
+	clampY := func(y int) int { return min(max(y, bounds.Min.Y), bounds.Max.Y-1) }
+	left, right := clampX(int(x0)), clampX(int(x0)+1)
+	top, bottom := clampY(int(y0)), clampY(int(y0)+1)
+
+	c00, c10 := img.RGBAAt(left, top), img.RGBAAt(right, top)
+	c01, c11 := img.RGBAAt(left, bottom), img.RGBAAt(right, bottom)
+	lerp := func(v00, v10, v01, v11 uint8) uint8 {
+		top := float64(v00)*(1-ax) + float64(v10)*ax
+		bottom := float64(v01)*(1-ax) + float64(v11)*ax
+		return uint8(math.Round(top*(1-ay) + bottom*ay))
+	}
+	return color.RGBA{
+		R: lerp(c00.R, c10.R, c01.R, c11.R),
+		G: lerp(c00.G, c10.G, c01.G, c11.G),
+		B: lerp(c00.B, c10.B, c01.B, c11.B),
+		A: lerp(c00.A, c10.A, c01.A, c11.A),
+	}
+}
+
+func encodeWMSImage(img *image.RGBA, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: wmsJPEGQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+type wmsCapabilities struct {
+	XMLName    xml.Name `xml:"WMS_Capabilities"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsXlink string   `xml:"xmlns:xlink,attr"`
+	Version    string   `xml:"version,attr"`
+
+	Service struct {
+		Name           string    `xml:"Name"`
+		Title          string    `xml:"Title"`
+		OnlineResource xlinkHref `xml:"OnlineResource"`
+		MaxWidth       int       `xml:"MaxWidth"`
+		MaxHeight      int       `xml:"MaxHeight"`
+	} `xml:"Service"`
+	Capability struct {
+		Request struct {
+			GetCapabilities wmsOperation `xml:"GetCapabilities"`
+			GetMap          wmsOperation `xml:"GetMap"`
+		} `xml:"Request"`
+		Exception []string `xml:"Exception>Format"`
+		Layer     wmsLayer `xml:"Layer"`
+	} `xml:"Capability"`
+}
+
+type wmsOperation struct {
+	Format         []string  `xml:"Format"`
+	OnlineResource xlinkHref `xml:"DCPType>HTTP>Get>OnlineResource"`
+}
+
+type wmsLayer struct {
+	Queryable      int               `xml:"queryable,attr"`
+	Name           string            `xml:"Name,omitempty"`
+	Title          string            `xml:"Title"`
+	CRS            []string          `xml:"CRS"`
+	GeographicBBox wmsGeographicBBox `xml:"EX_GeographicBoundingBox"`
+	BoundingBox    []wmsBoundingBox  `xml:"BoundingBox"`
+	Attribution    *wmsAttribution   `xml:"Attribution,omitempty"`
+	Layer          []wmsLayer        `xml:"Layer"`
+}
+
+type wmsGeographicBBox struct {
+	West  string `xml:"westBoundLongitude"`
+	East  string `xml:"eastBoundLongitude"`
+	South string `xml:"southBoundLatitude"`
+	North string `xml:"northBoundLatitude"`
+}
+
+type wmsBoundingBox struct {
+	CRS  string `xml:"CRS,attr"`
+	MinX string `xml:"minx,attr"`
+	MinY string `xml:"miny,attr"`
+	MaxX string `xml:"maxx,attr"`
+	MaxY string `xml:"maxy,attr"`
+}
+
+type wmsAttribution struct {
+	Title string `xml:"Title"`
+}
+
+// newWMSLayer returns the layer of the provider with its bounds in every supported CRS
+// newWMSLayer 返回地图源的图层，包含所有支持坐标参考系下的范围
+func newWMSLayer(name, title string, bounds mapprovider.BBox) wmsLayer {
+	degrees := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	meters := func(v float64) string { return strconv.FormatFloat(v, 'f', 8, 64) }
+
+	lonLat := &wmsMapRequest{crs: crsCRS84, bbox: bounds}
+	mercator := lonLat.mercatorBounds()
+	return wmsLayer{
+		Name:  name,
+		Title: title,
+		GeographicBBox: wmsGeographicBBox{
+			West: degrees(bounds[0]), East: degrees(bounds[2]), South: degrees(bounds[1]), North: degrees(bounds[3]),
+		},
+		BoundingBox: []wmsBoundingBox{
+			{CRS: crsWebMercator, MinX: meters(mercator[0]), MinY: meters(mercator[1]), MaxX: meters(mercator[2]), MaxY: meters(mercator[3])},
+			// axis order of EPSG:4326 is lat,lon
+			{CRS: crsWGS84, MinX: degrees(bounds[1]), MinY: degrees(bounds[0]), MaxX: degrees(bounds[3]), MaxY: degrees(bounds[2])},
+			{CRS: crsCRS84, MinX: degrees(bounds[0]), MinY: degrees(bounds[1]), MaxX: degrees(bounds[2]), MaxY: degrees(bounds[3])},
+		},
+	}
+}
+
+// newWMSCapabilities builds the capabilities document: a root layer with the supported CRS,
+// and one named layer per provider in registry order
+// newWMSCapabilities 构建能力文档：根图层声明支持的坐标参考系，按注册顺序每个地图源一个命名图层
+func newWMSCapabilities(registry *mapprovider.Registry, baseURL string) *wmsCapabilities {
+	capabilities := &wmsCapabilities{
+		Xmlns:      "http://www.opengis.net/wms",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    wmsVersion,
+	}
+	serviceURL := xlinkHref{Href: baseURL + "/wms?"}
+	capabilities.Service.Name = "WMS"
+	capabilities.Service.Title = "go-map-proxy"
+	capabilities.Service.OnlineResource = serviceURL
+	capabilities.Service.MaxWidth = wmsMaxImageSize
+	capabilities.Service.MaxHeight = wmsMaxImageSize
+
+	capabilities.Capability.Request.GetCapabilities = wmsOperation{Format: []string{"text/xml"}, OnlineResource: serviceURL}
+	capabilities.Capability.Request.GetMap = wmsOperation{Format: wmsFormats, OnlineResource: serviceURL}
+	capabilities.Capability.Exception = []string{"XML"}
+
+	world := mapprovider.BBox{-180, -mapprovider.MaxMercatorLat, 180, mapprovider.MaxMercatorLat}
+	root := newWMSLayer("", "go-map-proxy", world)
+	root.CRS = wmsCRSs
+	for _, kv := range registry.MapSourceSlice {
+		metadata := kv.Value.GetMapMetadata().GetMetadataWithDefaults()
+		bounds := world
+		if metadata.Bounds != nil {
+			bounds = *metadata.Bounds
+		}
+		layer := newWMSLayer(metadata.ID, metadata.Name, bounds)
+		if metadata.Attribution != "" {
+			layer.Attribution = &wmsAttribution{Title: metadata.Attribution}
+		}
+		root.Layer = append(root.Layer, layer)
+	}
+	capabilities.Capability.Layer = root
+
+	return capabilities
+}
@@ -0,0 +1,216 @@
+package ogc
+
+import (
+	"encoding/xml"
+	"errors"
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/pkg/mapprovider"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestWMSCapabilities(t *testing.T) {
+	registry := newTestRegistry(
+		&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", Attribution: "© Roads"},
+		&mapprovider.TileMapMetadata{Name: "City", ID: "city", Bounds: &mapprovider.BBox{116, 39, 117, 40}},
+	)
+	data, err := xml.Marshal(newWMSCapabilities(registry, "http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed struct {
+		GetMapURL struct {
+			Href string `xml:"href,attr"`
+		} `xml:"Capability>Request>GetMap>DCPType>HTTP>Get>OnlineResource"`
+		Root struct {
+			CRS    []string `xml:"CRS"`
+			Layers []struct {
+				Name        string `xml:"Name"`
+				Attribution string `xml:"Attribution>Title"`
+				West        string `xml:"EX_GeographicBoundingBox>westBoundLongitude"`
+				BoundingBox []struct {
+					CRS  string `xml:"CRS,attr"`
+					MinX string `xml:"minx,attr"`
+					MinY string `xml:"miny,attr"`
+				} `xml:"BoundingBox"`
+			} `xml:"Layer"`
+		} `xml:"Capability>Layer"`
+	}
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.GetMapURL.Href != "http://example.com/wms?" || strings.Join(parsed.Root.CRS, ",") != "EPSG:3857,EPSG:4326,CRS:84" {
+		t.Errorf("unexpected capabilities: %s", data)
+	}
+	if len(parsed.Root.Layers) != 2 || parsed.Root.Layers[0].Name != "roads" || parsed.Root.Layers[0].Attribution != "© Roads" {
+		t.Fatalf("unexpected layers: %+v", parsed.Root.Layers)
+	}
+	city := parsed.Root.Layers[1]
+	if city.West != "116.000000" || len(city.BoundingBox) != 3 {
+		t.Fatalf("unexpected city layer: %+v", city)
+	}
+	// EPSG:4326 bounding box is lat,lon
+	if bbox := city.BoundingBox[1]; bbox.CRS != "EPSG:4326" || bbox.MinX != "39.000000" || bbox.MinY != "116.000000" {
+		t.Errorf("unexpected EPSG:4326 bounding box: %+v", bbox)
+	}
+}
+
+func TestParseWMSMapRequest(t *testing.T) {
+	valid := kvpParams{"LAYERS": "roads", "CRS": "EPSG:4326", "BBOX": "39,116,40,117", "WIDTH": "256", "HEIGHT": "128", "FORMAT": "image/jpg"}
+	request, err := parseWMSMapRequest(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.bbox != [4]float64{116, 39, 117, 40} || request.format != "image/jpeg" || request.width != 256 || request.height != 128 {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	for _, tc := range []struct {
+		name, value string
+		code        string
+	}{
+		{"LAYERS", "", exceptionMissingParameter},
+		{"VERSION", "1.1.1", exceptionInvalidParameter},
+		{"STYLES", "fancy", wmsExceptionStyleNotDefined},
+		{"CRS", "EPSG:2385", wmsExceptionInvalidCRS},
+		{"BBOX", "1,2,3", exceptionInvalidParameter},
+		{"BBOX", "40,116,39,117", exceptionInvalidParameter},
+		{"WIDTH", "0", exceptionInvalidParameter},
+		{"HEIGHT", "10000", exceptionInvalidParameter},
+		{"FORMAT", "image/gif", wmsExceptionInvalidFormat},
+		{"BGCOLOR", "0xGG0000", exceptionInvalidParameter},
+	} {
+		params := kvpParams{}
+		for k, v := range valid {
+			params[k] = v
+		}
+		params[tc.name] = tc.value
+
+		_, err := parseWMSMapRequest(params)
+		var requestErr *wmsRequestError
+		if !errors.As(err, &requestErr) || requestErr.code != tc.code {
+			t.Errorf("%s=%s: unexpected error %v", tc.name, tc.value, err)
+		}
+	}
+}
+
+func TestWMSTileGrid(t *testing.T) {
+	metadata := (&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 1, MaxZoom: 10}).GetMetadataWithDefaults()
+	world := [4]float64{-webMercatorHalfWorld, -webMercatorHalfWorld, webMercatorHalfWorld, webMercatorHalfWorld}
+
+	for _, tc := range []struct {
+		bbox          [4]float64
+		width, height int
+		grid          wmsTileGrid
+	}{
+		// exactly the resolution of zoom 1
+		{world, 512, 512, wmsTileGrid{z: 1, tileSize: 256, minX: 0, minY: 0, maxX: 1, maxY: 1}},
+		// slightly more detailed than zoom 1
+		{world, 600, 600, wmsTileGrid{z: 2, tileSize: 256, minX: 0, minY: 0, maxX: 3, maxY: 3}},
+		// clamped to the min zoom, a 2x2 tile mosaic is allowed for small images
+		{world, 16, 16, wmsTileGrid{z: 1, tileSize: 256, minX: 0, minY: 0, maxX: 1, maxY: 1}},
+		// 16x16 tiles at zoom 5 fit 4 times the image pixels
+		{[4]float64{0, 0, webMercatorHalfWorld, webMercatorHalfWorld}, 4096, 4096, wmsTileGrid{z: 5, tileSize: 256, minX: 16, minY: 0, maxX: 31, maxY: 15}},
+		// the mosaic of a stretched image is lowered to zoom 2, whose 2x2 tiles fit 4 times the 4096x16 pixels
+		{[4]float64{0, 0, webMercatorHalfWorld, webMercatorHalfWorld}, 4096, 16, wmsTileGrid{z: 2, tileSize: 256, minX: 2, minY: 0, maxX: 3, maxY: 1}},
+	} {
+		request := &wmsMapRequest{crs: crsWebMercator, bbox: tc.bbox, width: tc.width, height: tc.height}
+		grid, err := request.tileGrid(metadata)
+		if err != nil || grid != tc.grid {
+			t.Errorf("bbox %v width %d: unexpected grid %+v, %v", tc.bbox, tc.width, grid, err)
+		}
+	}
+}
+
+func TestRenderWMSLayer(t *testing.T) {
+	red := color.RGBA{R: 0xff, A: 0xff}
+	blue := color.RGBA{B: 0xff, A: 0xff}
+	tiles := map[int][]byte{0: testutil.SolidTile(t, 256, red), 1: testutil.SolidTile(t, 256, blue)}
+	fetch := func(z, x, y int) ([]byte, error) {
+		if z != 1 {
+			t.Errorf("unexpected zoom %d", z)
+		}
+		if y == 1 {
+			return nil, errors.New("tile missing")
+		}
+		return tiles[x], nil
+	}
+
+	// lon/lat bbox wrapping over the antimeridian, 0.5° per pixel horizontally and 1° vertically
+	request := &wmsMapRequest{crs: crsCRS84, bbox: [4]float64{90, -10, 270, 89}, width: 360, height: 99, format: "image/png", transparent: true}
+	metadata := (&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MaxZoom: 1}).GetMetadataWithDefaults()
+	grid, err := request.tileGrid(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := request.newCanvas()
+	if err := renderWMSLayer(img, request, grid, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		x, y  int
+		color color.RGBA
+	}{
+		{10, 50, blue},         // 95.25°E
+		{170, 50, blue},        // 175.25°E
+		{190, 50, red},         // 185.25°E = 174.75°W, tile x 0 of the next world
+		{350, 50, red},         // 265.25°E = 94.75°W
+		{10, 98, color.RGBA{}}, // 9.5°S, the south tile is missing
+		{10, 1, color.RGBA{}},  // 87.5°N is outside the Web Mercator world
+	} {
+		if got := img.RGBAAt(tc.x, tc.y); got != tc.color {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", tc.x, tc.y, tc.color, got)
+		}
+	}
+}
+
+func TestWMSHandlerErrors(t *testing.T) {
+	registry := newTestRegistry(&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads"})
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+
+	e := echo.New()
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{"SERVICE=WMTS&REQUEST=GetMap", exceptionInvalidParameter},
+		{"SERVICE=WMS", exceptionMissingParameter},
+		{"SERVICE=WMS&REQUEST=GetFeatureInfo", exceptionOperationNotSupport},
+		{"SERVICE=WMS&REQUEST=GetMap&LAYERS=roads,rails&CRS=EPSG:3857&BBOX=0,0,1,1&WIDTH=1&HEIGHT=1&FORMAT=image/png", wmsExceptionLayerNotDefined},
+	} {
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/wms/?"+tc.query, nil), recorder)
+		if err := WMSHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), `code="`+tc.code+`"`) {
+			t.Errorf("%s: unexpected response %d %s", tc.query, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestOverMatchesDraw(t *testing.T) {
+	for _, tc := range []struct{ src, dst color.RGBA }{
+		{color.RGBA{R: 0x80, A: 0x80}, color.RGBA{B: 0xff, A: 0xff}},
+		{color.RGBA{G: 0x33, B: 0x11, A: 0x40}, color.RGBA{R: 0x20, G: 0x60, A: 0x90}},
+		{color.RGBA{}, color.RGBA{R: 0x12, G: 0x34, B: 0x56, A: 0x78}},
+		{color.RGBA{R: 0xff, A: 0xff}, color.RGBA{G: 0xff, A: 0xff}},
+	} {
+		want := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		want.SetRGBA(0, 0, tc.dst)
+		draw.Draw(want, want.Bounds(), &image.Uniform{C: tc.src}, image.Point{}, draw.Over)
+		if got := over(tc.src, tc.dst); got != want.RGBAAt(0, 0) {
+			t.Errorf("%v over %v: expected %v, got %v", tc.src, tc.dst, want.RGBAAt(0, 0), got)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package ogc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"go-map-proxy/internal/handler/common"
+	"go-map-proxy/internal/handler/tilemap"
+	"go-map-proxy/internal/tileservice"
+	"go-map-proxy/pkg/mapprovider"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WMTS 1.0.0 service: GetCapabilities and GetTile in KVP and RESTful encodings
+// ref: https://www.ogc.org/standard/wmts/ (OGC 07-057r7)
+
+// tile matrix set of 256px Web Mercator tiles, sets of other tile sizes use the size as suffix
+// 256 像素 Web Mercator 瓦片的瓦片矩阵集，其他瓦片大小的矩阵集以大小为后缀
+const googleMapsCompatible = "GoogleMapsCompatible"
+
+// tileMatrixSetID returns the tile matrix set id of the tile size
+func tileMatrixSetID(tileSize mapprovider.MapSize) string {
+	if tileSize == mapprovider.MapSize256 {
+		return googleMapsCompatible
+	}
+	return fmt.Sprintf("%s%d", googleMapsCompatible, tileSize)
+}
+
+// WMTSHandler handles the KVP requests
+// e.g. `/wmts?SERVICE=WMTS&REQUEST=GetCapabilities`
+// `/wmts?SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&LAYER=open_street_map_standard&STYLE=default&TILEMATRIXSET=GoogleMapsCompatible&TILEMATRIX=3&TILEROW=2&TILECOL=6&FORMAT=image/png`
+// WMTSHandler 处理 KVP 编码的请求
+func WMTSHandler(c echo.Context) error {
+	params := newKVPParams(c)
+
+	if service := params["SERVICE"]; service != "" && !strings.EqualFold(service, "WMTS") {
+		return owsError(c, http.StatusBadRequest, exceptionInvalidParameter, "service", fmt.Sprintf("service %s is not supported", service))
+	}
+
+	switch request := params["REQUEST"]; {
+	case request == "":
+		return owsError(c, http.StatusBadRequest, exceptionMissingParameter, "request", "request is required")
+	case strings.EqualFold(request, "GetCapabilities"):
+		return WMTSCapabilitiesHandler(c)
+	case strings.EqualFold(request, "GetTile"):
+		for _, name := range []string{"LAYER", "TILEMATRIXSET", "TILEMATRIX", "TILEROW", "TILECOL"} {
+			if params[name] == "" {
+				return owsError(c, http.StatusBadRequest, exceptionMissingParameter, strings.ToLower(name), name+" is required")
+			}
+		}
+		return serveWMTSTile(c, params["LAYER"], params["TILEMATRIXSET"], params["TILEMATRIX"], params["TILEROW"], params["TILECOL"], params["FORMAT"])
+	default:
+		return owsError(c, http.StatusBadRequest, exceptionOperationNotSupport, "request", fmt.Sprintf("request %s is not supported", request))
+	}
+}
+
+// WMTSRestTileHandler handles the RESTful tile requests
+// format: /wmts/rest/:layer/:tileMatrixSet/:tileMatrix/:tileRow/:tileCol.:ext
+// WMTSRestTileHandler 处理 RESTful 编码的瓦片请求
+func WMTSRestTileHandler(c echo.Context) error {
+	tileCol, ext, _ := strings.Cut(c.Param("tileCol"), ".")
+	format := ""
+	if ext != "" {
+		format = "image/" + ext
+	}
+	return serveWMTSTile(c, c.Param("layer"), c.Param("tileMatrixSet"), c.Param("tileMatrix"), c.Param("tileRow"), tileCol, format)
+}
+
+// serveWMTSTile checks the WMTS tile parameters and serves the tile with the tile map handler logic
+// serveWMTSTile 校验 WMTS 瓦片参数，并使用瓦片处理器逻辑返回瓦片
+func serveWMTSTile(c echo.Context, layer, tileMatrixSet, tileMatrix, tileRow, tileCol, format string) error {
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	provider, ok := registry.GetProvider(layer)
+	if !ok {
+		return owsError(c, http.StatusBadRequest, exceptionInvalidParameter, "layer", fmt.Sprintf("layer %s not found", layer))
+	}
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+
+	if tileMatrixSet != tileMatrixSetID(metadata.MapSize) {
+		return owsError(c, http.StatusBadRequest, exceptionInvalidParameter, "tilematrixset",
+			fmt.Sprintf("tile matrix set %s is not supported by layer %s, expected %s", tileMatrixSet, layer, tileMatrixSetID(metadata.MapSize)))
+	}
+	if format != "" && format != string(metadata.ContentType) && !(format == "image/jpg" && metadata.ContentType == mapprovider.MapContentTypeJPEG) {
+		return owsError(c, http.StatusBadRequest, exceptionInvalidParameter, "format",
+			fmt.Sprintf("format %s is not supported by layer %s, expected %s", format, layer, metadata.ContentType))
+	}
+
+	z, errZ := strconv.Atoi(tileMatrix)
+	y, errY := strconv.Atoi(tileRow)
+	x, errX := strconv.Atoi(tileCol)
+	for _, invalid := range []struct {
+		locator string
+		err     error
+	}{{"tilematrix", errZ}, {"tilerow", errY}, {"tilecol", errX}} {
+		if invalid.err != nil {
+			return owsError(c, http.StatusBadRequest, exceptionInvalidParameter, invalid.locator, fmt.Sprintf("%s must be an integer", invalid.locator))
+		}
+	}
+	if !tileInRange(metadata, z, x, y) {
+		return owsError(c, http.StatusBadRequest, exceptionTileOutOfRange, "tilematrix",
+			fmt.Sprintf("tile %d/%d/%d is out of the range of layer %s (zoom %d-%d)", z, x, y, layer, metadata.MinZoom, metadata.MaxZoom))
+	}
+
+	return tilemap.ServeTile(c, &tileservice.TileMapPathParam{MapType: layer, X: x, Y: y, Z: z})
+}
+
+// WMTSCapabilitiesHandler returns the capabilities document of all registered providers
+// e.g. `/wmts/1.0.0/WMTSCapabilities.xml`
+// WMTSCapabilitiesHandler 返回所有已注册地图源的能力文档
+func WMTSCapabilitiesHandler(c echo.Context) error {
+	return writeXML(c, http.StatusOK, newWMTSCapabilities(mapprovider.GetRegistry(), common.RequestBaseURL(c)))
+}
+
+type wmtsCapabilities struct {
+	XMLName    xml.Name `xml:"Capabilities"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsOWS   string   `xml:"xmlns:ows,attr"`
+	XmlnsXlink string   `xml:"xmlns:xlink,attr"`
+	Version    string   `xml:"version,attr"`
+
+	ServiceIdentification struct {
+		Title              string `xml:"ows:Title"`
+		ServiceType        string `xml:"ows:ServiceType"`
+		ServiceTypeVersion string `xml:"ows:ServiceTypeVersion"`
+	} `xml:"ows:ServiceIdentification"`
+	OperationsMetadata struct {
+		Operation []owsOperation `xml:"ows:Operation"`
+	} `xml:"ows:OperationsMetadata"`
+	Contents struct {
+		Layer         []wmtsLayer         `xml:"Layer"`
+		TileMatrixSet []wmtsTileMatrixSet `xml:"TileMatrixSet"`
+	} `xml:"Contents"`
+	ServiceMetadataURL xlinkHref `xml:"ServiceMetadataURL"`
+}
+
+type xlinkHref struct {
+	Href string `xml:"xlink:href,attr"`
+}
+
+type owsOperation struct {
+	Name string   `xml:"name,attr"`
+	Get  []owsGet `xml:"ows:DCP>ows:HTTP>ows:Get"`
+}
+
+// owsGet is a GET endpoint of an operation with its request encoding (KVP or RESTful)
+type owsGet struct {
+	Href     string `xml:"xlink:href,attr"`
+	Encoding string `xml:"ows:Constraint>ows:AllowedValues>ows:Value"`
+}
+
+type wmtsLayer struct {
+	Title             string                `xml:"ows:Title"`
+	Identifier        string                `xml:"ows:Identifier"`
+	WGS84BoundingBox  owsBoundingBox        `xml:"ows:WGS84BoundingBox"`
+	Style             wmtsStyle             `xml:"Style"`
+	Format            string                `xml:"Format"`
+	TileMatrixSetLink wmtsTileMatrixSetLink `xml:"TileMatrixSetLink"`
+	ResourceURL       wmtsResourceURL       `xml:"ResourceURL"`
+}
+
+type owsBoundingBox struct {
+	LowerCorner string `xml:"ows:LowerCorner"`
+	UpperCorner string `xml:"ows:UpperCorner"`
+}
+
+type wmtsStyle struct {
+	IsDefault  bool   `xml:"isDefault,attr"`
+	Identifier string `xml:"ows:Identifier"`
+}
+
+type wmtsTileMatrixSetLink struct {
+	TileMatrixSet    string                 `xml:"TileMatrixSet"`
+	TileMatrixLimits []wmtsTileMatrixLimits `xml:"TileMatrixSetLimits>TileMatrixLimits"`
+}
+
+type wmtsTileMatrixLimits struct {
+	TileMatrix int `xml:"TileMatrix"`
+	MinTileRow int `xml:"MinTileRow"`
+	MaxTileRow int `xml:"MaxTileRow"`
+	MinTileCol int `xml:"MinTileCol"`
+	MaxTileCol int `xml:"MaxTileCol"`
+}
+
+type wmtsResourceURL struct {
+	Format       string `xml:"format,attr"`
+	ResourceType string `xml:"resourceType,attr"`
+	Template     string `xml:"template,attr"`
+}
+
+type wmtsTileMatrixSet struct {
+	Identifier        string           `xml:"ows:Identifier"`
+	SupportedCRS      string           `xml:"ows:SupportedCRS"`
+	WellKnownScaleSet string           `xml:"WellKnownScaleSet,omitempty"`
+	TileMatrix        []wmtsTileMatrix `xml:"TileMatrix"`
+}
+
+type wmtsTileMatrix struct {
+	Identifier       int     `xml:"ows:Identifier"`
+	ScaleDenominator float64 `xml:"ScaleDenominator"`
+	TopLeftCorner    string  `xml:"TopLeftCorner"`
+	TileWidth        int     `xml:"TileWidth"`
+	TileHeight       int     `xml:"TileHeight"`
+	MatrixWidth      int     `xml:"MatrixWidth"`
+	MatrixHeight     int     `xml:"MatrixHeight"`
+}
+
+// newWMTSCapabilities builds the capabilities document: one layer per provider in registry order,
+// and one Web Mercator tile matrix set per tile size up to the max zoom of its layers
+// newWMTSCapabilities 构建能力文档：按注册顺序每个地图源一个图层，每种瓦片大小一个 Web Mercator 瓦片矩阵集，层级到其图层的最大缩放级别
+func newWMTSCapabilities(registry *mapprovider.Registry, baseURL string) *wmtsCapabilities {
+	capabilities := &wmtsCapabilities{
+		Xmlns:      "http://www.opengis.net/wmts/1.0",
+		XmlnsOWS:   "http://www.opengis.net/ows/1.1",
+		XmlnsXlink: "http://www.w3.org/1999/xlink",
+		Version:    "1.0.0",
+	}
+	capabilities.ServiceIdentification.Title = "go-map-proxy"
+	capabilities.ServiceIdentification.ServiceType = "OGC WMTS"
+	capabilities.ServiceIdentification.ServiceTypeVersion = "1.0.0"
+	capabilities.ServiceMetadataURL.Href = baseURL + "/wmts/1.0.0/WMTSCapabilities.xml"
+
+	kvpURL := baseURL + "/wmts?"
+	restURL := baseURL + "/wmts/"
+	for _, name := range []string{"GetCapabilities", "GetTile"} {
+		capabilities.OperationsMetadata.Operation = append(capabilities.OperationsMetadata.Operation, owsOperation{
+			Name: name,
+			Get:  []owsGet{{Href: kvpURL, Encoding: "KVP"}, {Href: restURL, Encoding: "RESTful"}},
+		})
+	}
+
+	// max zoom of each tile size, in order of appearance
+	var tileSizes []mapprovider.MapSize
+	maxZooms := make(map[mapprovider.MapSize]int)
+
+	bbox := owsBoundingBox{
+		LowerCorner: fmt.Sprintf("-180 %.6f", -mapprovider.MaxMercatorLat),
+		UpperCorner: fmt.Sprintf("180 %.6f", mapprovider.MaxMercatorLat),
+	}
+	for _, kv := range registry.MapSourceSlice {
+		metadata := kv.Value.GetMapMetadata().GetMetadataWithDefaults()
+		contentType := string(metadata.ContentType)
+		_, ext, _ := strings.Cut(contentType, "/")
+
+		layer := wmtsLayer{
+			Title:            metadata.Name,
+			Identifier:       metadata.ID,
+			WGS84BoundingBox: bbox,
+			Style:            wmtsStyle{IsDefault: true, Identifier: "default"},
+			Format:           contentType,
+			TileMatrixSetLink: wmtsTileMatrixSetLink{
+				TileMatrixSet: tileMatrixSetID(metadata.MapSize),
+			},
+			ResourceURL: wmtsResourceURL{
+				Format:       contentType,
+				ResourceType: "tile",
+				Template:     fmt.Sprintf("%s/wmts/rest/%s/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.%s", baseURL, metadata.ID, ext),
+			},
+		}
+		for z := metadata.MinZoom; z <= metadata.MaxZoom; z++ {
+			layer.TileMatrixSetLink.TileMatrixLimits = append(layer.TileMatrixSetLink.TileMatrixLimits, wmtsTileMatrixLimits{
+				TileMatrix: z, MaxTileRow: 1<<z - 1, MaxTileCol: 1<<z - 1,
+			})
+		}
+		capabilities.Contents.Layer = append(capabilities.Contents.Layer, layer)
+
+		if maxZoom, ok := maxZooms[metadata.MapSize]; !ok {
+			tileSizes = append(tileSizes, metadata.MapSize)
+			maxZooms[metadata.MapSize] = metadata.MaxZoom
+		} else {
+			maxZooms[metadata.MapSize] = max(maxZoom, metadata.MaxZoom)
+		}
+	}
+
+	for _, tileSize := range tileSizes {
+		tileMatrixSet := wmtsTileMatrixSet{
+			Identifier:   tileMatrixSetID(tileSize),
+			SupportedCRS: "urn:ogc:def:crs:EPSG::3857",
+		}
+		if tileSize == mapprovider.MapSize256 {
+			tileMatrixSet.WellKnownScaleSet = "urn:ogc:def:wkss:OGC:1.0:GoogleMapsCompatible"
+		}
+		for z := 0; z <= maxZooms[tileSize]; z++ {
+			tileMatrixSet.TileMatrix = append(tileMatrixSet.TileMatrix, wmtsTileMatrix{
+				Identifier:       z,
+				ScaleDenominator: scaleDenominator(z, int(tileSize)),
+				TopLeftCorner:    fmt.Sprintf("%.8f %.8f", -webMercatorHalfWorld, webMercatorHalfWorld),
+				TileWidth:        int(tileSize),
+				TileHeight:       int(tileSize),
+				MatrixWidth:      1 << z,
+				MatrixHeight:     1 << z,
+			})
+		}
+		capabilities.Contents.TileMatrixSet = append(capabilities.Contents.TileMatrixSet, tileMatrixSet)
+	}
+
+	return capabilities
+}
@@ -0,0 +1,122 @@
+package ogc
+
+import (
+	"encoding/xml"
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/pkg/mapprovider"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestRegistry(metadata ...*mapprovider.TileMapMetadata) *mapprovider.Registry {
+	registry := &mapprovider.Registry{MapSourceIndex: make(map[string]mapprovider.TileMapProvider)}
+	for _, m := range metadata {
+		provider := &testutil.FakeProvider{Metadata: m}
+		registry.MapSourceSlice = append(registry.MapSourceSlice, mapprovider.MapSourceMappingKV{Key: m.ID, Value: provider})
+		registry.MapSourceIndex[m.ID] = provider
+	}
+	return registry
+}
+
+func TestWMTSCapabilities(t *testing.T) {
+	registry := newTestRegistry(
+		&mapprovider.TileMapMetadata{Name: "Roads & Rails", ID: "roads", MinZoom: 2, MaxZoom: 5, ContentType: mapprovider.MapContentTypeJPEG},
+		&mapprovider.TileMapMetadata{Name: "Retina", ID: "retina", MaxZoom: 3, MapSize: mapprovider.MapSize512},
+		&mapprovider.TileMapMetadata{Name: "Deep", ID: "deep", MaxZoom: 7},
+	)
+	data, err := xml.Marshal(newWMTSCapabilities(registry, "http://example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// parse back without namespaces
+	var parsed struct {
+		Layers []struct {
+			Identifier string `xml:"Identifier"`
+			Format     string `xml:"Format"`
+			Set        string `xml:"TileMatrixSetLink>TileMatrixSet"`
+			Limits     []int  `xml:"TileMatrixSetLink>TileMatrixSetLimits>TileMatrixLimits>TileMatrix"`
+			Resource   struct {
+				Template string `xml:"template,attr"`
+			} `xml:"ResourceURL"`
+		} `xml:"Contents>Layer"`
+		Sets []struct {
+			Identifier string `xml:"Identifier"`
+			Matrices   []struct {
+				Identifier       int     `xml:"Identifier"`
+				ScaleDenominator float64 `xml:"ScaleDenominator"`
+				TileWidth        int     `xml:"TileWidth"`
+				MatrixWidth      int     `xml:"MatrixWidth"`
+			} `xml:"TileMatrix"`
+		} `xml:"Contents>TileMatrixSet"`
+	}
+	if err := xml.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.Layers) != 3 || parsed.Layers[0].Identifier != "roads" || parsed.Layers[1].Identifier != "retina" {
+		t.Fatalf("unexpected layers: %+v", parsed.Layers)
+	}
+	roads := parsed.Layers[0]
+	if roads.Format != "image/jpeg" || roads.Set != "GoogleMapsCompatible" || len(roads.Limits) != 4 || roads.Limits[0] != 2 {
+		t.Errorf("unexpected layer roads: %+v", roads)
+	}
+	if roads.Resource.Template != "http://example.com/wmts/rest/roads/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.jpeg" {
+		t.Errorf("unexpected resource url: %s", roads.Resource.Template)
+	}
+	if parsed.Layers[1].Set != "GoogleMapsCompatible512" {
+		t.Errorf("unexpected tile matrix set of 512px layer: %s", parsed.Layers[1].Set)
+	}
+
+	if len(parsed.Sets) != 2 || parsed.Sets[0].Identifier != "GoogleMapsCompatible" || parsed.Sets[1].Identifier != "GoogleMapsCompatible512" {
+		t.Fatalf("unexpected tile matrix sets: %+v", parsed.Sets)
+	}
+	// 256px set goes up to the max zoom of its layers
+	matrices := parsed.Sets[0].Matrices
+	if len(matrices) != 8 || matrices[7].MatrixWidth != 128 || matrices[0].TileWidth != 256 {
+		t.Errorf("unexpected matrices: %+v", matrices)
+	}
+	// well known GoogleMapsCompatible scale of zoom 0
+	if scale := matrices[0].ScaleDenominator; scale < 559082264.02 || scale > 559082264.03 {
+		t.Errorf("unexpected scale denominator %f", scale)
+	}
+	if retina := parsed.Sets[1].Matrices; len(retina) != 4 || retina[0].TileWidth != 512 || math.Abs(retina[0].ScaleDenominator*2-matrices[0].ScaleDenominator) > 1e-6 {
+		t.Errorf("unexpected 512px matrices: %+v", retina)
+	}
+}
+
+func TestWMTSHandlerErrors(t *testing.T) {
+	registry := newTestRegistry(&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 2, MaxZoom: 5})
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+
+	e := echo.New()
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{"SERVICE=WMS&REQUEST=GetCapabilities", exceptionInvalidParameter},
+		{"service=WMTS", exceptionMissingParameter},
+		{"service=WMTS&request=GetFeatureInfo", exceptionOperationNotSupport},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible&tilematrix=3&tilerow=1", exceptionMissingParameter},
+		{"service=WMTS&request=GetTile&layer=rails&tilematrixset=GoogleMapsCompatible&tilematrix=3&tilerow=1&tilecol=1", exceptionInvalidParameter},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible512&tilematrix=3&tilerow=1&tilecol=1", exceptionInvalidParameter},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible&tilematrix=3&tilerow=1&tilecol=1&format=image/webp", exceptionInvalidParameter},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible&tilematrix=a&tilerow=1&tilecol=1", exceptionInvalidParameter},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible&tilematrix=1&tilerow=0&tilecol=0", exceptionTileOutOfRange},
+		{"service=WMTS&request=GetTile&layer=roads&tilematrixset=GoogleMapsCompatible&tilematrix=3&tilerow=8&tilecol=0", exceptionTileOutOfRange},
+	} {
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/wmts/?"+tc.query, nil), recorder)
+		if err := WMTSHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), `exceptionCode="`+tc.code+`"`) {
+			t.Errorf("%s: expected %s, got %d %s", tc.query, tc.code, recorder.Code, recorder.Body.String())
+		}
+	}
+}
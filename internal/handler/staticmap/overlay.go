@@ -0,0 +1,347 @@
+package staticmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GeoJSON overlays styled by the simplestyle-spec feature properties: `marker-color`, `marker-size`, `stroke`,
+// `stroke-width` and `stroke-opacity`. Points are drawn as markers, lines and polygon rings as paths.
+// ref: https://github.com/mapbox/simplestyle-spec/tree/master/1.1.0
+// GeoJSON 叠加要素，样式取自 simplestyle-spec 要素属性；点绘制为标记，线和多边形环绘制为路径
+
+// max positions of all overlays
+const maxOverlayPositions = 10000
+
+// overlay is one GeoJSON geometry with the style of its feature, positions are `[lon, lat]`
+type overlay struct {
+	markers [][2]float64
+	lines   [][][2]float64
+	style   overlayStyle
+}
+
+type overlayStyle struct {
+	markerColor  color.NRGBA
+	markerRadius float64
+	stroke       color.NRGBA
+	strokeWidth  float64
+}
+
+// simplestyle-spec defaults
+var defaultOverlayStyle = overlayStyle{
+	markerColor:  color.NRGBA{R: 0x7e, G: 0x7e, B: 0x7e, A: 0xff},
+	markerRadius: markerRadiuses["medium"],
+	stroke:       color.NRGBA{R: 0x55, G: 0x55, B: 0x55, A: 0xff},
+	strokeWidth:  2,
+}
+
+// marker radius of `marker-size`, in pixels
+var markerRadiuses = map[string]float64{"small": 5, "medium": 7, "large": 10}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Features    []geoJSONObject `json:"features"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Geometries  []geoJSONObject `json:"geometries"`
+	Properties  map[string]any  `json:"properties"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// GeoJSON position, altitude is ignored
+type position []float64
+
+// overlayParser flattens GeoJSON objects into overlays
+type overlayParser struct {
+	overlays  []overlay
+	positions int
+}
+
+// parseOverlays parses a GeoJSON FeatureCollection, Feature or geometry
+func parseOverlays(data []byte) ([]overlay, error) {
+	var object geoJSONObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	parser := &overlayParser{}
+	if err := parser.add(&object, defaultOverlayStyle); err != nil {
+		return nil, err
+	}
+	return parser.overlays, nil
+}
+
+func (parser *overlayParser) add(object *geoJSONObject, style overlayStyle) error {
+	switch object.Type {
+	case "FeatureCollection":
+		for i := range object.Features {
+			if err := parser.add(&object.Features[i], defaultOverlayStyle); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		style, err := parseOverlayStyle(object.Properties)
+		if err != nil {
+			return err
+		}
+		if object.Geometry == nil {
+			return nil
+		}
+		return parser.add(object.Geometry, style)
+	case "GeometryCollection":
+		for i := range object.Geometries {
+			if err := parser.add(&object.Geometries[i], style); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	result := overlay{style: style}
+	var err error
+	switch object.Type {
+	case "Point":
+		var point position
+		if err = json.Unmarshal(object.Coordinates, &point); err == nil {
+			result.markers, err = parser.convert([]position{point})
+		}
+	case "MultiPoint":
+		var points []position
+		if err = json.Unmarshal(object.Coordinates, &points); err == nil {
+			result.markers, err = parser.convert(points)
+		}
+	case "LineString":
+		var line []position
+		if err = json.Unmarshal(object.Coordinates, &line); err == nil {
+			err = parser.addLines(&result, [][]position{line})
+		}
+	case "MultiLineString", "Polygon":
+		var lines [][]position
+		if err = json.Unmarshal(object.Coordinates, &lines); err == nil {
+			err = parser.addLines(&result, lines)
+		}
+	case "MultiPolygon":
+		var polygons [][][]position
+		if err = json.Unmarshal(object.Coordinates, &polygons); err == nil {
+			for _, rings := range polygons {
+				if err = parser.addLines(&result, rings); err != nil {
+					break
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("GeoJSON type %q is not supported", object.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("%s coordinates: %w", object.Type, err)
+	}
+	parser.overlays = append(parser.overlays, result)
+	return nil
+}
+
+func (parser *overlayParser) addLines(result *overlay, lines [][]position) error {
+	for _, line := range lines {
+		points, err := parser.convert(line)
+		if err != nil {
+			return err
+		}
+		result.lines = append(result.lines, points)
+	}
+	return nil
+}
+
+// convert checks the positions and counts them against maxOverlayPositions
+func (parser *overlayParser) convert(positions []position) ([][2]float64, error) {
+	parser.positions += len(positions)
+	if parser.positions > maxOverlayPositions {
+		return nil, fmt.Errorf("more than %d positions", maxOverlayPositions)
+	}
+	points := make([][2]float64, 0, len(positions))
+	for _, p := range positions {
+		if len(p) < 2 {
+			return nil, fmt.Errorf("position %v must be [lon, lat]", p)
+		}
+		points = append(points, [2]float64{p[0], p[1]})
+	}
+	return points, nil
+}
+
+// parseOverlayStyle reads the simplestyle-spec properties, absent properties keep the defaults
+// parseOverlayStyle 读取 simplestyle-spec 属性，缺省的属性使用默认值
+func parseOverlayStyle(properties map[string]any) (overlayStyle, error) {
+	style := defaultOverlayStyle
+	var err error
+	if value, ok := properties["marker-color"].(string); ok {
+		if style.markerColor, err = parseColor(value); err != nil {
+			return style, err
+		}
+	}
+	if value, ok := properties["marker-size"].(string); ok {
+		radius, ok := markerRadiuses[value]
+		if !ok {
+			return style, fmt.Errorf("marker-size %q must be small, medium or large", value)
+		}
+		style.markerRadius = radius
+	}
+	if value, ok := properties["stroke"].(string); ok {
+		if style.stroke, err = parseColor(value); err != nil {
+			return style, err
+		}
+	}
+	if value, ok := properties["stroke-width"].(float64); ok {
+		if value < 0 || value > 50 {
+			return style, fmt.Errorf("stroke-width %v must be between 0 and 50", value)
+		}
+		style.strokeWidth = value
+	}
+	if value, ok := properties["stroke-opacity"].(float64); ok {
+		if value < 0 || value > 1 {
+			return style, fmt.Errorf("stroke-opacity %v must be between 0 and 1", value)
+		}
+		style.stroke.A = uint8(math.Round(value * 255))
+	}
+	return style, nil
+}
+
+// parseColor parses `#rgb` or `#rrggbb`
+func parseColor(value string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.NRGBA{}, fmt.Errorf("color %q must be #rgb or #rrggbb", value)
+	}
+	return color.NRGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}, nil
+}
+
+// drawOverlays draws the paths of all overlays, then the markers on top of them.
+// project converts `[lon, lat]` to the pixel position in img.
+// drawOverlays 先绘制所有叠加要素的路径，再在其上绘制标记；project 将经纬度转换为 img 中的像素位置
+func drawOverlays(img *image.RGBA, overlays []overlay, project func(lon, lat float64) (x, y float64)) {
+	mask := newCoverageMask(img.Bounds())
+	for _, o := range overlays {
+		if len(o.lines) == 0 || o.style.strokeWidth == 0 {
+			continue
+		}
+		// the whole geometry is one coverage, so joints of translucent paths are not darker
+		// 整个几何使用一个覆盖率，半透明路径的连接处不会加深
+		for _, line := range o.lines {
+			for i := 1; i < len(line); i++ {
+				x0, y0 := project(line[i-1][0], line[i-1][1])
+				x1, y1 := project(line[i][0], line[i][1])
+				mask.strokeSegment(x0, y0, x1, y1, o.style.strokeWidth/2)
+			}
+		}
+		mask.flush(img, o.style.stroke)
+	}
+
+	for _, o := range overlays {
+		for _, marker := range o.markers {
+			x, y := project(marker[0], marker[1])
+			// white outline around the colored disc
+			mask.fillCircle(x, y, o.style.markerRadius+2)
+			mask.flush(img, color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff})
+			mask.fillCircle(x, y, o.style.markerRadius)
+			mask.flush(img, o.style.markerColor)
+		}
+	}
+}
+
+// coverageMask is the anti-aliased coverage of a shape, in [0, 1] per pixel
+type coverageMask struct {
+	bounds image.Rectangle
+	values []float32
+	// pixels covered since the last flush
+	dirty image.Rectangle
+}
+
+func newCoverageMask(bounds image.Rectangle) *coverageMask {
+	return &coverageMask{bounds: bounds, values: make([]float32, bounds.Dx()*bounds.Dy())}
+}
+
+func (mask *coverageMask) cover(x, y int, coverage float64) {
+	if coverage <= 0 {
+		return
+	}
+	i := (y-mask.bounds.Min.Y)*mask.bounds.Dx() + (x - mask.bounds.Min.X)
+	mask.values[i] = max(mask.values[i], float32(min(coverage, 1)))
+	mask.dirty = mask.dirty.Union(image.Rect(x, y, x+1, y+1))
+}
+
+// strokeSegment covers the pixels within radius of the segment, only pixels near the segment are visited
+// strokeSegment 覆盖到线段距离不超过 radius 的像素，只访问线段附近的像素
+func (mask *coverageMask) strokeSegment(x0, y0, x1, y1, radius float64) {
+	dx, dy := x1-x0, y1-y0
+	lengthSq := dx*dx + dy*dy
+	distance := func(px, py float64) float64 {
+		t := 0.0
+		if lengthSq > 0 {
+			t = math.Max(0, math.Min(1, ((px-x0)*dx+(py-y0)*dy)/lengthSq))
+		}
+		return math.Hypot(px-(x0+t*dx), py-(y0+t*dy))
+	}
+	// visit along the major axis, within the half width of the stroke on the minor axis
+	span := radius*1.5 + 2
+	horizontal := math.Abs(dx) >= math.Abs(dy)
+	from, to, minorFrom, minorTo := x0, x1, y0, y1
+	if !horizontal {
+		from, to, minorFrom, minorTo = y0, y1, x0, x1
+	}
+	if from > to {
+		from, to, minorFrom, minorTo = to, from, minorTo, minorFrom
+	}
+	for major := int(math.Floor(from - radius - 1)); major <= int(math.Ceil(to+radius+1)); major++ {
+		center := minorFrom
+		if to > from {
+			t := math.Max(0, math.Min(1, (float64(major)+0.5-from)/(to-from)))
+			center = minorFrom + t*(minorTo-minorFrom)
+		}
+		for minor := int(math.Floor(center - span)); minor <= int(math.Ceil(center+span)); minor++ {
+			x, y := major, minor
+			if !horizontal {
+				x, y = minor, major
+			}
+			if !image.Pt(x, y).In(mask.bounds) {
+				continue
+			}
+			mask.cover(x, y, radius+0.5-distance(float64(x)+0.5, float64(y)+0.5))
+		}
+	}
+}
+
+func (mask *coverageMask) fillCircle(cx, cy, radius float64) {
+	area := image.Rect(int(math.Floor(cx-radius-1)), int(math.Floor(cy-radius-1)), int(math.Ceil(cx+radius+1)), int(math.Ceil(cy+radius+1))).Intersect(mask.bounds)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			mask.cover(x, y, radius+0.5-math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy))
+		}
+	}
+}
+
+// flush blends the color over img with the coverage and clears the mask.
+// RGBA pixels are alpha-premultiplied.
+// flush 按覆盖率将颜色混合到 img 上并清空遮罩，RGBA 像素为预乘 alpha
+func (mask *coverageMask) flush(img *image.RGBA, c color.NRGBA) {
+	for y := mask.dirty.Min.Y; y < mask.dirty.Max.Y; y++ {
+		for x := mask.dirty.Min.X; x < mask.dirty.Max.X; x++ {
+			i := (y-mask.bounds.Min.Y)*mask.bounds.Dx() + (x - mask.bounds.Min.X)
+			alpha := float64(mask.values[i]) * float64(c.A) / 255
+			mask.values[i] = 0
+			if alpha <= 0 {
+				continue
+			}
+			dst := img.RGBAAt(x, y)
+			blend := func(src, dst uint8) uint8 {
+				return uint8(math.Round(float64(src)*alpha + float64(dst)*(1-alpha)))
+			}
+			img.SetRGBA(x, y, color.RGBA{R: blend(c.R, dst.R), G: blend(c.G, dst.G), B: blend(c.B, dst.B), A: blend(0xff, dst.A)})
+		}
+	}
+	mask.dirty = image.Rectangle{}
+}
@@ -0,0 +1,95 @@
+package staticmap
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestParseOverlays(t *testing.T) {
+	overlays, err := parseOverlays([]byte(`{
+		"type": "FeatureCollection",
+		"features": [
+			{"type": "Feature", "properties": {"marker-color": "#f00", "marker-size": "large"}, "geometry": {"type": "Point", "coordinates": [116.4, 39.9, 50]}},
+			{"type": "Feature", "properties": {"stroke": "#0000ff", "stroke-width": 4, "stroke-opacity": 0.5}, "geometry": {"type": "LineString", "coordinates": [[116, 39], [117, 40]]}},
+			{"type": "Feature", "properties": null, "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [1, 1], [0, 0]]], [[[2, 2], [3, 2], [3, 3], [2, 2]]]]}},
+			{"type": "Feature", "properties": {}, "geometry": null}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlays) != 3 {
+		t.Fatalf("expected 3 overlays, got %d", len(overlays))
+	}
+
+	marker := overlays[0]
+	if len(marker.markers) != 1 || marker.markers[0] != [2]float64{116.4, 39.9} {
+		t.Errorf("unexpected markers %v", marker.markers)
+	}
+	if marker.style.markerColor != (color.NRGBA{R: 0xff, A: 0xff}) || marker.style.markerRadius != 10 {
+		t.Errorf("unexpected marker style %+v", marker.style)
+	}
+
+	line := overlays[1]
+	if len(line.lines) != 1 || len(line.lines[0]) != 2 {
+		t.Errorf("unexpected lines %v", line.lines)
+	}
+	if line.style.stroke != (color.NRGBA{B: 0xff, A: 0x80}) || line.style.strokeWidth != 4 {
+		t.Errorf("unexpected line style %+v", line.style)
+	}
+
+	if polygons := overlays[2]; len(polygons.lines) != 2 || polygons.style != defaultOverlayStyle {
+		t.Errorf("unexpected polygons %+v", polygons)
+	}
+}
+
+func TestParseOverlaysErrors(t *testing.T) {
+	for _, tc := range []struct {
+		geoJSON string
+		err     string
+	}{
+		{`{"type": "Point", "coordinates": [1]}`, "must be [lon, lat]"},
+		{`{"type": "Circle", "coordinates": [1, 2]}`, "not supported"},
+		{`{"type": "LineString", "coordinates": [1, 2]}`, "LineString coordinates"},
+		{`{"type": "Feature", "properties": {"stroke": "blue"}, "geometry": {"type": "Point", "coordinates": [1, 2]}}`, "#rgb or #rrggbb"},
+		{`{"type": "Feature", "properties": {"marker-size": "huge"}, "geometry": {"type": "Point", "coordinates": [1, 2]}}`, "small, medium or large"},
+		{`{"type": "MultiPoint", "coordinates": [` + strings.Repeat("[1, 2],", maxOverlayPositions) + `[1, 2]]}`, "more than"},
+	} {
+		_, err := parseOverlays([]byte(tc.geoJSON))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%.60s: expected error %q, got %v", tc.geoJSON, tc.err, err)
+		}
+	}
+}
+
+func TestDrawOverlays(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	overlays := []overlay{
+		// horizontal path along y = 10.5, and back over itself with half opacity
+		{lines: [][][2]float64{{{5, 10}, {50, 10}, {20, 10}}}, style: overlayStyle{stroke: color.NRGBA{B: 0xff, A: 0x80}, strokeWidth: 4}},
+		{markers: [][2]float64{{40, 40}}, style: overlayStyle{markerColor: red, markerRadius: 5}},
+	}
+	// positions are already pixels
+	drawOverlays(img, overlays, func(lon, lat float64) (float64, float64) { return lon + 0.5, lat + 0.5 })
+
+	for _, tc := range []struct {
+		x, y  int
+		color color.RGBA
+	}{
+		// the overlapping part of the path is not darker
+		{30, 10, color.RGBA{B: 0x80, A: 0x80}},
+		{45, 10, color.RGBA{B: 0x80, A: 0x80}},
+		{30, 20, color.RGBA{}},
+		// marker center and outline
+		{40, 40, color.RGBA{R: 0xff, A: 0xff}},
+		{46, 40, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+		{50, 40, color.RGBA{}},
+	} {
+		if got := img.RGBAAt(tc.x, tc.y); got != tc.color {
+			t.Errorf("pixel (%d, %d): expected %v, got %v", tc.x, tc.y, tc.color, got)
+		}
+	}
+}
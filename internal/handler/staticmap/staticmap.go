@@ -0,0 +1,212 @@
+// Static map images: tiles around a center composed into one image with GeoJSON overlays
+// 静态地图图片：将中心点周围的瓦片合成为一张图片并绘制 GeoJSON 叠加要素
+package staticmap
+
+import (
+	"bytes"
+	"fmt"
+	"go-map-proxy/internal/handler/tilemap"
+	"go-map-proxy/internal/model"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// max width and height of static map images
+	maxImageSize = 2048
+	// max size of the GeoJSON overlay
+	maxOverlayBytes = 1 << 20
+	// quality of JPEG images
+	jpegQuality = 90
+)
+
+// staticMapRequest is a parsed static map request
+type staticMapRequest struct {
+	lon, lat float64
+	zoom     int
+	width    int
+	height   int
+	format   string
+	overlays []overlay
+}
+
+// StaticMapHandler returns the image of the provider centered at a WGS84 coordinate
+// format: /static/:mapType?center=<lon>,<lat>&zoom=<z>&width=<px>&height=<px>&format=<png|jpeg>&geojson=<GeoJSON>&cache=<boolean>
+// The GeoJSON overlay may also be the POST body. `X-Map-Bounds` is the WGS84 bbox of the image.
+// StaticMapHandler 返回以 WGS84 坐标为中心的地图源图片，GeoJSON 叠加要素也可以作为 POST 请求体，X-Map-Bounds 为图片的 WGS84 范围
+func StaticMapHandler(c echo.Context) error {
+	mapType := c.Param("mapType")
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	provider, ok := registry.GetProvider(mapType)
+	if !ok {
+		return jsonError(c, http.StatusNotFound, fmt.Sprintf("Tile map source %s not found", mapType))
+	}
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+
+	var body []byte
+	if c.Request().Body != nil {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(c.Request().Body, maxOverlayBytes+1)); err != nil {
+			return jsonError(c, http.StatusBadRequest, fmt.Sprintf("Read request body error: %v", err))
+		}
+	}
+	request, err := parseStaticMapRequest(c.QueryParams(), body)
+	if err != nil {
+		return jsonError(c, http.StatusBadRequest, fmt.Sprintf("Invalid static map parameters: %v", err))
+	}
+	if request.zoom < metadata.MinZoom || request.zoom > metadata.MaxZoom {
+		return jsonError(c, http.StatusBadRequest, fmt.Sprintf("Zoom %d is out of the range of %s [%d, %d]", request.zoom, mapType, metadata.MinZoom, metadata.MaxZoom))
+	}
+
+	useCache := c.QueryParam("cache") != "false"
+	img, bounds, err := renderStaticMap(request, int(metadata.MapSize), tilemap.ProviderTileFetcher(provider, mapType, useCache))
+	if err != nil {
+		logger.Errorf("Render static map of %s error: %v", mapType, err)
+		return jsonError(c, http.StatusBadGateway, fmt.Sprintf("Render %s static map error: %v", mapType, err))
+	}
+
+	data, err := encodeImage(img, request.format)
+	if err != nil {
+		return jsonError(c, http.StatusInternalServerError, fmt.Sprintf("Encode static map error: %v", err))
+	}
+	c.Response().Header().Set("X-Map-Bounds", fmt.Sprintf("%f,%f,%f,%f", bounds[0], bounds[1], bounds[2], bounds[3]))
+	return c.Blob(http.StatusOK, request.format, data)
+}
+
+func jsonError(c echo.Context, status int, message string) error {
+	return c.JSON(status, model.BaseAPIResponse[any]{
+		Code:    status,
+		Message: message,
+		Data:    nil,
+	})
+}
+
+// parseStaticMapRequest checks the query parameters, the overlay is the `geojson` parameter or the body
+// parseStaticMapRequest 校验查询参数，叠加要素来自 geojson 参数或请求体
+func parseStaticMapRequest(query url.Values, body []byte) (*staticMapRequest, error) {
+	request := &staticMapRequest{format: "image/png"}
+
+	center := strings.Split(query.Get("center"), ",")
+	if len(center) != 2 {
+		return nil, fmt.Errorf("center must be lon,lat")
+	}
+	lon, errLon := strconv.ParseFloat(strings.TrimSpace(center[0]), 64)
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(center[1]), 64)
+	if errLon != nil || errLat != nil || lon < -180 || lon > 180 || lat < -mapprovider.MaxMercatorLat || lat > mapprovider.MaxMercatorLat {
+		return nil, fmt.Errorf("center %s is not a lon,lat inside the Web Mercator world", query.Get("center"))
+	}
+	request.lon, request.lat = lon, lat
+
+	zoom, err := strconv.Atoi(query.Get("zoom"))
+	if err != nil || zoom < 0 {
+		return nil, fmt.Errorf("zoom must be a non-negative integer")
+	}
+	request.zoom = zoom
+
+	for _, size := range []struct {
+		name  string
+		value *int
+	}{{"width", &request.width}, {"height", &request.height}} {
+		number, err := strconv.Atoi(query.Get(size.name))
+		if err != nil || number <= 0 || number > maxImageSize {
+			return nil, fmt.Errorf("%s must be an integer between 1 and %d", size.name, maxImageSize)
+		}
+		*size.value = number
+	}
+
+	switch format := strings.TrimPrefix(query.Get("format"), "image/"); format {
+	case "", "png":
+	case "jpg", "jpeg":
+		request.format = "image/jpeg"
+	default:
+		return nil, fmt.Errorf("format %s is not supported, expected png or jpeg", format)
+	}
+
+	geoJSON := []byte(query.Get("geojson"))
+	if len(geoJSON) == 0 {
+		geoJSON = bytes.TrimSpace(body)
+	}
+	if len(geoJSON) > maxOverlayBytes {
+		return nil, fmt.Errorf("geojson is larger than %d bytes", maxOverlayBytes)
+	}
+	if len(geoJSON) > 0 {
+		if request.overlays, err = parseOverlays(geoJSON); err != nil {
+			return nil, fmt.Errorf("geojson: %w", err)
+		}
+	}
+	return request, nil
+}
+
+// renderStaticMap composes the tiles around the center at the native pixel scale of the tiles and draws the overlays,
+// bounds is the WGS84 bbox of the image. Pixels beyond the Web Mercator world are transparent.
+// renderStaticMap 以瓦片原始像素比例合成中心点周围的瓦片并绘制叠加要素，bounds 为图片的 WGS84 范围，超出 Web 墨卡托范围的像素为透明
+func renderStaticMap(request *staticMapRequest, tileSize int, fetch tilemap.TileFetcher) (*image.RGBA, mapprovider.BBox, error) {
+	// 512px tiles of zoom z have the pixels of 256px tiles of zoom z+1
+	// z 级别 512 像素瓦片的像素与 z+1 级别 256 像素瓦片相同
+	pixelZoom := request.zoom + bits.TrailingZeros(uint(tileSize/256))
+	centerX, centerY := mapprovider.LonLatToPixelXY(request.lon, request.lat, pixelZoom)
+	originX, originY := centerX-request.width/2, centerY-request.height/2
+
+	last := 1<<request.zoom - 1
+	minX, maxX := floorDiv(originX, tileSize), floorDiv(originX+request.width-1, tileSize)
+	minY, maxY := max(floorDiv(originY, tileSize), 0), min(floorDiv(originY+request.height-1, tileSize), last)
+	mosaic, err := tilemap.Mosaic(request.zoom, minX, minY, maxX, maxY, tileSize, fetch)
+	if err != nil {
+		return nil, mapprovider.BBox{}, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, request.width, request.height))
+	// the mosaic starts at tile (minX, minY), the image at pixel (originX, originY)
+	offset := image.Pt(minX*tileSize-originX, minY*tileSize-originY)
+	draw.Draw(img, mosaic.Bounds().Add(offset), mosaic, image.Point{}, draw.Src)
+
+	project := func(lon, lat float64) (x, y float64) {
+		lat = math.Max(-mapprovider.MaxMercatorLat, math.Min(mapprovider.MaxMercatorLat, lat))
+		px, py := mapprovider.LonLatToPixelXY(lon, lat, pixelZoom)
+		// draw at pixel centers
+		return float64(px-originX) + 0.5, float64(py-originY) + 0.5
+	}
+	drawOverlays(img, request.overlays, project)
+
+	minLon, maxLat := mapprovider.PixelXYToLonLat(originX, originY, pixelZoom)
+	maxLon, minLat := mapprovider.PixelXYToLonLat(originX+request.width, originY+request.height, pixelZoom)
+	return img, mapprovider.BBox{minLon, minLat, maxLon, maxLat}, nil
+}
+
+// floorDiv is the integer division rounded down, for pixels left of or above the world
+func floorDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 && a < 0 {
+		q--
+	}
+	return q
+}
+
+// encodeImage encodes the image, JPEG images are flattened on white
+func encodeImage(img *image.RGBA, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	if format == "image/jpeg" {
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: jpegQuality})
+		return buf.Bytes(), err
+	}
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), err
+}
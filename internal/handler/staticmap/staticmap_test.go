@@ -0,0 +1,125 @@
+package staticmap
+
+import (
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/pkg/mapprovider"
+	"image/color"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseStaticMapRequest(t *testing.T) {
+	valid := url.Values{"center": {"116.4,39.9"}, "zoom": {"10"}, "width": {"300"}, "height": {"200"}, "format": {"jpg"}}
+	request, err := parseStaticMapRequest(valid, []byte(` {"type": "Point", "coordinates": [116.4, 39.9]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.lon != 116.4 || request.lat != 39.9 || request.zoom != 10 || request.width != 300 || request.height != 200 || request.format != "image/jpeg" || len(request.overlays) != 1 {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	for _, tc := range []struct {
+		name, value string
+	}{
+		{"center", "116.4"},
+		{"center", "116.4,89"},
+		{"zoom", "-1"},
+		{"width", "0"},
+		{"height", "4096"},
+		{"format", "gif"},
+		{"geojson", `{"type": "Point"`},
+	} {
+		query := url.Values{}
+		for k, v := range valid {
+			query[k] = v
+		}
+		query.Set(tc.name, tc.value)
+		if _, err := parseStaticMapRequest(query, nil); err == nil {
+			t.Errorf("%s=%s: expected error", tc.name, tc.value)
+		}
+	}
+}
+
+func TestRenderStaticMap(t *testing.T) {
+	for _, tileSize := range []int{256, 512} {
+		colors := map[[2]int]color.RGBA{
+			{0, 0}: {R: 0xff, A: 0xff}, {1, 0}: {G: 0xff, A: 0xff},
+			{0, 1}: {B: 0xff, A: 0xff}, {1, 1}: {R: 0xff, G: 0xff, A: 0xff},
+		}
+		tiles := make(map[[2]int][]byte)
+		for xy, c := range colors {
+			tiles[xy] = testutil.SolidTile(t, tileSize, c)
+		}
+		fetch := func(z, x, y int) ([]byte, error) {
+			return tiles[[2]int{x, y}], nil
+		}
+
+		// centered at the origin of zoom 1, crossing the antimeridian on the right
+		request := &staticMapRequest{lon: 0, lat: 0, zoom: 1, width: 2*tileSize + 100, height: 100, format: "image/png",
+			overlays: []overlay{{markers: [][2]float64{{0, 0}}, style: overlayStyle{markerColor: color.NRGBA{A: 0xff}, markerRadius: 3}}}}
+		img, bounds, err := renderStaticMap(request, tileSize, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		center := request.width / 2
+		for _, tc := range []struct {
+			x, y  int
+			color color.RGBA
+		}{
+			{center - 10, 10, colors[[2]int{0, 0}]},
+			{center + 10, 10, colors[[2]int{1, 0}]},
+			{center - 10, 90, colors[[2]int{0, 1}]},
+			{center + 10, 90, colors[[2]int{1, 1}]},
+			// 50px beyond 180°E is tile x 0 of the next world
+			{request.width - 10, 10, colors[[2]int{0, 0}]},
+			// the marker
+			{center, 50, color.RGBA{A: 0xff}},
+		} {
+			if got := img.RGBAAt(tc.x, tc.y); got != tc.color {
+				t.Errorf("%dpx tiles, pixel (%d, %d): expected %v, got %v", tileSize, tc.x, tc.y, tc.color, got)
+			}
+		}
+
+		// 2*tileSize+100 px wide at 2*tileSize px per 360°
+		if halfWidth := 180 * float64(2*tileSize+100) / float64(2*tileSize); math.Abs(bounds[2]-halfWidth) > 1e-6 || math.Abs(bounds[0]+halfWidth) > 1e-6 {
+			t.Errorf("%dpx tiles: unexpected bounds %v", tileSize, bounds)
+		}
+		if bounds[1] >= 0 || bounds[3] <= 0 || math.Abs(bounds[1]+bounds[3]) > 1e-6 {
+			t.Errorf("%dpx tiles: unexpected bounds %v", tileSize, bounds)
+		}
+	}
+}
+
+func TestStaticMapHandlerErrors(t *testing.T) {
+	provider := &testutil.FakeProvider{Metadata: &mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 2, MaxZoom: 5}}
+	registry := &mapprovider.Registry{MapSourceIndex: map[string]mapprovider.TileMapProvider{"roads": provider}}
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+
+	e := echo.New()
+	for _, tc := range []struct {
+		mapType, query string
+		status         int
+	}{
+		{"rails", "center=0,0&zoom=3&width=10&height=10", http.StatusNotFound},
+		{"roads", "center=0,0&zoom=3&width=10", http.StatusBadRequest},
+		{"roads", "center=0,0&zoom=6&width=10&height=10", http.StatusBadRequest},
+	} {
+		recorder := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/static/?"+tc.query, nil), recorder)
+		c.SetParamNames("mapType")
+		c.SetParamValues(tc.mapType)
+		if err := StaticMapHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		if recorder.Code != tc.status || !strings.Contains(recorder.Body.String(), `"code"`) {
+			t.Errorf("%+v: unexpected response %d %s", tc, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tilemap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/tileservice"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"sync"
+
+	// WebP tiles of providers such as TraceStrack are decoded to compose WMS and static maps
+	_ "golang.org/x/image/webp"
+)
+
+// concurrent tile fetches of one mosaic
+const mosaicFetchConcurrency = 8
+
+// TileFetcher returns the picture of the tile z/x/y
+type TileFetcher func(z, x, y int) ([]byte, error)
+
+// ProviderTileFetcher returns the fetcher of the provider tiles through the tile cache
+// ProviderTileFetcher 返回通过瓦片缓存获取地图源瓦片的 fetcher
+func ProviderTileFetcher(provider mapprovider.TileMapProvider, mapType string, useCache bool) TileFetcher {
+	return func(z, x, y int) ([]byte, error) {
+		picBytes, _, err := tileservice.FetchTile(provider, &tileservice.TileMapPathParam{MapType: mapType, X: x, Y: y, Z: z}, useCache)
+		return picBytes, err
+	}
+}
+
+// Mosaic fetches the tiles [minX, maxX] x [minY, maxY] of zoom z concurrently and stitches them into one image,
+// tile (minX, minY) is at the origin. x may exceed the world and wraps around, y must be inside the world.
+// Failed tiles are left transparent, an error is returned only if no tile is available.
+// Mosaic 并发获取 z 级别 [minX, maxX] x [minY, maxY] 范围的瓦片并拼接为一张图片，瓦片 (minX, minY) 位于原点；
+// x 可以超出世界范围并环绕，y 必须在世界范围内；获取失败的瓦片保持透明，仅在没有可用瓦片时返回错误
+func Mosaic(z, minX, minY, maxX, maxY, tileSize int, fetch TileFetcher) (*image.RGBA, error) {
+	mosaic := image.NewRGBA(image.Rect(0, 0, (maxX-minX+1)*tileSize, (maxY-minY+1)*tileSize))
+
+	type tileIndex struct{ x, y int }
+	tiles := make(chan tileIndex)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		drawn   int
+		lastErr error
+	)
+	for range min(mosaicFetchConcurrency, (maxX-minX+1)*(maxY-minY+1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tiles {
+				n := 1 << z
+				tileX := (t.x%n + n) % n
+				tile, err := decodeTile(fetch(z, tileX, t.y))
+				if err != nil {
+					logger.Warnf("Mosaic tile %d/%d/%d unavailable: %v", z, tileX, t.y, err)
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
+					continue
+				}
+				// tiles are drawn to disjoint rectangles of the mosaic
+				offset := image.Pt((t.x-minX)*tileSize, (t.y-minY)*tileSize)
+				draw.Draw(mosaic, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(tileSize, tileSize))}, tile, tile.Bounds().Min, draw.Src)
+				mu.Lock()
+				drawn++
+				mu.Unlock()
+			}
+		}()
+	}
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			tiles <- tileIndex{x, y}
+		}
+	}
+	close(tiles)
+	wg.Wait()
+
+	if drawn == 0 && lastErr != nil {
+		return nil, fmt.Errorf("no tile available at zoom %d: %w", z, lastErr)
+	}
+	return mosaic, nil
+}
+
+func decodeTile(picBytes []byte, err error) (image.Image, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(picBytes) == 0 {
+		return nil, errors.New("empty tile")
+	}
+	tile, _, err := image.Decode(bytes.NewReader(picBytes))
+	return tile, err
+}
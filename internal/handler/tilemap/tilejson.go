@@ -0,0 +1,71 @@
+package tilemap
+
+import (
+	"fmt"
+	"go-map-proxy/internal/handler/common"
+	"go-map-proxy/internal/model"
+	"go-map-proxy/pkg/mapprovider"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TileJSON is a TileJSON 3.0.0 document of a raster provider, `tileSize` is the MapLibre extension
+// ref: https://github.com/mapbox/tilejson-spec/tree/master/3.0.0
+// TileJSON 是栅格地图源的 TileJSON 3.0.0 文档，tileSize 为 MapLibre 扩展字段
+type TileJSON struct {
+	TileJSON    string     `json:"tilejson"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Scheme      string     `json:"scheme"`
+	Tiles       []string   `json:"tiles"`
+	MinZoom     int        `json:"minzoom"`
+	MaxZoom     int        `json:"maxzoom"`
+	TileSize    int        `json:"tileSize"`
+	Bounds      [4]float64 `json:"bounds"`
+	Center      [3]float64 `json:"center"`
+	Attribution string     `json:"attribution,omitempty"`
+}
+
+// NewTileJSON builds the TileJSON document of the provider metadata, tiles are served from baseURL.
+// Bounds default to the Web Mercator world, the center is the middle of the bounds at the min zoom.
+// NewTileJSON 根据地图源元数据构建 TileJSON 文档，瓦片地址基于 baseURL；
+// 范围默认为 Web Mercator 全球范围，中心点为范围中点、缩放级别为最小缩放级别
+func NewTileJSON(metadata *mapprovider.TileMapMetadata, baseURL string) *TileJSON {
+	bounds := mapprovider.BBox{-180, -mapprovider.MaxMercatorLat, 180, mapprovider.MaxMercatorLat}
+	if metadata.Bounds != nil {
+		bounds = *metadata.Bounds
+	}
+
+	return &TileJSON{
+		TileJSON:    "3.0.0",
+		Name:        metadata.Name,
+		Description: fmt.Sprintf("%s (%s), coordinate type %s", metadata.Name, metadata.ID, metadata.CoordinateType),
+		Scheme:      "xyz",
+		Tiles:       []string{fmt.Sprintf("%s/map/%s/{z}/{x}/{y}/", baseURL, metadata.ID)},
+		MinZoom:     metadata.MinZoom,
+		MaxZoom:     metadata.MaxZoom,
+		TileSize:    int(metadata.MapSize),
+		Bounds:      bounds,
+		Center:      [3]float64{(bounds[0] + bounds[2]) / 2, (bounds[1] + bounds[3]) / 2, float64(metadata.MinZoom)},
+		Attribution: metadata.Attribution,
+	}
+}
+
+// TileJSONHandler returns the TileJSON document of a provider
+// e.g. `/map/open_street_map_standard/tilejson.json`
+// TileJSONHandler 返回地图源的 TileJSON 文档
+func TileJSONHandler(c echo.Context) error {
+	mapType := c.Param("mapType")
+	provider, ok := mapprovider.GetRegistry().GetProvider(mapType)
+	if !ok {
+		return c.JSON(http.StatusNotFound, model.BaseAPIResponse[any]{
+			Code:    http.StatusNotFound,
+			Message: fmt.Sprintf("Tile map source %s not found", mapType),
+			Data:    nil,
+		})
+	}
+
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+	return c.JSON(http.StatusOK, NewTileJSON(metadata, common.RequestBaseURL(c)))
+}
@@ -0,0 +1,27 @@
+package tilemap
+
+import (
+	"go-map-proxy/pkg/mapprovider"
+	"testing"
+)
+
+func TestNewTileJSON(t *testing.T) {
+	metadata := (&mapprovider.TileMapMetadata{Name: "Roads", ID: "roads", MinZoom: 3, MaxZoom: 16, MapSize: mapprovider.MapSize512, Attribution: "&copy; Roads"}).GetMetadataWithDefaults()
+	tileJSON := NewTileJSON(metadata, "https://tiles.example.com")
+
+	if tileJSON.TileJSON != "3.0.0" || tileJSON.Tiles[0] != "https://tiles.example.com/map/roads/{z}/{x}/{y}/" {
+		t.Errorf("unexpected tilejson: %+v", tileJSON)
+	}
+	if tileJSON.MinZoom != 3 || tileJSON.MaxZoom != 16 || tileJSON.TileSize != 512 || tileJSON.Attribution != "&copy; Roads" {
+		t.Errorf("unexpected tilejson: %+v", tileJSON)
+	}
+	if tileJSON.Bounds[0] != -180 || tileJSON.Bounds[3] != mapprovider.MaxMercatorLat || tileJSON.Center != [3]float64{0, 0, 3} {
+		t.Errorf("unexpected default bounds %v center %v", tileJSON.Bounds, tileJSON.Center)
+	}
+
+	metadata.Bounds = &mapprovider.BBox{113, 22, 114, 24}
+	tileJSON = NewTileJSON(metadata, "https://tiles.example.com")
+	if tileJSON.Bounds != [4]float64{113, 22, 114, 24} || tileJSON.Center != [3]float64{113.5, 23, 3} {
+		t.Errorf("unexpected bounds %v center %v", tileJSON.Bounds, tileJSON.Center)
+	}
+}
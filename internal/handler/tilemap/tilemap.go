@@ -1,33 +1,29 @@
 package tilemap
 
 import (
+	"errors"
 	"fmt"
 	"go-map-proxy/assets"
 	"go-map-proxy/internal/config"
 	"go-map-proxy/internal/model"
+	"go-map-proxy/internal/tileservice"
 	"go-map-proxy/internal/utils"
 	"go-map-proxy/pkg/logger"
 	"go-map-proxy/pkg/mapprovider"
-	"io"
+	"strconv"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
-type TileMapPathParam struct {
-	MapType string `param:"mapType" json:"mapType"`
-	X       int    `param:"x" json:"x"`
-	Y       int    `param:"y" json:"y"`
-	Z       int    `param:"z" json:"z"`
-}
-
 // List tile map sources
 func TileMapSourceList(c echo.Context) error {
 
-	mapSourceList := make([]*mapprovider.TileMapMetadata, 0, len(mapprovider.MapSourceSlice))
+	registry := mapprovider.GetRegistry()
+	mapSourceList := make([]*mapprovider.TileMapMetadata, 0, len(registry.MapSourceSlice))
 
 	// use slice to prevent map source order
-	for _, provider := range mapprovider.MapSourceSlice {
+	for _, provider := range registry.MapSourceSlice {
 		mapSourceList = append(mapSourceList, provider.Value.GetMapMetadata().GetMetadataWithDefaults())
 	}
 
@@ -45,17 +41,33 @@ func TileMapSourceList(c echo.Context) error {
 // mapType: the type of map, e.g. "google", "osm", etc.
 // x, y, z: the tile coordinates
 // cache: whether to use exist tile cache, default is true
+// format: image format of the tile (png, jpeg or webp), negotiated with the `Accept` header if omitted
+// quality: JPEG quality 1-100 of transcoded tiles
+// `/:mapID/:z/:x/:y@2x` is the 512px tile of the virtual @2x layer, the same as `/:mapID@2x/:z/:x/:y`
+// `/:mapID/:z/:x/:y@2x` 为虚拟 @2x 图层的 512 像素瓦片，等同于 `/:mapID@2x/:z/:x/:y`
 func TileMapHandler(c echo.Context) error {
 
-	tileMapParam := new(TileMapPathParam)
+	tileMapParam := new(tileservice.TileMapPathParam)
 
 	// Fluent Binding
 	err := echo.PathParamsBinder(c).
 		MustString("mapType", &tileMapParam.MapType).
 		MustInt("x", &tileMapParam.X).
-		MustInt("y", &tileMapParam.Y).
 		MustInt("z", &tileMapParam.Z).
 		BindError()
+	if err == nil {
+		y, retina := strings.CutSuffix(c.Param("y"), mapprovider.RetinaSuffix)
+		if tileMapParam.Y, err = strconv.Atoi(y); err != nil {
+			err = fmt.Errorf("y %q is not an integer", c.Param("y"))
+		}
+		if retina && !strings.HasSuffix(tileMapParam.MapType, mapprovider.RetinaSuffix) {
+			tileMapParam.MapType += mapprovider.RetinaSuffix
+		}
+	}
+	var format *tileFormat
+	if err == nil {
+		format, err = parseTileFormat(c)
+	}
 	if err != nil {
 		return c.JSON(200, model.BaseAPIResponse[any]{
 			Code:    400,
@@ -64,8 +76,28 @@ func TileMapHandler(c echo.Context) error {
 		})
 	}
 
-	// find map provider in `mapprovider.MapSourceIndex`
-	provider, ok := mapprovider.MapSourceIndex[tileMapParam.MapType]
+	return serveTile(c, tileMapParam, format)
+}
+
+// ServeTile writes the tile of the provider with the cache, request coalescing and stale handling
+// of the tile map handler, it is shared by the other tile protocols (WMTS, TMS ...)
+// ServeTile 使用瓦片处理器的缓存、请求合并和过期处理逻辑返回瓦片，供其他瓦片协议（WMTS、TMS 等）复用
+func ServeTile(c echo.Context, tileMapParam *tileservice.TileMapPathParam) error {
+	return serveTile(c, tileMapParam, nil)
+}
+
+// serveTile serves the tile in the requested format, the native format if `format` is nil
+func serveTile(c echo.Context, tileMapParam *tileservice.TileMapPathParam, format *tileFormat) error {
+	// take a snapshot of config, providers and cache, so that a config reload
+	// during this request does not mix old and new settings, the providers stay open until the request is done
+	// 获取配置、地图源和缓存的快照，请求期间的配置重载不会混用新旧配置，地图源在请求结束前保持打开
+	cfg := config.GetConfig()
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	cache := utils.GetCache()
+
+	// find map provider in `registry.MapSourceIndex`
+	provider, ok := registry.GetProvider(tileMapParam.MapType)
 	if !ok {
 		return c.JSON(200, model.BaseAPIResponse[any]{
 			Code:    400,
@@ -73,102 +105,78 @@ func TileMapHandler(c echo.Context) error {
 			Data:    nil,
 		})
 	}
-	providerMetadata := provider.GetMapMetadata().GetMetadataWithDefaults()
-
-	// handle map cache
-	isUseCache := true
-	cacheParam := c.QueryParam("cache")
-
-	// fix: read Cfg enable cache config
-	if cacheParam == "false" || !config.Cfg.Cache.Enable {
-		isUseCache = false
-	}
-	// hash map cache key
-	// cacheKey := fmt.Sprintf("%s/%d/%d/%d", tileMapParam.MapType, tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
-	// path map cache key
-	fileExtension := strings.Split(string(providerMetadata.ContentType), "/")[1]
-	cacheKey := fmt.Sprintf("%s/%d/%d/%d.%s", tileMapParam.MapType, tileMapParam.Z, tileMapParam.X, tileMapParam.Y, fileExtension)
-
-	if isUseCache {
-		// check if tile map picture is in cache
-		if cacheData, err := utils.Cache.GetCache(cacheKey); err == nil {
-			logger.Debugf("Tile map cache hit: %s", cacheKey)
-			c.Response().Header().Set(echo.HeaderContentType, string(providerMetadata.ContentType))
-			c.Response().Header().Set(echo.HeaderContentLength, fmt.Sprintf("%d", len(cacheData)))
-			// set cache policy
-			c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("max-age=%d", config.Cfg.Cache.MaxAge))
-			c.Response().Header().Set("X-cache", "HIT")
-			c.Response().WriteHeader(200)
-			_, err = c.Response().Writer.Write(cacheData)
-			if err != nil {
-				return c.JSON(200, model.BaseAPIResponse[any]{
-					Code:    500,
-					Message: fmt.Sprintf("Write tile map picture error: %v", err),
-					Data:    nil,
-				})
-			}
-			return nil
+	if format != nil {
+		var err error
+		if provider, err = format.transcode(c, provider); err != nil {
+			return c.JSON(200, model.BaseAPIResponse[any]{
+				Code:    400,
+				Message: fmt.Sprintf("Invalid tile map parameters: %v", err),
+				Data:    nil,
+			})
 		}
-		c.Response().Header().Set("X-cache", "MISS")
-		logger.Debugf("Tile map cache miss: %s", cacheKey)
 	}
 
-	// get tile map picture response
-	tileMapPicResponse, err := provider.GetMapPic(tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
-	if err != nil {
-		logger.Errorf("Get tile map picture error: %v", err)
+	tile, err := tileservice.FetchCachedTile(cfg, cache, provider, tileMapParam, c.QueryParam("cache") != "false")
+	if errors.Is(err, mapprovider.ErrTileNotFound) {
+		// missing tiles of local tile files are expected outside their coverage, not errors
+		// 本地瓦片文件覆盖范围外缺失瓦片属正常情况，不视为错误
+		logger.Debugf("Tile map picture not found: %v", err)
 		return c.JSON(200, model.BaseAPIResponse[any]{
-			Code:    500,
-			Message: fmt.Sprintf("Get %s tile map picture error: %v", tileMapParam.MapType, err),
+			Code:    404,
+			Message: fmt.Sprintf("%s tile map picture %d/%d/%d not found", tileMapParam.MapType, tileMapParam.Z, tileMapParam.X, tileMapParam.Y),
 			Data:    nil,
 		})
 	}
-	defer tileMapPicResponse.Body.Close()
-
-	// set response header
-	if !strings.Contains(tileMapPicResponse.Header.Get("Content-Type"), "image") {
-		logger.Errorf("Tile map picture content type is not image: %s, fallback to metadata content type", tileMapPicResponse.Header.Get("Content-Type"))
-		c.Response().Header().Set(echo.HeaderContentType, string(providerMetadata.ContentType))
-	} else {
-		c.Response().Header().Set(echo.HeaderContentType, tileMapPicResponse.Header.Get("Content-Type"))
-	}
-
-	// set response length
-	// c.Response().Header().Set(echo.HeaderContentLength, tileMapPicResponse.Header.Get("Content-Length"))
-
-	// read tile map picture body
-	picBytes, err := io.ReadAll(tileMapPicResponse.Body)
 	if err != nil {
-		logger.Errorf("Read tile map picture error: %v", err)
+		logger.Errorf("Get tile map picture error: %v", err)
 		return c.JSON(200, model.BaseAPIResponse[any]{
 			Code:    500,
-			Message: fmt.Sprintf("Read tile map picture error: %v", err),
+			Message: fmt.Sprintf("Get %s tile map picture error: %v", tileMapParam.MapType, err),
 			Data:    nil,
 		})
 	}
-	// save tile map picture to cache (in new goroutine) if cache is enabled
-	if isUseCache {
-		go func() {
-			err := utils.Cache.SetCache(cacheKey, picBytes)
-			if err != nil {
-				logger.Errorf("Set tile map cache error: %v", err)
-			} else {
-				logger.Debugf("Set tile map cache success: %s", cacheKey)
-			}
-		}()
-	}
 
 	// if picBytes is empty, return failure picture
-	if len(picBytes) == 0 {
+	if len(tile.PicBytes) == 0 {
 		logger.Errorf("Tile map picture is empty")
 		return c.Blob(200, "image/png", assets.TileMapFailedPng)
 	}
 
-	// set content length
+	// member of the fallback chain which returned the tile, cached tiles do not record it
+	// 返回瓦片的回退链成员，缓存的瓦片不记录该信息
+	if tile.Source != "" {
+		c.Response().Header().Set(mapprovider.TileSourceHeader, tile.Source)
+	}
+	// fresh cached tiles are sent with the cache max age, stale tiles with a short one,
+	// so that clients come back for the refreshed tile instead of keeping the stale one for max_age
+	// 新鲜的缓存瓦片使用缓存的 max age，过期瓦片使用较短的 max age，客户端会重新获取刷新后的瓦片而不是保留过期瓦片
+	maxAge := 0
+	switch tile.CacheStatus {
+	case tileservice.CacheHit:
+		maxAge = cfg.Cache.MaxAge
+	case tileservice.CacheStale, tileservice.CacheStaleIfError:
+		maxAge = min(cfg.Cache.MaxAge, tileservice.StaleMaxAge)
+	}
+	return writeTile(c, tile.PicBytes, tile.ContentType, maxAge, string(tile.CacheStatus))
+}
+
+// writeTile writes the tile picture to the response.
+// maxAge sets `Cache-Control` if positive, cacheStatus sets `X-cache` if not empty.
+// writeTile 将瓦片写入响应，maxAge 为正数时设置 Cache-Control，cacheStatus 非空时设置 X-cache
+func writeTile(c echo.Context, picBytes []byte, contentType string, maxAge int, cacheStatus string) error {
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
 	c.Response().Header().Set(echo.HeaderContentLength, fmt.Sprintf("%d", len(picBytes)))
+	if maxAge > 0 {
+		// set cache policy
+		c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("max-age=%d", maxAge))
+	}
+	if cacheStatus != "" {
+		c.Response().Header().Set("X-cache", cacheStatus)
+	}
+
 	// write tile map picture to response
 	c.Response().WriteHeader(200)
-	_, err = c.Response().Writer.Write(picBytes)
+	_, err := c.Response().Writer.Write(picBytes)
 	if err != nil {
 		logger.Errorf("Write tile map picture error: %v", err)
 		return c.JSON(200, model.BaseAPIResponse[any]{
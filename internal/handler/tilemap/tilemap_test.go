@@ -0,0 +1,161 @@
+package tilemap
+
+import (
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
+	"go-map-proxy/pkg/mbtiles"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestServeTileStale(t *testing.T) {
+	var failing atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("fresh"))
+	}))
+	defer upstream.Close()
+
+	// tiles are fresh for 60s, stale for 60s more and usable on error for 1h after that
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	configYAML := "cache:\n  path: " + filepath.Join(dir, "cache") + "\n  max_age: 3600\n  ttl: 60\n  stale_while_revalidate: 60\n  stale_if_error: 3600\n" +
+		"providers:\n  - id: stale_test\n    kind: xyz\n    url: " + upstream.URL + "/{z}/{x}/{y}.png\n    content_type: image/png\n"
+	if err := os.WriteFile(configPath, []byte(configYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InitConfig(configPath); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.GetConfig()
+	registry, err := mapprovider.NewRegistry(cfg.Providers, cfg.LayerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+	cache := &utils.PathMapCache{CachePath: cfg.Cache.Path}
+	utils.SetCache(cache)
+	defer utils.SetCache(nil)
+
+	// cacheTile stores the tile as written age ago
+	cacheTile := func(age time.Duration) {
+		t.Helper()
+		if err := cache.SetCache("stale_test/3/1/2.png", []byte("cached")); err != nil {
+			t.Fatal(err)
+		}
+		path, _ := cache.CacheFilePath("stale_test/3/1/2.png")
+		storedAt := time.Now().Add(-age)
+		if err := os.Chtimes(path, storedAt, storedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serve := func() *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/map/stale_test/3/1/2/", nil), recorder)
+		c.SetParamNames("mapType", "z", "x", "y")
+		c.SetParamValues("stale_test", "3", "1", "2")
+		if err := TileMapHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		return recorder
+	}
+
+	for _, tc := range []struct {
+		name         string
+		age          time.Duration
+		failing      bool
+		body         string
+		status       string
+		cacheControl string
+	}{
+		{"fresh", 10 * time.Second, false, "cached", "HIT", "max-age=3600"},
+		{"stale", 90 * time.Second, false, "cached", "STALE", "max-age=60"},
+		{"stale if error", 30 * time.Minute, true, "cached", "STALE-IF-ERROR", "max-age=60"},
+		{"expired", 30 * time.Minute, false, "fresh", "MISS", ""},
+		{"expired failing", 2 * time.Hour, true, `"code":500`, "", ""},
+	} {
+		failing.Store(tc.failing)
+		cacheTile(tc.age)
+		recorder := serve()
+		if !strings.Contains(recorder.Body.String(), tc.body) || recorder.Header().Get("X-cache") != tc.status || recorder.Header().Get(echo.HeaderCacheControl) != tc.cacheControl {
+			t.Errorf("%s: unexpected response %q, X-cache %q, Cache-Control %q", tc.name, recorder.Body.String(), recorder.Header().Get("X-cache"), recorder.Header().Get(echo.HeaderCacheControl))
+		}
+		// wait for the background refresh of the stale tile
+		deadline := time.Now().Add(5 * time.Second)
+		for tc.status == "STALE" {
+			if value, _ := cache.GetCache("stale_test/3/1/2.png"); string(value) == "fresh" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: stale tile is not refreshed", tc.name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestServeTileLocal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local.mbtiles")
+	writer, err := mbtiles.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteTile(1, 1, 0, []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testutil.InitConfig(t, "cache:\n  path: "+filepath.Join(dir, "cache")+"\n"+
+		"providers:\n  - id: local\n    kind: mbtiles\n    path: "+path+"\n    content_type: image/png\n")
+	registry, err := mapprovider.NewRegistry(cfg.Providers, cfg.LayerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+	defer mapprovider.SetRegistry(mapprovider.SetRegistry(registry))
+	cache := &utils.PathMapCache{CachePath: cfg.Cache.Path}
+	utils.SetCache(cache)
+	defer utils.SetCache(nil)
+
+	serve := func(x string) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/map/local/1/"+x+"/0/", nil), recorder)
+		c.SetParamNames("mapType", "z", "x", "y")
+		c.SetParamValues("local", "1", x, "0")
+		if err := TileMapHandler(c); err != nil {
+			t.Fatal(err)
+		}
+		return recorder
+	}
+
+	// local tiles are read from the file, not copied to the cache
+	if recorder := serve("1"); recorder.Body.String() != "local" || recorder.Header().Get("X-cache") != "" {
+		t.Errorf("unexpected response %q, X-cache %q", recorder.Body.String(), recorder.Header().Get("X-cache"))
+	}
+	if _, err := cache.GetCache("local/1/1/0.png"); err == nil {
+		t.Error("local tile is cached")
+	}
+	// missing tiles are not found instead of errors
+	if recorder := serve("0"); !strings.Contains(recorder.Body.String(), `"code":404`) {
+		t.Errorf("unexpected response %q for a missing tile", recorder.Body.String())
+	}
+}
@@ -0,0 +1,128 @@
+package tilemap
+
+import (
+	"fmt"
+	"go-map-proxy/pkg/mapprovider"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// values of the `format` query parameter
+var tileFormats = map[string]mapprovider.MapContentType{
+	"png":        mapprovider.MapContentTypePNG,
+	"jpeg":       mapprovider.MapContentTypeJPEG,
+	"jpg":        mapprovider.MapContentTypeJPEG,
+	"webp":       mapprovider.MapContentTypeWebP,
+	"image/png":  mapprovider.MapContentTypePNG,
+	"image/jpeg": mapprovider.MapContentTypeJPEG,
+	"image/jpg":  mapprovider.MapContentTypeJPEG,
+	"image/webp": mapprovider.MapContentTypeWebP,
+}
+
+// tileFormat is the image format requested by the client
+type tileFormat struct {
+	// content type of the `format` query parameter, empty to negotiate with the `Accept` header
+	contentType mapprovider.MapContentType
+	// JPEG quality of the `quality` query parameter, 0 means the default quality
+	quality int
+}
+
+// parseTileFormat reads the `format` and `quality` query parameters
+func parseTileFormat(c echo.Context) (*tileFormat, error) {
+	format := &tileFormat{}
+	if value := c.QueryParam("format"); value != "" {
+		contentType, ok := tileFormats[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("format %q is not supported, expected png, jpeg or webp", value)
+		}
+		format.contentType = contentType
+	}
+	if value := c.QueryParam("quality"); value != "" {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("quality %q is not an integer in [1, 100]", value)
+		}
+		format.quality = quality
+	}
+	return format, nil
+}
+
+// transcode returns the provider serving the tiles in the requested format, the provider itself if no transcoding is needed.
+// The format is negotiated with the `Accept` header if the `format` query parameter is omitted,
+// WebP is only served by WebP providers since it can not be encoded.
+// transcode 返回以请求格式提供瓦片的地图源，无需转码时返回地图源本身。
+// 未指定 `format` 查询参数时根据 `Accept` 请求头协商格式，WebP 无法编码，仅由 WebP 地图源提供
+func (format *tileFormat) transcode(c echo.Context, provider mapprovider.TileMapProvider) (mapprovider.TileMapProvider, error) {
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+	native := metadata.ContentType
+	// vector tiles are not images
+	if metadata.MapType == mapprovider.MapTypeVector {
+		return provider, nil
+	}
+
+	contentType := format.contentType
+	if contentType == "" {
+		contentType = negotiateContentType(c.Request().Header.Get(echo.HeaderAccept), native)
+		c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	}
+	if contentType == native && (contentType != mapprovider.MapContentTypeJPEG || format.quality == 0) {
+		return provider, nil
+	}
+	if contentType == mapprovider.MapContentTypeWebP {
+		return nil, fmt.Errorf("format webp is only served by WebP maps, %s serves %s", metadata.ID, native)
+	}
+	return mapprovider.NewTranscodedProvider(provider, contentType, format.quality)
+}
+
+// negotiateContentType picks the content type of the highest `Accept` weight which can be served:
+// the native type, PNG or JPEG, wildcards match the native type. The native type is used if nothing matches.
+// negotiateContentType 选择 `Accept` 中权重最高且可提供的内容类型：原生类型、PNG 或 JPEG，通配符匹配原生类型，
+// 无匹配时使用原生类型
+func negotiateContentType(accept string, native mapprovider.MapContentType) mapprovider.MapContentType {
+	type mediaRange struct {
+		contentType mapprovider.MapContentType
+		weight      float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if weight <= 0 {
+			continue
+		}
+
+		switch mediaType {
+		case "*/*", "image/*":
+			ranges = append(ranges, mediaRange{native, weight})
+		case string(native), string(mapprovider.MapContentTypePNG), string(mapprovider.MapContentTypeJPEG):
+			ranges = append(ranges, mediaRange{mapprovider.MapContentType(mediaType), weight})
+		case "image/jpg":
+			ranges = append(ranges, mediaRange{mapprovider.MapContentTypeJPEG, weight})
+		}
+	}
+	if len(ranges) == 0 {
+		return native
+	}
+
+	slices.SortStableFunc(ranges, func(a, b mediaRange) int {
+		switch {
+		case a.weight > b.weight:
+			return -1
+		case a.weight < b.weight:
+			return 1
+		}
+		return 0
+	})
+	return ranges[0].contentType
+}
@@ -0,0 +1,95 @@
+package tilemap
+
+import (
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/pkg/mapprovider"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	const (
+		png  = mapprovider.MapContentTypePNG
+		jpeg = mapprovider.MapContentTypeJPEG
+		webp = mapprovider.MapContentTypeWebP
+	)
+	for _, tc := range []struct {
+		accept   string
+		native   mapprovider.MapContentType
+		expected mapprovider.MapContentType
+	}{
+		{"", webp, webp},
+		{"image/png", webp, png},
+		{"image/jpeg;q=0.9, image/png;q=0.5", webp, jpeg},
+		{"image/jpg", png, jpeg},
+		// browsers: WebP can not be encoded, the wildcard matches the native type
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", png, png},
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", webp, webp},
+		{"image/webp, image/png;q=0.5", jpeg, png},
+		{"image/png;q=0, image/jpeg;q=0.1", webp, jpeg},
+		{"application/json", jpeg, jpeg},
+	} {
+		if got := negotiateContentType(tc.accept, tc.native); got != tc.expected {
+			t.Errorf("accept %q (native %s): expected %s, got %s", tc.accept, tc.native, tc.expected, got)
+		}
+	}
+}
+
+func TestTileFormatTranscode(t *testing.T) {
+	provider := &testutil.FakeProvider{}
+	e := echo.New()
+
+	for _, tc := range []struct {
+		query      string
+		accept     string
+		transcoded mapprovider.MapContentType // empty if the provider is served as is
+		extension  string
+		invalid    bool
+	}{
+		{query: "", accept: "", extension: "png"},
+		{query: "format=png", extension: "png"},
+		{query: "format=JPG", transcoded: mapprovider.MapContentTypeJPEG, extension: "jpeg"},
+		{query: "format=jpeg&quality=60", transcoded: mapprovider.MapContentTypeJPEG, extension: "q60.jpeg"},
+		{query: "format=jpeg&quality=90", transcoded: mapprovider.MapContentTypeJPEG, extension: "jpeg"},
+		{query: "quality=60", accept: "image/jpeg", transcoded: mapprovider.MapContentTypeJPEG, extension: "q60.jpeg"},
+		// quality only applies to JPEG
+		{query: "quality=60", extension: "png"},
+		{query: "format=webp", invalid: true},
+		{query: "format=gif", invalid: true},
+		{query: "quality=0", invalid: true},
+		{query: "quality=high", invalid: true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/map/fake/3/1/2/?"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set(echo.HeaderAccept, tc.accept)
+		}
+		c := e.NewContext(req, httptest.NewRecorder())
+
+		format, err := parseTileFormat(c)
+		var served mapprovider.TileMapProvider
+		if err == nil {
+			served, err = format.transcode(c, provider)
+		}
+		if (err != nil) != tc.invalid {
+			t.Errorf("%q: expected invalid %v, got error %v", tc.query, tc.invalid, err)
+			continue
+		}
+		if tc.invalid {
+			continue
+		}
+
+		transcoded, ok := served.(*mapprovider.TranscodedProvider)
+		if ok != (tc.transcoded != "") || ok && transcoded.ContentType != tc.transcoded {
+			t.Errorf("%q: expected transcoded to %q, got %+v", tc.query, tc.transcoded, served.GetMapMetadata())
+		}
+		if got := mapprovider.CacheExtension(served); got != tc.extension {
+			t.Errorf("%q: expected extension %s, got %s", tc.query, tc.extension, got)
+		}
+		if vary := c.Response().Header().Get(echo.HeaderVary); (vary == echo.HeaderAccept) == (format.contentType != "") {
+			t.Errorf("%q: unexpected Vary %q", tc.query, vary)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/model"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminAuthMiddleware protects admin api with `admin.token` in config.
+// The token is read on every request, so it follows config reload.
+// If the token is empty, the admin api only accepts requests from localhost.
+// AdminAuthMiddleware 使用配置中的 `admin.token` 保护管理接口，令牌为空时仅允许本机访问
+func AdminAuthMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := config.GetConfig().Admin.Token
+			if token == "" {
+				if !isLoopbackRequest(c.Request()) {
+					return c.JSON(http.StatusForbidden, model.BaseAPIResponse[any]{
+						Code:    http.StatusForbidden,
+						Message: "admin api is only available from localhost when admin.token is empty",
+						Data:    nil,
+					})
+				}
+				return next(c)
+			}
+
+			requestToken := c.QueryParam("token")
+			if auth := c.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+				requestToken = strings.TrimPrefix(auth, "Bearer ")
+			}
+
+			if subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, model.BaseAPIResponse[any]{
+					Code:    http.StatusUnauthorized,
+					Message: "invalid admin token",
+					Data:    nil,
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// isLoopbackRequest checks the address of the connection, X-Forwarded-For can be set by the client
+func isLoopbackRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,559 @@
+// Tile seeding jobs: pre-warm the cache of a provider for a region and zoom range.
+// Job state is saved to `<id>.json` periodically and permanently failed tiles are appended to `<id>.failed`,
+// so jobs survive restarts and failed tiles can be retried later.
+// 瓦片预热任务：为地图源在指定区域和缩放级别范围内预先填充缓存。
+// 任务状态定期保存到 `<id>.json`，最终失败的瓦片追加到 `<id>.failed`，任务可在重启后继续，失败瓦片可稍后重试
+
+package seed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/tileservice"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConcurrency = 4
+	maxConcurrency     = 32
+
+	// attempts of each tile before it is recorded as failed
+	maxAttempts = 3
+	// interval of saving job state
+	saveInterval = 5 * time.Second
+)
+
+// wait before the nth retry of a tile is n * retryBackoff
+var retryBackoff = time.Second
+
+// errProviderRemoved stops a job whose provider is no longer registered, e.g. removed by a config reload
+var errProviderRemoved = errors.New("provider is removed")
+
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobCancelled JobStatus = "cancelled"
+	// the job can not be resumed, e.g. its provider was removed
+	JobFailed JobStatus = "failed"
+)
+
+// JobPass is the stage of a job
+type JobPass string
+
+const (
+	// fetch the tiles covering the region
+	PassSeed JobPass = "seed"
+	// fetch the tiles failed in previous passes
+	PassRetry JobPass = "retry"
+)
+
+// JobRequest is the parameters of a seeding job, either BBox or Polygon must be set
+// JobRequest 是预热任务参数，BBox 和 Polygon 必须设置其一
+type JobRequest struct {
+	ProviderID string `json:"provider_id"`
+	MinZoom    int    `json:"min_zoom"`
+	MaxZoom    int    `json:"max_zoom"`
+
+	// WGS84 `[minLon, minLat, maxLon, maxLat]`
+	BBox *BBox `json:"bbox,omitempty"`
+	// GeoJSON Polygon, MultiPolygon, Feature or FeatureCollection in WGS84
+	Polygon json.RawMessage `json:"polygon,omitempty"`
+
+	// number of concurrent upstream requests, default 4
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// JobInfo is a snapshot of a job's progress, it is also the persisted job state
+// JobInfo 是任务进度的快照，同时也是持久化的任务状态
+type JobInfo struct {
+	ID      string     `json:"id"`
+	Request JobRequest `json:"request"`
+	Status  JobStatus  `json:"status"`
+	Pass    JobPass    `json:"pass"`
+
+	// total is 0 until the covering tiles are counted
+	Total int64 `json:"total"`
+	// tiles fetched from the upstream
+	Completed int64 `json:"completed"`
+	// tiles already in the cache
+	Skipped int64 `json:"skipped"`
+	// tiles failed after all attempts, listed in `<id>.failed`
+	Failed   int64   `json:"failed"`
+	Progress float64 `json:"progress"`
+	// tiles before this index in enumeration order (zoom, row, column) are done
+	Checkpoint int64  `json:"checkpoint"`
+	LastError  string `json:"last_error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type tileOutcome int
+
+const (
+	tileSeeded tileOutcome = iota
+	tileSkipped
+	tileFailed
+)
+
+type tile struct {
+	x, y, z int
+	// index in enumeration order
+	index int64
+}
+
+func (t tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.z, t.x, t.y)
+}
+
+// Job is a running or finished seeding job.
+// The provider is looked up for every tile, so that the job follows config reloads and does not keep using
+// the providers of a replaced registry, which are closed after a while.
+// Job 是运行中或已结束的预热任务。每个瓦片都会重新查找地图源，任务随配置重载切换，
+// 不会继续使用被替换注册表中的地图源（这些地图源稍后会被关闭）
+type Job struct {
+	request JobRequest
+	// nil if the job can not run
+	region   *Region
+	stateDir string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// aborts the running pass with a cause, set before the pass starts
+	abort context.CancelCauseFunc
+	// stopped by shutdown, keep the running status to resume on next start
+	shutdown bool
+
+	mu sync.Mutex
+	// persisted state, counters only include tiles before the checkpoint
+	state JobInfo
+	// outcomes of tiles finished after the checkpoint
+	pending map[int64]tileOutcome
+	// tiles recovered in the retry pass
+	recovered int64
+}
+
+// Info returns a snapshot of the job
+func (job *Job) Info() JobInfo {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.infoLocked()
+}
+
+func (job *Job) infoLocked() JobInfo {
+	info := job.state
+	info.Completed += job.recovered
+	info.Failed -= job.recovered
+	for _, outcome := range job.pending {
+		switch outcome {
+		case tileSeeded:
+			info.Completed++
+		case tileSkipped:
+			info.Skipped++
+		case tileFailed:
+			info.Failed++
+		}
+	}
+	if info.Total > 0 {
+		info.Progress = min(float64(info.Completed+info.Skipped+info.Failed)/float64(info.Total), 1)
+	}
+	return info
+}
+
+// Done is closed when the job stops
+func (job *Job) Done() <-chan struct{} {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.done
+}
+
+// stop cancels the job, shutdown keeps the running status for resuming
+func (job *Job) stop(shutdown bool) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.shutdown = shutdown
+	job.cancel()
+}
+
+func (job *Job) statePath() string {
+	return filepath.Join(job.stateDir, job.state.ID+".json")
+}
+
+func (job *Job) failuresPath() string {
+	return filepath.Join(job.stateDir, job.state.ID+".failed")
+}
+
+// save writes the job state atomically
+// save 原子地写入任务状态
+func (job *Job) save() error {
+	job.mu.Lock()
+	raw, err := json.MarshalIndent(job.state, "", "  ")
+	job.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(job.stateDir, 0755); err != nil {
+		return err
+	}
+	tmpPath := job.statePath() + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, job.statePath())
+}
+
+func (job *Job) saveOrLog() {
+	if err := job.save(); err != nil {
+		logger.Errorf("Save seed job %s state error: %v", job.state.ID, err)
+	}
+}
+
+// finishTile records the outcome of a tile in the seed pass and advances the checkpoint
+// over the contiguous finished tiles
+// finishTile 记录种子阶段瓦片的结果，并将检查点推进到连续完成的瓦片之后
+func (job *Job) finishTile(index int64, outcome tileOutcome) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.pending[index] = outcome
+	for {
+		outcome, ok := job.pending[job.state.Checkpoint]
+		if !ok {
+			return
+		}
+		delete(job.pending, job.state.Checkpoint)
+		job.state.Checkpoint++
+		switch outcome {
+		case tileSeeded:
+			job.state.Completed++
+		case tileSkipped:
+			job.state.Skipped++
+		case tileFailed:
+			job.state.Failed++
+		}
+	}
+}
+
+// recordFailure appends a permanently failed tile to the failures file
+func (job *Job) recordFailure(t tile, err error) {
+	logger.Warnf("Seed job %s: tile %s failed: %v", job.state.ID, t, err)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.state.LastError = fmt.Sprintf("%s: %v", t, err)
+
+	file, openErr := os.OpenFile(job.failuresPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if openErr != nil {
+		logger.Errorf("Seed job %s: record failed tile %s error: %v", job.state.ID, t, openErr)
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, t)
+}
+
+// run executes the current pass of the job, the job must not be running
+// run 执行任务的当前阶段
+func (job *Job) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	// the pass is aborted with errProviderRemoved if the provider is removed meanwhile
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	job.abort = abort
+
+	stopSaving := job.saveInBackground()
+	var err error
+	switch job.state.Pass {
+	case PassRetry:
+		err = job.runRetry(ctx)
+	default:
+		err = job.runSeed(ctx)
+	}
+	stopSaving()
+	if cause := context.Cause(ctx); err == nil && errors.Is(cause, errProviderRemoved) {
+		err = cause
+	}
+
+	job.mu.Lock()
+	switch {
+	case err != nil:
+		job.state.Status = JobFailed
+		job.state.LastError = err.Error()
+	case job.shutdown:
+		// keep running status and resume on next start
+	case ctx.Err() != nil:
+		job.state.Status = JobCancelled
+	default:
+		job.state.Status = JobCompleted
+	}
+	if job.state.Status != JobRunning {
+		finishedAt := time.Now()
+		job.state.FinishedAt = &finishedAt
+	}
+	info := job.infoLocked()
+	job.mu.Unlock()
+
+	job.saveOrLog()
+	logger.Infof("Seed job %s %s pass stopped (%s): %d completed, %d skipped, %d failed of %d tiles",
+		info.ID, info.Pass, info.Status, info.Completed, info.Skipped, info.Failed, info.Total)
+}
+
+// saveInBackground saves the job state periodically until the returned function is called
+func (job *Job) saveInBackground() (stop func()) {
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(saveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				job.saveOrLog()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-stopped
+	}
+}
+
+// runSeed enumerates the covering tiles zoom by zoom from the checkpoint and fetches them with bounded concurrency
+// runSeed 从检查点开始逐级枚举覆盖瓦片，并以有限并发获取
+func (job *Job) runSeed(ctx context.Context) error {
+	total := job.region.CountTiles(job.request.MinZoom, job.request.MaxZoom)
+	job.mu.Lock()
+	job.state.Total = total
+	checkpoint := job.state.Checkpoint
+	job.mu.Unlock()
+	logger.Infof("Seed job %s started: %s z%d-%d, %d tiles, from %d", job.state.ID, job.request.ProviderID, job.request.MinZoom, job.request.MaxZoom, total, checkpoint)
+
+	job.process(ctx, func(tiles chan<- tile) {
+		var index int64
+		for z := job.request.MinZoom; z <= job.request.MaxZoom; z++ {
+			finished := job.region.ForEachTile(z, func(x, y int) bool {
+				index++
+				if index <= checkpoint {
+					return true
+				}
+				select {
+				case tiles <- tile{x: x, y: y, z: z, index: index - 1}:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+			if !finished {
+				return
+			}
+		}
+	}, func(t tile, outcome tileOutcome) {
+		job.finishTile(t.index, outcome)
+	})
+	return nil
+}
+
+// runRetry fetches the tiles in the failures file again, tiles failing again are recorded for the next retry.
+// The failures file is moved to `<id>.failed.retry` during the pass so it can be restarted after a crash.
+// runRetry 重新获取失败文件中的瓦片，再次失败的瓦片记录下来供下次重试。
+// 重试期间失败文件被移动到 `<id>.failed.retry`，以便崩溃后重新开始
+func (job *Job) runRetry(ctx context.Context) error {
+	retryPath := job.failuresPath() + ".retry"
+	if _, err := os.Stat(retryPath); err != nil {
+		if err := os.Rename(job.failuresPath(), retryPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("move failures file error: %w", err)
+		}
+	} else {
+		// restarted retry pass, failures are recorded again
+		_ = os.Remove(job.failuresPath())
+	}
+
+	failures, err := readFailures(retryPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read failures file error: %w", err)
+	}
+
+	job.mu.Lock()
+	// the failures file may contain duplicates after a resume
+	job.state.Completed += job.state.Failed - int64(len(failures))
+	job.state.Failed = int64(len(failures))
+	job.recovered = 0
+	job.mu.Unlock()
+	logger.Infof("Seed job %s retry started: %d tiles", job.state.ID, len(failures))
+
+	job.process(ctx, func(tiles chan<- tile) {
+		for _, t := range failures {
+			select {
+			case tiles <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}, func(t tile, outcome tileOutcome) {
+		if outcome != tileFailed {
+			job.mu.Lock()
+			job.recovered++
+			job.mu.Unlock()
+		}
+	})
+
+	if ctx.Err() != nil {
+		// put the unfinished tiles back
+		if err := appendFile(retryPath, job.failuresPath()); err != nil {
+			logger.Errorf("Seed job %s: restore failures file error: %v", job.state.ID, err)
+		}
+	}
+	_ = os.Remove(retryPath)
+
+	job.mu.Lock()
+	if ctx.Err() != nil {
+		// recovered tiles are still listed in the failures file, count them on the next retry
+		job.recovered = 0
+	} else {
+		job.state.Completed += job.recovered
+		job.state.Failed -= job.recovered
+		job.recovered = 0
+	}
+	job.mu.Unlock()
+	return nil
+}
+
+// process fetches the tiles sent by produce with `Concurrency` workers, finish is called for every processed tile.
+// Tiles interrupted by cancellation are not finished, the pass is aborted if the provider is removed.
+// process 使用 Concurrency 个工作协程获取 produce 产生的瓦片，每个处理完的瓦片都会调用 finish，被取消中断的瓦片不会调用，
+// 地图源被移除时中止当前阶段
+func (job *Job) process(ctx context.Context, produce func(tiles chan<- tile), finish func(t tile, outcome tileOutcome)) {
+	tiles := make(chan tile)
+	var wg sync.WaitGroup
+	for range job.request.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tiles {
+				outcome, err := job.seedTile(ctx, t)
+				if errors.Is(err, errProviderRemoved) {
+					job.abort(err)
+					continue
+				}
+				if errors.Is(err, context.Canceled) {
+					continue
+				}
+				if outcome == tileFailed {
+					job.recordFailure(t, err)
+				}
+				finish(t, outcome)
+			}
+		}()
+	}
+
+	produce(tiles)
+	close(tiles)
+	wg.Wait()
+}
+
+// seedTile fetches one tile through the tile cache like the tile requests, tiles which are fresh on disk are skipped
+// seedTile 与瓦片请求一样经瓦片缓存获取一个瓦片，磁盘上未过期的瓦片会被跳过
+func (job *Job) seedTile(ctx context.Context, t tile) (tileOutcome, error) {
+	cache := utils.GetCache()
+	if cache == nil {
+		return tileFailed, errors.New("cache is disabled")
+	}
+	// the registry is held until the tile is fetched, a reload does not close the provider meanwhile
+	// 获取瓦片期间持有注册表，重载不会在此期间关闭地图源
+	registry, release := mapprovider.AcquireRegistry()
+	defer release()
+	provider, ok := registry.GetProvider(job.request.ProviderID)
+	if !ok {
+		return tileFailed, fmt.Errorf("%w: tile map source %s not found", errProviderRemoved, job.request.ProviderID)
+	}
+
+	param := &tileservice.TileMapPathParam{MapType: job.request.ProviderID, X: t.x, Y: t.y, Z: t.z}
+	// only the disk tier is checked, so that the seeded region is not loaded into memory
+	// 只检查磁盘层，预热区域不会被加载到内存
+	if _, storedAt, err := utils.GetCacheWithTime(utils.BackingCache(cache), tileservice.TileCacheKey(provider, param)); err == nil {
+		policy := utils.CachePolicy{}
+		if conf := config.GetConfig(); conf != nil {
+			policy = conf.Cache.Policy(job.request.ProviderID)
+		}
+		if policy.Freshness(storedAt, time.Now()) == utils.TileFresh {
+			return tileSkipped, nil
+		}
+	}
+
+	var err error
+	for attempt := range maxAttempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * retryBackoff):
+			case <-ctx.Done():
+				return tileFailed, ctx.Err()
+			}
+		}
+
+		var picBytes []byte
+		picBytes, _, err = tileservice.FetchTile(provider, param, true)
+		if err == nil && len(picBytes) == 0 {
+			err = errors.New("tile map picture is empty")
+		}
+		if err == nil {
+			return tileSeeded, nil
+		}
+	}
+	return tileFailed, err
+}
+
+// readFailures reads the `z/x/y` lines of a failures file without duplicates
+func readFailures(path string) ([]tile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var failures []tile
+	seen := make(map[tile]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var t tile
+		if _, err := fmt.Sscanf(scanner.Text(), "%d/%d/%d", &t.z, &t.x, &t.y); err != nil {
+			logger.Warnf("Invalid failed tile %q in %s", scanner.Text(), path)
+			continue
+		}
+		if !seen[t] {
+			seen[t] = true
+			failures = append(failures, t)
+		}
+	}
+	return failures, scanner.Err()
+}
+
+// appendFile appends the content of src to dst
+func appendFile(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dst, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(content)
+	return err
+}
@@ -0,0 +1,239 @@
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// failingProvider returns a fake provider failing on tiles with x == failX
+func failingProvider(failX *atomic.Int32) *testutil.FakeProvider {
+	return &testutil.FakeProvider{Fail: func(x, y, z int) bool { return x == int(failX.Load()) }}
+}
+
+// newTestJob returns a stopped job seeding the whole world from z0 to z2 (21 tiles),
+// the provider is registered as `fake` until the test ends
+func newTestJob(t *testing.T, provider mapprovider.TileMapProvider) *Job {
+	t.Helper()
+	retryBackoff = 0
+	testutil.InitConfig(t, "cache:\n  path: "+filepath.Join(t.TempDir(), "cache")+"\n")
+	previous := mapprovider.SetRegistry(&mapprovider.Registry{MapSourceIndex: map[string]mapprovider.TileMapProvider{"fake": provider}})
+	t.Cleanup(func() { mapprovider.SetRegistry(previous) })
+
+	region, _ := NewBBoxRegion(BBox{-180, -85, 180, 85})
+	request := JobRequest{ProviderID: "fake", MinZoom: 0, MaxZoom: 2, Concurrency: 4}
+	return &Job{
+		request:  request,
+		region:   region,
+		stateDir: t.TempDir(),
+		cancel:   func() {},
+		state:    JobInfo{ID: "test", Request: request, Status: JobRunning, Pass: PassSeed},
+		pending:  make(map[int64]tileOutcome),
+	}
+}
+
+func runJob(job *Job, ctx context.Context) {
+	job.run(ctx, make(chan struct{}))
+}
+
+func TestJobRun(t *testing.T) {
+	cache := utils.NewMemoryCache(1 << 20)
+	utils.SetCache(cache)
+	defer utils.SetCache(nil)
+
+	var failX atomic.Int32
+	failX.Store(3)
+	provider := failingProvider(&failX)
+	job := newTestJob(t, provider)
+	runJob(job, context.Background())
+
+	info := job.Info()
+	// x == 3 only exists at z2, one column of 4 tiles
+	if info.Status != JobCompleted || info.Total != 21 || info.Completed != 17 || info.Failed != 4 || info.Checkpoint != 21 {
+		t.Errorf("unexpected job info: %+v", info)
+	}
+	if info.Progress != 1 || info.LastError == "" {
+		t.Errorf("unexpected progress %f or last error %q", info.Progress, info.LastError)
+	}
+	if calls := provider.Calls.Load(); calls != 17+4*maxAttempts {
+		t.Errorf("expected %d upstream calls, got %d", 17+4*maxAttempts, calls)
+	}
+	if value, err := cache.GetCache("fake/2/1/2.png"); err != nil || string(value) != "tile" {
+		t.Errorf("tile not cached: %q %v", value, err)
+	}
+
+	// state and failures are persisted
+	var saved JobInfo
+	raw, err := os.ReadFile(job.statePath())
+	if err != nil || json.Unmarshal(raw, &saved) != nil || saved.Status != JobCompleted || saved.Checkpoint != 21 {
+		t.Errorf("unexpected saved state: %s %v", raw, err)
+	}
+	failures, err := readFailures(job.failuresPath())
+	if err != nil || len(failures) != 4 {
+		t.Errorf("expected 4 failed tiles, got %v %v", failures, err)
+	}
+
+	// tiles already cached are skipped on the next run, without loading them into the memory tier
+	memory := utils.NewMemoryCache(1 << 20)
+	utils.SetCache(utils.NewTieredCache(memory, cache))
+	provider.Calls.Store(0)
+	job = newTestJob(t, provider)
+	runJob(job, context.Background())
+	if info := job.Info(); info.Skipped != 17 || info.Failed != 4 || provider.Calls.Load() != 4*maxAttempts {
+		t.Errorf("expected cached tiles to be skipped: %+v, %d calls", info, provider.Calls.Load())
+	}
+	if stats := memory.Stats().(utils.MemoryCacheStats); stats.Items != 0 {
+		t.Errorf("expected skipped tiles not to be loaded into memory, got %d items", stats.Items)
+	}
+}
+
+func TestJobResumeFromCheckpoint(t *testing.T) {
+	utils.SetCache(utils.NewMemoryCache(1 << 20))
+	defer utils.SetCache(nil)
+
+	provider := &testutil.FakeProvider{}
+	job := newTestJob(t, provider)
+	job.state.Checkpoint = 5
+	job.state.Completed = 5
+	runJob(job, context.Background())
+
+	if info := job.Info(); info.Completed != 21 || info.Checkpoint != 21 || provider.Calls.Load() != 16 {
+		t.Errorf("expected to resume after 5 tiles: %+v, %d calls", info, provider.Calls.Load())
+	}
+}
+
+func TestJobRetry(t *testing.T) {
+	utils.SetCache(utils.NewMemoryCache(1 << 20))
+	defer utils.SetCache(nil)
+
+	var failX atomic.Int32
+	failX.Store(3)
+	provider := failingProvider(&failX)
+	job := newTestJob(t, provider)
+	runJob(job, context.Background())
+
+	// upstream recovers
+	failX.Store(-1)
+	provider.Calls.Store(0)
+	job.state.Pass = PassRetry
+	runJob(job, context.Background())
+
+	if info := job.Info(); info.Status != JobCompleted || info.Completed != 21 || info.Failed != 0 || provider.Calls.Load() != 4 {
+		t.Errorf("unexpected job info after retry: %+v, %d calls", info, provider.Calls.Load())
+	}
+	if _, err := os.Stat(job.failuresPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no failures file, got %v", err)
+	}
+}
+
+func TestJobStop(t *testing.T) {
+	utils.SetCache(utils.NewMemoryCache(1 << 20))
+	defer utils.SetCache(nil)
+
+	provider := &testutil.FakeProvider{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// cancelled by user
+	job := newTestJob(t, provider)
+	job.request.MaxZoom = 10
+	runJob(job, ctx)
+	if info := job.Info(); info.Status != JobCancelled || info.FinishedAt == nil || info.Checkpoint >= info.Total {
+		t.Errorf("unexpected job info after cancel: %+v", info)
+	}
+
+	// stopped by shutdown, resumed on next start
+	job = newTestJob(t, provider)
+	job.request.MaxZoom = 10
+	job.shutdown = true
+	runJob(job, ctx)
+	if info := job.Info(); info.Status != JobRunning || info.FinishedAt != nil {
+		t.Errorf("unexpected job info after shutdown: %+v", info)
+	}
+}
+
+func TestManagerResume(t *testing.T) {
+	stateDir := t.TempDir()
+	for _, state := range []JobInfo{
+		{ID: "done", Request: JobRequest{ProviderID: "open_street_map_standard", MaxZoom: 2, BBox: &BBox{-180, -85, 180, 85}}, Status: JobCompleted},
+		{ID: "gone", Request: JobRequest{ProviderID: "removed_provider", MaxZoom: 2, BBox: &BBox{-180, -85, 180, 85}}, Status: JobRunning},
+	} {
+		raw, _ := json.Marshal(state)
+		if err := os.WriteFile(filepath.Join(stateDir, state.ID+".json"), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := NewManager(stateDir)
+	if err := manager.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	if job, err := manager.Get("done"); err != nil || job.Info().Status != JobCompleted {
+		t.Errorf("expected completed job to be loaded: %v", err)
+	}
+	// running job whose provider was removed can not be resumed
+	job, err := manager.Get("gone")
+	if err != nil || job.Info().Status != JobFailed {
+		t.Fatalf("expected failed job: %v", err)
+	}
+	if _, err := manager.Retry("gone"); err == nil {
+		t.Error("expected retry of failed job to be rejected")
+	}
+}
+
+func TestManagerCreateMaxTiles(t *testing.T) {
+	testutil.InitConfig(t, "cache:\n  path: "+filepath.Join(t.TempDir(), "cache")+"\nseed:\n  max_tiles: 20\n")
+	utils.SetCache(utils.NewMemoryCache(1 << 20))
+	defer utils.SetCache(nil)
+
+	manager := NewManager(t.TempDir())
+	defer manager.Close()
+	// the whole world from z0 to z2 is 21 tiles
+	request := JobRequest{ProviderID: "open_street_map_standard", MaxZoom: 2, BBox: &BBox{-180, -85, 180, 85}}
+	if _, err := manager.Create(request); err == nil || !strings.Contains(err.Error(), "seed.max_tiles") {
+		t.Errorf("expected max tiles error, got %v", err)
+	}
+	if jobs := manager.List(); len(jobs) != 0 {
+		t.Errorf("expected rejected job not to be listed, got %v", jobs)
+	}
+}
+
+func TestJobFollowsRegistry(t *testing.T) {
+	utils.SetCache(utils.NewMemoryCache(1 << 20))
+	defer utils.SetCache(nil)
+
+	// the first tile swaps the registry, the following tiles are fetched from the new provider
+	replacement := &testutil.FakeProvider{}
+	var swap sync.Once
+	provider := &testutil.FakeProvider{Fail: func(x, y, z int) bool {
+		swap.Do(func() {
+			mapprovider.SetRegistry(&mapprovider.Registry{MapSourceIndex: map[string]mapprovider.TileMapProvider{"fake": replacement}})
+		})
+		return false
+	}}
+	job := newTestJob(t, provider)
+	runJob(job, context.Background())
+	if info := job.Info(); info.Status != JobCompleted || info.Completed != 21 || provider.Calls.Load() != 1 || replacement.Calls.Load() != 20 {
+		t.Errorf("expected tiles after the swap from the new provider: %+v, %d and %d calls", info, provider.Calls.Load(), replacement.Calls.Load())
+	}
+
+	// the provider is removed by a reload, the job fails instead of failing every tile
+	mapprovider.SetRegistry(&mapprovider.Registry{MapSourceIndex: map[string]mapprovider.TileMapProvider{}})
+	job.request.MaxZoom = 3
+	job.state.Status = JobRunning
+	runJob(job, context.Background())
+	if info := job.Info(); info.Status != JobFailed || !strings.Contains(info.LastError, "provider is removed") || info.Failed != 0 {
+		t.Errorf("unexpected job info after the provider is removed: %+v", info)
+	}
+}
@@ -0,0 +1,301 @@
+package seed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("seed job not found")
+
+// StateDir returns the directory of seeding job states next to the cache directory, `<cache.path>_seed`
+// StateDir 返回与缓存目录相邻的预热任务状态目录 `<cache.path>_seed`
+func StateDir(cachePath string) string {
+	return filepath.Clean(cachePath) + "_seed"
+}
+
+// Manager creates, tracks, resumes and cancels seeding jobs
+// Manager 负责创建、跟踪、恢复和取消预热任务
+type Manager struct {
+	stateDir string
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewManager(stateDir string) *Manager {
+	return &Manager{
+		stateDir: stateDir,
+		jobs:     make(map[string]*Job),
+	}
+}
+
+// Create validates the request and starts the job in background,
+// jobs whose bbox is covered by more than `seed.max_tiles` tiles are rejected
+// Create 校验请求并在后台启动任务，外包框覆盖瓦片数超过 `seed.max_tiles` 的任务会被拒绝
+func (manager *Manager) Create(request JobRequest) (*Job, error) {
+	conf := config.GetConfig()
+	if conf == nil || !conf.Cache.Enable || utils.GetCache() == nil {
+		return nil, errors.New("cache is disabled")
+	}
+
+	if request.Concurrency <= 0 {
+		request.Concurrency = defaultConcurrency
+	}
+	request.Concurrency = min(request.Concurrency, maxConcurrency)
+
+	job, err := manager.newJob(JobInfo{
+		ID:        newJobID(),
+		Request:   request,
+		Status:    JobRunning,
+		Pass:      PassSeed,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the tiles of the bbox are counted without enumerating them, the request is not held by a huge region
+	// 外包框瓦片数无需枚举即可算出，巨大区域不会阻塞请求
+	if maxTiles := conf.Seed.MaxTiles; maxTiles > 0 {
+		if count := job.region.CountBBoxTiles(request.MinZoom, request.MaxZoom); count > maxTiles {
+			return nil, fmt.Errorf("seed job covers up to %d tiles, more than seed.max_tiles %d", count, maxTiles)
+		}
+	}
+	if err := job.save(); err != nil {
+		return nil, fmt.Errorf("save seed job state error: %w", err)
+	}
+
+	manager.mu.Lock()
+	manager.jobs[job.state.ID] = job
+	manager.mu.Unlock()
+
+	manager.start(job)
+	return job, nil
+}
+
+// newJob builds a stopped job from its state
+func (manager *Manager) newJob(state JobInfo) (*Job, error) {
+	request := state.Request
+	provider, ok := mapprovider.GetRegistry().GetProvider(request.ProviderID)
+	if !ok {
+		return nil, fmt.Errorf("tile map source %s not found", request.ProviderID)
+	}
+	if mapprovider.IsLocal(provider) {
+		return nil, fmt.Errorf("tile map source %s reads local tiles, which are not cached", request.ProviderID)
+	}
+
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+	if request.MinZoom > request.MaxZoom {
+		return nil, fmt.Errorf("min_zoom %d is greater than max_zoom %d", request.MinZoom, request.MaxZoom)
+	}
+	if request.MinZoom < metadata.MinZoom || request.MaxZoom > metadata.MaxZoom {
+		return nil, fmt.Errorf("zoom range [%d, %d] is out of the provider range [%d, %d]", request.MinZoom, request.MaxZoom, metadata.MinZoom, metadata.MaxZoom)
+	}
+
+	var region *Region
+	var err error
+	switch {
+	case request.BBox != nil && len(request.Polygon) > 0:
+		return nil, errors.New("only one of bbox and polygon can be set")
+	case request.BBox != nil:
+		region, err = NewBBoxRegion(*request.BBox)
+	case len(request.Polygon) > 0:
+		region, err = NewPolygonRegion(request.Polygon)
+	default:
+		return nil, errors.New("bbox or polygon is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	close(done)
+	return &Job{
+		request:  request,
+		region:   region,
+		stateDir: manager.stateDir,
+		cancel:   func() {},
+		done:     done,
+		state:    state,
+		pending:  make(map[int64]tileOutcome),
+	}, nil
+}
+
+// start runs the current pass of a stopped job in background
+func (manager *Manager) start(job *Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	job.mu.Lock()
+	job.cancel = cancel
+	job.done = done
+	job.shutdown = false
+	job.state.Status = JobRunning
+	job.state.FinishedAt = nil
+	job.mu.Unlock()
+
+	go job.run(ctx, done)
+}
+
+// Resume loads the saved jobs and restarts the running ones
+// Resume 加载已保存的任务并重新启动运行中的任务
+func (manager *Manager) Resume() error {
+	entries, err := os.ReadDir(manager.stateDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(manager.stateDir, entry.Name()))
+		if err != nil {
+			logger.Errorf("Read seed job state %s error: %v", entry.Name(), err)
+			continue
+		}
+		var state JobInfo
+		if err := json.Unmarshal(raw, &state); err != nil || state.ID == "" {
+			logger.Errorf("Invalid seed job state %s: %v", entry.Name(), err)
+			continue
+		}
+
+		job, err := manager.newJob(state)
+		if err != nil {
+			// keep the job visible, it can not run anymore
+			logger.Errorf("Resume seed job %s error: %v", state.ID, err)
+			done := make(chan struct{})
+			close(done)
+			job = &Job{stateDir: manager.stateDir, cancel: func() {}, done: done, state: state, pending: make(map[int64]tileOutcome)}
+			if state.Status == JobRunning {
+				finishedAt := time.Now()
+				job.state.Status = JobFailed
+				job.state.LastError = err.Error()
+				job.state.FinishedAt = &finishedAt
+				job.saveOrLog()
+			}
+		}
+
+		manager.mu.Lock()
+		manager.jobs[state.ID] = job
+		manager.mu.Unlock()
+
+		if job.state.Status == JobRunning {
+			logger.Infof("Resume seed job %s (%s pass) from tile %d", state.ID, state.Pass, state.Checkpoint)
+			manager.start(job)
+		}
+	}
+	return nil
+}
+
+// List returns all jobs, newest first
+func (manager *Manager) List() []JobInfo {
+	manager.mu.Lock()
+	jobs := make([]*Job, 0, len(manager.jobs))
+	for _, job := range manager.jobs {
+		jobs = append(jobs, job)
+	}
+	manager.mu.Unlock()
+
+	infos := make([]JobInfo, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, job.Info())
+	}
+	slices.SortFunc(infos, func(a, b JobInfo) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return infos
+}
+
+func (manager *Manager) Get(id string) (*Job, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	job, ok := manager.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return job, nil
+}
+
+// Cancel stops a running job, tiles being fetched are finished first
+// Cancel 停止运行中的任务，正在获取的瓦片会先完成
+func (manager *Manager) Cancel(id string) (*Job, error) {
+	job, err := manager.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	job.stop(false)
+	<-job.Done()
+	return job, nil
+}
+
+// Retry starts a retry pass over the failed tiles of a stopped job
+// Retry 对已停止任务的失败瓦片启动重试
+func (manager *Manager) Retry(id string) (*Job, error) {
+	job, err := manager.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	job.mu.Lock()
+	switch {
+	case job.state.Status == JobRunning:
+		err = fmt.Errorf("seed job %s is running", id)
+	case job.region == nil:
+		err = fmt.Errorf("seed job %s can not run: %s", id, job.state.LastError)
+	case job.state.Failed == 0:
+		err = fmt.Errorf("seed job %s has no failed tiles", id)
+	default:
+		// mark running now so concurrent retries are rejected
+		job.state.Status = JobRunning
+		job.state.Pass = PassRetry
+	}
+	job.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	manager.start(job)
+	return job, nil
+}
+
+// Close stops all running jobs and saves their state, they are resumed on next start
+// Close 停止所有运行中的任务并保存状态，下次启动时恢复
+func (manager *Manager) Close() {
+	manager.mu.Lock()
+	jobs := make([]*Job, 0, len(manager.jobs))
+	for _, job := range manager.jobs {
+		jobs = append(jobs, job)
+	}
+	manager.mu.Unlock()
+
+	for _, job := range jobs {
+		job.stop(true)
+	}
+	for _, job := range jobs {
+		<-job.Done()
+	}
+}
+
+func newJobID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,294 @@
+// Seeding region: a WGS84 bounding box or GeoJSON polygons, and the XYZ tiles covering it
+// 预热区域：WGS84 范围框或 GeoJSON 多边形，以及覆盖它的 XYZ 瓦片
+
+package seed
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/mapprovider"
+	"math"
+)
+
+// Point is a WGS84 coordinate `[lon, lat]`
+type Point [2]float64
+
+// Ring is a closed linear ring of a polygon
+type Ring []Point
+
+// Polygon is an outer ring followed by optional holes
+// Polygon 由外环和可选的内环（洞）组成
+type Polygon []Ring
+
+// BBox is a WGS84 bounding box `[minLon, minLat, maxLon, maxLat]`
+type BBox = mapprovider.BBox
+
+// Region is the area to seed, tiles are enumerated from the bbox and,
+// if polygons are set, only tiles intersecting a polygon are kept
+// Region 是预热区域，先按范围框枚举瓦片，如设置了多边形则只保留与多边形相交的瓦片
+type Region struct {
+	BBox     BBox
+	Polygons []Polygon
+}
+
+// NewBBoxRegion returns the region of a bounding box
+func NewBBoxRegion(bbox BBox) (*Region, error) {
+	if err := bbox.Validate(); err != nil {
+		return nil, err
+	}
+	return &Region{BBox: bbox}, nil
+}
+
+// NewPolygonRegion returns the region of a GeoJSON Polygon, MultiPolygon, Feature or FeatureCollection
+// NewPolygonRegion 返回 GeoJSON Polygon、MultiPolygon、Feature 或 FeatureCollection 对应的区域
+func NewPolygonRegion(geojson json.RawMessage) (*Region, error) {
+	polygons, err := parseGeoJSON(geojson)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON polygon: %w", err)
+	}
+	if len(polygons) == 0 {
+		return nil, errors.New("invalid GeoJSON polygon: no polygon found")
+	}
+
+	bbox := BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, polygon := range polygons {
+		for _, point := range polygon[0] {
+			bbox[0] = math.Min(bbox[0], point[0])
+			bbox[1] = math.Min(bbox[1], point[1])
+			bbox[2] = math.Max(bbox[2], point[0])
+			bbox[3] = math.Max(bbox[3], point[1])
+		}
+	}
+	if err := bbox.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON polygon: %w", err)
+	}
+	return &Region{BBox: bbox, Polygons: polygons}, nil
+}
+
+// tileRange returns the tile range of the bbox at zoom z, inclusive
+func (region *Region) tileRange(z int) (minX, minY, maxX, maxY int) {
+	return region.BBox.TileRange(z)
+}
+
+// ForEachTile calls fn for every tile covering the region at zoom z in row-major order,
+// it stops when fn returns false
+// ForEachTile 按行优先顺序对 z 级别覆盖区域的每个瓦片调用 fn，fn 返回 false 时停止
+func (region *Region) ForEachTile(z int, fn func(x, y int) bool) bool {
+	minX, minY, maxX, maxY := region.tileRange(z)
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			if !region.coversTile(x, y, z) {
+				continue
+			}
+			if !fn(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CountTiles returns the number of tiles covering the region from minZoom to maxZoom
+func (region *Region) CountTiles(minZoom, maxZoom int) int64 {
+	if len(region.Polygons) == 0 {
+		return region.CountBBoxTiles(minZoom, maxZoom)
+	}
+	var count int64
+	for z := minZoom; z <= maxZoom; z++ {
+		region.ForEachTile(z, func(x, y int) bool {
+			count++
+			return true
+		})
+	}
+	return count
+}
+
+// CountBBoxTiles returns the number of tiles covering the bbox of the region from minZoom to maxZoom,
+// an upper bound of CountTiles computed without enumerating the tiles
+// CountBBoxTiles 返回 minZoom 到 maxZoom 覆盖区域外包框的瓦片数，是无需枚举瓦片即可算出的 CountTiles 上限
+func (region *Region) CountBBoxTiles(minZoom, maxZoom int) int64 {
+	var count int64
+	for z := minZoom; z <= maxZoom; z++ {
+		minX, minY, maxX, maxY := region.tileRange(z)
+		count += int64(maxX-minX+1) * int64(maxY-minY+1)
+	}
+	return count
+}
+
+func (region *Region) coversTile(x, y, z int) bool {
+	if len(region.Polygons) == 0 {
+		return true
+	}
+	tile := mapprovider.TileBBox(x, y, z)
+	for _, polygon := range region.Polygons {
+		if polygonIntersectsBBox(polygon, tile) {
+			return true
+		}
+	}
+	return false
+}
+
+// polygonIntersectsBBox reports whether the polygon and the box overlap,
+// a box lying completely inside a hole does not overlap
+// polygonIntersectsBBox 判断多边形与矩形是否重叠，完全位于洞内的矩形不算重叠
+func polygonIntersectsBBox(polygon Polygon, bbox BBox) bool {
+	if !ringIntersectsBBox(polygon[0], bbox) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if bboxInsideRing(bbox, hole) {
+			return false
+		}
+	}
+	return true
+}
+
+func ringIntersectsBBox(ring Ring, bbox BBox) bool {
+	// a vertex of the ring inside the box
+	for _, point := range ring {
+		if bbox.Contains(point) {
+			return true
+		}
+	}
+	// the box inside the ring
+	if pointInRing(Point{bbox[0], bbox[1]}, ring) {
+		return true
+	}
+	// an edge of the ring crossing the box
+	return ringCrossesBBox(ring, bbox)
+}
+
+func bboxInsideRing(bbox BBox, ring Ring) bool {
+	for _, point := range ring {
+		if bbox.Contains(point) {
+			return false
+		}
+	}
+	if ringCrossesBBox(ring, bbox) {
+		return false
+	}
+	return pointInRing(Point{bbox[0], bbox[1]}, ring)
+}
+
+func ringCrossesBBox(ring Ring, bbox BBox) bool {
+	corners := [4]Point{{bbox[0], bbox[1]}, {bbox[2], bbox[1]}, {bbox[2], bbox[3]}, {bbox[0], bbox[3]}}
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		edgeBBox := BBox{math.Min(a[0], b[0]), math.Min(a[1], b[1]), math.Max(a[0], b[0]), math.Max(a[1], b[1])}
+		if !edgeBBox.Intersects(bbox) {
+			continue
+		}
+		for j := range corners {
+			if segmentsIntersect(a, b, corners[j], corners[(j+1)%4]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pointInRing is the ray casting point in polygon test
+// pointInRing 使用射线法判断点是否在多边形内
+func pointInRing(point Point, ring Ring) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > point[1]) != (b[1] > point[1]) &&
+			point[0] < (b[0]-a[0])*(point[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func segmentsIntersect(p1, p2, q1, q2 Point) bool {
+	d1 := cross(q1, q2, p1)
+	d2 := cross(q1, q2, p2)
+	d3 := cross(p1, p2, q1)
+	d4 := cross(p1, p2, q2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(q1, q2, p1)) || (d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) || (d4 == 0 && onSegment(p1, p2, q2))
+}
+
+func cross(origin, a, b Point) float64 {
+	return (a[0]-origin[0])*(b[1]-origin[1]) - (a[1]-origin[1])*(b[0]-origin[0])
+}
+
+func onSegment(a, b, point Point) bool {
+	return point[0] >= math.Min(a[0], b[0]) && point[0] <= math.Max(a[0], b[0]) &&
+		point[1] >= math.Min(a[1], b[1]) && point[1] <= math.Max(a[1], b[1])
+}
+
+// minimal GeoJSON object, only the members needed to find polygons
+type geoJSONObject struct {
+	Type        string           `json:"type"`
+	Coordinates json.RawMessage  `json:"coordinates"`
+	Geometry    *geoJSONObject   `json:"geometry"`
+	Features    []*geoJSONObject `json:"features"`
+}
+
+func parseGeoJSON(raw json.RawMessage) ([]Polygon, error) {
+	var object geoJSONObject
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	return object.polygons()
+}
+
+func (object *geoJSONObject) polygons() ([]Polygon, error) {
+	switch object.Type {
+	case "Polygon":
+		var polygon Polygon
+		if err := json.Unmarshal(object.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		if err := validatePolygon(polygon); err != nil {
+			return nil, err
+		}
+		return []Polygon{polygon}, nil
+	case "MultiPolygon":
+		var polygons []Polygon
+		if err := json.Unmarshal(object.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+		for _, polygon := range polygons {
+			if err := validatePolygon(polygon); err != nil {
+				return nil, err
+			}
+		}
+		return polygons, nil
+	case "Feature":
+		if object.Geometry == nil {
+			return nil, errors.New("feature has no geometry")
+		}
+		return object.Geometry.polygons()
+	case "FeatureCollection":
+		var polygons []Polygon
+		for _, feature := range object.Features {
+			featurePolygons, err := feature.polygons()
+			if err != nil {
+				return nil, err
+			}
+			polygons = append(polygons, featurePolygons...)
+		}
+		return polygons, nil
+	default:
+		return nil, fmt.Errorf("unsupported GeoJSON type %q, expect Polygon, MultiPolygon, Feature or FeatureCollection", object.Type)
+	}
+}
+
+func validatePolygon(polygon Polygon) error {
+	if len(polygon) == 0 {
+		return errors.New("polygon has no ring")
+	}
+	for _, ring := range polygon {
+		if len(ring) < 4 {
+			return fmt.Errorf("polygon ring has %d positions, at least 4 are required", len(ring))
+		}
+	}
+	return nil
+}
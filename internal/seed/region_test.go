@@ -0,0 +1,80 @@
+package seed
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBBoxRegion(t *testing.T) {
+	region, err := NewBBoxRegion(BBox{-180, -85, 180, 85})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// whole world: 1 + 4 + 16 tiles
+	if count := region.CountTiles(0, 2); count != 21 {
+		t.Errorf("expected 21 tiles, got %d", count)
+	}
+
+	if _, err := NewBBoxRegion(BBox{114, 23, 113, 24}); err == nil {
+		t.Error("expected error for inverted bbox")
+	}
+}
+
+func TestPolygonRegion(t *testing.T) {
+	// triangle in the north-east quadrant, z1 tile 1/0 only
+	triangle := json.RawMessage(`{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[10, 10], [170, 10], [10, 80], [10, 10]]]}}`)
+	region, err := NewPolygonRegion(triangle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tiles [][2]int
+	region.ForEachTile(1, func(x, y int) bool {
+		tiles = append(tiles, [2]int{x, y})
+		return true
+	})
+	if len(tiles) != 1 || tiles[0] != [2]int{1, 0} {
+		t.Errorf("expected tile 1/0 at z1, got %v", tiles)
+	}
+
+	// at z2 the triangle does not reach the north-east corner tile 3/0
+	region.ForEachTile(2, func(x, y int) bool {
+		if x == 3 && y == 0 {
+			t.Error("tile 3/0 at z2 is outside the triangle")
+		}
+		return true
+	})
+	if count, bboxCount := region.CountTiles(2, 2), int64(4); count >= bboxCount {
+		t.Errorf("expected fewer tiles than the bbox, got %d", count)
+	}
+}
+
+func TestPolygonRegionHole(t *testing.T) {
+	// square with a hole covering z2 tile 2/1 (0..90 lon, 0..66.5 lat)
+	square := json.RawMessage(`{"type": "Polygon", "coordinates": [
+		[[-10, -10], [100, -10], [100, 75], [-10, 75], [-10, -10]],
+		[[-5, -5], [95, -5], [95, 70], [-5, 70], [-5, -5]]
+	]}`)
+	region, err := NewPolygonRegion(square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region.coversTile(2, 1, 2) {
+		t.Error("tile 2/1 at z2 lies in the hole")
+	}
+	if !region.coversTile(1, 1, 2) {
+		t.Error("tile 1/1 at z2 crosses the outer ring")
+	}
+}
+
+func TestPolygonRegionInvalid(t *testing.T) {
+	for _, raw := range []string{
+		`{"type": "Point", "coordinates": [0, 0]}`,
+		`{"type": "Polygon", "coordinates": [[[0, 0], [1, 1], [0, 0]]]}`,
+		`{"type": "FeatureCollection", "features": []}`,
+	} {
+		if _, err := NewPolygonRegion(json.RawMessage(raw)); err == nil {
+			t.Errorf("expected error for %s", raw)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package testutil provides the fake providers, tiles and config shared by the tests of the handlers and the seeder
+// Package testutil 提供处理器和预热任务测试共用的模拟地图源、瓦片和配置
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/pkg/mapprovider"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// FakeProvider returns Body as PNG tiles after Delay and counts upstream calls in Calls,
+// tiles for which Fail returns true are upstream errors
+type FakeProvider struct {
+	// nil means the metadata of id `fake`
+	Metadata *mapprovider.TileMapMetadata
+	// nil means `tile`
+	Body  []byte
+	Delay time.Duration
+	Fail  func(x, y, z int) bool
+	Calls atomic.Int32
+}
+
+func (fp *FakeProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	fp.Calls.Add(1)
+	time.Sleep(fp.Delay)
+	if fp.Fail != nil && fp.Fail(x, y, z) {
+		return nil, errors.New("upstream error")
+	}
+	body := fp.Body
+	if body == nil {
+		body = []byte("tile")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"image/png"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+func (fp *FakeProvider) GetMapMetadata() *mapprovider.TileMapMetadata {
+	if fp.Metadata == nil {
+		return &mapprovider.TileMapMetadata{Name: "fake", ID: "fake"}
+	}
+	return fp.Metadata
+}
+
+// InitConfig loads the config from a file in a temporary directory with the YAML content
+func InitConfig(t testing.TB, content string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.InitConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	return config.GetConfig()
+}
+
+// SolidTile returns a PNG tile of size x size pixels filled with the color
+func SolidTile(t testing.TB, size int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,280 @@
+// Package tileservice fetches tiles through the tile cache with request coalescing and stale handling,
+// shared by the tile handlers and the cache seeder
+// Package tileservice 经瓦片缓存获取瓦片，包括请求合并和过期处理，供瓦片处理器和缓存预热共用
+package tileservice
+
+import (
+	"bytes"
+	"fmt"
+	"go-map-proxy/internal/config"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/logger"
+	"go-map-proxy/pkg/mapprovider"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TileMapPathParam is the tile of a map, bound from the path of tile requests
+type TileMapPathParam struct {
+	MapType string `param:"mapType" json:"mapType"`
+	X       int    `param:"x" json:"x"`
+	Y       int    `param:"y" json:"y"`
+	Z       int    `param:"z" json:"z"`
+}
+
+// FetchTile returns the tile picture of the provider through the tile cache, with the same request coalescing
+// and stale handling as the tile handler, for seeding and protocols composing images from tiles (WMS ...)
+// FetchTile 通过瓦片缓存获取地图源瓦片，请求合并和过期处理与瓦片处理器相同，供缓存预热和由瓦片合成图片的协议（WMS 等）使用
+func FetchTile(provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam, useCache bool) (picBytes []byte, contentType string, err error) {
+	tile, err := FetchCachedTile(config.GetConfig(), utils.GetCache(), provider, tileMapParam, useCache)
+	return tile.PicBytes, tile.ContentType, err
+}
+
+// FetchCachedTile returns the tile from the cache if it is fresh, otherwise from the provider with request coalescing.
+// Stale tiles are served while refreshed in background, expired tiles are served if the provider fails within
+// stale-if-error. The cache is not used if `useCache` is false, the cache is disabled or the provider reads local files.
+// FetchCachedTile 瓦片新鲜时从缓存返回，否则合并请求从地图源获取。过期但仍在 stale-while-revalidate 内的瓦片
+// 直接返回并在后台刷新，地图源失败时在 stale-if-error 内返回已过期的瓦片。useCache 为 false、缓存关闭或地图源读取本地文件时不使用缓存
+func FetchCachedTile(cfg *config.Config, cache utils.Cacher, provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam, useCache bool) (Tile, error) {
+	providerMetadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+	if cfg == nil || !cfg.Cache.Enable || cache == nil || mapprovider.IsLocal(provider) {
+		useCache = false
+	}
+	// path map cache key
+	cacheKey := TileCacheKey(provider, tileMapParam)
+
+	// stale tile kept for stale-if-error
+	var staleData []byte
+	var staleStoredAt time.Time
+	var policy utils.CachePolicy
+
+	if useCache {
+		policy = cfg.Cache.Policy(tileMapParam.MapType)
+		// check if tile map picture is in cache
+		if cacheData, storedAt, err := utils.GetCacheWithTime(cache, cacheKey); err == nil {
+			cached := Tile{PicBytes: cacheData, ContentType: string(providerMetadata.ContentType)}
+			switch policy.Freshness(storedAt, time.Now()) {
+			case utils.TileFresh:
+				logger.Debugf("Tile map cache hit: %s", cacheKey)
+				cached.CacheStatus = CacheHit
+				return cached, nil
+			case utils.TileStale:
+				// serve the stale tile and refresh it in background
+				// 返回过期瓦片并在后台刷新
+				logger.Debugf("Tile map cache stale: %s, refreshing in background", cacheKey)
+				refreshTileInBackground(cache, cacheKey, provider, tileMapParam)
+				cached.CacheStatus = CacheStale
+				return cached, nil
+			case utils.TileExpired:
+				logger.Debugf("Tile map cache expired: %s", cacheKey)
+				staleData, staleStoredAt = cacheData, storedAt
+			}
+		}
+		logger.Debugf("Tile map cache miss: %s", cacheKey)
+	}
+
+	// get tile map picture, concurrent misses of the same tile share one upstream request
+	tile, err := loadTile(cacheKey, provider, tileMapParam, cacheIfUsed(cache, useCache))
+	if (err != nil || len(tile.PicBytes) == 0) && staleData != nil && policy.UsableOnError(staleStoredAt, time.Now()) {
+		// serve the expired tile if the upstream fails within stale-if-error
+		// 上游失败时，在 stale-if-error 窗口内返回过期瓦片
+		logger.Warnf("Get tile map picture %s failed, serve stale cache: %v", cacheKey, err)
+		return Tile{PicBytes: staleData, ContentType: string(providerMetadata.ContentType), CacheStatus: CacheStaleIfError}, nil
+	}
+	if useCache {
+		tile.CacheStatus = CacheMiss
+	}
+	return tile, err
+}
+
+// TileCacheKey returns the cache key of the tile in the cache namespace and extension of the provider,
+// e.g. `osm/3/4/5.png`, the transcoded variants differ in the extension
+// TileCacheKey 返回瓦片在地图源缓存命名空间和扩展名下的缓存键，转码后的变体仅扩展名不同
+func TileCacheKey(provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam) string {
+	return utils.TileCacheKeyWithExtension(mapprovider.CacheID(provider), tileMapParam.Z, tileMapParam.X, tileMapParam.Y, mapprovider.CacheExtension(provider))
+}
+
+// StaleMaxAge is the max age in seconds of stale tiles served from the cache
+const StaleMaxAge = 60
+
+// CacheStatus is the cache status of a tile, sent in the `X-cache` header
+type CacheStatus string
+
+const (
+	CacheHit          CacheStatus = "HIT"
+	CacheMiss         CacheStatus = "MISS"
+	CacheStale        CacheStatus = "STALE"
+	CacheStaleIfError CacheStatus = "STALE-IF-ERROR"
+)
+
+// Tile is a fetched tile, shared by coalesced requests
+type Tile struct {
+	PicBytes    []byte
+	ContentType string
+	// member of the fallback chain which returned the tile, empty for other providers
+	Source string
+	// empty if the cache is not used
+	CacheStatus CacheStatus
+}
+
+// in-flight tile fetches keyed by cache key (`mapType/z/x/y.ext`)
+// 以缓存键为键的进行中瓦片请求
+var tileFlights utils.FlightGroup[Tile]
+
+// return the cache if it is used, otherwise nil
+func cacheIfUsed(cache utils.Cacher, isUseCache bool) utils.Cacher {
+	if isUseCache {
+		return cache
+	}
+	return nil
+}
+
+// loadTile fetches the tile with in-flight deduplication: concurrent calls with the same cache key
+// share one upstream request (including the GCJ02 reprojection). The leader saves the tile to
+// `cache` before the flight ends if it is not nil, so that a request arriving after the flight reads it from the cache
+// instead of starting another upstream request.
+// loadTile 对进行中的请求去重获取瓦片：相同缓存键的并发调用共享一次上游请求（包括 GCJ02 纠偏），
+// 由首个调用者在 cache 非空时于请求结束前写入缓存，之后到达的请求可直接从缓存读取，不会再次请求上游
+func loadTile(cacheKey string, provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam, cache utils.Cacher) (Tile, error) {
+	tile, err, shared := tileFlights.Do(cacheKey, func() (Tile, error) {
+		tile, err := fetchTile(provider, tileMapParam, cache != nil)
+		if err != nil {
+			return Tile{}, err
+		}
+		// save tile map picture to cache if cache is enabled
+		if cache != nil && len(tile.PicBytes) > 0 {
+			setTileCache(cache, cacheKey, tile.PicBytes)
+		}
+		return tile, nil
+	})
+	if shared {
+		logger.Debugf("Tile map request coalesced: %s", cacheKey)
+	}
+	return tile, err
+}
+
+// fetchTile gets the tile from the provider and reads the body.
+// The content type falls back to the provider metadata if the upstream does not return an image type.
+// Derived tiles (normalized 256px tiles, overzoomed tiles) are cut from the source tile fetched through the cache,
+// so the tiles cut from the same source tile share one upstream request. Composite maps and fallback chains
+// get their member tiles through the cache of the members.
+// fetchTile 从地图源获取瓦片并读取内容，上游未返回图片类型时回退到元数据中的类型。
+// 派生瓦片（归一化的 256 像素瓦片、超出最大级别的瓦片）从经缓存获取的源瓦片裁出，取自同一源瓦片的瓦片共享一次上游请求。
+// 组合图层和回退链经成员缓存获取成员瓦片
+func fetchTile(provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam, useCache bool) (Tile, error) {
+	providerMetadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+
+	// get tile map picture response
+	var tileMapPicResponse *http.Response
+	var err error
+	switch typed := provider.(type) {
+	case mapprovider.DerivedTileProvider:
+		tileMapPicResponse, err = fetchDerivedTile(typed, tileMapParam, useCache)
+	case mapprovider.MemberTileProvider:
+		tileMapPicResponse, err = typed.GetMemberTile(tileMapParam.X, tileMapParam.Y, tileMapParam.Z, func(member mapprovider.TileMapProvider, x, y, z int) (*http.Response, error) {
+			return fetchMemberTile(member, x, y, z, useCache)
+		})
+	default:
+		tileMapPicResponse, err = provider.GetMapPic(tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
+	}
+	if err != nil {
+		return Tile{}, err
+	}
+	defer tileMapPicResponse.Body.Close()
+
+	tile := Tile{
+		ContentType: tileMapPicResponse.Header.Get("Content-Type"),
+		Source:      tileMapPicResponse.Header.Get(mapprovider.TileSourceHeader),
+	}
+	if !strings.Contains(tile.ContentType, "image") {
+		logger.Errorf("Tile map picture content type is not image: %s, fallback to metadata content type", tile.ContentType)
+		tile.ContentType = string(providerMetadata.ContentType)
+	}
+
+	// read tile map picture body
+	tile.PicBytes, err = io.ReadAll(tileMapPicResponse.Body)
+	if err != nil {
+		return Tile{}, fmt.Errorf("read tile map picture error: %w", err)
+	}
+	return tile, nil
+}
+
+// fetchDerivedTile cuts the tile from the source tile, which is fetched through the cache of the source provider
+// fetchDerivedTile 从经源地图源缓存获取的源瓦片裁出瓦片
+func fetchDerivedTile(provider mapprovider.DerivedTileProvider, tileMapParam *TileMapPathParam, useCache bool) (*http.Response, error) {
+	sourceParam := &TileMapPathParam{MapType: provider.GetMapMetadata().ID}
+	var derived bool
+	sourceParam.X, sourceParam.Y, sourceParam.Z, derived = provider.SourceTile(tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
+	if !derived {
+		return provider.GetMapPic(tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
+	}
+	source, err := FetchCachedTile(config.GetConfig(), utils.GetCache(), provider.Source(), sourceParam, useCache)
+	if err != nil {
+		return nil, fmt.Errorf("get source tile %d/%d/%d error: %w", sourceParam.Z, sourceParam.X, sourceParam.Y, err)
+	}
+	if len(source.PicBytes) == 0 {
+		return nil, fmt.Errorf("source tile %d/%d/%d is empty", sourceParam.Z, sourceParam.X, sourceParam.Y)
+	}
+	response, err := provider.TileFromSource(source.PicBytes, tileMapParam.X, tileMapParam.Y, tileMapParam.Z)
+	if err == nil && source.Source != "" {
+		response.Header.Set(mapprovider.TileSourceHeader, source.Source)
+	}
+	return response, err
+}
+
+// fetchMemberTile fetches the tile of a member of a composite map or fallback chain through the cache of the member,
+// an empty tile is an error
+// fetchMemberTile 经成员缓存获取组合图层或回退链成员的瓦片，瓦片为空时返回错误
+func fetchMemberTile(member mapprovider.TileMapProvider, x, y, z int, useCache bool) (*http.Response, error) {
+	memberParam := &TileMapPathParam{MapType: member.GetMapMetadata().ID, X: x, Y: y, Z: z}
+	tile, err := FetchCachedTile(config.GetConfig(), utils.GetCache(), member, memberParam, useCache)
+	if err != nil {
+		return nil, err
+	}
+	if len(tile.PicBytes) == 0 {
+		return nil, fmt.Errorf("tile %d/%d/%d is empty", z, x, y)
+	}
+
+	header := http.Header{"Content-Type": []string{tile.ContentType}}
+	if tile.Source != "" {
+		header.Set(mapprovider.TileSourceHeader, tile.Source)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(tile.PicBytes)),
+		ContentLength: int64(len(tile.PicBytes)),
+	}, nil
+}
+
+func setTileCache(cache utils.Cacher, cacheKey string, picBytes []byte) {
+	if err := cache.SetCache(cacheKey, picBytes); err != nil {
+		logger.Errorf("Set tile map cache error: %v", err)
+	} else {
+		logger.Debugf("Set tile map cache success: %s", cacheKey)
+	}
+}
+
+// keys being refreshed in background
+var refreshingTiles sync.Map
+
+// refreshTileInBackground fetches the tile again and updates the cache, at most one refresh per key
+// refreshTileInBackground 在后台重新获取瓦片并更新缓存，每个键同时最多一个刷新
+func refreshTileInBackground(cache utils.Cacher, cacheKey string, provider mapprovider.TileMapProvider, tileMapParam *TileMapPathParam) {
+	if _, loaded := refreshingTiles.LoadOrStore(cacheKey, struct{}{}); loaded {
+		return
+	}
+
+	go func() {
+		defer refreshingTiles.Delete(cacheKey)
+
+		tile, err := loadTile(cacheKey, provider, tileMapParam, cache)
+		if err != nil || len(tile.PicBytes) == 0 {
+			// keep the stale tile
+			logger.Warnf("Refresh tile map cache %s failed: %v", cacheKey, err)
+		}
+	}()
+}
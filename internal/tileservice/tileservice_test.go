@@ -0,0 +1,105 @@
+package tileservice
+
+import (
+	"go-map-proxy/internal/testutil"
+	"go-map-proxy/internal/utils"
+	"go-map-proxy/pkg/mapprovider"
+	"go-map-proxy/pkg/mbtiles"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadTileCoalescing(t *testing.T) {
+	provider := &testutil.FakeProvider{Delay: 100 * time.Millisecond}
+	param := &TileMapPathParam{MapType: "fake", X: 1, Y: 2, Z: 3}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tile, err := loadTile("fake/3/1/2.png", provider, param, nil)
+			if err != nil || string(tile.PicBytes) != "tile" || tile.ContentType != "image/png" {
+				t.Errorf("unexpected result: %q %s %v", tile.PicBytes, tile.ContentType, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := provider.Calls.Load(); calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+}
+
+func TestLoadTileCachedBeforeReturn(t *testing.T) {
+	provider := &testutil.FakeProvider{}
+	param := &TileMapPathParam{MapType: "fake", X: 1, Y: 2, Z: 3}
+	cache := utils.NewMemoryCache(1 << 20)
+
+	if _, err := loadTile("fake/3/1/2.png", provider, param, cache); err != nil {
+		t.Fatal(err)
+	}
+	// the leader writes the cache inside the flight, a request after it hits the cache
+	if value, err := cache.GetCache("fake/3/1/2.png"); err != nil || string(value) != "tile" {
+		t.Errorf("tile is not cached when the flight ends: %q, %v", value, err)
+	}
+}
+
+func TestLoadTileFallback(t *testing.T) {
+	// primary has tile 1/0/0, backup has tiles 1/0/0 and 1/1/0
+	var defs []mapprovider.ProviderDefinition
+	for id, tiles := range map[string][][3]int{"primary": {{1, 0, 0}}, "backup": {{1, 0, 0}, {1, 1, 0}}} {
+		path := filepath.Join(t.TempDir(), id+".mbtiles")
+		writer, err := mbtiles.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tile := range tiles {
+			if err := writer.WriteTile(tile[0], tile[1], tile[2], []byte(id)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		defs = append(defs, mapprovider.ProviderDefinition{ID: id, Kind: mapprovider.ProviderKindMBTiles, Path: path, MaxZoom: 2, ContentType: "image/png"})
+	}
+	defs = append(defs, mapprovider.ProviderDefinition{
+		ID:      "chain",
+		Kind:    mapprovider.ProviderKindFallback,
+		Members: []mapprovider.CompositeMember{{ID: "primary"}, {ID: "backup"}},
+	})
+	registry, err := mapprovider.NewRegistry(defs, mapprovider.LayerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer registry.Close()
+	chain := registry.MapSourceIndex["chain"]
+
+	for _, tc := range []struct {
+		x, y, z int
+		source  string
+	}{
+		{0, 0, 1, "primary"},
+		{1, 0, 1, "backup"},
+		{0, 1, 1, ""},
+	} {
+		param := &TileMapPathParam{MapType: "chain", X: tc.x, Y: tc.y, Z: tc.z}
+		tile, err := loadTile(TileCacheKey(chain, param), chain, param, nil)
+		if tc.source == "" {
+			if err == nil || !strings.Contains(err.Error(), "map: chain all members failed") {
+				t.Errorf("%d/%d/%d: expected error, got %v", tc.z, tc.x, tc.y, err)
+			}
+			continue
+		}
+		if err != nil || tile.Source != tc.source || string(tile.PicBytes) != tc.source || tile.ContentType != "image/png" {
+			t.Errorf("%d/%d/%d: expected tile of %s, got %q (%s, source %s) %v", tc.z, tc.x, tc.y, tc.source, tile.PicBytes, tile.ContentType, tile.Source, err)
+		}
+	}
+	if key := TileCacheKey(chain, &TileMapPathParam{X: 1, Y: 0, Z: 1}); key != "chain/1/1/0.png" {
+		t.Errorf("unexpected cache key %s", key)
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import "time"
+
+// TileFreshness is the state of a cached tile according to its CachePolicy
+// TileFreshness 表示缓存瓦片根据 CachePolicy 判定的新鲜度状态
+type TileFreshness int
+
+const (
+	// within ttl, serve it
+	TileFresh TileFreshness = iota
+	// expired but within stale-while-revalidate, serve it and refresh in background
+	TileStale
+	// expired, fetch from upstream, serve it only if upstream fails within stale-if-error
+	TileExpired
+)
+
+// CachePolicy is the expiration policy of a provider's tiles, zero TTL never expires
+// CachePolicy 是地图源瓦片的过期策略，TTL 为 0 表示永不过期
+type CachePolicy struct {
+	// tiles older than TTL are expired
+	TTL time.Duration
+	// window after TTL in which expired tiles are served immediately while being refreshed in background
+	StaleWhileRevalidate time.Duration
+	// window after TTL in which expired tiles are served when the upstream fails
+	StaleIfError time.Duration
+}
+
+// Freshness returns the state of a tile stored at storedAt, unknown stored time is fresh
+// Freshness 返回在 storedAt 写入的瓦片的状态，写入时间未知视为新鲜
+func (policy CachePolicy) Freshness(storedAt, now time.Time) TileFreshness {
+	if policy.TTL <= 0 || storedAt.IsZero() {
+		return TileFresh
+	}
+
+	age := now.Sub(storedAt)
+	switch {
+	case age <= policy.TTL:
+		return TileFresh
+	case age <= policy.TTL+policy.StaleWhileRevalidate:
+		return TileStale
+	default:
+		return TileExpired
+	}
+}
+
+// UsableOnError reports whether a tile stored at storedAt may be served when the upstream fails
+// UsableOnError 判断上游失败时能否返回在 storedAt 写入的瓦片
+func (policy CachePolicy) UsableOnError(storedAt, now time.Time) bool {
+	if policy.TTL <= 0 || storedAt.IsZero() {
+		return true
+	}
+	return now.Sub(storedAt) <= policy.TTL+policy.StaleIfError
+}
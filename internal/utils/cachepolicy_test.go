@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachePolicyFreshness(t *testing.T) {
+	now := time.Now()
+	policy := CachePolicy{TTL: time.Hour, StaleWhileRevalidate: time.Hour, StaleIfError: 24 * time.Hour}
+
+	cases := []struct {
+		age           time.Duration
+		freshness     TileFreshness
+		usableOnError bool
+	}{
+		{30 * time.Minute, TileFresh, true},
+		{90 * time.Minute, TileStale, true},
+		{3 * time.Hour, TileExpired, true},
+		{30 * time.Hour, TileExpired, false},
+	}
+	for _, tc := range cases {
+		storedAt := now.Add(-tc.age)
+		if got := policy.Freshness(storedAt, now); got != tc.freshness {
+			t.Errorf("age %s: expected freshness %d, got %d", tc.age, tc.freshness, got)
+		}
+		if got := policy.UsableOnError(storedAt, now); got != tc.usableOnError {
+			t.Errorf("age %s: expected usable on error %t, got %t", tc.age, tc.usableOnError, got)
+		}
+	}
+
+	// zero ttl and unknown stored time never expire
+	if (CachePolicy{}).Freshness(now.Add(-1000*time.Hour), now) != TileFresh {
+		t.Errorf("zero ttl should never expire")
+	}
+	if policy.Freshness(time.Time{}, now) != TileFresh {
+		t.Errorf("unknown stored time should be fresh")
+	}
+}
@@ -0,0 +1,590 @@
+// Size-bounded disk cache with LRU or LFU eviction
+// 基于 LRU 或 LFU 淘汰策略的限容磁盘缓存
+
+package utils
+
+import (
+	"cmp"
+	"container/list"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// evict down to this ratio of the limit, so that eviction does not run on every write
+// 淘汰到限制的该比例以下，避免每次写入都触发淘汰
+const evictLowWatermark = 0.9
+
+// number of the path locks ordering writes and evictions of the same file
+const pathLockStripes = 64
+
+// EvictionPolicy selects the files evicted first when a limit is exceeded
+// EvictionPolicy 决定超出限制时优先淘汰的文件
+type EvictionPolicy string
+
+const (
+	// least recently used files first, the default
+	EvictionLRU EvictionPolicy = "lru"
+	// least frequently used files first, files with the same hits in least recently used order.
+	// Hits are counted since the file is indexed, files scanned at startup start with none.
+	EvictionLFU EvictionPolicy = "lfu"
+)
+
+// Validate checks the policy, empty means LRU
+func (policy EvictionPolicy) Validate() error {
+	switch policy {
+	case "", EvictionLRU, EvictionLFU:
+		return nil
+	}
+	return fmt.Errorf("eviction policy %q is invalid, expected lru or lfu", policy)
+}
+
+// CacheLimit limits the size of a cache, zero means unlimited
+// CacheLimit 限制缓存大小，0 表示不限制
+type CacheLimit struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+func (limit CacheLimit) isUnlimited() bool {
+	return limit.MaxBytes <= 0 && limit.MaxFiles <= 0
+}
+
+// exceeded reports whether the usage is over the limit scaled by ratio
+func (limit CacheLimit) exceeded(usage *cacheUsage, ratio float64) bool {
+	if limit.MaxBytes > 0 && float64(usage.Bytes) > float64(limit.MaxBytes)*ratio {
+		return true
+	}
+	if limit.MaxFiles > 0 && float64(usage.Files) > float64(limit.MaxFiles)*ratio {
+		return true
+	}
+	return false
+}
+
+type cacheUsage struct {
+	Bytes     int64 `json:"bytes"`
+	Files     int64 `json:"files"`
+	Evictions int64 `json:"evictions"`
+}
+
+// cache index entry, one per file
+type cacheEntry struct {
+	// key of the file, empty for files scanned at startup until they are read
+	key        string
+	path       string
+	provider   string
+	size       int64
+	lastAccess time.Time
+	hits       int64
+}
+
+// per provider LRU list, front is the most recently used
+type providerIndex struct {
+	usage cacheUsage
+	lru   *list.List
+}
+
+// EvictingCache wraps a DiskCacher and removes the least recently (LRU) or least frequently (LFU) used files
+// when the total or per provider size exceeds the limits.
+// The index is kept in memory and rebuilt from the directory tree at startup,
+// the initial recency is the file modification time.
+// EvictingCache 包装 DiskCacher，当总大小或单个地图源大小超过限制时删除最近最少使用（LRU）或最不经常使用（LFU）的文件。
+// 索引保存在内存中，启动时从目录树重建，初始访问时间为文件修改时间。
+type EvictingCache struct {
+	DiskCacher
+
+	// a file is written and removed under its path lock, so that eviction
+	// does not remove a file written again after it was picked
+	// 文件的写入和删除都持有其路径锁，避免淘汰删除被选中后又重新写入的文件
+	pathLocks [pathLockStripes]sync.Mutex
+
+	mu             sync.Mutex
+	policy         EvictionPolicy
+	limit          CacheLimit
+	providerLimits map[string]CacheLimit
+	entries        map[string]*list.Element // file path -> entry
+	providers      map[string]*providerIndex
+	total          cacheUsage
+	scanned        bool
+	onEvict        func(key string)
+
+	evictions atomic.Int64
+	notify    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// EvictionStats is the statistics of EvictingCache
+type EvictionStats struct {
+	Root      string                `json:"root"`
+	Scanned   bool                  `json:"scanned"`
+	Policy    EvictionPolicy        `json:"policy"`
+	Limit     CacheLimit            `json:"limit"`
+	Usage     cacheUsage            `json:"usage"`
+	Providers map[string]cacheUsage `json:"providers"`
+}
+
+// NewEvictingCache creates an EvictingCache and starts the index rebuild and eviction worker
+// NewEvictingCache 创建 EvictingCache，并启动索引重建和淘汰工作协程
+func NewEvictingCache(cacher DiskCacher, policy EvictionPolicy, limit CacheLimit, providerLimits map[string]CacheLimit) *EvictingCache {
+	cache := &EvictingCache{
+		DiskCacher: cacher,
+		entries:    make(map[string]*list.Element),
+		providers:  make(map[string]*providerIndex),
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	cache.SetPolicy(policy)
+	cache.SetLimits(limit, providerLimits)
+
+	go cache.run()
+	return cache
+}
+
+// SetLimits replaces the limits, it is safe to call at any time
+// SetLimits 替换限制，可随时调用
+func (cache *EvictingCache) SetLimits(limit CacheLimit, providerLimits map[string]CacheLimit) {
+	cache.mu.Lock()
+	cache.limit = limit
+	cache.providerLimits = providerLimits
+	cache.mu.Unlock()
+
+	cache.triggerEviction()
+}
+
+// SetPolicy replaces the eviction policy, empty means LRU
+// SetPolicy 替换淘汰策略，为空时使用 LRU
+func (cache *EvictingCache) SetPolicy(policy EvictionPolicy) {
+	if policy == "" {
+		policy = EvictionLRU
+	}
+	cache.mu.Lock()
+	cache.policy = policy
+	cache.mu.Unlock()
+}
+
+// Close stops the eviction worker, the cached files are kept
+func (cache *EvictingCache) Close() {
+	cache.closeOnce.Do(func() {
+		close(cache.done)
+	})
+}
+
+func (cache *EvictingCache) GetCache(key string) ([]byte, error) {
+	value, _, err := cache.GetCacheWithTime(key)
+	return value, err
+}
+
+func (cache *EvictingCache) GetCacheWithTime(key string) ([]byte, time.Time, error) {
+	value, storedAt, err := cache.DiskCacher.GetCacheWithTime(key)
+
+	path, pathErr := cache.CacheFilePath(key)
+	if pathErr != nil {
+		return value, storedAt, err
+	}
+
+	cache.mu.Lock()
+	if element, ok := cache.entries[path]; ok {
+		if err != nil {
+			// the file is gone, drop it from the index
+			// 文件已不存在，从索引中删除
+			cache.removeLocked(element)
+		} else {
+			cache.hitLocked(element, key)
+		}
+	}
+	cache.mu.Unlock()
+
+	return value, storedAt, err
+}
+
+func (cache *EvictingCache) SetCache(key string, value []byte) error {
+	path, err := cache.CacheFilePath(key)
+	if err != nil {
+		return cache.DiskCacher.SetCache(key, value)
+	}
+
+	lock := cache.pathLock(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := cache.DiskCacher.SetCache(key, value); err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	cache.addLocked(key, path, providerOfKey(key), int64(len(value)), time.Now(), false)
+	overLimit := cache.overLimitLocked(1)
+	cache.mu.Unlock()
+
+	if overLimit {
+		cache.triggerEviction()
+	}
+	return nil
+}
+
+// TouchCache records a read served by the memory tier, so that hot tiles in memory are not evicted from disk
+// TouchCache 记录由内存层响应的读取，避免内存中的热点瓦片被从磁盘淘汰
+func (cache *EvictingCache) TouchCache(key string) {
+	path, err := cache.CacheFilePath(key)
+	if err != nil {
+		return
+	}
+
+	cache.mu.Lock()
+	if element, ok := cache.entries[path]; ok {
+		cache.hitLocked(element, key)
+	}
+	cache.mu.Unlock()
+}
+
+// OnEvict sets the function called with the key of every evicted file
+func (cache *EvictingCache) OnEvict(onEvict func(key string)) {
+	cache.mu.Lock()
+	cache.onEvict = onEvict
+	cache.mu.Unlock()
+}
+
+// Stats returns the usage and eviction counts
+func (cache *EvictingCache) Stats() any {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := EvictionStats{
+		Root:      cache.RootPath(),
+		Scanned:   cache.scanned,
+		Policy:    cache.policy,
+		Limit:     cache.limit,
+		Usage:     cache.total,
+		Providers: make(map[string]cacheUsage, len(cache.providers)),
+	}
+	stats.Usage.Evictions = cache.evictions.Load()
+	for provider, index := range cache.providers {
+		stats.Providers[provider] = index.usage
+	}
+	return stats
+}
+
+// pathLock returns the lock of the file path
+func (cache *EvictingCache) pathLock(path string) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(path))
+	return &cache.pathLocks[hash.Sum32()%pathLockStripes]
+}
+
+// provider of `<mapType>/<z>/<x>/<y>.<ext>` key
+func providerOfKey(key string) string {
+	provider, _, _ := strings.Cut(key, "/")
+	return provider
+}
+
+func (cache *EvictingCache) providerIndexLocked(provider string) *providerIndex {
+	index, ok := cache.providers[provider]
+	if !ok {
+		index = &providerIndex{lru: list.New()}
+		cache.providers[provider] = index
+	}
+	return index
+}
+
+// add or update an entry, new entries are the most recently used unless atBack is set
+func (cache *EvictingCache) addLocked(key, path, provider string, size int64, lastAccess time.Time, atBack bool) {
+	if element, ok := cache.entries[path]; ok {
+		entry := element.Value.(*cacheEntry)
+		if key != "" {
+			entry.key = key
+		}
+		index := cache.providers[entry.provider]
+		index.usage.Bytes += size - entry.size
+		cache.total.Bytes += size - entry.size
+		entry.size = size
+		cache.touchLocked(element, lastAccess)
+		return
+	}
+
+	index := cache.providerIndexLocked(provider)
+	entry := &cacheEntry{key: key, path: path, provider: provider, size: size, lastAccess: lastAccess}
+	if atBack {
+		cache.entries[path] = index.lru.PushBack(entry)
+	} else {
+		cache.entries[path] = index.lru.PushFront(entry)
+	}
+
+	index.usage.Bytes += size
+	index.usage.Files++
+	cache.total.Bytes += size
+	cache.total.Files++
+}
+
+// hitLocked records a read of the entry
+func (cache *EvictingCache) hitLocked(element *list.Element, key string) {
+	entry := element.Value.(*cacheEntry)
+	entry.key = key
+	entry.hits++
+	cache.touchLocked(element, time.Now())
+}
+
+func (cache *EvictingCache) touchLocked(element *list.Element, lastAccess time.Time) {
+	entry := element.Value.(*cacheEntry)
+	if lastAccess.After(entry.lastAccess) {
+		entry.lastAccess = lastAccess
+	}
+	cache.providers[entry.provider].lru.MoveToFront(element)
+}
+
+func (cache *EvictingCache) removeLocked(element *list.Element) *cacheEntry {
+	entry := element.Value.(*cacheEntry)
+	index := cache.providers[entry.provider]
+	index.lru.Remove(element)
+	index.usage.Bytes -= entry.size
+	index.usage.Files--
+	cache.total.Bytes -= entry.size
+	cache.total.Files--
+	delete(cache.entries, entry.path)
+	return entry
+}
+
+func (cache *EvictingCache) overLimitLocked(ratio float64) bool {
+	if cache.limit.exceeded(&cache.total, ratio) {
+		return true
+	}
+	for provider, limit := range cache.providerLimits {
+		if index, ok := cache.providers[provider]; ok && limit.exceeded(&index.usage, ratio) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cache *EvictingCache) triggerEviction() {
+	select {
+	case cache.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run rebuilds the index and then evicts whenever it is notified
+func (cache *EvictingCache) run() {
+	cache.rebuildIndex()
+	cache.evict()
+
+	for {
+		select {
+		case <-cache.done:
+			return
+		case <-cache.notify:
+			cache.evict()
+		}
+	}
+}
+
+// rebuildIndex walks the cache directory and adds every file to the index
+// rebuildIndex 遍历缓存目录，将所有文件加入索引
+func (cache *EvictingCache) rebuildIndex() {
+	root := cache.RootPath()
+	_, isPathCache := cache.DiskCacher.(*PathMapCache)
+	start := time.Now()
+	var scanned []*cacheEntry
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		// the provider is the first directory of the path cache, hash cache paths carry no provider
+		// 路径缓存的第一级目录为地图源，哈希缓存路径不包含地图源信息
+		provider := ""
+		if isPathCache {
+			if rel, err := filepath.Rel(root, path); err == nil {
+				provider = providerOfKey(filepath.ToSlash(rel))
+			}
+		}
+
+		scanned = append(scanned, &cacheEntry{path: path, provider: provider, size: info.Size(), lastAccess: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("rebuild cache index %s failed: %v", root, err)
+	}
+
+	// newest first, scanned files are appended after the files written since startup
+	// 按时间从新到旧排序，扫描到的文件追加在启动后写入的文件之后
+	slices.SortFunc(scanned, func(a, b *cacheEntry) int {
+		return b.lastAccess.Compare(a.lastAccess)
+	})
+
+	cache.mu.Lock()
+	for _, entry := range scanned {
+		if _, ok := cache.entries[entry.path]; !ok {
+			cache.addLocked("", entry.path, entry.provider, entry.size, entry.lastAccess, true)
+		}
+	}
+	cache.mu.Unlock()
+	files := len(scanned)
+
+	cache.mu.Lock()
+	cache.scanned = true
+	total := cache.total
+	cache.mu.Unlock()
+
+	logger.Infof("cache index %s rebuilt in %s: %d files scanned, %d files / %d bytes indexed", root, time.Since(start), files, total.Files, total.Bytes)
+}
+
+// evict removes the files picked by the policy until every limit is under the low watermark
+// evict 按淘汰策略删除文件，直到所有限制都低于低水位
+func (cache *EvictingCache) evict() {
+	cache.mu.Lock()
+	if !cache.overLimitLocked(1) {
+		cache.mu.Unlock()
+		return
+	}
+
+	var victims []*cacheEntry
+
+	// per provider limits first
+	// 先处理单个地图源的限制
+	for provider, limit := range cache.providerLimits {
+		index, ok := cache.providers[provider]
+		if !ok || limit.isUnlimited() {
+			continue
+		}
+		next := cache.victimOrderLocked([]*providerIndex{index})
+		for limit.exceeded(&index.usage, evictLowWatermark) {
+			element := next()
+			if element == nil {
+				break
+			}
+			victims = append(victims, cache.removeLocked(element))
+		}
+	}
+
+	// then the global limit, evict among all providers
+	// 再处理全局限制，在所有地图源中淘汰
+	indexes := make([]*providerIndex, 0, len(cache.providers))
+	for _, index := range cache.providers {
+		indexes = append(indexes, index)
+	}
+	next := cache.victimOrderLocked(indexes)
+	for cache.limit.exceeded(&cache.total, evictLowWatermark) {
+		element := next()
+		if element == nil {
+			break
+		}
+		victims = append(victims, cache.removeLocked(element))
+	}
+	total := cache.total
+	cache.mu.Unlock()
+
+	// remove files outside the lock
+	// 在锁外删除文件
+	var freed, removed int64
+	for _, victim := range victims {
+		if cache.removeVictim(victim) {
+			freed += victim.size
+			removed++
+		}
+	}
+
+	evictions := cache.evictions.Add(removed)
+	logger.Infof("cache %s evicted %d files (%d bytes), usage: %d files / %d bytes, total evictions: %d",
+		cache.RootPath(), removed, freed, total.Files, total.Bytes, evictions)
+}
+
+// victimOrderLocked returns a function which yields the next entry to evict among the indexes, nil when none is left.
+// LRU takes the oldest tail of the lists, LFU sorts the entries by hits once, when the first victim is needed.
+// victimOrderLocked 返回依次给出待淘汰条目的函数，没有条目时返回 nil。
+// LRU 取各链表尾中最旧的条目，LFU 在需要第一个淘汰条目时按命中次数排序一次
+func (cache *EvictingCache) victimOrderLocked(indexes []*providerIndex) func() *list.Element {
+	if cache.policy != EvictionLFU {
+		return func() *list.Element {
+			var oldest *list.Element
+			for _, index := range indexes {
+				back := index.lru.Back()
+				if back != nil && (oldest == nil || back.Value.(*cacheEntry).lastAccess.Before(oldest.Value.(*cacheEntry).lastAccess)) {
+					oldest = back
+				}
+			}
+			return oldest
+		}
+	}
+
+	var elements []*list.Element
+	sorted := false
+	return func() *list.Element {
+		if !sorted {
+			for _, index := range indexes {
+				for element := index.lru.Back(); element != nil; element = element.Prev() {
+					elements = append(elements, element)
+				}
+			}
+			slices.SortStableFunc(elements, func(a, b *list.Element) int {
+				entryA, entryB := a.Value.(*cacheEntry), b.Value.(*cacheEntry)
+				if c := cmp.Compare(entryA.hits, entryB.hits); c != 0 {
+					return c
+				}
+				return entryA.lastAccess.Compare(entryB.lastAccess)
+			})
+			sorted = true
+		}
+		if len(elements) == 0 {
+			return nil
+		}
+		element := elements[0]
+		elements = elements[1:]
+		return element
+	}
+}
+
+// removeVictim removes the file of an evicted entry unless it was written again since it was picked,
+// it reports whether the file is removed
+// removeVictim 删除被淘汰条目的文件，若被选中后又重新写入则保留，返回文件是否被删除
+func (cache *EvictingCache) removeVictim(victim *cacheEntry) bool {
+	lock := cache.pathLock(victim.path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cache.mu.Lock()
+	_, rewritten := cache.entries[victim.path]
+	cache.mu.Unlock()
+	if rewritten {
+		return false
+	}
+
+	if err := os.Remove(victim.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warnf("evict cache file %s failed: %v", victim.path, err)
+		return false
+	}
+
+	cache.mu.Lock()
+	if index, ok := cache.providers[victim.provider]; ok {
+		index.usage.Evictions++
+	}
+	onEvict := cache.onEvict
+	cache.mu.Unlock()
+
+	// drop the value from the memory tier, it would keep serving a tile which is no longer cached
+	// 从内存层删除该值，否则会继续响应已不在缓存中的瓦片
+	if onEvict != nil && victim.key != "" {
+		onEvict(victim.key)
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// wait until the background worker has rebuilt the index and evicted
+func waitEvictionIdle(t *testing.T, cache *EvictingCache) EvictionStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stats := cache.Stats().(EvictionStats)
+		cache.mu.Lock()
+		overLimit := cache.overLimitLocked(1)
+		cache.mu.Unlock()
+		if stats.Scanned && !overLimit {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("eviction did not finish in time")
+	return EvictionStats{}
+}
+
+func TestEvictingCacheLRU(t *testing.T) {
+	root := t.TempDir()
+	cache := NewEvictingCache(&PathMapCache{CachePath: root}, EvictionLRU, CacheLimit{MaxFiles: 10}, nil)
+	defer cache.Close()
+	waitEvictionIdle(t, cache)
+
+	tile := make([]byte, 100)
+	for i := range 10 {
+		if err := cache.SetCache(fmt.Sprintf("osm/1/0/%d.png", i), tile); err != nil {
+			t.Fatalf("set cache failed: %v", err)
+		}
+	}
+
+	// touch the oldest tile, so it survives the eviction
+	if _, err := cache.GetCache("osm/1/0/0.png"); err != nil {
+		t.Fatalf("get cache failed: %v", err)
+	}
+
+	if err := cache.SetCache("osm/1/0/10.png", tile); err != nil {
+		t.Fatalf("set cache failed: %v", err)
+	}
+	stats := waitEvictionIdle(t, cache)
+
+	// evicted to the low watermark: 9 files
+	if stats.Usage.Files != 9 || stats.Usage.Evictions != 2 {
+		t.Fatalf("unexpected usage after eviction: %+v", stats.Usage)
+	}
+	if _, err := cache.GetCache("osm/1/0/0.png"); err != nil {
+		t.Errorf("recently used tile was evicted: %v", err)
+	}
+	for _, key := range []string{"osm/1/0/1.png", "osm/1/0/2.png"} {
+		if _, err := os.Stat(filepath.Join(root, key)); !os.IsNotExist(err) {
+			t.Errorf("least recently used tile %s was not evicted", key)
+		}
+	}
+}
+
+func TestEvictingCacheRebuildAndProviderLimit(t *testing.T) {
+	root := t.TempDir()
+	pathCache := &PathMapCache{CachePath: root}
+
+	// pre-existing tiles with increasing modification time
+	base := time.Now().Add(-time.Hour)
+	for i := range 5 {
+		for _, provider := range []string{"a", "b"} {
+			key := fmt.Sprintf("%s/2/1/%d.png", provider, i)
+			if err := pathCache.SetCache(key, make([]byte, 1000)); err != nil {
+				t.Fatalf("set cache failed: %v", err)
+			}
+			path, _ := pathCache.CacheFilePath(key)
+			modTime := base.Add(time.Duration(i) * time.Minute)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatalf("chtimes failed: %v", err)
+			}
+		}
+	}
+
+	cache := NewEvictingCache(pathCache, EvictionLRU, CacheLimit{}, map[string]CacheLimit{"a": {MaxBytes: 3000}})
+	defer cache.Close()
+	stats := waitEvictionIdle(t, cache)
+
+	if stats.Providers["a"].Files != 2 || stats.Providers["b"].Files != 5 {
+		t.Fatalf("unexpected provider usage: %+v", stats.Providers)
+	}
+	// the oldest tiles of provider a are evicted
+	for i := range 3 {
+		if _, err := os.Stat(filepath.Join(root, "a", "2", "1", fmt.Sprintf("%d.png", i))); !os.IsNotExist(err) {
+			t.Errorf("tile a/2/1/%d.png was not evicted", i)
+		}
+	}
+}
+
+func TestEvictingCacheLFU(t *testing.T) {
+	root := t.TempDir()
+	cache := NewEvictingCache(&PathMapCache{CachePath: root}, EvictionLFU, CacheLimit{MaxFiles: 10}, nil)
+	defer cache.Close()
+	waitEvictionIdle(t, cache)
+
+	tile := make([]byte, 100)
+	for i := range 10 {
+		if err := cache.SetCache(fmt.Sprintf("osm/1/0/%d.png", i), tile); err != nil {
+			t.Fatalf("set cache failed: %v", err)
+		}
+	}
+	// every tile but 5 and 9 is read, 9 is the most recently used but never read
+	for i := range 9 {
+		if i == 5 {
+			continue
+		}
+		if _, err := cache.GetCache(fmt.Sprintf("osm/1/0/%d.png", i)); err != nil {
+			t.Fatalf("get cache failed: %v", err)
+		}
+	}
+
+	if err := cache.SetCache("osm/1/0/10.png", tile); err != nil {
+		t.Fatalf("set cache failed: %v", err)
+	}
+	stats := waitEvictionIdle(t, cache)
+
+	if stats.Policy != EvictionLFU || stats.Usage.Files != 9 || stats.Usage.Evictions != 2 {
+		t.Fatalf("unexpected usage after eviction: %+v", stats)
+	}
+	// the least frequently used tiles are evicted, 5 is older than 9
+	for _, key := range []string{"osm/1/0/5.png", "osm/1/0/9.png"} {
+		if _, err := os.Stat(filepath.Join(root, key)); !os.IsNotExist(err) {
+			t.Errorf("least frequently used tile %s was not evicted", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "osm/1/0/0.png")); err != nil {
+		t.Errorf("frequently used tile was evicted: %v", err)
+	}
+}
+
+func TestEvictingCacheRewrittenVictim(t *testing.T) {
+	root := t.TempDir()
+	cache := NewEvictingCache(&PathMapCache{CachePath: root}, EvictionLRU, CacheLimit{}, nil)
+	defer cache.Close()
+	waitEvictionIdle(t, cache)
+
+	key := "osm/1/0/0.png"
+	if err := cache.SetCache(key, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := cache.CacheFilePath(key)
+
+	// picked for eviction, then written again before the file is removed
+	cache.mu.Lock()
+	victim := cache.removeLocked(cache.entries[path])
+	cache.mu.Unlock()
+	if err := cache.SetCache(key, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.removeVictim(victim) {
+		t.Error("rewritten file is removed")
+	}
+	if value, err := cache.GetCache(key); err != nil || string(value) != "new" {
+		t.Errorf("unexpected cached value %q, %v", value, err)
+	}
+
+	// a victim which is not written again is removed
+	cache.mu.Lock()
+	victim = cache.removeLocked(cache.entries[path])
+	cache.mu.Unlock()
+	if !cache.removeVictim(victim) {
+		t.Error("victim is not removed")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("victim file exists: %v", err)
+	}
+}
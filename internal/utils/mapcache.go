@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"fmt"
 	"go-map-proxy/pkg/logger"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Cacher interface {
@@ -22,33 +24,102 @@ type Cacher interface {
 	// GenerateCacheKey(str string) (key string)
 }
 
+// TimedCacher is a Cacher which knows when each value was stored
+// TimedCacher 是能够返回每个值写入时间的缓存
+type TimedCacher interface {
+	Cacher
+
+	// GetCacheWithTime returns the value and the time it was stored
+	GetCacheWithTime(key string) (value []byte, storedAt time.Time, err error)
+}
+
+// GetCacheWithTime returns the value and its stored time from any Cacher,
+// the stored time is zero if the Cacher does not track it
+// GetCacheWithTime 从任意缓存获取值及其写入时间，缓存不记录时间时返回零值
+func GetCacheWithTime(cacher Cacher, key string) (value []byte, storedAt time.Time, err error) {
+	if timedCacher, ok := cacher.(TimedCacher); ok {
+		return timedCacher.GetCacheWithTime(key)
+	}
+	value, err = cacher.GetCache(key)
+	return value, time.Time{}, err
+}
+
+// TileCacheKey returns the cache key of a tile, `mapType/z/x/y.ext`, the extension comes from the content type
+// TileCacheKey 返回瓦片的缓存键 `mapType/z/x/y.ext`，扩展名取自内容类型
+func TileCacheKey(mapType string, z, x, y int, contentType string) string {
+	_, fileExtension, _ := strings.Cut(contentType, "/")
+	return TileCacheKeyWithExtension(mapType, z, x, y, fileExtension)
+}
+
+// TileCacheKeyWithExtension returns the cache key of a tile variant, `mapType/z/x/y.<extension>`
+// TileCacheKeyWithExtension 返回瓦片变体的缓存键 `mapType/z/x/y.<extension>`
+func TileCacheKeyWithExtension(mapType string, z, x, y int, extension string) string {
+	return fmt.Sprintf("%s/%d/%d/%d.%s", mapType, z, x, y, extension)
+}
+
+// DiskCacher is a Cacher which stores every key in its own file under a root directory
+// DiskCacher 是将每个键存储为根目录下独立文件的缓存
+type DiskCacher interface {
+	TimedCacher
+
+	// RootPath returns the cache root directory
+	RootPath() string
+
+	// CacheFilePath returns the file path of the given key
+	CacheFilePath(key string) (string, error)
+}
+
+// StatsReporter is implemented by caches which expose statistics, the result must be JSON serializable
+// StatsReporter 由提供统计信息的缓存实现，返回值必须可 JSON 序列化
+type StatsReporter interface {
+	Stats() any
+}
+
+// TouchCacher is a Cacher which counts reads served by a tier in front of it, e.g. the memory tier
+// TouchCacher 是能够记录由其前一层（如内存层）响应的读取的缓存
+type TouchCacher interface {
+	// TouchCache records a read of the key without reading the value
+	TouchCache(key string)
+}
+
+// EvictionNotifier is a Cacher which reports the keys it evicts, so that the tiers in front of it drop them
+// EvictionNotifier 是会报告被淘汰键的缓存，以便其前面的缓存层同步删除
+type EvictionNotifier interface {
+	// OnEvict replaces the function called with every evicted key, nil removes it
+	OnEvict(onEvict func(key string))
+}
+
 // map cache base on key hash path (like nginx cache strategy)
 // e.g.: <CachePath>/6f/1e/d002ab5595859014ebf0951522d9
 type HashMapCache struct {
 	CachePath string
 }
 
+// holder for storing the Cacher interface in atomic.Pointer
+type cacherHolder struct {
+	Cacher
+}
+
 var (
-	Cache         Cacher
-	InitCacheOnce sync.Once
+	// global cache, it can be swapped on config reload
+	// 全局缓存，配置重载时可原子替换
+	currentCache atomic.Pointer[cacherHolder]
 )
 
-func NewHashMapCache(cachePath string) *HashMapCache {
-	// check if the global Cache is already initialized
-	if Cache != nil {
-		panic("Cacher is already initialized")
+// GetCache returns the current global Cacher, nil if it is not initialized
+// GetCache 返回当前全局缓存，未初始化时返回 nil
+func GetCache() Cacher {
+	holder := currentCache.Load()
+	if holder == nil {
+		return nil
 	}
+	return holder.Cacher
+}
 
-	InitCacheOnce.Do(func() {
-		Cache = &HashMapCache{
-			CachePath: cachePath,
-		}
-	})
-
-	// type assert
-	fmt.Printf("InitHashMapCache: cache path: %s\n", Cache.(*HashMapCache).CachePath)
-
-	return Cache.(*HashMapCache)
+// SetCache atomically replaces the global Cacher, requests holding the old one are not affected
+// SetCache 原子替换全局缓存，持有旧缓存的请求不受影响
+func SetCache(cacher Cacher) {
+	currentCache.Store(&cacherHolder{Cacher: cacher})
 }
 
 // Generate cache key for the given string MD5
@@ -85,6 +156,15 @@ func (hashmapcache *HashMapCache) getCachePath(key string) (string, error) {
 
 }
 
+func (hashmapcache *HashMapCache) RootPath() string {
+	return hashmapcache.CachePath
+}
+
+// CacheFilePath returns the file path of the key string
+func (hashmapcache *HashMapCache) CacheFilePath(keyStr string) (string, error) {
+	return hashmapcache.getCachePath(hashmapcache.GenerateCacheKey(keyStr))
+}
+
 // SetCache: save the data to the cache path, according to the hash value of the key
 func (hashmapcache *HashMapCache) SetCache(keyStr string, value []byte) error {
 	// check if the value is empty
@@ -117,28 +197,22 @@ func (hashmapcache *HashMapCache) SetCache(keyStr string, value []byte) error {
 
 // GetCache: get the data from the cache path, according to the hash value of the key
 func (hashmapcache *HashMapCache) GetCache(keyStr string) ([]byte, error) {
+	value, _, err := hashmapcache.GetCacheWithTime(keyStr)
+	return value, err
+}
+
+// GetCacheWithTime: get the data and its stored time from the cache path, according to the hash value of the key
+func (hashmapcache *HashMapCache) GetCacheWithTime(keyStr string) ([]byte, time.Time, error) {
 	// key e.g. 6f1ed002ab5595859014ebf0951522d9
 	key := hashmapcache.GenerateCacheKey(keyStr)
 
 	// get the cache path
 	cacheFilePath, err := hashmapcache.getCachePath(key)
 	if err != nil {
-		return nil, fmt.Errorf("get cache path failed: %w", err)
+		return nil, time.Time{}, fmt.Errorf("get cache path failed: %w", err)
 	}
 
-	// read the value from the cache file
-	value, err := os.ReadFile(cacheFilePath)
-	if err != nil {
-		logger.Debugf("read cache file %s failed: %v", cacheFilePath, err)
-		return nil, fmt.Errorf("read cache file %s failed: %w", cacheFilePath, err)
-	}
-
-	// check if the value is empty
-	if len(value) == 0 {
-		return nil, fmt.Errorf("cache file %s is empty", cacheFilePath)
-	}
-
-	return value, nil
+	return readCacheFile(cacheFilePath)
 }
 
 // map cache base on clean path
@@ -148,24 +222,6 @@ type PathMapCache struct {
 	CachePath string
 }
 
-func NewPathMapCache(cachePath string) *PathMapCache {
-	// check if the global Cache is already initialized
-	if Cache != nil {
-		panic("Cacher is already initialized")
-	}
-
-	InitCacheOnce.Do(func() {
-		Cache = &PathMapCache{
-			CachePath: cachePath,
-		}
-	})
-
-	// type assert
-	fmt.Printf("InitPathMapCache: cache path: %s\n", Cache.(*PathMapCache).CachePath)
-
-	return Cache.(*PathMapCache)
-}
-
 // parse the key string to the map type, z, x, y and extension and combine them to a cache path
 func (pathmapcache *PathMapCache) getCachePath(keyStr string) (string, error) {
 	// keyStr is like "googlemap/6/10/20.png"
@@ -180,6 +236,15 @@ func (pathmapcache *PathMapCache) getCachePath(keyStr string) (string, error) {
 	return cacheFilePath, nil
 }
 
+func (pathmapcache *PathMapCache) RootPath() string {
+	return pathmapcache.CachePath
+}
+
+// CacheFilePath returns the file path of the key string
+func (pathmapcache *PathMapCache) CacheFilePath(keyStr string) (string, error) {
+	return pathmapcache.getCachePath(keyStr)
+}
+
 func (pathmapcache *PathMapCache) SetCache(keyStr string, value []byte) error {
 	// check if the value is empty
 	if len(value) == 0 {
@@ -206,23 +271,47 @@ func (pathmapcache *PathMapCache) SetCache(keyStr string, value []byte) error {
 }
 
 func (pathmapcache *PathMapCache) GetCache(keyStr string) ([]byte, error) {
+	value, _, err := pathmapcache.GetCacheWithTime(keyStr)
+	return value, err
+}
+
+// GetCacheWithTime returns the data and its stored time (file modification time)
+func (pathmapcache *PathMapCache) GetCacheWithTime(keyStr string) ([]byte, time.Time, error) {
 	// get the cache path
 	cacheFilePath, err := pathmapcache.getCachePath(keyStr)
 	if err != nil {
-		return nil, fmt.Errorf("get cache path failed: %w", err)
+		return nil, time.Time{}, fmt.Errorf("get cache path failed: %w", err)
+	}
+
+	return readCacheFile(cacheFilePath)
+}
+
+// read the cache file and its modification time, which is the time the value was stored
+// 读取缓存文件及其修改时间（即写入缓存的时间）
+func readCacheFile(cacheFilePath string) ([]byte, time.Time, error) {
+	file, err := os.Open(cacheFilePath)
+	if err != nil {
+		logger.Debugf("read cache file %s failed: %v", cacheFilePath, err)
+		return nil, time.Time{}, fmt.Errorf("read cache file %s failed: %w", cacheFilePath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("stat cache file %s failed: %w", cacheFilePath, err)
 	}
 
 	// read the value from the cache file
-	value, err := os.ReadFile(cacheFilePath)
+	value, err := io.ReadAll(file)
 	if err != nil {
 		logger.Debugf("read cache file %s failed: %v", cacheFilePath, err)
-		return nil, fmt.Errorf("read cache file %s failed: %w", cacheFilePath, err)
+		return nil, time.Time{}, fmt.Errorf("read cache file %s failed: %w", cacheFilePath, err)
 	}
 
 	// check if the value is empty
 	if len(value) == 0 {
-		return nil, fmt.Errorf("cache file %s is empty", cacheFilePath)
+		return nil, time.Time{}, fmt.Errorf("cache file %s is empty", cacheFilePath)
 	}
 
-	return value, nil
+	return value, info.ModTime(), nil
 }
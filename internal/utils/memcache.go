@@ -0,0 +1,225 @@
+// In-memory hot tile tier in front of the disk cache
+// 位于磁盘缓存之前的内存热点瓦片层
+
+package utils
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// values larger than this fraction of the memory limit are not kept in memory
+// 大于内存限制该比例的值不放入内存
+const memoryCacheMaxItemRatio = 8
+
+type memoryEntry struct {
+	key      string
+	value    []byte
+	storedAt time.Time
+}
+
+// MemoryCache is a bounded in-memory LRU cache
+// MemoryCache 是有容量上限的内存 LRU 缓存
+type MemoryCache struct {
+	maxBytes int64
+
+	mu        sync.Mutex
+	bytes     int64
+	entries   map[string]*list.Element
+	lru       *list.List // front is the most recently used
+	evictions int64
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (cache *MemoryCache) GetCache(key string) ([]byte, error) {
+	value, _, err := cache.GetCacheWithTime(key)
+	return value, err
+}
+
+func (cache *MemoryCache) GetCacheWithTime(key string) ([]byte, time.Time, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("memory cache miss: %s", key)
+	}
+	cache.lru.MoveToFront(element)
+	entry := element.Value.(*memoryEntry)
+	return entry.value, entry.storedAt, nil
+}
+
+func (cache *MemoryCache) SetCache(key string, value []byte) error {
+	cache.set(key, value, time.Now())
+	return nil
+}
+
+// set stores the value with the given stored time and evicts the least recently used values
+// set 以给定写入时间存储值，并淘汰最近最少使用的值
+func (cache *MemoryCache) set(key string, value []byte, storedAt time.Time) {
+	size := int64(len(value))
+	if size == 0 || size > cache.maxBytes/memoryCacheMaxItemRatio {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		cache.bytes += size - int64(len(entry.value))
+		entry.value = value
+		entry.storedAt = storedAt
+		cache.lru.MoveToFront(element)
+	} else {
+		cache.entries[key] = cache.lru.PushFront(&memoryEntry{key: key, value: value, storedAt: storedAt})
+		cache.bytes += size
+	}
+
+	for cache.bytes > cache.maxBytes {
+		back := cache.lru.Back()
+		entry := back.Value.(*memoryEntry)
+		cache.lru.Remove(back)
+		delete(cache.entries, entry.key)
+		cache.bytes -= int64(len(entry.value))
+		cache.evictions++
+	}
+}
+
+// DeleteCache removes the value of the key
+func (cache *MemoryCache) DeleteCache(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+		cache.bytes -= int64(len(entry.value))
+	}
+}
+
+// MemoryCacheStats is the statistics of MemoryCache
+type MemoryCacheStats struct {
+	MaxBytes  int64 `json:"max_bytes"`
+	Bytes     int64 `json:"bytes"`
+	Items     int   `json:"items"`
+	Evictions int64 `json:"evictions"`
+}
+
+func (cache *MemoryCache) Stats() any {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return MemoryCacheStats{
+		MaxBytes:  cache.maxBytes,
+		Bytes:     cache.bytes,
+		Items:     cache.lru.Len(),
+		Evictions: cache.evictions,
+	}
+}
+
+// TieredCache layers a MemoryCache over a disk Cacher.
+// Writes go to both tiers, disk hits are promoted to memory. Memory hits are passed to the disk tier as touches
+// and values evicted from disk are dropped from memory, so that the disk eviction sees the hot tiles.
+// TieredCache 将内存缓存叠加在磁盘缓存之上：写入同时写两层，磁盘命中时提升到内存。
+// 内存命中会同步为磁盘层的访问记录，磁盘淘汰的值也会从内存删除，使磁盘淘汰能够感知热点瓦片
+type TieredCache struct {
+	Memory *MemoryCache
+	Disk   Cacher
+
+	memoryHits   atomic.Int64
+	memoryMisses atomic.Int64
+	diskHits     atomic.Int64
+	diskMisses   atomic.Int64
+}
+
+// NewTieredCache creates a TieredCache, it replaces the eviction listener of the disk tier,
+// e.g. the one of the previous memory tier on config reload
+// NewTieredCache 创建 TieredCache，会替换磁盘层的淘汰监听（如配置重载前的内存层）
+func NewTieredCache(memory *MemoryCache, disk Cacher) *TieredCache {
+	if notifier, ok := disk.(EvictionNotifier); ok {
+		notifier.OnEvict(memory.DeleteCache)
+	}
+	return &TieredCache{
+		Memory: memory,
+		Disk:   disk,
+	}
+}
+
+func (cache *TieredCache) GetCache(key string) ([]byte, error) {
+	value, _, err := cache.GetCacheWithTime(key)
+	return value, err
+}
+
+func (cache *TieredCache) GetCacheWithTime(key string) ([]byte, time.Time, error) {
+	if value, storedAt, err := cache.Memory.GetCacheWithTime(key); err == nil {
+		cache.memoryHits.Add(1)
+		if toucher, ok := cache.Disk.(TouchCacher); ok {
+			toucher.TouchCache(key)
+		}
+		return value, storedAt, nil
+	}
+	cache.memoryMisses.Add(1)
+
+	value, storedAt, err := GetCacheWithTime(cache.Disk, key)
+	if err != nil {
+		cache.diskMisses.Add(1)
+		return nil, time.Time{}, err
+	}
+	cache.diskHits.Add(1)
+
+	// promote to memory, keep the disk stored time for expiration
+	// 提升到内存，保留磁盘写入时间用于过期判断
+	cache.Memory.set(key, value, storedAt)
+	return value, storedAt, nil
+}
+
+// SetCache writes through to memory and disk
+func (cache *TieredCache) SetCache(key string, value []byte) error {
+	cache.Memory.set(key, value, time.Now())
+	return cache.Disk.SetCache(key, value)
+}
+
+// BackingCache returns the tier holding every value of the cache, the disk tier of a TieredCache,
+// so that reading it does not promote values into memory
+// BackingCache 返回保存缓存全部值的层（TieredCache 的磁盘层），读取时不会将值提升到内存
+func BackingCache(cache Cacher) Cacher {
+	if tiered, ok := cache.(*TieredCache); ok {
+		return tiered.Disk
+	}
+	return cache
+}
+
+// TieredCacheStats is the statistics of TieredCache
+type TieredCacheStats struct {
+	Memory       any   `json:"memory"`
+	Disk         any   `json:"disk"`
+	MemoryHits   int64 `json:"memory_hits"`
+	MemoryMisses int64 `json:"memory_misses"`
+	DiskHits     int64 `json:"disk_hits"`
+	DiskMisses   int64 `json:"disk_misses"`
+}
+
+func (cache *TieredCache) Stats() any {
+	stats := TieredCacheStats{
+		Memory:       cache.Memory.Stats(),
+		MemoryHits:   cache.memoryHits.Load(),
+		MemoryMisses: cache.memoryMisses.Load(),
+		DiskHits:     cache.diskHits.Load(),
+		DiskMisses:   cache.diskMisses.Load(),
+	}
+	if reporter, ok := cache.Disk.(StatsReporter); ok {
+		stats.Disk = reporter.Stats()
+	}
+	return stats
+}
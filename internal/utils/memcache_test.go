@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheLRU(t *testing.T) {
+	cache := NewMemoryCache(8000)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_ = cache.SetCache(key, make([]byte, 1000))
+	}
+	// touch a, so b is the least recently used
+	if _, err := cache.GetCache("a"); err != nil {
+		t.Fatalf("get a failed: %v", err)
+	}
+	for _, key := range []string{"e", "f", "g", "h", "i"} {
+		_ = cache.SetCache(key, make([]byte, 1000))
+	}
+
+	if _, err := cache.GetCache("b"); err == nil {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, err := cache.GetCache("a"); err != nil {
+		t.Errorf("expected a to be kept: %v", err)
+	}
+
+	// values larger than 1/8 of the limit are not kept
+	_ = cache.SetCache("large", make([]byte, 1001))
+	if _, err := cache.GetCache("large"); err == nil {
+		t.Errorf("expected large value to be skipped")
+	}
+}
+
+func TestTieredCachePromotion(t *testing.T) {
+	disk := &PathMapCache{CachePath: t.TempDir()}
+	if err := disk.SetCache("osm/1/0/0.png", []byte("tile")); err != nil {
+		t.Fatalf("set disk cache failed: %v", err)
+	}
+	_, diskStoredAt, _ := disk.GetCacheWithTime("osm/1/0/0.png")
+
+	cache := NewTieredCache(NewMemoryCache(1<<20), disk)
+
+	for range 3 {
+		value, storedAt, err := cache.GetCacheWithTime("osm/1/0/0.png")
+		if err != nil || string(value) != "tile" {
+			t.Fatalf("get cache failed: %v", err)
+		}
+		// promoted tiles keep the disk stored time
+		if !storedAt.Equal(diskStoredAt) {
+			t.Errorf("expected stored time %s, got %s", diskStoredAt, storedAt)
+		}
+	}
+	if _, err := cache.GetCache("osm/1/0/1.png"); err == nil {
+		t.Errorf("expected miss")
+	}
+
+	stats := cache.Stats().(TieredCacheStats)
+	if stats.DiskHits != 1 || stats.MemoryHits != 2 || stats.MemoryMisses != 2 || stats.DiskMisses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// write through
+	if err := cache.SetCache("osm/1/1/0.png", []byte("new")); err != nil {
+		t.Fatalf("set cache failed: %v", err)
+	}
+	if value, err := disk.GetCache("osm/1/1/0.png"); err != nil || string(value) != "new" {
+		t.Errorf("expected write through to disk: %v", err)
+	}
+	if _, storedAt, _ := cache.Memory.GetCacheWithTime("osm/1/1/0.png"); time.Since(storedAt) > time.Minute {
+		t.Errorf("unexpected memory stored time %s", storedAt)
+	}
+}
+
+func TestTieredCacheDiskEviction(t *testing.T) {
+	disk := NewEvictingCache(&PathMapCache{CachePath: t.TempDir()}, EvictionLRU, CacheLimit{MaxFiles: 10}, nil)
+	defer disk.Close()
+	waitEvictionIdle(t, disk)
+	cache := NewTieredCache(NewMemoryCache(1<<20), disk)
+
+	for i := range 10 {
+		if err := cache.SetCache(fmt.Sprintf("osm/1/0/%d.png", i), []byte("tile")); err != nil {
+			t.Fatalf("set cache failed: %v", err)
+		}
+	}
+	// served by memory, the disk tier counts it as used
+	if _, err := cache.GetCache("osm/1/0/0.png"); err != nil {
+		t.Fatalf("get cache failed: %v", err)
+	}
+	if stats := cache.Stats().(TieredCacheStats); stats.MemoryHits != 1 || stats.DiskHits != 0 {
+		t.Fatalf("expected a memory hit: %+v", stats)
+	}
+
+	if err := cache.SetCache("osm/1/0/10.png", []byte("tile")); err != nil {
+		t.Fatalf("set cache failed: %v", err)
+	}
+	waitEvictionIdle(t, disk)
+
+	if _, err := disk.GetCache("osm/1/0/0.png"); err != nil {
+		t.Errorf("tile used from memory was evicted from disk: %v", err)
+	}
+	// evicted from disk, dropped from memory
+	for _, key := range []string{"osm/1/0/1.png", "osm/1/0/2.png"} {
+		if _, err := cache.Memory.GetCache(key); err == nil {
+			t.Errorf("evicted tile %s is still in memory", key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Request coalescing: concurrent calls with the same key share one execution
+// 请求合并：相同键的并发调用共享同一次执行
+
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+type flightCall[T any] struct {
+	wg    sync.WaitGroup
+	value T
+	err   error
+}
+
+// FlightGroup deduplicates in-flight calls by key
+// FlightGroup 按键对进行中的调用去重
+type FlightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// Do executes fn once for all concurrent callers with the same key,
+// shared reports whether the result was produced by another caller's execution
+// Do 对相同键的并发调用只执行一次 fn，shared 表示结果是否来自其他调用者的执行
+func (group *FlightGroup[T]) Do(key string, fn func() (T, error)) (value T, err error, shared bool) {
+	group.mu.Lock()
+	if group.calls == nil {
+		group.calls = make(map[string]*flightCall[T])
+	}
+	if call, ok := group.calls[key]; ok {
+		group.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err, true
+	}
+
+	call := &flightCall[T]{}
+	call.wg.Add(1)
+	group.calls[key] = call
+	group.mu.Unlock()
+
+	defer func() {
+		// turn a panic into an error, so that the waiters are released
+		// 将 panic 转为错误，确保等待者被释放
+		if recovered := recover(); recovered != nil {
+			call.err = fmt.Errorf("flight %s panic: %v", key, recovered)
+			err = call.err
+		}
+
+		group.mu.Lock()
+		delete(group.calls, key)
+		group.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.value, call.err = fn()
+	return call.value, call.err, false
+}
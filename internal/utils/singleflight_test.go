@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDo(t *testing.T) {
+	var group FlightGroup[[]byte]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err, _ := group.Do("osm/1/0/0.png", func() ([]byte, error) {
+				calls.Add(1)
+				<-release
+				return []byte("tile"), nil
+			})
+			if err != nil {
+				t.Errorf("do failed: %v", err)
+			}
+			results[i] = value
+		}()
+	}
+
+	// let all goroutines join the flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+	for i, value := range results {
+		if string(value) != "tile" {
+			t.Errorf("result %d: unexpected value %q", i, value)
+		}
+	}
+
+	// panics are returned as errors
+	_, err, _ := group.Do("panic", func() ([]byte, error) { panic("boom") })
+	if err == nil {
+		t.Errorf("expected panic error")
+	}
+}
@@ -29,21 +29,32 @@ import (
 
 // Convert lon/lat to pixel position
 func lonLatToPixelXY(lon, lat float64, z int) (px, py int) {
+	x, y := lonLatToWorldPixel(lon, lat, z)
+	return int(x), int(y)
+}
+
+// Convert lon/lat to the fractional pixel position
+func lonLatToWorldPixel(lon, lat float64, z int) (px, py float64) {
 	scale := math.Pow(2, float64(z)) * 256
 	x := (lon + 180.0) / 360.0
 	siny := math.Sin(lat * math.Pi / 180.0)
 	y := 0.5 - math.Log((1+siny)/(1-siny))/(4*math.Pi)
 
-	px = int(x * scale)
-	py = int(y * scale)
+	px = x * scale
+	py = y * scale
 	return
 }
 
 // Convert pixel to lon/lat
 func pixelXYToLonLat(px, py int, z int) (lon, lat float64) {
+	return worldPixelToLonLat(float64(px), float64(py), z)
+}
+
+// Convert the fractional pixel position to lon/lat
+func worldPixelToLonLat(px, py float64, z int) (lon, lat float64) {
 	scale := math.Pow(2, float64(z)) * 256
-	x := float64(px) / scale
-	y := float64(py) / scale
+	x := px / scale
+	y := py / scale
 
 	lon = x*360.0 - 180.0
 	n := math.Pi - 2.0*math.Pi*y
@@ -77,6 +88,33 @@ func gcj02ToBd09(gcjLat, gcjLon float64) (bdLat, bdLon float64) {
 	return
 }
 
+// Convert GCJ02 to WGS84 by iterating wgs84ToGCJ02, the error is below 1e-9 degree
+// 坐标转换：从 GCJ02 迭代反算 WGS84，误差小于 1e-9 度
+func gcj02ToWGS84(gcjLat, gcjLon float64) (wgsLat, wgsLon float64) {
+	wgsLat, wgsLon = gcjLat, gcjLon
+	for range 10 {
+		lat, lon := wgs84ToGCJ02(wgsLat, wgsLon)
+		dLat, dLon := gcjLat-lat, gcjLon-lon
+		wgsLat += dLat
+		wgsLon += dLon
+		if math.Abs(dLat) < 1e-9 && math.Abs(dLon) < 1e-9 {
+			break
+		}
+	}
+	return
+}
+
+// Convert BD09 to GCJ02 坐标转换：从百度 BD09 转换到 GCJ02
+func bd09ToGCJ02(bdLat, bdLon float64) (gcjLat, gcjLon float64) {
+	x := bdLon - 0.0065
+	y := bdLat - 0.006
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*math.Pi*3000.0/180.0)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*math.Pi*3000.0/180.0)
+	gcjLon = z * math.Cos(theta)
+	gcjLat = z * math.Sin(theta)
+	return
+}
+
 // latitude offset calculation (GCJ02 encrypted)
 func transformLat(x, y float64) float64 {
 	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
@@ -115,6 +153,24 @@ func tmsToGoogleXY(x, y, z int) (gx, gy, gz int) {
 	return
 }
 
+// TMSToGoogleXY converts OSGeo TMS tile coordinates (y axis from south) to Google XYZ coordinates
+// TMSToGoogleXY 将 OSGeo TMS 瓦片坐标（y 轴自南向北）转换为 Google XYZ 坐标
+func TMSToGoogleXY(x, y, z int) (gx, gy, gz int) {
+	return tmsToGoogleXY(x, y, z)
+}
+
+// LonLatToPixelXY converts the WGS84 coordinate to the pixel position in the 256px tile world at zoom z
+// LonLatToPixelXY 将 WGS84 坐标转换为 z 级别 256 像素瓦片世界中的像素位置
+func LonLatToPixelXY(lon, lat float64, z int) (px, py int) {
+	return lonLatToPixelXY(lon, lat, z)
+}
+
+// PixelXYToLonLat converts the pixel position in the 256px tile world at zoom z to the WGS84 coordinate
+// PixelXYToLonLat 将 z 级别 256 像素瓦片世界中的像素位置转换为 WGS84 坐标
+func PixelXYToLonLat(px, py int, z int) (lon, lat float64) {
+	return pixelXYToLonLat(px, py, z)
+}
+
 // GCJ02MapProvider 支持 GCJ02 和 BD09，可通过 CoordinateType 字段区分
 // GCJ02MapProvider support GCJ02 and BD09, which can be distinguished by the CoordinateType field
 type GCJ02MapProvider struct {
@@ -122,8 +178,13 @@ type GCJ02MapProvider struct {
 	Name           string
 	BaseURL        string
 	ReferenceURL   string
-	CoordinateType string // 可为 "GCJ02" 或 "BD09" Can be "GCJ02" or "BD09"
-	IsTMS          bool   // 是否为 TMS 坐标系 Whether it is a TMS coordinate system
+	CoordinateType string     // 可为 "GCJ02" 或 "BD09" Can be "GCJ02" or "BD09"
+	IsTMS          bool       // 是否为 TMS 坐标系 Whether it is a TMS coordinate system
+	BaiduGrid      bool       // 是否为百度瓦片网格 (BD09MC) Whether the source uses the Baidu tile grid (BD09MC)
+	Resampling     Resampling // 重采样核，默认 nearest Resampling kernel, nearest by default
+
+	// extra request headers, e.g. API keys
+	Headers map[string]string
 }
 
 func (gcjmap *GCJ02MapProvider) GetMapMetadata() *TileMapMetadata {
@@ -134,12 +195,16 @@ func (gcjmap *GCJ02MapProvider) GetMapMetadata() *TileMapMetadata {
 func (gcjmap *GCJ02MapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 	logger.Debugf("GetMapPic: %s, %d, %d, %d", gcjmap.Name, x, y, z)
 
-	httpClient := request.DefaultHTTPClient
+	httpClient := request.GetDefaultHTTPClient()
 
 	wgsLonTopLeft, wgsLatTopLeft := pixelXYToLonLat(x*256, y*256, z)
 	wgsLonBottomRight, wgsLatBottomRight := pixelXYToLonLat((x+1)*256-1, (y+1)*256-1, z)
 
-	if !isInMainlandChina(wgsLatTopLeft, wgsLonTopLeft) && !isInMainlandChina(wgsLatBottomRight, wgsLonBottomRight) {
+	inMainlandChina := isInMainlandChina(wgsLatTopLeft, wgsLonTopLeft) || isInMainlandChina(wgsLatBottomRight, wgsLonBottomRight)
+
+	// tiles of the Baidu grid are always warped, the grid differs from the Google grid where BD09 is not shifted
+	// 百度瓦片网格与 Google 网格不同，即使 BD09 不加偏的区域也需要逐像素转换
+	if !inMainlandChina && !gcjmap.BaiduGrid {
 		url := strings.Replace(gcjmap.BaseURL, "{x}", strconv.Itoa(x), 1)
 		url = strings.Replace(url, "{y}", strconv.Itoa(y), 1)
 		url = strings.Replace(url, "{z}", strconv.Itoa(z), 1)
@@ -150,6 +215,7 @@ func (gcjmap *GCJ02MapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 		} else {
 			req.Header.Set("Referer", "https://www.amap.com/")
 		}
+		setRequestHeaders(req, gcjmap.Headers)
 		resp, err := httpClient.Do(req)
 		if err != nil {
 			return nil, err
@@ -157,83 +223,38 @@ func (gcjmap *GCJ02MapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 		return resp, nil
 	}
 
-	tile := image.NewRGBA(image.Rect(0, 0, 256, 256))
-
-	// temporary cache for source tiles
-	sourceTileCache := make(map[string]image.Image)
-
-	for py := 0; py < 256; py++ {
-		for px := 0; px < 256; px++ {
-			wgsLon, wgsLat := pixelXYToLonLat(x*256+px, y*256+py, z)
-			gcjLat, gcjLon := wgs84ToGCJ02(wgsLat, wgsLon)
-
-			if gcjmap.CoordinateType == "BD09" {
-				gcjLat, gcjLon = gcj02ToBd09(gcjLat, gcjLon) // 转 BD09
-			}
-
-			gx, gy := lonLatToPixelXY(gcjLon, gcjLat, z)
-			tx := gx / 256
-			ty := gy / 256
-			sx := gx % 256
-			sy := gy % 256
-
-			tileKey := fmt.Sprintf("%d_%d_%d", tx, ty, z)
-			// get cached tile
-			srcTile, ok := sourceTileCache[tileKey]
-
-			if !ok {
-				logger.Debugf("Tile %s not found in cache, fetching from %s", tileKey, gcjmap.BaseURL)
-				// if isTMS, convert to Google XYZ
-				if gcjmap.IsTMS {
-					tx, ty, z = tmsToGoogleXY(tx, ty, z)
-				}
-
-				url := strings.Replace(gcjmap.BaseURL, "{x}", strconv.Itoa(tx), 1)
-				url = strings.Replace(url, "{y}", strconv.Itoa(ty), 1)
-				url = strings.Replace(url, "{z}", strconv.Itoa(z), 1)
-				req, _ := http.NewRequest(http.MethodGet, url, nil)
-				req.Header.Set("User-Agent", request.DefaultUserAgent)
-				if gcjmap.ReferenceURL != "" {
-					req.Header.Set("Referer", gcjmap.ReferenceURL)
-				}
-				resp, err := httpClient.Do(req)
-
-				// handle error
-				if err != nil || resp.StatusCode != http.StatusOK {
-					logger.Errorf("Failed to fetch tile %s: %v", tileKey, err)
-					continue
-				}
-
-				// check content type
-				contentType := resp.Header.Get("Content-Type")
-				var img image.Image
-				if strings.Contains(contentType, "image/png") {
-					img, err = png.Decode(resp.Body)
-				} else if strings.Contains(contentType, "image/jpeg") {
-					img, err = jpeg.Decode(resp.Body)
-				} else {
-					logger.Errorf("Unsupported content type %s for tile %s", contentType, tileKey)
-					resp.Body.Close()
-					return nil, fmt.Errorf("unsupported response content type %s", contentType)
-				}
-
-				resp.Body.Close()
-				if err != nil {
-					logger.Errorf("Failed to decode tile %s: %v", tileKey, err)
-					continue
-				}
-				sourceTileCache[tileKey] = img
-				srcTile = img
-			}
-
-			if rgbaImg, ok := srcTile.(*image.RGBA); ok {
-				if sx < 256 && sy < 256 {
-					tile.SetRGBA(px, py, rgbaImg.RGBAAt(sx, sy))
-				}
-			} else {
-				tile.Set(px, py, srcTile.At(sx, sy))
-			}
+	toSource := func(wgsLat, wgsLon float64) (float64, float64) {
+		if !inMainlandChina {
+			return wgsLat, wgsLon
+		}
+		gcjLat, gcjLon := wgs84ToGCJ02(wgsLat, wgsLon)
+		if gcjmap.CoordinateType == "BD09" {
+			gcjLat, gcjLon = gcj02ToBd09(gcjLat, gcjLon) // 转 BD09
 		}
+		return gcjLat, gcjLon
+	}
+
+	var tile *image.RGBA
+	var err error
+	if gcjmap.BaiduGrid {
+		baiduZ := baiduZoom(z)
+		tile, err = warpTile(x, y, z, gcjmap.Resampling, func(wgsLat, wgsLon float64) (float64, float64) {
+			bdLat, bdLon := toSource(wgsLat, wgsLon)
+			return bd09ToBaiduWorldPixel(bdLat, bdLon, baiduZ)
+		}, func(tx, ty int) (image.Image, error) {
+			tx, ty = baiduTileXY(tx, ty)
+			return gcjmap.fetchSourceTile(httpClient, tx, ty, baiduZ)
+		})
+	} else {
+		tile, err = warpTile(x, y, z, gcjmap.Resampling, func(wgsLat, wgsLon float64) (float64, float64) {
+			sourceLat, sourceLon := toSource(wgsLat, wgsLon)
+			return lonLatToWorldPixel(sourceLon, sourceLat, z)
+		}, func(tx, ty int) (image.Image, error) {
+			return gcjmap.fetchSourceTile(httpClient, tx, ty, z)
+		})
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	var buf bytes.Buffer
@@ -248,6 +269,87 @@ func (gcjmap *GCJ02MapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 	}, nil
 }
 
+// fetchSourceTile fetches the GCJ02/BD09 source tile, a failed tile is nil and its pixels are left transparent
+func (gcjmap *GCJ02MapProvider) fetchSourceTile(httpClient *http.Client, tx, ty, z int) (image.Image, error) {
+	tileKey := fmt.Sprintf("%d_%d_%d", tx, ty, z)
+	logger.Debugf("Tile %s not found in cache, fetching from %s", tileKey, gcjmap.BaseURL)
+	// if isTMS, convert to Google XYZ
+	if gcjmap.IsTMS {
+		tx, ty, z = tmsToGoogleXY(tx, ty, z)
+	}
+
+	url := strings.Replace(gcjmap.BaseURL, "{x}", strconv.Itoa(tx), 1)
+	url = strings.Replace(url, "{y}", strconv.Itoa(ty), 1)
+	url = strings.Replace(url, "{z}", strconv.Itoa(z), 1)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("User-Agent", request.DefaultUserAgent)
+	if gcjmap.ReferenceURL != "" {
+		req.Header.Set("Referer", gcjmap.ReferenceURL)
+	}
+	setRequestHeaders(req, gcjmap.Headers)
+	resp, err := httpClient.Do(req)
+
+	// handle error
+	if err != nil {
+		logger.Errorf("Failed to fetch tile %s: %v", tileKey, err)
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorf("Failed to fetch tile %s: status code %d", tileKey, resp.StatusCode)
+		return nil, nil
+	}
+
+	// check content type
+	contentType := resp.Header.Get("Content-Type")
+	var img image.Image
+	if strings.Contains(contentType, "image/png") {
+		img, err = png.Decode(resp.Body)
+	} else if strings.Contains(contentType, "image/jpeg") {
+		img, err = jpeg.Decode(resp.Body)
+	} else {
+		logger.Errorf("Unsupported content type %s for tile %s", contentType, tileKey)
+		return nil, fmt.Errorf("unsupported response content type %s", contentType)
+	}
+	if err != nil {
+		logger.Errorf("Failed to decode tile %s: %v", tileKey, err)
+		return nil, nil
+	}
+	return img, nil
+}
+
+// warpTile fills the 256px tile z/x/y pixel by pixel: the WGS84 coordinate of the pixel is converted to the pixel
+// position in the source tile grid by `sourcePixel`, y axis down, and the pixel is resampled from the source tiles
+// with the kernel: nearest copies the source pixel under the top-left corner of the pixel, the other kernels
+// interpolate the source pixels around its center, across source tile boundaries.
+// Each source tile is requested once by `sourceTile`, a nil tile leaves its pixels transparent, an error fails the tile.
+// warpTile 逐像素生成 256 像素瓦片 z/x/y：像素的 WGS84 坐标经 sourcePixel 转换为源瓦片网格中的像素位置（y 轴向下），
+// 再按重采样核从源瓦片采样：nearest 复制像素左上角处的源像素，其他重采样核跨源瓦片边界对像素中心周围的源像素插值。
+// 每个源瓦片只通过 sourceTile 请求一次，返回 nil 时对应像素保持透明，返回错误时整个瓦片失败
+func warpTile(x, y, z int, kernel Resampling, sourcePixel func(lat, lon float64) (float64, float64), sourceTile func(tx, ty int) (image.Image, error)) (*image.RGBA, error) {
+	tile := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	sampler := newTileSampler(sourceTile)
+
+	// pixel position sampled in the tile
+	offset := 0.0
+	if kernel == ResamplingBilinear || kernel == ResamplingBicubic {
+		offset = 0.5
+	}
+
+	for py := 0; py < 256; py++ {
+		for px := 0; px < 256; px++ {
+			lon, lat := worldPixelToLonLat(float64(x*256+px)+offset, float64(y*256+py)+offset, z)
+			u, v := sourcePixel(lat, lon)
+			c, err := sampler.sample(kernel, u, v)
+			if err != nil {
+				return nil, err
+			}
+			tile.SetRGBA(px, py, c)
+		}
+	}
+	return tile, nil
+}
+
 var AmapRoadMap = &GCJ02MapProvider{
 	TileMapMetadata: &TileMapMetadata{
 		Name:           "Amap Road Map",
@@ -264,12 +366,44 @@ var AmapRoadMap = &GCJ02MapProvider{
 	ReferenceURL: "https://www.amap.com/",
 }
 
+// Baidu maps use their own tile grid (BD09MC), the BD09 tiles are corrected to WGS84 and served as standard XYZ
+// 百度地图使用自有瓦片网格 (BD09MC)，BD09 瓦片纠偏到 WGS84 后以标准 XYZ 提供
+var BaiduRoadMap = &GCJ02MapProvider{
+	TileMapMetadata: &TileMapMetadata{
+		Name:           "Baidu Road Map",
+		ID:             "baidu_road",
+		MinZoom:        3,
+		MaxZoom:        18,
+		MapType:        MapTypeRaster,
+		MapSize:        MapSize256,
+		ContentType:    MapContentTypePNG,
+		CoordinateType: CoordinateTypeWGS84,
+	},
+
+	Name:           "Baidu Road Map",
+	BaseURL:        "https://maponline0.bdimg.com/tile/?qt=vtile&x={x}&y={y}&z={z}&styles=pl&scaler=1&udt=20250515",
+	ReferenceURL:   "https://map.baidu.com/",
+	CoordinateType: "BD09",
+	BaiduGrid:      true,
+}
+
 // ref: http://www.maps5.com/s/list1/50.html
-// var BaiduSatelliteMap = &GCJ02MapProvider{
-// 	Name: "Baidu Map 百度地图影像图",
-// 	// https://maponline0.bdimg.com/starpic/?qt=satepc&u=x=768;y=160;z=12;v=009;type=sate&fm=46&app=webearth2&v=009&udt=20250515
-// 	BaseURL:        "https://maponline0.bdimg.com/starpic/?qt=satepc&u=x={x};y={y};z={z};v=009;type=sate&fm=46&app=webearth2&v=009&udt=20250515",
-// 	ReferenceURL:   "https://map.baidu.com/",
-// 	CoordinateType: "BD09",
-// 	IsTMS:          true,
-// }
+var BaiduSatelliteMap = &GCJ02MapProvider{
+	TileMapMetadata: &TileMapMetadata{
+		Name:           "Baidu Satellite Map",
+		ID:             "baidu_satellite",
+		MinZoom:        3,
+		MaxZoom:        18,
+		MapType:        MapTypeRaster,
+		MapSize:        MapSize256,
+		ContentType:    MapContentTypePNG,
+		CoordinateType: CoordinateTypeWGS84,
+	},
+
+	Name: "Baidu Map 百度地图影像图",
+	// https://maponline0.bdimg.com/starpic/?qt=satepc&u=x=768;y=160;z=12;v=009;type=sate&fm=46&app=webearth2&v=009&udt=20250515
+	BaseURL:        "https://maponline0.bdimg.com/starpic/?qt=satepc&u=x={x};y={y};z={z};v=009;type=sate&fm=46&app=webearth2&v=009&udt=20250515",
+	ReferenceURL:   "https://map.baidu.com/",
+	CoordinateType: "BD09",
+	BaiduGrid:      true,
+}
@@ -1,15 +1,98 @@
 package mapprovider
 
 import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
 )
 
+func TestBaiduMercator(t *testing.T) {
+	// BD09MC of Tiananmen published by the Baidu Maps API
+	x, y := bd09ToBaiduMercator(39.915, 116.404)
+	if math.Abs(x-12958175) > 1 || math.Abs(y-4825923) > 1 {
+		t.Errorf("unexpected BD09MC %v, %v", x, y)
+	}
+	if sx, sy := bd09ToBaiduMercator(-39.915, -116.404); sx != -x || sy != -y {
+		t.Errorf("BD09MC is not symmetric: %v, %v", sx, sy)
+	}
+	// latitudes are clamped to the grid extent
+	_, north := bd09ToBaiduMercator(89, 0)
+	if _, limit := bd09ToBaiduMercator(74, 0); north != limit {
+		t.Errorf("latitude is not clamped: %v", north)
+	}
+
+	// Guangzhou is in the Baidu tile 12/769/160
+	px, py := bd09ToBaiduWorldPixel(23.13, 113.26, 12)
+	if tx, ty := baiduTileXY(int(math.Floor(px/256)), int(math.Floor(py/256))); tx != 769 || ty != 160 {
+		t.Errorf("expected Baidu tile 769/160, got %d/%d", tx, ty)
+	}
+}
+
+// baiduTileColor is the color of the Baidu tile x/y served by the test server
+func baiduTileColor(x, y int) color.RGBA {
+	return color.RGBA{R: uint8(x), G: uint8(y), B: 255, A: 255}
+}
+
 func TestBaiduSatelliteMap(t *testing.T) {
-	// resp, err := BaiduSatelliteMap.GetMapPic(53345, 28422, 16)
-	// if err != nil {
-	// 	t.Errorf("Error: %v", err)
-	// 	return
-	// }
-	// t.Logf("Response: %v", resp)
-	// t.Log("Test completed successfully.")
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		x, _ := strconv.Atoi(query.Get("x"))
+		y, _ := strconv.Atoi(query.Get("y"))
+		mu.Lock()
+		requested[query.Get("z")+"/"+query.Get("x")+"/"+query.Get("y")] = true
+		mu.Unlock()
+
+		tile := image.NewRGBA(image.Rect(0, 0, 256, 256))
+		draw.Draw(tile, tile.Bounds(), &image.Uniform{C: baiduTileColor(x, y)}, image.Point{}, draw.Src)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(encodePNG(tile))
+	}))
+	defer server.Close()
+
+	provider := *BaiduSatelliteMap
+	provider.BaseURL = server.URL + "/?x={x}&y={y}&z={z}"
+
+	// 10/843/388 covers Beijing, its pixels come from the Baidu tiles at zoom 11, 32768 meters wide
+	response, err := provider.GetMapPic(843, 388, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pixel := range [][2]int{{0, 0}, {128, 128}, {255, 255}} {
+		lon, lat := pixelXYToLonLat(843*256+pixel[0], 388*256+pixel[1], 10)
+		bdLat, bdLon := gcj02ToBd09(wgs84ToGCJ02(lat, lon))
+		mx, my := bd09ToBaiduMercator(bdLat, bdLon)
+		expected := baiduTileColor(int(mx/32768), int(my/32768))
+		if r, g, b, a := tile.At(pixel[0], pixel[1]).RGBA(); uint8(r>>8) != expected.R || uint8(g>>8) != expected.G || b>>8 != 255 || a>>8 != 255 {
+			t.Errorf("pixel %v: expected %v, got %v %v %v %v", pixel, expected, r>>8, g>>8, b>>8, a>>8)
+		}
+	}
+	for key := range requested {
+		var z, x, y int
+		if _, err := fmt.Sscanf(key, "%d/%d/%d", &z, &x, &y); err != nil || z != 11 || x < 395 || x > 396 || y < 146 || y > 147 {
+			t.Errorf("unexpected Baidu tile %s", key)
+		}
+	}
+
+	// tiles outside mainland China are warped through the Baidu grid as well
+	clear(requested)
+	if _, err := provider.GetMapPic(511, 340, 10); err != nil {
+		t.Fatal(err)
+	}
+	if requested["10/511/340"] || len(requested) == 0 {
+		t.Errorf("tile outside China is not warped: %v", requested)
+	}
 }
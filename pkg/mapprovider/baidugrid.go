@@ -0,0 +1,69 @@
+// Baidu tile grid: BD09 coordinates projected to BD09MC (Baidu Mercator, meters), tiles counted from the origin
+// 百度瓦片网格：BD09 坐标投影为 BD09MC（百度墨卡托，单位米），瓦片编号从原点起算
+
+package mapprovider
+
+import "math"
+
+// baiduMaxZoom is the max zoom level of the Baidu tile grid
+const baiduMaxZoom = 19
+
+// latitude bands of the BD09 to BD09MC polynomials
+var baiduLatitudeBands = []float64{75, 60, 45, 30, 15, 0}
+
+// BD09 to BD09MC polynomial coefficients of each latitude band, from the Baidu Maps JavaScript API
+// 各纬度带 BD09 转 BD09MC 的多项式系数，来自百度地图 JavaScript API
+var baiduLL2MC = [][10]float64{
+	{-0.0015702102444, 111320.7020616939, 1704480524535203, -10338987376042340, 26112667856603880, -35149669176653700, 26595700718403920, -10725012454188240, 1800819912950474, 82.5},
+	{0.0008277824516172526, 111320.7020463578, 647795574.6671607, -4082003173.641316, 10774905663.51142, -15171875531.51559, 12053065338.62167, -5124939663.577472, 913311935.9512032, 67.5},
+	{0.00337398766765, 111320.7020202162, 4481351.045890365, -23393751.19931662, 79682215.47186455, -115964993.2797253, 97236711.15602145, -43661946.33752821, 8477230.501135234, 52.5},
+	{0.00220636496208, 111320.7020209128, 51751.86112841131, 3796837.749470245, 992013.7397791013, -1221952.21711287, 1340652.697009075, -620943.6990984312, 144416.9293806241, 37.5},
+	{-0.0003441963504368392, 111320.7020576856, 278.2353980772752, 2485758.690035394, 6070.750963243378, 54821.18345352118, 9540.606633304236, -2710.55326746645, 1405.483844121726, 22.5},
+	{-0.0003218135878613132, 111320.7020701615, 0.00369383431289, 823725.6402795718, 0.46104986909093, 2351.343141331292, 1.58060784298199, 8.77738589078284, 0.37238884252424, 7.45},
+}
+
+// Convert BD09 to BD09MC 坐标转换：从百度 BD09 经纬度转换到百度墨卡托 BD09MC（米）
+// The latitude is clamped to [-74, 74], the extent of the Baidu tile grid
+func bd09ToBaiduMercator(bdLat, bdLon float64) (x, y float64) {
+	lat := math.Max(-74, math.Min(74, bdLat))
+	coefficients := baiduLL2MC[len(baiduLL2MC)-1]
+	for i, band := range baiduLatitudeBands {
+		if math.Abs(lat) >= band {
+			coefficients = baiduLL2MC[i]
+			break
+		}
+	}
+
+	x = coefficients[0] + coefficients[1]*math.Abs(bdLon)
+	c := math.Abs(lat) / coefficients[9]
+	y = coefficients[2] + c*(coefficients[3]+c*(coefficients[4]+c*(coefficients[5]+c*(coefficients[6]+c*(coefficients[7]+c*coefficients[8])))))
+	return math.Copysign(x, bdLon), math.Copysign(y, lat)
+}
+
+// baiduResolution returns the meters per pixel of the Baidu tile grid at zoom z, 2^(18-z)
+func baiduResolution(z int) float64 {
+	return math.Exp2(float64(18 - z))
+}
+
+// baiduZoom returns the zoom level of the Baidu tile grid for the 256px tiles at zoom z: the coarsest one
+// at least as fine as z. A Baidu pixel at zoom z is about 1.68 web mercator pixels, so it is z+1 up to baiduMaxZoom.
+// baiduZoom 返回 z 级别 256 像素瓦片所用的百度瓦片网格级别：精度不低于 z 级别的最粗级别，即 z+1，最大为 baiduMaxZoom
+func baiduZoom(z int) int {
+	return min(z+1, baiduMaxZoom)
+}
+
+// bd09ToBaiduWorldPixel converts the BD09 coordinate to the pixel position in the Baidu tile grid at zoom z,
+// with the y axis down as in warpTile. The tile at (px/256, py/256) is the Baidu tile (x, -y-1), since
+// Baidu tiles are counted from the BD09MC origin with the y axis up.
+// bd09ToBaiduWorldPixel 将 BD09 坐标转换为 z 级别百度瓦片网格中的像素位置，y 轴向下（与 warpTile 一致）。
+// 百度瓦片从 BD09MC 原点起编号且 y 轴向上，因此 (px/256, py/256) 处的瓦片为百度瓦片 (x, -y-1)
+func bd09ToBaiduWorldPixel(bdLat, bdLon float64, z int) (px, py float64) {
+	x, y := bd09ToBaiduMercator(bdLat, bdLon)
+	resolution := baiduResolution(z)
+	return x / resolution, -y / resolution
+}
+
+// baiduTileXY converts the tile position of bd09ToBaiduWorldPixel to the Baidu tile x, y
+func baiduTileXY(tx, ty int) (x, y int) {
+	return tx, -ty - 1
+}
@@ -0,0 +1,216 @@
+package mapprovider
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// CompositeMember is a member layer of a composite map or a member provider of a fallback chain
+// CompositeMember 是组合图层的成员图层或回退链的成员地图源
+type CompositeMember struct {
+	// id of a registered provider
+	ID string `json:"id" yaml:"id" mapstructure:"id"`
+	// 0-1 of composite members, omitted means 1 (opaque)
+	Opacity *float64 `json:"opacity,omitempty" yaml:"opacity" mapstructure:"opacity"`
+}
+
+// CompositeProvider blends the tiles of its members into one image, bottom to top with their opacity,
+// e.g. TianDiTu road annotations on TianDiTu satellite. Member tiles are scaled to the composite tile size.
+// The composite tiles are cached under the composite id.
+// CompositeProvider 按成员顺序（自下而上）和不透明度将成员瓦片混合为一张图片，例如天地图卫星图叠加路网注记。
+// 成员瓦片缩放到组合瓦片的尺寸，组合瓦片缓存在组合图层 id 下
+type CompositeProvider struct {
+	*TileMapMetadata
+	// definition the metadata is completed from once the members are resolved
+	def     ProviderDefinition
+	members []compositeLayer
+}
+
+// compositeLayer is a resolved member
+type compositeLayer struct {
+	provider TileMapProvider
+	opacity  float64
+}
+
+// newCompositeProvider returns the composite map of the definition, the members are resolved by `resolve`
+func newCompositeProvider(def *ProviderDefinition) *CompositeProvider {
+	name := def.Name
+	if name == "" {
+		name = def.ID
+	}
+	return &CompositeProvider{
+		TileMapMetadata: &TileMapMetadata{Name: name, ID: def.ID},
+		def:             *def,
+	}
+}
+
+// resolve looks up the members in the registered providers and completes the metadata:
+// the zoom range defaults to the union of the member ranges, the tile size to the largest member tile size
+// and the coordinate type to the one of the bottom member
+// resolve 在已注册的地图源中查找成员并补全元数据：缩放范围默认为成员范围的并集，
+// 瓦片尺寸默认为最大的成员瓦片尺寸，坐标系默认为最底层成员的坐标系
+func (provider *CompositeProvider) resolve(index map[string]TileMapProvider) error {
+	def := &provider.def
+	members := make([]compositeLayer, 0, len(def.Members))
+	var attributions []string
+	metadata := *provider.TileMapMetadata
+	metadata.MapType = MapTypeRaster
+	metadata.MinZoom = maxDefinitionZoom
+
+	for i, member := range def.Members {
+		registered, ok := index[member.ID]
+		if !ok {
+			return fmt.Errorf("members[%d]: map %s not found", i, member.ID)
+		}
+		memberMetadata := registered.GetMapMetadata().GetMetadataWithDefaults()
+		if memberMetadata.MapType == MapTypeVector {
+			return fmt.Errorf("members[%d]: map %s is a vector map", i, member.ID)
+		}
+		if nested, ok := registered.(memberProvider); ok && !ProviderKindComposite.acceptsMember(nested.kind()) {
+			return fmt.Errorf("members[%d]: map %s is a %s map", i, member.ID, nested.kind())
+		}
+
+		opacity := 1.0
+		if member.Opacity != nil {
+			opacity = *member.Opacity
+		}
+		members = append(members, compositeLayer{provider: registered, opacity: opacity})
+
+		if i == 0 {
+			metadata.CoordinateType = memberMetadata.CoordinateType
+		}
+		metadata.MinZoom = min(metadata.MinZoom, memberMetadata.MinZoom)
+		metadata.MaxZoom = max(metadata.MaxZoom, memberMetadata.MaxZoom)
+		metadata.MapSize = max(metadata.MapSize, memberMetadata.MapSize)
+		if memberMetadata.Attribution != "" && !slices.Contains(attributions, memberMetadata.Attribution) {
+			attributions = append(attributions, memberMetadata.Attribution)
+		}
+	}
+
+	// fields set in the definition override the ones of the members
+	// 定义中设置的字段优先于成员的字段
+	if def.MinZoom != 0 {
+		metadata.MinZoom = def.MinZoom
+	}
+	if def.MaxZoom != 0 {
+		metadata.MaxZoom = def.MaxZoom
+	}
+	if metadata.MinZoom > metadata.MaxZoom {
+		return fmt.Errorf("min_zoom %d is greater than max_zoom %d", metadata.MinZoom, metadata.MaxZoom)
+	}
+	if def.TileSize != 0 {
+		metadata.MapSize = MapSize(def.TileSize)
+	}
+	if def.CoordinateType != "" {
+		metadata.CoordinateType = MapCoordinateType(def.CoordinateType)
+	}
+	metadata.ContentType = MapContentType(def.ContentType)
+	metadata.Attribution = def.Attribution
+	if metadata.Attribution == "" {
+		metadata.Attribution = strings.Join(attributions, " | ")
+	}
+
+	provider.members = members
+	provider.TileMapMetadata = metadata.GetMetadataWithDefaults()
+	return nil
+}
+
+func (provider *CompositeProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+func (provider *CompositeProvider) kind() ProviderKind {
+	return ProviderKindComposite
+}
+
+// Members returns the member providers with a tile at zoom `z`, bottom to top, nil for the members
+// out of their zoom range or fully transparent
+// Members 自下而上返回在 z 级别有瓦片的成员地图源，超出缩放范围或完全透明的成员为 nil
+func (provider *CompositeProvider) Members(z int) []TileMapProvider {
+	providers := make([]TileMapProvider, len(provider.members))
+	for i, member := range provider.members {
+		metadata := member.provider.GetMapMetadata().GetMetadataWithDefaults()
+		if member.opacity > 0 && z >= metadata.MinZoom && z <= metadata.MaxZoom {
+			providers[i] = member.provider
+		}
+	}
+	return providers
+}
+
+// GetMapPic fetches the member tiles concurrently and blends them, the tile fails if any member fails
+// GetMapPic 并发获取成员瓦片并混合，任一成员失败则整个瓦片失败
+func (provider *CompositeProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	return provider.GetMemberTile(x, y, z, readTileResponse)
+}
+
+// GetMemberTile fetches the member tiles concurrently with fetch and blends them, the tile fails if any member fails
+// GetMemberTile 使用 fetch 并发获取成员瓦片并混合，任一成员失败则整个瓦片失败
+func (provider *CompositeProvider) GetMemberTile(x, y, z int, fetch MemberTileFetcher) (*http.Response, error) {
+	members := provider.Members(z)
+	tiles := make([]image.Image, len(members))
+	errs := make([]error, len(members))
+	var wg sync.WaitGroup
+	for i, member := range members {
+		if member == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := fetch(member, x, y, z)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer response.Body.Close()
+			if tiles[i], _, err = image.Decode(response.Body); err != nil {
+				errs[i] = fmt.Errorf("decode tile %d/%d/%d error: %w", z, x, y, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("member %s: %w", members[i].GetMapMetadata().ID, err)
+		}
+	}
+	return provider.blend(tiles, x, y, z)
+}
+
+// blend draws the member tiles over each other, JPEG tiles are flattened onto white
+func (provider *CompositeProvider) blend(tiles []image.Image, x, y, z int) (*http.Response, error) {
+	size := int(provider.MapSize)
+	bounds := image.Rect(0, 0, size, size)
+	composite := image.NewRGBA(bounds)
+	if provider.ContentType == MapContentTypeJPEG {
+		draw.Draw(composite, bounds, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	}
+
+	blended := false
+	for i, tile := range tiles {
+		if tile == nil {
+			continue
+		}
+		if tile.Bounds().Dx() != size || tile.Bounds().Dy() != size {
+			scaled := image.NewRGBA(bounds)
+			xdraw.BiLinear.Scale(scaled, bounds, tile, tile.Bounds(), xdraw.Src, nil)
+			tile = scaled
+		}
+		mask := &image.Uniform{C: color.Alpha16{A: uint16(provider.members[i].opacity*0xffff + 0.5)}}
+		draw.DrawMask(composite, bounds, tile, tile.Bounds().Min, mask, image.Point{}, draw.Over)
+		blended = true
+	}
+	if !blended {
+		return nil, fmt.Errorf("map: %s has no member tile at %d/%d/%d", provider.Name, z, x, y)
+	}
+
+	return encodeTileResponse(composite, provider.ContentType, DefaultJPEGQuality)
+}
@@ -0,0 +1,163 @@
+package mapprovider
+
+import (
+	"errors"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompositeProvider(t *testing.T) {
+	bottom := newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors", MinZoom: 2, MaxZoom: 6, Attribution: "A"})
+	top := newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MinZoom: 1, MaxZoom: 4, MapSize: MapSize512, Attribution: "B"})
+	half := 0.5
+	composite := newCompositeProvider(&ProviderDefinition{
+		ID:      "blend",
+		Kind:    ProviderKindComposite,
+		Members: []CompositeMember{{ID: "colors"}, {ID: "large", Opacity: &half}},
+	})
+	if err := composite.resolve(map[string]TileMapProvider{"colors": bottom, "large": top}); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := composite.GetMapMetadata()
+	if metadata.Name != "blend" || metadata.MinZoom != 1 || metadata.MaxZoom != 6 || metadata.MapSize != MapSize512 ||
+		metadata.ContentType != MapContentTypePNG || metadata.Attribution != "A | B" {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if CacheID(composite) != "blend" {
+		t.Errorf("unexpected cache id %s", CacheID(composite))
+	}
+
+	for _, tc := range []struct {
+		x, y, z  int
+		px, py   int
+		expected color.RGBA
+	}{
+		// the 256px bottom tile is scaled to 512px, the top one is blended with half opacity
+		{1, 2, 3, 10, 10, color.RGBA{R: 20, G: 80, B: 120, A: 0xff}},
+		{1, 2, 3, 300, 300, color.RGBA{R: 80, G: 80, B: 120, A: 0xff}},
+		// above the max zoom of the top member
+		{1, 2, 5, 10, 10, colorOf(1, 2, 5)},
+		// below the min zoom of the bottom member, the top member is half transparent
+		{1, 1, 1, 10, 10, color.RGBA{R: 0, G: 20, B: 20, A: 0x80}},
+	} {
+		response, err := composite.GetMapPic(tc.x, tc.y, tc.z)
+		if err != nil {
+			t.Fatalf("%d/%d/%d: %v", tc.z, tc.x, tc.y, err)
+		}
+		tile, err := png.Decode(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tile.Bounds().Dx() != 512 {
+			t.Errorf("unexpected tile size %v", tile.Bounds())
+		}
+		// premultiplied colors
+		r, g, b, a := tile.At(tc.px, tc.py).RGBA()
+		got := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8)}
+		for _, diff := range []int{int(got.R) - int(tc.expected.R), int(got.G) - int(tc.expected.G), int(got.B) - int(tc.expected.B), int(got.A) - int(tc.expected.A)} {
+			if diff < -2 || diff > 2 {
+				t.Errorf("%d/%d/%d pixel (%d, %d): expected %v, got %v", tc.z, tc.x, tc.y, tc.px, tc.py, tc.expected, got)
+				break
+			}
+		}
+	}
+
+	// a failed member fails the tile
+	if _, err := composite.GetMapPic(3, 2, 3); err == nil || !strings.Contains(err.Error(), "member colors") {
+		t.Errorf("expected member error, got %v", err)
+	}
+
+	members := composite.Members(5)
+	if len(members) != 2 || members[0] != bottom || members[1] != nil {
+		t.Errorf("unexpected members at zoom 5: %v", members)
+	}
+
+	// member tiles come from the fetch function, e.g. the cache of the members
+	var fetched []string
+	fetch := func(member TileMapProvider, x, y, z int) (*http.Response, error) {
+		fetched = append(fetched, member.GetMapMetadata().ID)
+		return readTileResponse(member, x, y, z)
+	}
+	if _, err := composite.GetMemberTile(1, 2, 5, fetch); err != nil || strings.Join(fetched, ",") != "colors" {
+		t.Errorf("unexpected member fetches %v: %v", fetched, err)
+	}
+	for _, fetch := range []MemberTileFetcher{
+		func(member TileMapProvider, x, y, z int) (*http.Response, error) {
+			return nil, errors.New("fetch failed")
+		},
+		func(member TileMapProvider, x, y, z int) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("not an image"))}, nil
+		},
+	} {
+		if _, err := composite.GetMemberTile(1, 2, 5, fetch); err == nil || !strings.Contains(err.Error(), "member colors") {
+			t.Errorf("expected member error, got %v", err)
+		}
+	}
+}
+
+func TestRegistryComposite(t *testing.T) {
+	defs := []ProviderDefinition{{
+		ID:          "tianditu_hybrid",
+		Name:        "TianDiTu Hybrid",
+		Kind:        ProviderKindComposite,
+		ContentType: string(MapContentTypeJPEG),
+		Members:     []CompositeMember{{ID: "tianditu_satellite"}, {ID: "tianditu_road"}},
+	}}
+	registry, err := NewRegistry(defs, LayerOptions{NormalizeTileSize: []string{"open_railway_map"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	composite, ok := registry.MapSourceIndex["tianditu_hybrid"].(*CompositeProvider)
+	if !ok {
+		t.Fatalf("tianditu_hybrid is not registered")
+	}
+	metadata := composite.GetMapMetadata()
+	if metadata.Name != "TianDiTu Hybrid" || metadata.ContentType != MapContentTypeJPEG || metadata.CoordinateType != TianDiTuSatellite.GetMapMetadata().CoordinateType {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+
+	// members are resolved with the layer options applied
+	defs[0].Members = []CompositeMember{{ID: "bing_satelite"}, {ID: "open_railway_map"}}
+	registry, err = NewRegistry(defs, LayerOptions{NormalizeTileSize: []string{"open_railway_map"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	composite = registry.MapSourceIndex["tianditu_hybrid"].(*CompositeProvider)
+	if members := composite.Members(10); members[1] != registry.MapSourceIndex["open_railway_map"] {
+		t.Errorf("member open_railway_map is not normalized")
+	}
+	if composite.GetMapMetadata().MapSize != MapSize256 {
+		t.Errorf("unexpected tile size %d", composite.GetMapMetadata().MapSize)
+	}
+
+	opacity := 1.5
+	for _, tc := range []struct {
+		name    string
+		members []CompositeMember
+		options LayerOptions
+		want    string
+	}{
+		{"no members", nil, LayerOptions{}, "members are required"},
+		{"missing member", []CompositeMember{{ID: "missing"}}, LayerOptions{}, "map missing not found"},
+		{"itself", []CompositeMember{{ID: "tianditu_hybrid"}}, LayerOptions{}, "can not contain itself"},
+		{"opacity", []CompositeMember{{ID: "tianditu_road", Opacity: &opacity}}, LayerOptions{}, "opacity 1.5"},
+		{"layer option", []CompositeMember{{ID: "tianditu_road"}}, LayerOptions{Overzoom: []OverzoomDefinition{{ID: "tianditu_hybrid", MaxZoom: 20}}}, "composite map"},
+	} {
+		defs[0].Members = tc.members
+		if _, err := NewRegistry(defs, tc.options); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+
+	// composites can not be members
+	defs[0].Members = []CompositeMember{{ID: "tianditu_road"}}
+	nested := append(defs, ProviderDefinition{ID: "nested", Kind: ProviderKindComposite, Members: []CompositeMember{{ID: "tianditu_hybrid"}}})
+	if _, err := NewRegistry(nested, LayerOptions{}); err == nil || !strings.Contains(err.Error(), "is a composite map") {
+		t.Errorf("expected nested composite error, got: %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+// Declarative provider definitions loaded from the `providers:` section of config.yaml
+// 从 config.yaml 的 `providers:` 配置段加载的声明式地图源定义
+
+package mapprovider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ProviderKind selects which TileMapProvider implementation is built from a definition
+// ProviderKind 决定根据定义构建哪一种 TileMapProvider 实现
+type ProviderKind string
+
+const (
+	// standard Google XYZ url template: {x} {y} {z}
+	ProviderKindXYZ ProviderKind = "xyz"
+	// Bing quadkey url template: {quadkey}
+	ProviderKindQuadKey ProviderKind = "quadkey"
+	// OSGeo TMS url template, y axis is inverted
+	ProviderKindTMS ProviderKind = "tms"
+	// GCJ02/BD09 source corrected to WGS84 with pixel-level correction
+	ProviderKindGCJ02Corrected ProviderKind = "gcj02-corrected"
+	// Baidu tile grid (BD09MC) url template: {x} {y} {z}, corrected to WGS84 with pixel-level correction
+	ProviderKindBaidu ProviderKind = "baidu"
+	// local MBTiles file: path
+	ProviderKindMBTiles ProviderKind = "mbtiles"
+	// local PMTiles archive: path
+	ProviderKindPMTiles ProviderKind = "pmtiles"
+	// registered providers blended into one layer: members
+	ProviderKindComposite ProviderKind = "composite"
+	// registered providers tried in order until one returns the tile: members
+	ProviderKindFallback ProviderKind = "fallback"
+)
+
+const providerKindsText = "xyz, quadkey, tms, gcj02-corrected, baidu, mbtiles, pmtiles, composite, fallback"
+
+// isFileBased reports whether the kind serves tiles from a local file instead of an url
+func (kind ProviderKind) isFileBased() bool {
+	return kind == ProviderKindMBTiles || kind == ProviderKindPMTiles
+}
+
+// hasMembers reports whether the kind is built from member providers instead of an url
+func (kind ProviderKind) hasMembers() bool {
+	return kind == ProviderKindComposite || kind == ProviderKindFallback
+}
+
+// acceptsMember reports whether a provider of kind `member` can be a member of the kind,
+// composites contain fallback chains but no composites, fallback chains contain neither, so members form no cycle
+// acceptsMember 判断 member 类型的地图源能否作为该类型的成员，组合图层可包含回退链但不能包含组合图层，
+// 回退链两者都不能包含，成员之间不会形成环
+func (kind ProviderKind) acceptsMember(member ProviderKind) bool {
+	switch kind {
+	case ProviderKindComposite:
+		return member != ProviderKindComposite
+	case ProviderKindFallback:
+		return !member.hasMembers()
+	}
+	return false
+}
+
+// max zoom level accepted from config
+const maxDefinitionZoom = 24
+
+// provider id is used as url path segment and cache directory name
+var providerIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ProviderDefinition describes a tile map provider declared in config.yaml
+// ProviderDefinition 描述在 config.yaml 中声明的瓦片地图源
+type ProviderDefinition struct {
+	ID   string       `json:"id" yaml:"id" mapstructure:"id"`
+	Name string       `json:"name" yaml:"name" mapstructure:"name"`
+	Kind ProviderKind `json:"kind" yaml:"kind" mapstructure:"kind"`
+
+	// https://{serverpart:a,b,c}.example.com/{z}/{x}/{y}.png
+	URL string `json:"url" yaml:"url" mapstructure:"url"`
+	// local file of file based kinds (mbtiles, pmtiles)
+	Path string `json:"path,omitempty" yaml:"path" mapstructure:"path"`
+
+	MinZoom        int    `json:"min_zoom" yaml:"min_zoom" mapstructure:"min_zoom"`
+	MaxZoom        int    `json:"max_zoom" yaml:"max_zoom" mapstructure:"max_zoom"`
+	TileSize       int    `json:"tile_size" yaml:"tile_size" mapstructure:"tile_size"`
+	ContentType    string `json:"content_type" yaml:"content_type" mapstructure:"content_type"`
+	CoordinateType string `json:"coordinate_type" yaml:"coordinate_type" mapstructure:"coordinate_type"`
+	// resampling kernel of kinds gcj02-corrected and baidu: nearest (default), bilinear or bicubic
+	Resampling Resampling `json:"resampling,omitempty" yaml:"resampling" mapstructure:"resampling"`
+
+	// HTML attribution shown by map clients (TileJSON)
+	Attribution string `json:"attribution,omitempty" yaml:"attribution" mapstructure:"attribution"`
+
+	Referer string            `json:"referer" yaml:"referer" mapstructure:"referer"`
+	Headers map[string]string `json:"headers" yaml:"headers" mapstructure:"headers"`
+
+	// member layers of kind composite, bottom to top, or member providers of kind fallback, tried in order
+	Members []CompositeMember `json:"members,omitempty" yaml:"members" mapstructure:"members"`
+}
+
+// Validate checks a single definition, it does not check id conflicts
+// Validate 校验单个定义，不检查 ID 冲突
+func (def *ProviderDefinition) Validate() error {
+	var errs []error
+
+	if def.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	} else if !providerIDPattern.MatchString(def.ID) {
+		errs = append(errs, fmt.Errorf("id %q may only contain letters, digits, '_' and '-'", def.ID))
+	}
+
+	if def.URL == "" && !def.Kind.isFileBased() && !def.Kind.hasMembers() {
+		errs = append(errs, errors.New("url is required"))
+	}
+
+	switch def.Kind {
+	case ProviderKindXYZ, ProviderKindTMS, ProviderKindGCJ02Corrected, ProviderKindBaidu:
+		for _, placeholder := range []string{"{x}", "{y}", "{z}"} {
+			if def.URL != "" && !strings.Contains(def.URL, placeholder) {
+				errs = append(errs, fmt.Errorf("url must contain %s placeholder for kind %q", placeholder, def.Kind))
+			}
+		}
+	case ProviderKindQuadKey:
+		if def.URL != "" && !strings.Contains(def.URL, "{quadkey}") {
+			errs = append(errs, fmt.Errorf("url must contain {quadkey} placeholder for kind %q", def.Kind))
+		}
+	case ProviderKindMBTiles, ProviderKindPMTiles:
+		if def.Path == "" {
+			errs = append(errs, fmt.Errorf("path is required for kind %q", def.Kind))
+		}
+	case ProviderKindComposite, ProviderKindFallback:
+		errs = append(errs, def.validateMembers()...)
+	case "":
+		errs = append(errs, fmt.Errorf("kind is required (%s)", providerKindsText))
+	default:
+		errs = append(errs, fmt.Errorf("unknown kind %q, expected one of %s", def.Kind, providerKindsText))
+	}
+
+	if def.MinZoom < 0 || def.MinZoom > maxDefinitionZoom {
+		errs = append(errs, fmt.Errorf("min_zoom %d is out of range [0, %d]", def.MinZoom, maxDefinitionZoom))
+	}
+	if def.MaxZoom < 0 || def.MaxZoom > maxDefinitionZoom {
+		errs = append(errs, fmt.Errorf("max_zoom %d is out of range [0, %d]", def.MaxZoom, maxDefinitionZoom))
+	}
+	// url kinds without max_zoom get the default one, file and member kinds take it from the file or the members
+	// and check the zoom range once it is known
+	// 未设置 max_zoom 的 URL 类型使用默认值，文件和成员类型从文件或成员获取，并在确定后校验缩放范围
+	switch {
+	case def.MaxZoom != 0 && def.MinZoom > def.MaxZoom:
+		errs = append(errs, fmt.Errorf("min_zoom %d is greater than max_zoom %d", def.MinZoom, def.MaxZoom))
+	case def.MaxZoom == 0 && !def.Kind.isFileBased() && !def.Kind.hasMembers() && def.MinZoom > defaultMaxZoom:
+		errs = append(errs, fmt.Errorf("min_zoom %d is greater than the default max_zoom %d", def.MinZoom, defaultMaxZoom))
+	}
+
+	switch MapSize(def.TileSize) {
+	case 0, MapSize256, MapSize512, MapSize1024:
+	default:
+		errs = append(errs, fmt.Errorf("tile_size %d is invalid, expected 256, 512 or 1024", def.TileSize))
+	}
+
+	switch MapContentType(def.ContentType) {
+	case "", MapContentTypePNG, MapContentTypeJPEG:
+	case MapContentTypeWebP:
+		if def.Kind == ProviderKindComposite {
+			errs = append(errs, fmt.Errorf("content_type %q is invalid for kind %q, expected image/png or image/jpeg", def.ContentType, def.Kind))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("content_type %q is invalid, expected image/png, image/jpeg or image/webp", def.ContentType))
+	}
+
+	coordinateType := MapCoordinateType(def.CoordinateType)
+	if def.Kind == ProviderKindGCJ02Corrected {
+		// coordinate_type is the source coordinate system for corrected providers
+		// 对于纠偏类型，coordinate_type 表示源坐标系
+		if coordinateType != "" && coordinateType != CoordinateTypeGCJ02 && coordinateType != CoordinateTypeBD09 {
+			errs = append(errs, fmt.Errorf("coordinate_type %q is invalid for kind %q, expected GCJ02 or BD09", def.CoordinateType, def.Kind))
+		}
+	} else if def.Kind == ProviderKindBaidu {
+		// Baidu tiles are always BD09
+		if coordinateType != "" && coordinateType != CoordinateTypeBD09 {
+			errs = append(errs, fmt.Errorf("coordinate_type %q is invalid for kind %q, expected BD09", def.CoordinateType, def.Kind))
+		}
+	} else {
+		switch coordinateType {
+		case "", CoordinateTypeWebMercator, CoordinateTypeWGS84, CoordinateTypeGCJ02, CoordinateTypeBD09, CoordinateTypeCGCS2000:
+		default:
+			errs = append(errs, fmt.Errorf("coordinate_type %q is invalid", def.CoordinateType))
+		}
+	}
+
+	if def.Resampling != "" {
+		if def.Kind != ProviderKindGCJ02Corrected && def.Kind != ProviderKindBaidu {
+			errs = append(errs, fmt.Errorf("resampling only applies to kinds %q and %q", ProviderKindGCJ02Corrected, ProviderKindBaidu))
+		} else if err := def.Resampling.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateProviderDefinitions validates all definitions and checks id conflicts
+// with each other and with the providers in `existingIDs`
+// ValidateProviderDefinitions 校验所有定义，并检查定义之间以及与 existingIDs 的 ID 冲突
+func ValidateProviderDefinitions(defs []ProviderDefinition, existingIDs []string) error {
+	var errs []error
+	seen := make(map[string]int, len(defs))
+
+	for i := range defs {
+		def := &defs[i]
+		label := fmt.Sprintf("providers[%d]", i)
+		if def.ID != "" {
+			label = fmt.Sprintf("providers[%d] (%s)", i, def.ID)
+		}
+
+		if err := def.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+		}
+
+		if def.ID == "" {
+			continue
+		}
+		if slices.Contains(existingIDs, def.ID) {
+			errs = append(errs, fmt.Errorf("%s: id conflicts with a built-in provider", label))
+		}
+		if first, ok := seen[def.ID]; ok {
+			errs = append(errs, fmt.Errorf("%s: duplicate id, already declared by providers[%d]", label, first))
+			continue
+		}
+		seen[def.ID] = i
+	}
+
+	// members must be registered providers of a kind accepted as member
+	// 成员必须是已注册且类型可作为成员的地图源
+	for i := range defs {
+		def := &defs[i]
+		if !def.Kind.hasMembers() {
+			continue
+		}
+		for j, member := range def.Members {
+			if member.ID == "" || slices.Contains(existingIDs, member.ID) {
+				continue
+			}
+			first, ok := seen[member.ID]
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Errorf("providers[%d] (%s): members[%d]: map %s not found", i, def.ID, j, member.ID))
+			case !def.Kind.acceptsMember(defs[first].Kind):
+				errs = append(errs, fmt.Errorf("providers[%d] (%s): members[%d]: map %s is a %s map", i, def.ID, j, member.ID, defs[first].Kind))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateMembers checks the members of a composite or fallback definition, except their existence
+func (def *ProviderDefinition) validateMembers() []error {
+	var errs []error
+	if len(def.Members) == 0 {
+		errs = append(errs, fmt.Errorf("members are required for kind %q", def.Kind))
+	}
+	for i, member := range def.Members {
+		switch {
+		case member.ID == "":
+			errs = append(errs, fmt.Errorf("members[%d]: id is required", i))
+		case member.ID == def.ID:
+			errs = append(errs, fmt.Errorf("members[%d]: a %s map can not contain itself", i, def.Kind))
+		}
+		if member.Opacity != nil && def.Kind != ProviderKindComposite {
+			errs = append(errs, fmt.Errorf("members[%d]: opacity only applies to kind %q", i, ProviderKindComposite))
+		} else if member.Opacity != nil && (*member.Opacity < 0 || *member.Opacity > 1) {
+			errs = append(errs, fmt.Errorf("members[%d]: opacity %g is out of range [0, 1]", i, *member.Opacity))
+		}
+	}
+	return errs
+}
+
+// metadata builds the TileMapMetadata described by the definition
+func (def *ProviderDefinition) metadata() *TileMapMetadata {
+	name := def.Name
+	if name == "" {
+		name = def.ID
+	}
+
+	coordinateType := MapCoordinateType(def.CoordinateType)
+	if def.Kind == ProviderKindGCJ02Corrected || def.Kind == ProviderKindBaidu || coordinateType == "" {
+		// corrected providers are served as WGS84
+		// 纠偏后的地图源以 WGS84 提供
+		coordinateType = CoordinateTypeWGS84
+	}
+
+	metadata := &TileMapMetadata{
+		Name:           name,
+		ID:             def.ID,
+		MinZoom:        def.MinZoom,
+		MaxZoom:        def.MaxZoom,
+		MapType:        MapTypeRaster,
+		MapSize:        MapSize(def.TileSize),
+		ContentType:    MapContentType(def.ContentType),
+		CoordinateType: coordinateType,
+		Attribution:    def.Attribution,
+	}
+	return metadata.GetMetadataWithDefaults()
+}
+
+// NewProviderFromDefinition builds a TileMapProvider from a validated definition
+// NewProviderFromDefinition 根据已校验的定义构建 TileMapProvider
+func NewProviderFromDefinition(def *ProviderDefinition) (TileMapProvider, error) {
+	if err := def.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid provider definition %s: %w", def.ID, err)
+	}
+
+	if def.Kind.isFileBased() {
+		// metadata comes from the file, fields set in the definition override it
+		// 元数据来自文件，定义中设置的字段优先
+		coordinateType := MapCoordinateType(def.CoordinateType)
+		if coordinateType == "" {
+			coordinateType = CoordinateTypeWGS84
+		}
+		override := &TileMapMetadata{
+			Name:           def.Name,
+			ID:             def.ID,
+			MinZoom:        def.MinZoom,
+			MaxZoom:        def.MaxZoom,
+			MapSize:        MapSize(def.TileSize),
+			ContentType:    MapContentType(def.ContentType),
+			CoordinateType: coordinateType,
+			Attribution:    def.Attribution,
+		}
+		if def.Kind == ProviderKindPMTiles {
+			return NewPMTilesProvider(def.Path, override)
+		}
+		return NewMBTilesProvider(def.Path, override)
+	}
+
+	// members are resolved by the registry, the metadata is completed from them
+	// 成员由注册表解析，元数据根据成员补全
+	switch def.Kind {
+	case ProviderKindComposite:
+		return newCompositeProvider(def), nil
+	case ProviderKindFallback:
+		return newFallbackProvider(def), nil
+	}
+
+	metadata := def.metadata()
+
+	switch def.Kind {
+	case ProviderKindXYZ, ProviderKindTMS:
+		return &GoogleMapProvider{
+			TileMapMetadata: metadata,
+			BaseURL:         def.URL,
+			ReferenceURL:    def.Referer,
+			Headers:         def.Headers,
+			IsTMS:           def.Kind == ProviderKindTMS,
+		}, nil
+	case ProviderKindQuadKey:
+		return &QuadTreeMapProvider{
+			TileMapMetadata: metadata,
+			BaseURL:         def.URL,
+			ReferenceURL:    def.Referer,
+			Headers:         def.Headers,
+		}, nil
+	case ProviderKindGCJ02Corrected:
+		sourceCoordinateType := def.CoordinateType
+		if sourceCoordinateType == "" {
+			sourceCoordinateType = string(CoordinateTypeGCJ02)
+		}
+		return &GCJ02MapProvider{
+			TileMapMetadata: metadata,
+			Name:            metadata.Name,
+			BaseURL:         def.URL,
+			ReferenceURL:    def.Referer,
+			Headers:         def.Headers,
+			CoordinateType:  sourceCoordinateType,
+			Resampling:      def.Resampling,
+		}, nil
+	case ProviderKindBaidu:
+		return &GCJ02MapProvider{
+			TileMapMetadata: metadata,
+			Name:            metadata.Name,
+			BaseURL:         def.URL,
+			ReferenceURL:    def.Referer,
+			Headers:         def.Headers,
+			CoordinateType:  string(CoordinateTypeBD09),
+			BaiduGrid:       true,
+			Resampling:      def.Resampling,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown provider kind %q", def.Kind)
+}
@@ -0,0 +1,75 @@
+package mapprovider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateProviderDefinitions(t *testing.T) {
+	valid := ProviderDefinition{
+		ID:   "custom_xyz",
+		Kind: ProviderKindXYZ,
+		URL:  "https://tile.example.com/{z}/{x}/{y}.png",
+	}
+	if err := ValidateProviderDefinitions([]ProviderDefinition{valid}, BuiltinProviderIDs()); err != nil {
+		t.Fatalf("expected valid definition, got: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		def  ProviderDefinition
+		want string
+	}{
+		{"missing kind", ProviderDefinition{ID: "a", URL: valid.URL}, "kind is required"},
+		{"unknown kind", ProviderDefinition{ID: "a", Kind: "wms", URL: valid.URL}, "unknown kind"},
+		{"missing placeholder", ProviderDefinition{ID: "a", Kind: ProviderKindXYZ, URL: "https://t/{z}/{x}.png"}, "{y}"},
+		{"quadkey placeholder", ProviderDefinition{ID: "a", Kind: ProviderKindQuadKey, URL: valid.URL}, "{quadkey}"},
+		{"bad id", ProviderDefinition{ID: "a/b", Kind: ProviderKindXYZ, URL: valid.URL}, "may only contain"},
+		{"zoom range", ProviderDefinition{ID: "a", Kind: ProviderKindXYZ, URL: valid.URL, MinZoom: 10, MaxZoom: 5}, "greater than max_zoom"},
+		{"default max zoom", ProviderDefinition{ID: "a", Kind: ProviderKindXYZ, URL: valid.URL, MinZoom: 20}, "greater than the default max_zoom 18"},
+		{"tile size", ProviderDefinition{ID: "a", Kind: ProviderKindXYZ, URL: valid.URL, TileSize: 300}, "tile_size"},
+		{"content type", ProviderDefinition{ID: "a", Kind: ProviderKindXYZ, URL: valid.URL, ContentType: "image/gif"}, "content_type"},
+		{"gcj02 source", ProviderDefinition{ID: "a", Kind: ProviderKindGCJ02Corrected, URL: valid.URL, CoordinateType: "EPSG:4326"}, "expected GCJ02 or BD09"},
+		{"baidu source", ProviderDefinition{ID: "a", Kind: ProviderKindBaidu, URL: valid.URL, CoordinateType: "GCJ02"}, "expected BD09"},
+		{"mbtiles path", ProviderDefinition{ID: "a", Kind: ProviderKindMBTiles}, "path is required"},
+		{"pmtiles path", ProviderDefinition{ID: "a", Kind: ProviderKindPMTiles}, "path is required"},
+		{"builtin conflict", ProviderDefinition{ID: "google_satellite", Kind: ProviderKindXYZ, URL: valid.URL}, "built-in"},
+	}
+
+	for _, tc := range cases {
+		err := ValidateProviderDefinitions([]ProviderDefinition{tc.def}, BuiltinProviderIDs())
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+
+	err := ValidateProviderDefinitions([]ProviderDefinition{valid, valid}, nil)
+	if err == nil || !strings.Contains(err.Error(), "duplicate id") {
+		t.Errorf("expected duplicate id error, got: %v", err)
+	}
+}
+
+func TestNewProviderFromDefinition(t *testing.T) {
+	provider, err := NewProviderFromDefinition(&ProviderDefinition{
+		ID:             "custom_gcj02",
+		Kind:           ProviderKindGCJ02Corrected,
+		URL:            "https://tile.example.com/{z}/{x}/{y}.png",
+		CoordinateType: string(CoordinateTypeBD09),
+	})
+	if err != nil {
+		t.Fatalf("build provider failed: %v", err)
+	}
+
+	gcjProvider, ok := provider.(*GCJ02MapProvider)
+	if !ok {
+		t.Fatalf("expected *GCJ02MapProvider, got %T", provider)
+	}
+	if gcjProvider.CoordinateType != "BD09" {
+		t.Errorf("expected source coordinate type BD09, got %s", gcjProvider.CoordinateType)
+	}
+
+	metadata := provider.GetMapMetadata()
+	if metadata.CoordinateType != CoordinateTypeWGS84 || metadata.MaxZoom != 18 || metadata.MapSize != MapSize256 || metadata.Name != "custom_gcj02" {
+		t.Errorf("unexpected metadata defaults: %+v", metadata)
+	}
+}
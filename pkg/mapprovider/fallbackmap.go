@@ -0,0 +1,200 @@
+package mapprovider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/logger"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// TileSourceHeader is the response header with the id of the provider which actually returned the tile
+// of a fallback chain
+// TileSourceHeader 是记录回退链中实际返回瓦片的地图源 id 的响应头
+const TileSourceHeader = "X-Tile-Source"
+
+// FallbackProvider is a chain of providers tried in order until one returns the tile,
+// e.g. google_satellite -> arcgis_satelite -> bing_satelite. A member fails on error, non-200 status or empty body.
+// The tiles are cached under the chain id whichever member returned them.
+// FallbackProvider 是按顺序尝试直到有地图源返回瓦片的地图源链，例如 google_satellite -> arcgis_satelite -> bing_satelite。
+// 出错、状态码非 200 或内容为空时视为该成员失败，无论由哪个成员返回，瓦片都缓存在地图源链 id 下
+type FallbackProvider struct {
+	*TileMapMetadata
+	// definition the metadata is completed from once the members are resolved
+	def     ProviderDefinition
+	members []TileMapProvider
+}
+
+// newFallbackProvider returns the fallback chain of the definition, the members are resolved by `resolve`
+func newFallbackProvider(def *ProviderDefinition) *FallbackProvider {
+	name := def.Name
+	if name == "" {
+		name = def.ID
+	}
+	return &FallbackProvider{
+		TileMapMetadata: &TileMapMetadata{Name: name, ID: def.ID},
+		def:             *def,
+	}
+}
+
+// resolve looks up the members in the registered providers and completes the metadata: the members must have
+// the same tile size, the zoom range defaults to the union of the member ranges and the content type and
+// coordinate type to the ones of the first member. Members of another content type are transcoded.
+// resolve 在已注册的地图源中查找成员并补全元数据：成员瓦片尺寸必须相同，缩放范围默认为成员范围的并集，
+// 内容类型和坐标系默认为第一个成员的类型，其他内容类型的成员会被转码
+func (provider *FallbackProvider) resolve(index map[string]TileMapProvider) error {
+	def := &provider.def
+	members := make([]TileMapProvider, 0, len(def.Members))
+	var attributions []string
+	metadata := *provider.TileMapMetadata
+	metadata.MapType = MapTypeRaster
+	metadata.MinZoom = maxDefinitionZoom
+	metadata.ContentType = MapContentType(def.ContentType)
+
+	for i, member := range def.Members {
+		registered, ok := index[member.ID]
+		if !ok {
+			return fmt.Errorf("members[%d]: map %s not found", i, member.ID)
+		}
+		memberMetadata := registered.GetMapMetadata().GetMetadataWithDefaults()
+		if memberMetadata.MapType == MapTypeVector {
+			return fmt.Errorf("members[%d]: map %s is a vector map", i, member.ID)
+		}
+		if nested, ok := registered.(memberProvider); ok && !ProviderKindFallback.acceptsMember(nested.kind()) {
+			return fmt.Errorf("members[%d]: map %s is a %s map", i, member.ID, nested.kind())
+		}
+
+		if i == 0 {
+			metadata.MapSize = memberMetadata.MapSize
+			metadata.CoordinateType = memberMetadata.CoordinateType
+			if metadata.ContentType == "" {
+				metadata.ContentType = memberMetadata.ContentType
+			}
+		}
+		if memberMetadata.MapSize != metadata.MapSize {
+			return fmt.Errorf("members[%d]: map %s serves %dpx tiles, expected %dpx", i, member.ID, memberMetadata.MapSize, metadata.MapSize)
+		}
+		if memberMetadata.ContentType != metadata.ContentType {
+			transcoded, err := NewTranscodedProvider(registered, metadata.ContentType, 0)
+			if err != nil {
+				return fmt.Errorf("members[%d]: map %s: %w", i, member.ID, err)
+			}
+			registered = transcoded
+		}
+		members = append(members, registered)
+
+		metadata.MinZoom = min(metadata.MinZoom, memberMetadata.MinZoom)
+		metadata.MaxZoom = max(metadata.MaxZoom, memberMetadata.MaxZoom)
+		if memberMetadata.Attribution != "" && !slices.Contains(attributions, memberMetadata.Attribution) {
+			attributions = append(attributions, memberMetadata.Attribution)
+		}
+	}
+
+	// fields set in the definition override the ones of the members
+	// 定义中设置的字段优先于成员的字段
+	if def.MinZoom != 0 {
+		metadata.MinZoom = def.MinZoom
+	}
+	if def.MaxZoom != 0 {
+		metadata.MaxZoom = def.MaxZoom
+	}
+	if metadata.MinZoom > metadata.MaxZoom {
+		return fmt.Errorf("min_zoom %d is greater than max_zoom %d", metadata.MinZoom, metadata.MaxZoom)
+	}
+	if def.CoordinateType != "" {
+		metadata.CoordinateType = MapCoordinateType(def.CoordinateType)
+	}
+	metadata.Attribution = def.Attribution
+	if metadata.Attribution == "" {
+		metadata.Attribution = strings.Join(attributions, " | ")
+	}
+
+	provider.members = members
+	provider.TileMapMetadata = metadata.GetMetadataWithDefaults()
+	return nil
+}
+
+func (provider *FallbackProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+func (provider *FallbackProvider) kind() ProviderKind {
+	return ProviderKindFallback
+}
+
+// Members returns the members with a tile at zoom `z` in the order they are tried,
+// members of another content type are transcoded to the one of the chain
+// Members 按尝试顺序返回在 z 级别有瓦片的成员，其他内容类型的成员已转码为地图源链的内容类型
+func (provider *FallbackProvider) Members(z int) []TileMapProvider {
+	members := make([]TileMapProvider, 0, len(provider.members))
+	for _, member := range provider.members {
+		metadata := member.GetMapMetadata().GetMetadataWithDefaults()
+		if z >= metadata.MinZoom && z <= metadata.MaxZoom {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// GetMapPic tries the members in order and returns the first tile, with the member id in `TileSourceHeader`
+// GetMapPic 按顺序尝试成员并返回第一个瓦片，成员 id 记录在 TileSourceHeader 中
+func (provider *FallbackProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	return provider.GetMemberTile(x, y, z, readTileResponse)
+}
+
+// GetMemberTile tries the members in order with fetch and returns the first tile, with the member id
+// in `TileSourceHeader` unless the member reports its own source
+// GetMemberTile 使用 fetch 按顺序尝试成员并返回第一个瓦片，成员 id 记录在 TileSourceHeader 中（成员已记录来源时保留）
+func (provider *FallbackProvider) GetMemberTile(x, y, z int, fetch MemberTileFetcher) (*http.Response, error) {
+	var errs []error
+	for _, member := range provider.Members(z) {
+		memberID := member.GetMapMetadata().ID
+		response, err := fetch(member, x, y, z)
+		if err != nil {
+			logger.Warnf("Get member %s tile %d/%d/%d of %s failed, try the next member: %v", memberID, z, x, y, provider.ID, err)
+			errs = append(errs, fmt.Errorf("member %s: %w", memberID, err))
+			continue
+		}
+		if response.Header.Get(TileSourceHeader) == "" {
+			response.Header.Set(TileSourceHeader, memberID)
+		}
+		return response, nil
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("map: %s has no member at zoom level %d", provider.Name, z)
+	}
+	return nil, fmt.Errorf("map: %s all members failed: %w", provider.Name, errors.Join(errs...))
+}
+
+// readTileResponse gets the tile from the provider and reads the body, a non-200 response or an empty body is an error
+func readTileResponse(provider TileMapProvider, x, y, z int) (*http.Response, error) {
+	response, err := provider.GetMapPic(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile %d/%d/%d returns status %d", z, x, y, response.StatusCode)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read tile %d/%d/%d error: %w", z, x, y, err)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("tile %d/%d/%d is empty", z, x, y)
+	}
+
+	header := response.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}, nil
+}
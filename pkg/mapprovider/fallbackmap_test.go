@@ -0,0 +1,132 @@
+package mapprovider
+
+import (
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestFallbackProvider(t *testing.T) {
+	index := map[string]TileMapProvider{
+		"colors": newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors", MinZoom: 2, MaxZoom: 6, Attribution: "A"}),
+		"empty":  newFixedTileProvider(&TileMapMetadata{Name: "Empty", ID: "empty"}, []byte{}),
+		// PNG tiles declared as JPEG are decoded by the transcoding
+		"backup": newFixedTileProvider(&TileMapMetadata{Name: "Backup", ID: "backup", MinZoom: 1, MaxZoom: 4, ContentType: MapContentTypeJPEG, Attribution: "B"},
+			encodePNG(image.NewRGBA(image.Rect(0, 0, 256, 256)))),
+		"large": newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MapSize: MapSize512}),
+	}
+	chain := newFallbackProvider(&ProviderDefinition{
+		ID:      "chain",
+		Kind:    ProviderKindFallback,
+		Members: []CompositeMember{{ID: "colors"}, {ID: "empty"}, {ID: "backup"}},
+	})
+	if err := chain.resolve(index); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := chain.GetMapMetadata()
+	if metadata.Name != "chain" || metadata.MinZoom != 0 || metadata.MaxZoom != 18 || metadata.MapSize != MapSize256 ||
+		metadata.ContentType != MapContentTypePNG || metadata.Attribution != "A | B" {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if CacheID(chain) != "chain" {
+		t.Errorf("unexpected cache id %s", CacheID(chain))
+	}
+	if members := chain.Members(5); len(members) != 2 || members[0] != index["colors"] || members[1] != index["empty"] {
+		t.Errorf("unexpected members at zoom 5: %v", members)
+	}
+
+	for _, tc := range []struct {
+		x, y, z int
+		source  string
+	}{
+		{1, 2, 3, "colors"},
+		// colors returns 404 for x = 3, empty returns an empty body
+		{3, 2, 3, "backup"},
+		// out of the zoom range of colors
+		{1, 2, 1, "backup"},
+	} {
+		response, err := chain.GetMapPic(tc.x, tc.y, tc.z)
+		if err != nil {
+			t.Fatalf("%d/%d/%d: %v", tc.z, tc.x, tc.y, err)
+		}
+		if source := response.Header.Get(TileSourceHeader); source != tc.source {
+			t.Errorf("%d/%d/%d: expected source %s, got %s", tc.z, tc.x, tc.y, tc.source, source)
+		}
+		if _, err := png.Decode(response.Body); err != nil || response.Header.Get("Content-Type") != "image/png" {
+			t.Errorf("%d/%d/%d: expected PNG tile (%s), got %v", tc.z, tc.x, tc.y, response.Header.Get("Content-Type"), err)
+		}
+	}
+
+	chain.def.Members = []CompositeMember{{ID: "colors"}, {ID: "empty"}}
+	if err := chain.resolve(index); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.GetMapPic(3, 2, 3); err == nil || !strings.Contains(err.Error(), "all members failed") ||
+		!strings.Contains(err.Error(), "member empty") {
+		t.Errorf("expected member errors, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		def  ProviderDefinition
+		want string
+	}{
+		{ProviderDefinition{ID: "a", Members: []CompositeMember{{ID: "colors"}, {ID: "large"}}}, "serves 512px tiles, expected 256px"},
+		{ProviderDefinition{ID: "a", ContentType: string(MapContentTypeWebP), Members: []CompositeMember{{ID: "colors"}}}, "can not encode image/webp"},
+		{ProviderDefinition{ID: "a", Members: []CompositeMember{{ID: "missing"}}}, "map missing not found"},
+	} {
+		if err := newFallbackProvider(&tc.def).resolve(index); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: expected error containing %q, got: %v", tc.def.Members, tc.want, err)
+		}
+	}
+}
+
+func TestRegistryFallback(t *testing.T) {
+	defs := []ProviderDefinition{
+		{ID: "satellite", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "google_satellite"}, {ID: "arcgis_satelite"}, {ID: "bing_satelite"}}},
+		// fallback chains can be composite members
+		{ID: "hybrid", Kind: ProviderKindComposite, Members: []CompositeMember{{ID: "satellite"}, {ID: "tianditu_road"}}},
+	}
+	registry, err := NewRegistry(defs, LayerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, ok := registry.MapSourceIndex["satellite"].(*FallbackProvider)
+	if !ok {
+		t.Fatalf("satellite is not registered")
+	}
+	if members := chain.Members(10); len(members) != 3 {
+		t.Errorf("unexpected members %v", members)
+	}
+	if hybrid := registry.MapSourceIndex["hybrid"].(*CompositeProvider); hybrid.Members(10)[0] != chain {
+		t.Errorf("fallback chain is not a member of the composite")
+	}
+
+	opacity := 0.5
+	for _, tc := range []struct {
+		name    string
+		defs    []ProviderDefinition
+		options LayerOptions
+		want    string
+	}{
+		{"composite member", []ProviderDefinition{
+			{ID: "hybrid", Kind: ProviderKindComposite, Members: []CompositeMember{{ID: "tianditu_satellite"}, {ID: "tianditu_road"}}},
+			{ID: "chain", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "hybrid"}, {ID: "bing_satelite"}}},
+		}, LayerOptions{}, "map hybrid is a composite map"},
+		{"fallback member", []ProviderDefinition{
+			{ID: "chain", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "google_satellite"}}},
+			{ID: "chain2", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "chain"}}},
+		}, LayerOptions{}, "map chain is a fallback map"},
+		{"opacity", []ProviderDefinition{
+			{ID: "chain", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "google_satellite", Opacity: &opacity}}},
+		}, LayerOptions{}, "opacity only applies"},
+		{"layer option", []ProviderDefinition{
+			{ID: "chain", Kind: ProviderKindFallback, Members: []CompositeMember{{ID: "google_satellite"}}},
+		}, LayerOptions{Overzoom: []OverzoomDefinition{{ID: "chain", MaxZoom: 22}}}, "map chain is a fallback map, set the option on its members"},
+	} {
+		if _, err := NewRegistry(tc.defs, tc.options); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
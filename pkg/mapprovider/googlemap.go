@@ -29,6 +29,12 @@ type GoogleMapProvider struct {
 	// CoordinateType string
 
 	ReferenceURL string
+
+	// extra request headers, e.g. API keys
+	Headers map[string]string
+
+	// OSGeo TMS tile scheme, y axis is inverted
+	IsTMS bool
 }
 
 func (gmp *GoogleMapProvider) GetMapMetadata() *TileMapMetadata {
@@ -57,7 +63,12 @@ func (gmp *GoogleMapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 		return nil, fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", gmp.Name, z, gmp.MinZoom, gmp.MaxZoom)
 	}
 
-	httpClient := request.DefaultHTTPClient
+	// TMS y axis is inverted
+	if gmp.IsTMS {
+		x, y, z = tmsToGoogleXY(x, y, z)
+	}
+
+	httpClient := request.GetDefaultHTTPClient()
 	mapUrl := gmp.BaseURL
 	mapUrl = strings.Replace(mapUrl, "{x}", strconv.Itoa(x), 1) // Replace {x} with the actual x value
 	mapUrl = strings.Replace(mapUrl, "{y}", strconv.Itoa(y), 1) // Replace {y} with the actual y value
@@ -82,6 +93,7 @@ func (gmp *GoogleMapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 		request.Header.Set("Referer", "https://www.openstreetmap.org/")
 		request.Header.Set("Origin", "https://www.openstreetmap.org/")
 	}
+	setRequestHeaders(request, gmp.Headers)
 
 	response, err := httpClient.Do(request)
 	if err != nil {
@@ -100,6 +112,13 @@ func (gmp *GoogleMapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 	return response, nil
 }
 
+// set extra request headers
+func setRequestHeaders(request *http.Request, headers map[string]string) {
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+}
+
 // ===== Provider =====
 
 var GmapSatellite = &GoogleMapProvider{
@@ -171,6 +190,7 @@ var OpenStreetMapStandard = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypePNG,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "&copy; <a href=\"https://www.openstreetmap.org/copyright\">OpenStreetMap</a> contributors",
 	},
 	BaseURL: "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
 }
@@ -185,6 +205,7 @@ var OpenStreetMapPublicGPS = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypePNG,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "&copy; <a href=\"https://www.openstreetmap.org/copyright\">OpenStreetMap</a> contributors",
 	},
 	BaseURL: "https://gps.tile.openstreetmap.org/lines/{z}/{x}/{y}.png",
 }
@@ -202,6 +223,7 @@ var TraceStrackTopoMap = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypeWebP,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "&copy; <a href=\"https://www.openstreetmap.org/copyright\">OpenStreetMap</a> contributors, &copy; <a href=\"https://www.tracestrack.com/\">Tracestrack</a>",
 	},
 	BaseURL:      "https://tile.tracestrack.com/topo__/{z}/{x}/{y}.webp?key=383118983d4a867dd2d367451720d724",
 	ReferenceURL: "https://www.openstreetmap.org/",
@@ -218,6 +240,7 @@ var ArcgisSatelite = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypePNG,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "Tiles &copy; Esri, Maxar, Earthstar Geographics",
 	},
 	BaseURL:      "https://server.arcgisonline.com/ArcGIS/rest/services/World_Imagery/MapServer/tile/{z}/{y}/{x}",
 	ReferenceURL: "https://www.arcgis.com/home/webmap/viewer.html?webmap=0c4f2a1b8d5e4f3b8c7e6f3b8c7e6f3b",
@@ -315,6 +338,7 @@ var OpenStreetMapCyclOSM = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypePNG,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "&copy; <a href=\"https://www.openstreetmap.org/copyright\">OpenStreetMap</a> contributors, <a href=\"https://www.cyclosm.org/\">CyclOSM</a>",
 	},
 
 	BaseURL:      "https://{serverpart:a,b,c}.tile-cyclosm.openstreetmap.fr/cyclosm/{z}/{x}/{y}.png",
@@ -376,7 +400,7 @@ func (tmp *TencentMapSatelliteProvider) GetMapPic(x, y, z int) (*http.Response,
 		return nil, fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", tmp.Name, z, tmp.MinZoom, tmp.MaxZoom)
 	}
 
-	httpClient := request.DefaultHTTPClient
+	httpClient := request.GetDefaultHTTPClient()
 	mapUrl := tmp.BaseURL
 
 	// y = int.Parse( Math.Pow(2, z).ToString()) - 1 - y;
@@ -453,6 +477,7 @@ var OpenRailwayMap = &GoogleMapProvider{
 		MapType:        MapTypeRaster,
 		ContentType:    MapContentTypePNG,
 		CoordinateType: CoordinateTypeWGS84,
+		Attribution:    "&copy; <a href=\"https://www.openstreetmap.org/copyright\">OpenStreetMap</a> contributors, <a href=\"https://www.openrailwaymap.org/\">OpenRailwayMap</a> (CC-BY-SA)",
 	},
 	BaseURL:      "https://tiles.openrailwaymap.org/standard/{z}/{x}/{y}.png",
 	ReferenceURL: "https://www.openrailwaymap.org/",
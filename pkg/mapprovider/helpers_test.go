@@ -0,0 +1,89 @@
+package mapprovider
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// testTileProvider serves the tiles returned by tile with the content type of the metadata, a nil tile is a 404 response
+type testTileProvider struct {
+	metadata *TileMapMetadata
+	tile     func(x, y, z int) []byte
+}
+
+func (provider *testTileProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	body := provider.tile(x, y, z)
+	if body == nil {
+		return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	contentType := provider.metadata.GetMetadataWithDefaults().ContentType
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{string(contentType)}}, Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (provider *testTileProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.metadata
+}
+
+// newColorTileProvider returns 256px PNG tiles filled with colorOf the tile coordinates, tiles with x = 3 are missing
+func newColorTileProvider(metadata *TileMapMetadata) *testTileProvider {
+	return &testTileProvider{metadata: metadata, tile: func(x, y, z int) []byte {
+		if x == 3 {
+			return nil
+		}
+		img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: colorOf(x, y, z)}, image.Point{}, draw.Src)
+		return encodePNG(img)
+	}}
+}
+
+// newQuadrantTileProvider returns 512px PNG tiles whose quadrants are filled with colorOf(quadrant, tile y, tile z)
+func newQuadrantTileProvider(metadata *TileMapMetadata) *testTileProvider {
+	return &testTileProvider{metadata: metadata, tile: func(x, y, z int) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+		for i := range 4 {
+			quadrant := image.Rect(0, 0, 256, 256).Add(image.Pt(i%2*256, i/2*256))
+			draw.Draw(img, quadrant, &image.Uniform{C: colorOf(i, y, z)}, image.Point{}, draw.Src)
+		}
+		return encodePNG(img)
+	}}
+}
+
+// newFixedTileProvider returns the same body for every tile
+func newFixedTileProvider(metadata *TileMapMetadata, body []byte) *testTileProvider {
+	return &testTileProvider{metadata: metadata, tile: func(x, y, z int) []byte {
+		return body
+	}}
+}
+
+func colorOf(x, y, z int) color.RGBA {
+	return color.RGBA{R: uint8(x * 40), G: uint8(y * 40), B: uint8(z * 40), A: 0xff}
+}
+
+// encodePNG encodes the in-memory image, which does not fail
+func encodePNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func mustTileBytes(t *testing.T, provider TileMapProvider, x, y, z int) []byte {
+	t.Helper()
+	response, err := provider.GetMapPic(x, y, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	tileBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tileBytes
+}
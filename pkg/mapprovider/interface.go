@@ -1,9 +1,13 @@
 package mapprovider
 
 import (
+	"errors"
 	"net/http"
 )
 
+// ErrTileNotFound is wrapped by the errors of providers which know that a tile does not exist, e.g. local tile files
+var ErrTileNotFound = errors.New("map: tile not found")
+
 // map coordinate type enumeration
 type MapCoordinateType string
 
@@ -56,6 +60,13 @@ type TileMapMetadata struct {
 	MapSize        MapSize           `json:"map_size"`
 	CoordinateType MapCoordinateType `json:"coordinate_type"`
 	ContentType    MapContentType    `json:"content_type"`
+
+	// HTML attribution shown by map clients, optional
+	Attribution string `json:"attribution,omitempty"`
+	// WGS84 bounds of the tiles, nil means the whole Web Mercator world
+	Bounds *BBox `json:"bounds,omitempty"`
+	// max zoom of the upstream tiles if tiles up to MaxZoom are overzoomed, 0 otherwise
+	NativeMaxZoom int `json:"native_max_zoom,omitempty"`
 }
 
 type TileMapProvider interface {
@@ -66,6 +77,69 @@ type TileMapProvider interface {
 	GetMapMetadata() *TileMapMetadata
 }
 
+// DerivedTileProvider is implemented by providers whose tiles are cut from one tile of a source provider,
+// so callers can fetch the source tile through their cache and share it between the derived tiles
+// DerivedTileProvider 由从源地图源的单个瓦片裁出瓦片的地图源实现，调用方可经缓存获取源瓦片并在派生瓦片间共享
+type DerivedTileProvider interface {
+	TileMapProvider
+
+	// Source returns the source provider
+	Source() TileMapProvider
+
+	// SourceTile returns the source tile of tile z/x/y, derived is false if the tile is served by GetMapPic directly
+	SourceTile(x, y, z int) (sourceX, sourceY, sourceZ int, derived bool)
+
+	// TileFromSource cuts tile z/x/y from the encoded source tile
+	TileFromSource(sourceBytes []byte, x, y, z int) (*http.Response, error)
+}
+
+// MemberTileFetcher gets the tile of a member provider, e.g. through the cache of the member.
+// The response has status 200 and a non-empty body, otherwise an error is returned.
+// MemberTileFetcher 获取成员地图源的瓦片（例如经成员缓存获取），返回的响应状态码为 200 且内容非空，否则返回错误
+type MemberTileFetcher func(member TileMapProvider, x, y, z int) (*http.Response, error)
+
+// MemberTileProvider is implemented by providers whose tiles are made of the tiles of member providers,
+// e.g. composite maps, fallback chains and @2x layers, so callers can fetch the member tiles through their cache.
+// GetMapPic is GetMemberTile with the members fetched directly.
+// MemberTileProvider 由使用成员地图源瓦片生成瓦片的地图源实现（例如组合图层、回退链和 @2x 图层），调用方可经缓存获取成员瓦片。
+// GetMapPic 等同于直接获取成员瓦片的 GetMemberTile
+type MemberTileProvider interface {
+	TileMapProvider
+
+	// GetMemberTile returns tile z/x/y made of the member tiles returned by fetch
+	GetMemberTile(x, y, z int, fetch MemberTileFetcher) (*http.Response, error)
+}
+
+// cacheIDer is implemented by providers whose tiles are cached apart from the provider id
+type cacheIDer interface {
+	CacheID() string
+}
+
+// CacheID returns the cache namespace of the provider tiles, the provider id unless the provider keeps them apart
+// CacheID 返回地图源瓦片的缓存命名空间，除非地图源另行指定，否则为地图源 id
+func CacheID(provider TileMapProvider) string {
+	if ider, ok := provider.(cacheIDer); ok {
+		return ider.CacheID()
+	}
+	return provider.GetMapMetadata().ID
+}
+
+// localTiler is implemented by providers reading their tiles from local files
+type localTiler interface {
+	LocalTiles() bool
+}
+
+// IsLocal reports whether the provider reads its tiles from local files (MBTiles, PMTiles ...),
+// which are as fast to read as the cache, so that the tiles are not copied to the cache
+// IsLocal 判断地图源是否从本地文件（MBTiles、PMTiles 等）读取瓦片，这类瓦片读取速度与缓存相当，不写入缓存
+func IsLocal(provider TileMapProvider) bool {
+	local, ok := provider.(localTiler)
+	return ok && local.LocalTiles()
+}
+
+// max zoom of metadata without one
+const defaultMaxZoom = 18
+
 func (metadata *TileMapMetadata) GetMetadataWithDefaults() *TileMapMetadata {
 
 	// Name and ID should not be empty
@@ -80,7 +154,7 @@ func (metadata *TileMapMetadata) GetMetadataWithDefaults() *TileMapMetadata {
 		metadata.MinZoom = 0
 	}
 	if metadata.MaxZoom == 0 {
-		metadata.MaxZoom = 18
+		metadata.MaxZoom = defaultMaxZoom
 	}
 	if metadata.ContentType == "" {
 		metadata.ContentType = MapContentTypePNG
@@ -0,0 +1,141 @@
+package mapprovider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/mbtiles"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MBTilesProvider serves tiles from a local MBTiles file
+// MBTilesProvider 从本地 MBTiles 文件提供瓦片
+type MBTilesProvider struct {
+	*TileMapMetadata
+	reader *mbtiles.Reader
+}
+
+// content type of the MBTiles `format` metadata
+var mbtilesContentTypes = map[string]MapContentType{
+	"png":  MapContentTypePNG,
+	"jpg":  MapContentTypeJPEG,
+	"jpeg": MapContentTypeJPEG,
+	"webp": MapContentTypeWebP,
+}
+
+// NewMBTilesProvider opens the MBTiles file and derives the metadata from its metadata table,
+// non-empty fields of `override` take precedence
+// NewMBTilesProvider 打开 MBTiles 文件并从其 metadata 表生成元数据，override 中非空字段优先
+func NewMBTilesProvider(path string, override *TileMapMetadata) (*MBTilesProvider, error) {
+	reader, err := mbtiles.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := mbtilesMetadata(reader, override)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("mbtiles %s: %w", path, err)
+	}
+	return &MBTilesProvider{TileMapMetadata: metadata, reader: reader}, nil
+}
+
+func mbtilesMetadata(reader *mbtiles.Reader, override *TileMapMetadata) (*TileMapMetadata, error) {
+	values := reader.Metadata()
+
+	format := strings.ToLower(values["format"])
+	contentType, ok := mbtilesContentTypes[format]
+	if !ok && override.ContentType == "" {
+		return nil, fmt.Errorf("format %q is not supported, expected png, jpg or webp", values["format"])
+	}
+	if override.ContentType != "" {
+		contentType = override.ContentType
+	}
+
+	// zoom range from metadata, or from the tiles table if absent
+	minZoom, errMin := strconv.Atoi(values["minzoom"])
+	maxZoom, errMax := strconv.Atoi(values["maxzoom"])
+	if errMin != nil || errMax != nil {
+		var err error
+		if minZoom, maxZoom, err = reader.ZoomRange(); err != nil {
+			return nil, err
+		}
+	}
+	if override.MinZoom != 0 {
+		minZoom = override.MinZoom
+	}
+	if override.MaxZoom != 0 {
+		maxZoom = override.MaxZoom
+	}
+	if minZoom > maxZoom {
+		return nil, fmt.Errorf("min_zoom %d is greater than max_zoom %d", minZoom, maxZoom)
+	}
+
+	name := values["name"]
+	if override.Name != "" {
+		name = override.Name
+	}
+	if name == "" {
+		name = override.ID
+	}
+
+	attribution := values["attribution"]
+	if override.Attribution != "" {
+		attribution = override.Attribution
+	}
+	var bounds *BBox
+	if bbox, err := ParseBBox(values["bounds"]); err == nil {
+		bounds = &bbox
+	}
+
+	metadata := &TileMapMetadata{
+		Name:           name,
+		ID:             override.ID,
+		MinZoom:        minZoom,
+		MaxZoom:        maxZoom,
+		MapType:        MapTypeRaster,
+		MapSize:        override.MapSize,
+		ContentType:    contentType,
+		CoordinateType: override.CoordinateType,
+		Attribution:    attribution,
+		Bounds:         bounds,
+	}
+	return metadata.GetMetadataWithDefaults(), nil
+}
+
+func (provider *MBTilesProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+func (provider *MBTilesProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	if z < provider.MinZoom || z > provider.MaxZoom {
+		return nil, fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", provider.Name, z, provider.MinZoom, provider.MaxZoom)
+	}
+
+	data, err := provider.reader.Tile(z, x, y)
+	if errors.Is(err, mbtiles.ErrTileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrTileNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{string(provider.ContentType)}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}, nil
+}
+
+// LocalTiles reports that the tiles are read from a local file and not cached
+func (provider *MBTilesProvider) LocalTiles() bool {
+	return true
+}
+
+// Close closes the MBTiles file
+func (provider *MBTilesProvider) Close() error {
+	return provider.reader.Close()
+}
@@ -0,0 +1,121 @@
+package mapprovider
+
+import (
+	"errors"
+	"go-map-proxy/pkg/mbtiles"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMBTilesProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partner.mbtiles")
+	writer, err := mbtiles.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"name": "Partner Map", "format": "jpg", "minzoom": "1", "maxzoom": "3", "bounds": "113,22,114,23", "attribution": "Partner"} {
+		if err := writer.SetMetadata(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.WriteTile(2, 1, 0, []byte("tile 2/1/0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewProviderFromDefinition(&ProviderDefinition{ID: "partner", Kind: ProviderKindMBTiles, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.(io.Closer).Close()
+
+	metadata := provider.GetMapMetadata()
+	if metadata.Name != "Partner Map" || metadata.ContentType != MapContentTypeJPEG || metadata.MinZoom != 1 || metadata.MaxZoom != 3 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if metadata.Bounds == nil || *metadata.Bounds != (BBox{113, 22, 114, 23}) || metadata.Attribution != "Partner" {
+		t.Errorf("unexpected bounds %v and attribution %q", metadata.Bounds, metadata.Attribution)
+	}
+
+	response, err := provider.GetMapPic(1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if string(data) != "tile 2/1/0" || response.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("unexpected tile %q (%s)", data, response.Header.Get("Content-Type"))
+	}
+
+	if _, err := provider.GetMapPic(0, 0, 2); !errors.Is(err, ErrTileNotFound) || !errors.Is(err, mbtiles.ErrTileNotFound) {
+		t.Errorf("expected tile not found, got %v", err)
+	}
+	if !IsLocal(provider) {
+		t.Error("expected local tiles")
+	}
+
+	// min_zoom overrides the file zoom range without max_zoom
+	override, err := NewProviderFromDefinition(&ProviderDefinition{ID: "partner_z2", Kind: ProviderKindMBTiles, Path: path, MinZoom: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer override.(io.Closer).Close()
+	if metadata := override.GetMapMetadata(); metadata.MinZoom != 2 || metadata.MaxZoom != 3 {
+		t.Errorf("unexpected zoom range [%d, %d]", metadata.MinZoom, metadata.MaxZoom)
+	}
+
+	// min_zoom beyond the max zoom of the file
+	if _, err := NewProviderFromDefinition(&ProviderDefinition{ID: "partner_z5", Kind: ProviderKindMBTiles, Path: path, MinZoom: 5}); err == nil || !strings.Contains(err.Error(), "greater than max_zoom 3") {
+		t.Errorf("expected zoom range error, got %v", err)
+	}
+
+	if _, err := NewProviderFromDefinition(&ProviderDefinition{ID: "missing", Kind: ProviderKindMBTiles, Path: path + ".missing"}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestReplaceRegistryClosesAfterRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partner.mbtiles")
+	writer, err := mbtiles.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteTile(1, 1, 0, []byte("tile 1/1/0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry([]ProviderDefinition{{ID: "partner", Kind: ProviderKindMBTiles, Path: path, ContentType: "image/png"}}, LayerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetRegistry(SetRegistry(registry))
+
+	held, release := AcquireRegistry()
+	provider, _ := held.GetProvider("partner")
+	replacement, err := NewRegistry(nil, LayerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ReplaceRegistry(replacement)
+	if current, releaseCurrent := AcquireRegistry(); current != replacement {
+		t.Error("expected the replacement registry")
+	} else {
+		releaseCurrent()
+	}
+
+	// the replaced registry stays open until its last user is done
+	if tileBytes := mustTileBytes(t, provider, 1, 0, 1); string(tileBytes) != "tile 1/1/0" {
+		t.Errorf("unexpected tile %q", tileBytes)
+	}
+	release()
+	release()
+	if _, err := provider.GetMapPic(1, 0, 1); !errors.Is(err, mbtiles.ErrClosed) {
+		t.Errorf("expected closed reader, got %v", err)
+	}
+}
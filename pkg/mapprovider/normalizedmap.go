@@ -0,0 +1,131 @@
+package mapprovider
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+)
+
+// NormalizedSuffix is the cache namespace suffix of the 256px tiles of a normalized 512px provider,
+// the 512px source tiles stay in the namespace of the provider id
+// NormalizedSuffix 是归一化 512 像素地图源的 256 像素瓦片缓存命名空间后缀，512 像素源瓦片仍缓存在地图源 id 下
+const NormalizedSuffix = "@256"
+
+// NormalizedProvider serves a 512px provider on the 256px XYZ grid with the same id:
+// the 256px tile z/x/y is the quadrant of the 512px source tile z-1/(x/2)/(y/2),
+// at the min zoom of the provider it is the source tile z/x/y downscaled by half
+// NormalizedProvider 以相同 id 在 256 像素 XYZ 网格上提供 512 像素地图源：
+// 256 像素瓦片 z/x/y 是 512 像素源瓦片 z-1/(x/2)/(y/2) 的四分之一，在地图源最小级别则为源瓦片 z/x/y 缩小一半
+type NormalizedProvider struct {
+	*TileMapMetadata
+	base TileMapProvider
+}
+
+// NewNormalizedProvider returns the 256px view of the 512px raster provider, the max zoom is one level above the base
+// NewNormalizedProvider 返回 512 像素栅格地图源的 256 像素视图，最大级别比原地图源高一级
+func NewNormalizedProvider(base TileMapProvider) (*NormalizedProvider, error) {
+	baseMetadata := base.GetMapMetadata().GetMetadataWithDefaults()
+	if baseMetadata.MapSize != MapSize512 || baseMetadata.MapType == MapTypeVector {
+		return nil, fmt.Errorf("map %s is not a 512px raster map", baseMetadata.ID)
+	}
+
+	metadata := *baseMetadata
+	metadata.MapSize = MapSize256
+	metadata.MaxZoom = baseMetadata.MaxZoom + 1
+	metadata.ContentType = encodableContentType(baseMetadata.ContentType)
+	return &NormalizedProvider{TileMapMetadata: &metadata, base: base}, nil
+}
+
+func (provider *NormalizedProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+// Source returns the 512px provider
+func (provider *NormalizedProvider) Source() TileMapProvider {
+	return provider.base
+}
+
+// CacheID keeps the 256px tiles apart from the 512px source tiles cached under the provider id
+func (provider *NormalizedProvider) CacheID() string {
+	return provider.ID + NormalizedSuffix
+}
+
+// SourceTile returns the 512px tile of the base provider which the 256px tile z/x/y is cut from
+// SourceTile 返回 256 像素瓦片 z/x/y 所取自的原地图源 512 像素瓦片
+func (provider *NormalizedProvider) SourceTile(x, y, z int) (sourceX, sourceY, sourceZ int, derived bool) {
+	if z > provider.MinZoom {
+		return x >> 1, y >> 1, z - 1, true
+	}
+	return x, y, z, true
+}
+
+// GetMapPic fetches the source tile from the base provider and cuts the 256px tile from it
+// GetMapPic 从原地图源获取源瓦片并从中裁出 256 像素瓦片
+func (provider *NormalizedProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	if err := provider.checkTile(x, y, z); err != nil {
+		return nil, err
+	}
+	sourceX, sourceY, sourceZ, _ := provider.SourceTile(x, y, z)
+	source, err := fetchTileImage(provider.base, sourceX, sourceY, sourceZ)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTileResponse(provider.cut(source, x, y, z), provider.ContentType, DefaultJPEGQuality)
+}
+
+// TileFromSource cuts the 256px tile z/x/y from the encoded source tile, e.g. a cached one
+// TileFromSource 从已编码的源瓦片（例如缓存中的瓦片）裁出 256 像素瓦片 z/x/y
+func (provider *NormalizedProvider) TileFromSource(sourceBytes []byte, x, y, z int) (*http.Response, error) {
+	if err := provider.checkTile(x, y, z); err != nil {
+		return nil, err
+	}
+	source, _, err := image.Decode(bytes.NewReader(sourceBytes))
+	if err != nil {
+		return nil, fmt.Errorf("decode source tile of %d/%d/%d error: %w", z, x, y, err)
+	}
+	return encodeTileResponse(provider.cut(source, x, y, z), provider.ContentType, DefaultJPEGQuality)
+}
+
+func (provider *NormalizedProvider) checkTile(x, y, z int) error {
+	if z < provider.MinZoom || z > provider.MaxZoom {
+		return fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", provider.Name, z, provider.MinZoom, provider.MaxZoom)
+	}
+	if x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return fmt.Errorf("map: %s tile %d/%d/%d is out of the world", provider.Name, z, x, y)
+	}
+	return nil
+}
+
+// cut crops the quadrant of the tile from the source tile, or downscales the source tile at the min zoom
+func (provider *NormalizedProvider) cut(source image.Image, x, y, z int) image.Image {
+	if z <= provider.MinZoom {
+		return downscaleHalf(source)
+	}
+	bounds := source.Bounds()
+	half := image.Pt(bounds.Dx()/2, bounds.Dy()/2)
+	origin := bounds.Min.Add(image.Pt((x&1)*half.X, (y&1)*half.Y))
+	tile := image.NewRGBA(image.Rectangle{Max: half})
+	draw.Draw(tile, tile.Bounds(), source, origin, draw.Src)
+	return tile
+}
+
+// downscaleHalf averages every 2x2 block of the image
+func downscaleHalf(source image.Image) *image.RGBA {
+	bounds := source.Bounds()
+	tile := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+	for y := range tile.Rect.Dy() {
+		for x := range tile.Rect.Dx() {
+			var r, g, b, a uint32
+			for i := range 4 {
+				cr, cg, cb, ca := source.At(bounds.Min.X+2*x+i%2, bounds.Min.Y+2*y+i/2).RGBA()
+				r, g, b, a = r+cr, g+cg, b+cb, a+ca
+			}
+			// premultiplied 16-bit sums of four pixels to 8-bit
+			tile.SetRGBA(x, y, color.RGBA{R: uint8(r >> 10), G: uint8(g >> 10), B: uint8(b >> 10), A: uint8(a >> 10)})
+		}
+	}
+	return tile
+}
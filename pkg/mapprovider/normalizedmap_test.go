@@ -0,0 +1,131 @@
+package mapprovider
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestNormalizedProvider(t *testing.T) {
+	base := newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MinZoom: 1, MaxZoom: 6, MapSize: MapSize512, ContentType: MapContentTypeWebP})
+	normalized, err := NewNormalizedProvider(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := normalized.GetMapMetadata()
+	if metadata.ID != "large" || metadata.MapSize != MapSize256 || metadata.MinZoom != 1 || metadata.MaxZoom != 7 || metadata.ContentType != MapContentTypePNG {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if CacheID(normalized) != "large@256" || CacheID(base) != "large" {
+		t.Errorf("unexpected cache id %s, %s", CacheID(normalized), CacheID(base))
+	}
+
+	for _, tc := range []struct {
+		x, y, z  int
+		expected color.RGBA
+	}{
+		// quadrants of the source tile 2/1/3
+		{2, 6, 3, colorOf(0, 3, 2)},
+		{3, 6, 3, colorOf(1, 3, 2)},
+		{2, 7, 3, colorOf(2, 3, 2)},
+		{3, 7, 3, colorOf(3, 3, 2)},
+	} {
+		response, err := normalized.GetMapPic(tc.x, tc.y, tc.z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := png.Decode(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tile.Bounds().Dx() != 256 || tile.Bounds().Dy() != 256 {
+			t.Fatalf("unexpected tile size %v", tile.Bounds())
+		}
+		for _, p := range []image.Point{{0, 0}, {255, 255}} {
+			if got := color.RGBAModel.Convert(tile.At(p.X, p.Y)); got != tc.expected {
+				t.Errorf("tile %d/%d/%d pixel %v: expected %v, got %v", tc.z, tc.x, tc.y, p, tc.expected, got)
+			}
+		}
+	}
+
+	// at the min zoom the source tile 1/0/1 is downscaled, each quadrant becomes 128px
+	response, err := normalized.GetMapPic(0, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range []image.Point{{10, 10}, {200, 10}, {10, 200}, {200, 200}} {
+		if got, expected := color.RGBAModel.Convert(tile.At(p.X, p.Y)), colorOf(i, 1, 1); got != expected {
+			t.Errorf("downscaled pixel %v: expected %v, got %v", p, expected, got)
+		}
+	}
+
+	for _, xyz := range [][3]int{
+		{0, 0, 0}, // below the min zoom
+		{0, 0, 8}, // above the max zoom
+		{8, 0, 3}, // out of the world
+	} {
+		if _, err := normalized.GetMapPic(xyz[0], xyz[1], xyz[2]); err == nil {
+			t.Errorf("tile %v: expected error", xyz)
+		}
+	}
+
+	if _, err := NewNormalizedProvider(newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors"})); err == nil {
+		t.Error("expected error for 256px map")
+	}
+}
+
+func TestRegistryLayerOptions(t *testing.T) {
+	registry, err := NewRegistry(nil, LayerOptions{
+		// listing twice is a no-op
+		NormalizeTileSize: []string{"open_railway_map", "open_railway_map"},
+		Overzoom: []OverzoomDefinition{
+			{ID: "open_railway_map", MaxZoom: 21},
+			{ID: "arcgis_satelite", MaxZoom: 22},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	railway, ok := registry.MapSourceIndex["open_railway_map"].(*OverzoomProvider)
+	if !ok {
+		t.Fatalf("open_railway_map is not overzoomed")
+	}
+	if _, ok := railway.Source().(*NormalizedProvider); !ok {
+		t.Errorf("open_railway_map is not normalized before overzoom")
+	}
+	if metadata := railway.GetMapMetadata(); metadata.MapSize != MapSize256 || metadata.NativeMaxZoom != 19 || metadata.MaxZoom != 21 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if CacheID(railway) != "open_railway_map@256" {
+		t.Errorf("unexpected cache id %s", CacheID(railway))
+	}
+	for _, kv := range registry.MapSourceSlice {
+		if kv.Value != registry.MapSourceIndex[kv.Key] {
+			t.Errorf("%s is not replaced in place", kv.Key)
+		}
+	}
+
+	for _, tc := range []struct {
+		options LayerOptions
+		valid   bool
+	}{
+		{LayerOptions{NormalizeTileSize: []string{"missing"}}, false},
+		{LayerOptions{NormalizeTileSize: []string{"arcgis_satelite"}}, false},                            // 256px
+		{LayerOptions{Overzoom: []OverzoomDefinition{{ID: "trace_strack_topo_map", MaxZoom: 20}}}, true}, // WebP is served as PNG
+		{LayerOptions{Overzoom: []OverzoomDefinition{{ID: "arcgis_satelite", MaxZoom: 18}}}, false},      // not above the max zoom
+		{LayerOptions{Overzoom: []OverzoomDefinition{{ID: "arcgis_satelite", MaxZoom: 27}}}, false},      // above the zoom limit
+		// normalized WebP maps are served as PNG
+		{LayerOptions{NormalizeTileSize: []string{"trace_strack_topo_map"}, Overzoom: []OverzoomDefinition{{ID: "trace_strack_topo_map", MaxZoom: 20}}}, true},
+	} {
+		if _, err := NewRegistry(nil, tc.options); (err == nil) != tc.valid {
+			t.Errorf("%+v: expected valid %v, got error %v", tc.options, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package mapprovider
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/bits"
+	"net/http"
+)
+
+// OverzoomDefinition raises the max zoom of a provider, tiles above its native max zoom are upscaled
+// OverzoomDefinition 提高地图源的最大级别，超出原生最大级别的瓦片由放大生成
+type OverzoomDefinition struct {
+	ID      string `json:"id" yaml:"id" mapstructure:"id"`
+	MaxZoom int    `json:"max_zoom" yaml:"max_zoom" mapstructure:"max_zoom"`
+}
+
+// OverzoomProvider serves a provider beyond its max zoom: the tile z/x/y above the native max zoom is
+// the sub-region of the ancestor tile at the native max zoom, upscaled with bilinear interpolation
+// OverzoomProvider 在地图源最大级别之上提供瓦片：超出原生最大级别的瓦片 z/x/y
+// 取原生最大级别祖先瓦片中对应的子区域，并以双线性插值放大
+type OverzoomProvider struct {
+	*TileMapMetadata
+	base TileMapProvider
+}
+
+// NewOverzoomProvider returns the provider with tiles up to `maxZoom`, at most log2(tile size) levels above the native max zoom
+// NewOverzoomProvider 返回最大级别为 `maxZoom` 的地图源，最多比原生最大级别高 log2(瓦片尺寸) 级
+func NewOverzoomProvider(base TileMapProvider, maxZoom int) (*OverzoomProvider, error) {
+	baseMetadata := base.GetMapMetadata().GetMetadataWithDefaults()
+	if baseMetadata.MapType == MapTypeVector {
+		return nil, fmt.Errorf("map %s is a vector map", baseMetadata.ID)
+	}
+	maxLevels := bits.Len(uint(baseMetadata.MapSize)) - 1
+	if maxZoom <= baseMetadata.MaxZoom || maxZoom > baseMetadata.MaxZoom+maxLevels || maxZoom > maxDefinitionZoom {
+		return nil, fmt.Errorf("max_zoom %d of map %s is out of range [%d, %d]", maxZoom, baseMetadata.ID,
+			baseMetadata.MaxZoom+1, min(baseMetadata.MaxZoom+maxLevels, maxDefinitionZoom))
+	}
+
+	metadata := *baseMetadata
+	metadata.NativeMaxZoom = baseMetadata.MaxZoom
+	metadata.MaxZoom = maxZoom
+	metadata.ContentType = encodableContentType(baseMetadata.ContentType)
+	return &OverzoomProvider{TileMapMetadata: &metadata, base: base}, nil
+}
+
+func (provider *OverzoomProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+// Source returns the provider with the native tiles
+func (provider *OverzoomProvider) Source() TileMapProvider {
+	return provider.base
+}
+
+// CacheID keeps the tiles in the cache namespace of the base provider
+func (provider *OverzoomProvider) CacheID() string {
+	return CacheID(provider.base)
+}
+
+// SourceTile returns the ancestor of tile z/x/y at the native max zoom, tiles up to the native max zoom are not derived
+// SourceTile 返回瓦片 z/x/y 在原生最大级别的祖先瓦片，原生最大级别以内的瓦片不是派生瓦片
+func (provider *OverzoomProvider) SourceTile(x, y, z int) (sourceX, sourceY, sourceZ int, derived bool) {
+	if z <= provider.NativeMaxZoom {
+		return x, y, z, false
+	}
+	levels := z - provider.NativeMaxZoom
+	return x >> levels, y >> levels, provider.NativeMaxZoom, true
+}
+
+// GetMapPic fetches native tiles from the base provider, tiles above the native max zoom are upscaled from the ancestor.
+// Native WebP tiles are transcoded to PNG like the upscaled ones.
+// GetMapPic 从原地图源获取原生瓦片，超出原生最大级别的瓦片由祖先瓦片放大。原生 WebP 瓦片与放大的瓦片一样转码为 PNG
+func (provider *OverzoomProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	sourceX, sourceY, sourceZ, derived := provider.SourceTile(x, y, z)
+	if !derived {
+		if provider.ContentType == provider.base.GetMapMetadata().GetMetadataWithDefaults().ContentType {
+			return provider.base.GetMapPic(x, y, z)
+		}
+		native, err := fetchTileImage(provider.base, x, y, z)
+		if err != nil {
+			return nil, err
+		}
+		return encodeTileResponse(native, provider.ContentType, DefaultJPEGQuality)
+	}
+	if err := provider.checkTile(x, y, z); err != nil {
+		return nil, err
+	}
+	source, err := fetchTileImage(provider.base, sourceX, sourceY, sourceZ)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTileResponse(provider.cut(source, x, y, z), provider.ContentType, DefaultJPEGQuality)
+}
+
+// TileFromSource upscales tile z/x/y from the encoded ancestor tile, e.g. a cached one
+// TileFromSource 从已编码的祖先瓦片（例如缓存中的瓦片）放大生成瓦片 z/x/y
+func (provider *OverzoomProvider) TileFromSource(sourceBytes []byte, x, y, z int) (*http.Response, error) {
+	if err := provider.checkTile(x, y, z); err != nil {
+		return nil, err
+	}
+	source, _, err := image.Decode(bytes.NewReader(sourceBytes))
+	if err != nil {
+		return nil, fmt.Errorf("decode ancestor tile of %d/%d/%d error: %w", z, x, y, err)
+	}
+	return encodeTileResponse(provider.cut(source, x, y, z), provider.ContentType, DefaultJPEGQuality)
+}
+
+func (provider *OverzoomProvider) checkTile(x, y, z int) error {
+	if z <= provider.NativeMaxZoom || z > provider.MaxZoom {
+		return fmt.Errorf("map: %s zoom level %d is out of overzoom range [%d, %d]", provider.Name, z, provider.NativeMaxZoom+1, provider.MaxZoom)
+	}
+	if x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return fmt.Errorf("map: %s tile %d/%d/%d is out of the world", provider.Name, z, x, y)
+	}
+	return nil
+}
+
+// cut upscales the sub-region of tile z/x/y in the ancestor tile to the size of the ancestor tile
+func (provider *OverzoomProvider) cut(source image.Image, x, y, z int) image.Image {
+	levels := z - provider.NativeMaxZoom
+	bounds := source.Bounds()
+	ancestor := image.NewRGBA(image.Rectangle{Max: bounds.Size()})
+	draw.Draw(ancestor, ancestor.Bounds(), source, bounds.Min, draw.Src)
+
+	// the sub-region is 1/2^levels of the ancestor tile
+	mask := 1<<levels - 1
+	size := float64(ancestor.Rect.Dx()) / float64(int(1)<<levels)
+	return upscale(ancestor, float64(x&mask)*size, float64(y&mask)*size, size, ancestor.Rect.Size())
+}
+
+// upscale samples the square of `size` pixels at (originX, originY) of src to an image of `dstSize` with bilinear interpolation,
+// pixels next to the square are sampled from src so that adjacent tiles join seamlessly
+// upscale 以双线性插值将 src 中 (originX, originY) 处边长 `size` 的正方形采样为 `dstSize` 大小的图片，
+// 正方形外的相邻像素同样从 src 采样，使相邻瓦片无缝衔接
+func upscale(src *image.RGBA, originX, originY, size float64, dstSize image.Point) *image.RGBA {
+	dst := image.NewRGBA(image.Rectangle{Max: dstSize})
+	scaleX, scaleY := size/float64(dstSize.X), size/float64(dstSize.Y)
+	maxX, maxY := src.Rect.Dx()-1, src.Rect.Dy()-1
+
+	for dy := range dstSize.Y {
+		sy := originY + (float64(dy)+0.5)*scaleY - 0.5
+		y0 := int(math.Floor(sy))
+		fy := sy - float64(y0)
+		y1 := min(max(y0+1, 0), maxY)
+		y0 = min(max(y0, 0), maxY)
+		for dx := range dstSize.X {
+			sx := originX + (float64(dx)+0.5)*scaleX - 0.5
+			x0 := int(math.Floor(sx))
+			fx := sx - float64(x0)
+			x1 := min(max(x0+1, 0), maxX)
+			x0 = min(max(x0, 0), maxX)
+
+			c00, c10 := src.RGBAAt(x0, y0), src.RGBAAt(x1, y0)
+			c01, c11 := src.RGBAAt(x0, y1), src.RGBAAt(x1, y1)
+			lerp := func(v00, v10, v01, v11 uint8) uint8 {
+				top := float64(v00) + (float64(v10)-float64(v00))*fx
+				bottom := float64(v01) + (float64(v11)-float64(v01))*fx
+				return uint8(math.Round(top + (bottom-top)*fy))
+			}
+			dst.SetRGBA(dx, dy, color.RGBA{
+				R: lerp(c00.R, c10.R, c01.R, c11.R),
+				G: lerp(c00.G, c10.G, c01.G, c11.G),
+				B: lerp(c00.B, c10.B, c01.B, c11.B),
+				A: lerp(c00.A, c10.A, c01.A, c11.A),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,101 @@
+package mapprovider
+
+import (
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestOverzoomProvider(t *testing.T) {
+	base := newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MaxZoom: 4, MapSize: MapSize512})
+	overzoom, err := NewOverzoomProvider(base, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata := overzoom.GetMapMetadata(); metadata.MaxZoom != 6 || metadata.NativeMaxZoom != 4 || metadata.MapSize != MapSize512 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if x, y, z, derived := overzoom.SourceTile(13, 7, 6); x != 3 || y != 1 || z != 4 || !derived {
+		t.Errorf("unexpected source tile %d/%d/%d (%v)", z, x, y, derived)
+	}
+
+	for _, tc := range []struct {
+		x, y, z  int
+		expected color.RGBA
+	}{
+		// native tile, the center pixel is in the bottom-right quadrant
+		{3, 1, 4, colorOf(3, 1, 4)},
+		// 6/13/7 is the 128px square at (128, 384) of the ancestor 4/3/1, in its bottom-left quadrant
+		{13, 7, 6, colorOf(2, 1, 4)},
+		// 5/7/2 is the bottom-right quadrant of the ancestor 4/3/1
+		{7, 3, 5, colorOf(3, 1, 4)},
+	} {
+		response, err := overzoom.GetMapPic(tc.x, tc.y, tc.z)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := png.Decode(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tile.Bounds().Dx() != 512 {
+			t.Fatalf("unexpected tile size %v", tile.Bounds())
+		}
+		if got := color.RGBAModel.Convert(tile.At(256, 256)); got != tc.expected {
+			t.Errorf("tile %d/%d/%d: expected %v, got %v", tc.z, tc.x, tc.y, tc.expected, got)
+		}
+	}
+
+	// 5/6/2 is the top-left quadrant of 4/3/1, its right edge is blended with the top-right quadrant
+	tile, err := overzoom.TileFromSource(mustTileBytes(t, base, 3, 1, 4), 6, 2, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(tile.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	left, right := colorOf(0, 1, 4), colorOf(1, 1, 4)
+	if got := color.RGBAModel.Convert(img.At(511, 100)).(color.RGBA); got.R <= left.R || got.R >= right.R {
+		t.Errorf("edge pixel %v is not between %v and %v", got, left, right)
+	}
+
+	for _, xyz := range [][3]int{
+		{0, 0, 7},  // above the overzoom max zoom
+		{64, 0, 6}, // out of the world
+	} {
+		if _, err := overzoom.GetMapPic(xyz[0], xyz[1], xyz[2]); err == nil {
+			t.Errorf("tile %v: expected error", xyz)
+		}
+	}
+
+	for _, maxZoom := range []int{4, 14} {
+		if _, err := NewOverzoomProvider(base, maxZoom); err == nil {
+			t.Errorf("max zoom %d: expected error", maxZoom)
+		}
+	}
+}
+
+func TestOverzoomProviderWebP(t *testing.T) {
+	base := newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MaxZoom: 4, MapSize: MapSize512, ContentType: MapContentTypeWebP})
+	overzoom, err := NewOverzoomProvider(base, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := overzoom.GetMapMetadata().ContentType; contentType != MapContentTypePNG {
+		t.Errorf("expected content type image/png, got %s", contentType)
+	}
+	// native and upscaled tiles are both PNG
+	for _, xyz := range [][3]int{{3, 1, 4}, {13, 7, 6}} {
+		response, err := overzoom.GetMapPic(xyz[0], xyz[1], xyz[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := response.Header.Get("Content-Type"); contentType != string(MapContentTypePNG) {
+			t.Errorf("tile %v: expected content type image/png, got %s", xyz, contentType)
+		}
+		if _, err := png.Decode(response.Body); err != nil {
+			t.Errorf("tile %v: %v", xyz, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package mapprovider
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go-map-proxy/pkg/pmtiles"
+	"io"
+	"net/http"
+)
+
+// PMTilesProvider serves tiles from a local PMTiles archive with range reads
+// PMTilesProvider 通过范围读取从本地 PMTiles 归档提供瓦片
+type PMTilesProvider struct {
+	*TileMapMetadata
+	reader *pmtiles.Reader
+}
+
+// NewPMTilesProvider opens the PMTiles archive and derives the metadata from its header and JSON metadata,
+// non-empty fields of `override` take precedence
+// NewPMTilesProvider 打开 PMTiles 归档并从其头部和 JSON 元数据生成元数据，override 中非空字段优先
+func NewPMTilesProvider(path string, override *TileMapMetadata) (*PMTilesProvider, error) {
+	reader, err := pmtiles.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := pmtilesMetadata(reader, override)
+	if err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("pmtiles %s: %w", path, err)
+	}
+	return &PMTilesProvider{TileMapMetadata: metadata, reader: reader}, nil
+}
+
+func pmtilesMetadata(reader *pmtiles.Reader, override *TileMapMetadata) (*TileMapMetadata, error) {
+	header := reader.Header()
+	values := reader.Metadata()
+
+	contentType := override.ContentType
+	if contentType == "" {
+		headerContentType, err := header.TileType.ContentType()
+		if err != nil {
+			return nil, err
+		}
+		contentType = MapContentType(headerContentType)
+	}
+
+	minZoom, maxZoom := int(header.MinZoom), int(header.MaxZoom)
+	if override.MinZoom != 0 {
+		minZoom = override.MinZoom
+	}
+	if override.MaxZoom != 0 {
+		maxZoom = override.MaxZoom
+	}
+	if minZoom > maxZoom {
+		return nil, fmt.Errorf("min_zoom %d is greater than max_zoom %d", minZoom, maxZoom)
+	}
+
+	name, _ := values["name"].(string)
+	if override.Name != "" {
+		name = override.Name
+	}
+	if name == "" {
+		name = override.ID
+	}
+
+	mapSize := override.MapSize
+	if tileSize, ok := values["tile_size"].(float64); ok && mapSize == 0 {
+		mapSize = MapSize(tileSize)
+	}
+
+	attribution, _ := values["attribution"].(string)
+	if override.Attribution != "" {
+		attribution = override.Attribution
+	}
+	var bounds *BBox
+	if bbox := BBox(header.Bounds); bbox.Validate() == nil {
+		bounds = &bbox
+	}
+
+	metadata := &TileMapMetadata{
+		Name:           name,
+		ID:             override.ID,
+		MinZoom:        minZoom,
+		MaxZoom:        maxZoom,
+		MapType:        MapTypeRaster,
+		MapSize:        mapSize,
+		ContentType:    contentType,
+		CoordinateType: override.CoordinateType,
+		Attribution:    attribution,
+		Bounds:         bounds,
+	}
+	return metadata.GetMetadataWithDefaults(), nil
+}
+
+func (provider *PMTilesProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+func (provider *PMTilesProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	if z < provider.MinZoom || z > provider.MaxZoom {
+		return nil, fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", provider.Name, z, provider.MinZoom, provider.MaxZoom)
+	}
+
+	data, err := provider.reader.Tile(z, x, y)
+	if errors.Is(err, pmtiles.ErrTileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrTileNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{string(provider.ContentType)}},
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+	}, nil
+}
+
+// LocalTiles reports that the tiles are read from a local file and not cached
+func (provider *PMTilesProvider) LocalTiles() bool {
+	return true
+}
+
+// Close closes the PMTiles archive
+func (provider *PMTilesProvider) Close() error {
+	return provider.reader.Close()
+}
@@ -0,0 +1,72 @@
+package mapprovider
+
+import (
+	"errors"
+	"go-map-proxy/pkg/pmtiles"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestPMTilesProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "partner.pmtiles")
+	writer, err := pmtiles.NewWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteTile(2, 1, 0, []byte("tile 2/1/0")); err != nil {
+		t.Fatal(err)
+	}
+	header := pmtiles.Header{TileType: pmtiles.TileTypeJPEG, MinZoom: 1, MaxZoom: 3}
+	if err := writer.Finalize(header, map[string]any{"name": "Partner Map"}); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewProviderFromDefinition(&ProviderDefinition{ID: "partner", Kind: ProviderKindPMTiles, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer provider.(io.Closer).Close()
+
+	metadata := provider.GetMapMetadata()
+	if metadata.Name != "Partner Map" || metadata.ContentType != MapContentTypeJPEG || metadata.MinZoom != 1 || metadata.MaxZoom != 3 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+
+	response, err := provider.GetMapPic(1, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(response.Body)
+	if string(data) != "tile 2/1/0" || response.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("unexpected tile %q (%s)", data, response.Header.Get("Content-Type"))
+	}
+
+	if _, err := provider.GetMapPic(0, 0, 2); !errors.Is(err, ErrTileNotFound) || !errors.Is(err, pmtiles.ErrTileNotFound) {
+		t.Errorf("expected tile not found, got %v", err)
+	}
+	if !IsLocal(provider) {
+		t.Error("expected local tiles")
+	}
+
+	// min_zoom overrides the file zoom range without max_zoom
+	override, err := NewProviderFromDefinition(&ProviderDefinition{ID: "partner_z2", Kind: ProviderKindPMTiles, Path: path, MinZoom: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer override.(io.Closer).Close()
+	if metadata := override.GetMapMetadata(); metadata.MinZoom != 2 || metadata.MaxZoom != 3 {
+		t.Errorf("unexpected zoom range [%d, %d]", metadata.MinZoom, metadata.MaxZoom)
+	}
+
+	if err := provider.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.GetMapPic(1, 0, 2); !errors.Is(err, pmtiles.ErrClosed) {
+		t.Errorf("expected closed reader, got %v", err)
+	}
+
+	if _, err := NewProviderFromDefinition(&ProviderDefinition{ID: "missing", Kind: ProviderKindPMTiles, Path: path + ".missing"}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -13,6 +13,9 @@ type QuadTreeMapProvider struct {
 	// https://t.ssl.ak.tiles.virtualearth.net/tiles/a{quadkey}.jpeg?g=14482&n=z&prx=1"
 	BaseURL      string
 	ReferenceURL string
+
+	// extra request headers, e.g. API keys
+	Headers map[string]string
 }
 
 func (qmp *QuadTreeMapProvider) GetMapMetadata() *TileMapMetadata {
@@ -37,7 +40,7 @@ func xyzToQuadkey(x, y, zoom int) string {
 }
 
 func (qmp *QuadTreeMapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
-	httpClient := request.DefaultHTTPClient
+	httpClient := request.GetDefaultHTTPClient()
 	quadkey := xyzToQuadkey(x, y, z)
 	mapUrl := strings.Replace(qmp.BaseURL, "{quadkey}", quadkey, 1)
 
@@ -52,6 +55,7 @@ func (qmp *QuadTreeMapProvider) GetMapPic(x, y, z int) (*http.Response, error) {
 	} else {
 		request.Header.Set("Referer", "https://www.openstreetmap.org/")
 	}
+	setRequestHeaders(request, qmp.Headers)
 
 	response, err := httpClient.Do(request)
 	if err != nil {
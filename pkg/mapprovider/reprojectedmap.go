@@ -0,0 +1,106 @@
+package mapprovider
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"strings"
+)
+
+// ReprojectDefinition registers the layer `<id>@gcj02` or `<id>@bd09` serving a WGS84 provider in the GCJ02 or BD09 tile grid
+// ReprojectDefinition 注册图层 `<id>@gcj02` 或 `<id>@bd09`，以 GCJ02 或 BD09 瓦片网格提供 WGS84 地图源
+type ReprojectDefinition struct {
+	ID string `json:"id" yaml:"id" mapstructure:"id"`
+	// target coordinate type, GCJ02 or BD09
+	CoordinateType MapCoordinateType `json:"coordinate_type" yaml:"coordinate_type" mapstructure:"coordinate_type"`
+	// resampling kernel, nearest by default
+	Resampling Resampling `json:"resampling,omitempty" yaml:"resampling" mapstructure:"resampling"`
+}
+
+// ReprojectedProvider serves a WGS84 provider in the GCJ02 or BD09 tile grid with the pixel-level correction
+// of GCJ02MapProvider in reverse, for apps built on the Amap or Baidu SDKs. Tiles outside mainland China are
+// served as is, since GCJ02 does not shift them.
+// ReprojectedProvider 以 GCJ02 或 BD09 瓦片网格提供 WGS84 地图源，像素级纠偏与 GCJ02MapProvider 方向相反，
+// 供基于高德或百度 SDK 的应用使用。中国大陆以外的瓦片不偏移，直接返回原瓦片
+type ReprojectedProvider struct {
+	*TileMapMetadata
+	base       TileMapProvider
+	resampling Resampling
+}
+
+// reprojectedSuffix returns the id suffix of the layer in the coordinate type, `@gcj02` or `@bd09`
+func reprojectedSuffix(coordinateType MapCoordinateType) string {
+	return "@" + strings.ToLower(string(coordinateType))
+}
+
+// NewReprojectedProvider returns the layer `<id>@gcj02` or `<id>@bd09` of the 256px WGS84 raster provider,
+// resampled with the kernel
+// NewReprojectedProvider 返回 256 像素 WGS84 栅格地图源的图层 `<id>@gcj02` 或 `<id>@bd09`，按重采样核采样
+func NewReprojectedProvider(base TileMapProvider, coordinateType MapCoordinateType, resampling Resampling) (*ReprojectedProvider, error) {
+	baseMetadata := base.GetMapMetadata().GetMetadataWithDefaults()
+	if coordinateType != CoordinateTypeGCJ02 && coordinateType != CoordinateTypeBD09 {
+		return nil, fmt.Errorf("coordinate_type %q is invalid, expected GCJ02 or BD09", coordinateType)
+	}
+	if err := resampling.validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case baseMetadata.MapType == MapTypeVector:
+		return nil, fmt.Errorf("map %s is a vector map", baseMetadata.ID)
+	case baseMetadata.MapSize != MapSize256:
+		return nil, fmt.Errorf("map %s serves %dpx tiles, normalize it to 256px first", baseMetadata.ID, baseMetadata.MapSize)
+	case baseMetadata.CoordinateType == CoordinateTypeGCJ02 || baseMetadata.CoordinateType == CoordinateTypeBD09:
+		return nil, fmt.Errorf("map %s is already a %s map", baseMetadata.ID, baseMetadata.CoordinateType)
+	}
+
+	metadata := *baseMetadata
+	metadata.Name = fmt.Sprintf("%s (%s)", baseMetadata.Name, coordinateType)
+	metadata.ID = baseMetadata.ID + reprojectedSuffix(coordinateType)
+	metadata.CoordinateType = coordinateType
+	metadata.ContentType = encodableContentType(baseMetadata.ContentType)
+	return &ReprojectedProvider{TileMapMetadata: &metadata, base: base, resampling: resampling}, nil
+}
+
+func (provider *ReprojectedProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+// GetMapPic warps the WGS84 tiles covering the GCJ02/BD09 tile z/x/y, the tile fails if any of them fails
+// GetMapPic 将覆盖 GCJ02/BD09 瓦片 z/x/y 的 WGS84 瓦片逐像素纠偏合成，任一源瓦片失败则整个瓦片失败
+func (provider *ReprojectedProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	lonTopLeft, latTopLeft := pixelXYToLonLat(x*256, y*256, z)
+	lonBottomRight, latBottomRight := pixelXYToLonLat((x+1)*256-1, (y+1)*256-1, z)
+	if !isInMainlandChina(latTopLeft, lonTopLeft) && !isInMainlandChina(latBottomRight, lonBottomRight) {
+		if provider.ContentType == provider.base.GetMapMetadata().ContentType {
+			return provider.base.GetMapPic(x, y, z)
+		}
+		source, err := fetchTileImage(provider.base, x, y, z)
+		if err != nil {
+			return nil, err
+		}
+		return encodeTileResponse(source, provider.ContentType, DefaultJPEGQuality)
+	}
+
+	tile, err := warpTile(x, y, z, provider.resampling, func(lat, lon float64) (float64, float64) {
+		wgsLat, wgsLon := provider.toWGS84(lat, lon)
+		return lonLatToWorldPixel(wgsLon, wgsLat, z)
+	}, func(tx, ty int) (image.Image, error) {
+		// beyond the world edge
+		if tx < 0 || ty < 0 || tx >= 1<<z || ty >= 1<<z {
+			return nil, nil
+		}
+		return fetchTileImage(provider.base, tx, ty, z)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return encodeTileResponse(tile, provider.ContentType, DefaultJPEGQuality)
+}
+
+// toWGS84 converts the GCJ02 or BD09 coordinate of a pixel to WGS84
+func (provider *ReprojectedProvider) toWGS84(lat, lon float64) (float64, float64) {
+	if provider.CoordinateType == CoordinateTypeBD09 {
+		lat, lon = bd09ToGCJ02(lat, lon)
+	}
+	return gcj02ToWGS84(lat, lon)
+}
@@ -0,0 +1,155 @@
+package mapprovider
+
+import (
+	"image/png"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInverseCoordinateTransforms(t *testing.T) {
+	for _, point := range [][2]float64{{39.9, 116.4}, {22.54, 114.06}, {31.23, 121.47}, {45.75, 126.65}} {
+		lat, lon := point[0], point[1]
+
+		gcjLat, gcjLon := wgs84ToGCJ02(lat, lon)
+		if math.Abs(gcjLat-lat) < 1e-4 || math.Abs(gcjLon-lon) < 1e-4 {
+			t.Errorf("%v: GCJ02 is not shifted", point)
+		}
+		if wgsLat, wgsLon := gcj02ToWGS84(gcjLat, gcjLon); math.Abs(wgsLat-lat) > 1e-8 || math.Abs(wgsLon-lon) > 1e-8 {
+			t.Errorf("%v: GCJ02 to WGS84 returns %v, %v", point, wgsLat, wgsLon)
+		}
+
+		bdLat, bdLon := gcj02ToBd09(gcjLat, gcjLon)
+		if backLat, backLon := bd09ToGCJ02(bdLat, bdLon); math.Abs(backLat-gcjLat) > 1e-5 || math.Abs(backLon-gcjLon) > 1e-5 {
+			t.Errorf("%v: BD09 to GCJ02 returns %v, %v, expected %v, %v", point, backLat, backLon, gcjLat, gcjLon)
+		}
+	}
+}
+
+func TestReprojectedProvider(t *testing.T) {
+	base := newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors", MaxZoom: 12, ContentType: MapContentTypeWebP})
+	reprojected, err := NewReprojectedProvider(base, CoordinateTypeGCJ02, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := reprojected.GetMapMetadata()
+	if metadata.ID != "colors@gcj02" || metadata.Name != "Colors (GCJ02)" || metadata.CoordinateType != CoordinateTypeGCJ02 ||
+		metadata.ContentType != MapContentTypePNG || metadata.MaxZoom != 12 {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if base.metadata.ID != "colors" || base.metadata.CoordinateType != "" {
+		t.Errorf("base metadata is modified: %+v", base.metadata)
+	}
+
+	// 10/843/388 covers Beijing, GCJ02 is about 4.5 pixels east of WGS84 at zoom 10,
+	// so the left pixels of the GCJ02 tile come from the WGS84 tile on the west
+	response, err := reprojected.GetMapPic(843, 388, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		px, py int
+		x      int
+	}{
+		{1, 128, 842},
+		{7, 128, 843},
+		{255, 128, 843},
+	} {
+		r, g, b, _ := tile.At(tc.px, tc.py).RGBA()
+		expected := colorOf(tc.x, 388, 10)
+		if uint8(r>>8) != expected.R || uint8(g>>8) != expected.G || uint8(b>>8) != expected.B {
+			t.Errorf("pixel (%d, %d): expected the color of tile %d, got %v %v %v", tc.px, tc.py, tc.x, r>>8, g>>8, b>>8)
+		}
+	}
+
+	// BD09 is about 9.3 pixels east of WGS84
+	bd09, err := NewReprojectedProvider(base, CoordinateTypeBD09, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = bd09.GetMapPic(843, 388, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile, err = png.Decode(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := tile.At(7, 128).RGBA(); uint8(r>>8) != colorOf(842, 388, 10).R {
+		t.Errorf("BD09 pixel (7, 128) is not from tile 842")
+	}
+
+	// outside mainland China the tile is the WGS84 tile, transcoded from WebP
+	response, err = reprojected.GetMapPic(511, 340, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile, err = png.Decode(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := tile.At(0, 0).RGBA(); uint8(r>>8) != colorOf(511, 340, 10).R {
+		t.Errorf("tile outside China is shifted")
+	}
+	// failed source tiles fail the tile
+	if _, err := reprojected.GetMapPic(3, 3, 3); err == nil {
+		t.Error("expected source tile error")
+	}
+}
+
+func TestRegistryReproject(t *testing.T) {
+	registry, err := NewRegistry(nil, LayerOptions{Reproject: []ReprojectDefinition{
+		{ID: "arcgis_satelite", CoordinateType: CoordinateTypeGCJ02},
+		{ID: "arcgis_satelite", CoordinateType: CoordinateTypeBD09},
+		{ID: "open_street_map_standard", CoordinateType: CoordinateTypeBD09},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, kv := range registry.MapSourceSlice {
+		if strings.HasPrefix(kv.Key, "arcgis_satelite") {
+			ids = append(ids, kv.Key)
+		}
+		if kv.Value != registry.MapSourceIndex[kv.Key] {
+			t.Errorf("%s is not indexed", kv.Key)
+		}
+	}
+	if strings.Join(ids, ",") != "arcgis_satelite,arcgis_satelite@2x,arcgis_satelite@gcj02,arcgis_satelite@gcj02@2x,arcgis_satelite@bd09,arcgis_satelite@bd09@2x" {
+		t.Errorf("reprojected layers are not registered next to the provider: %v", ids)
+	}
+	if _, ok := registry.GetProvider("open_street_map_standard@bd09"); !ok {
+		t.Errorf("open_street_map_standard@bd09 is not registered")
+	}
+	if provider, ok := registry.GetProvider("arcgis_satelite@gcj02@2x"); !ok || provider.GetMapMetadata().CoordinateType != CoordinateTypeGCJ02 {
+		t.Errorf("arcgis_satelite@gcj02 has no @2x layer")
+	}
+
+	for _, tc := range []struct {
+		def  ReprojectDefinition
+		want string
+	}{
+		{ReprojectDefinition{ID: "missing", CoordinateType: CoordinateTypeGCJ02}, "map missing not found"},
+		{ReprojectDefinition{ID: "arcgis_satelite", CoordinateType: CoordinateTypeWGS84}, "expected GCJ02 or BD09"},
+		{ReprojectDefinition{ID: "tencent_map_road", CoordinateType: CoordinateTypeBD09}, "already a GCJ02 map"},
+		{ReprojectDefinition{ID: "open_railway_map", CoordinateType: CoordinateTypeGCJ02}, "normalize it to 256px first"},
+	} {
+		if _, err := NewRegistry(nil, LayerOptions{Reproject: []ReprojectDefinition{tc.def}}); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%+v: expected error containing %q, got: %v", tc.def, tc.want, err)
+		}
+	}
+	duplicate := ReprojectDefinition{ID: "arcgis_satelite", CoordinateType: CoordinateTypeGCJ02}
+	if _, err := NewRegistry(nil, LayerOptions{Reproject: []ReprojectDefinition{duplicate, duplicate}}); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("expected duplicate error, got: %v", err)
+	}
+	// normalized first
+	if _, err := NewRegistry(nil, LayerOptions{
+		NormalizeTileSize: []string{"open_railway_map"},
+		Reproject:         []ReprojectDefinition{{ID: "open_railway_map", CoordinateType: CoordinateTypeGCJ02}},
+	}); err != nil {
+		t.Error(err)
+	}
+}
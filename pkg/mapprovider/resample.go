@@ -0,0 +1,158 @@
+// Resampling kernels of the pixel-level correction
+// 像素级纠偏的重采样核
+
+package mapprovider
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Resampling selects how the pixels of corrected tiles are read from the source tiles
+// Resampling 决定纠偏瓦片的像素如何从源瓦片中采样
+type Resampling string
+
+const (
+	// copy the source pixel, sharp but jagged roads and text, the default
+	ResamplingNearest Resampling = "nearest"
+	// interpolate the 2x2 nearest source pixels
+	ResamplingBilinear Resampling = "bilinear"
+	// Catmull-Rom interpolation of the 4x4 nearest source pixels, smooth and sharper than bilinear
+	ResamplingBicubic Resampling = "bicubic"
+)
+
+// validate checks the kernel, empty means nearest
+func (kernel Resampling) validate() error {
+	switch kernel {
+	case "", ResamplingNearest, ResamplingBilinear, ResamplingBicubic:
+		return nil
+	}
+	return fmt.Errorf("resampling %q is invalid, expected nearest, bilinear or bicubic", kernel)
+}
+
+// ResamplingDefinition sets the resampling kernel of a corrected provider, e.g. the built-in amap_road
+// ResamplingDefinition 设置纠偏地图源的重采样核，例如内置的 amap_road
+type ResamplingDefinition struct {
+	ID     string     `json:"id" yaml:"id" mapstructure:"id"`
+	Kernel Resampling `json:"kernel" yaml:"kernel" mapstructure:"kernel"`
+}
+
+// withResampling returns a copy of the corrected provider with the kernel, the built-in providers are shared
+func withResampling(provider TileMapProvider, kernel Resampling) (TileMapProvider, error) {
+	if err := kernel.validate(); err != nil {
+		return nil, err
+	}
+	corrected, ok := provider.(*GCJ02MapProvider)
+	if !ok {
+		return nil, fmt.Errorf("map %s is not a GCJ02/BD09 corrected map", provider.GetMapMetadata().ID)
+	}
+	resampled := *corrected
+	resampled.Resampling = kernel
+	return &resampled, nil
+}
+
+// tileSampler reads the pixels of a source tile grid by their position in the grid, y axis down,
+// so that kernels sample across tile boundaries. Each tile is requested once, a nil tile is transparent.
+// tileSampler 按源瓦片网格中的像素位置（y 轴向下）读取像素，使重采样核可以跨瓦片边界采样。
+// 每个瓦片只请求一次，nil 瓦片视为透明
+type tileSampler struct {
+	sourceTile func(tx, ty int) (image.Image, error)
+	// temporary cache for source tiles, including the failed ones
+	tiles map[[2]int]*image.RGBA
+}
+
+func newTileSampler(sourceTile func(tx, ty int) (image.Image, error)) *tileSampler {
+	return &tileSampler{sourceTile: sourceTile, tiles: make(map[[2]int]*image.RGBA)}
+}
+
+// pixel returns the premultiplied pixel at (gx, gy) of the grid
+func (sampler *tileSampler) pixel(gx, gy int) (color.RGBA, error) {
+	tileKey := [2]int{floorDiv256(gx), floorDiv256(gy)}
+	tile, ok := sampler.tiles[tileKey]
+	if !ok {
+		source, err := sampler.sourceTile(tileKey[0], tileKey[1])
+		if err != nil {
+			return color.RGBA{}, err
+		}
+		if source != nil {
+			var isRGBA bool
+			if tile, isRGBA = source.(*image.RGBA); !isRGBA {
+				// decoded once, e.g. JPEG YCbCr or paletted PNG
+				tile = image.NewRGBA(source.Bounds())
+				draw.Draw(tile, tile.Bounds(), source, source.Bounds().Min, draw.Src)
+			}
+		}
+		sampler.tiles[tileKey] = tile
+	}
+	if tile == nil {
+		return color.RGBA{}, nil
+	}
+	bounds := tile.Bounds()
+	return tile.RGBAAt(bounds.Min.X+gx-tileKey[0]*256, bounds.Min.Y+gy-tileKey[1]*256), nil
+}
+
+// sample returns the color at (u, v) of the grid, pixel (i, j) covers [i, i+1) x [j, j+1):
+// nearest returns the pixel containing (u, v), the other kernels interpolate the pixel centers around it
+// sample 返回网格中 (u, v) 处的颜色，像素 (i, j) 覆盖 [i, i+1) x [j, j+1)：
+// nearest 返回包含 (u, v) 的像素，其他重采样核对其周围的像素中心插值
+func (sampler *tileSampler) sample(kernel Resampling, u, v float64) (color.RGBA, error) {
+	if kernel != ResamplingBilinear && kernel != ResamplingBicubic {
+		return sampler.pixel(int(math.Floor(u)), int(math.Floor(v)))
+	}
+
+	// position relative to the pixel centers
+	u, v = u-0.5, v-0.5
+	x0, y0 := math.Floor(u), math.Floor(v)
+	xOffset, xWeights := kernelWeights(kernel, u-x0)
+	yOffset, yWeights := kernelWeights(kernel, v-y0)
+	var sum [4]float64
+	for j, wy := range yWeights {
+		for i, wx := range xWeights {
+			weight := wx * wy
+			// taps outside the kernel support, do not request their tiles
+			if weight == 0 {
+				continue
+			}
+			c, err := sampler.pixel(int(x0)+xOffset+i, int(y0)+yOffset+j)
+			if err != nil {
+				return color.RGBA{}, err
+			}
+			sum[0] += weight * float64(c.R)
+			sum[1] += weight * float64(c.G)
+			sum[2] += weight * float64(c.B)
+			sum[3] += weight * float64(c.A)
+		}
+	}
+
+	// bicubic overshoots at edges, keep the color premultiplied
+	a := clampChannel(sum[3], 255)
+	return color.RGBA{R: clampChannel(sum[0], a), G: clampChannel(sum[1], a), B: clampChannel(sum[2], a), A: a}, nil
+}
+
+// kernelWeights returns the offset of the first tap from floor(u) and the weights of the taps, t = u - floor(u)
+func kernelWeights(kernel Resampling, t float64) (int, []float64) {
+	if kernel == ResamplingBilinear {
+		return 0, []float64{1 - t, t}
+	}
+	// Catmull-Rom, a = -0.5
+	t2, t3 := t*t, t*t*t
+	return -1, []float64{
+		(-t3 + 2*t2 - t) / 2,
+		(3*t3 - 5*t2 + 2) / 2,
+		(-3*t3 + 4*t2 + t) / 2,
+		(t3 - t2) / 2,
+	}
+}
+
+// clampChannel rounds the channel to [0, limit]
+func clampChannel(value float64, limit uint8) uint8 {
+	return uint8(math.Max(0, math.Min(float64(limit), math.Round(value))))
+}
+
+// floorDiv256 returns the tile index of the pixel position, rounding towards negative infinity
+func floorDiv256(g int) int {
+	return g >> 8
+}
@@ -0,0 +1,209 @@
+package mapprovider
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden images in testdata")
+
+// gradientTile returns the source tile tx/ty of a grid whose red channel is gx-128 on [128, 384), clamped outside
+func gradientTile(tx, ty int) image.Image {
+	tile := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for sy := 0; sy < 256; sy++ {
+		for sx := 0; sx < 256; sx++ {
+			value := uint8(max(0, min(255, tx*256+sx-128)))
+			tile.SetRGBA(sx, sy, color.RGBA{R: value, G: 50, B: 100, A: 255})
+		}
+	}
+	return tile
+}
+
+func TestTileSampler(t *testing.T) {
+	var requested []string
+	sampler := newTileSampler(func(tx, ty int) (image.Image, error) {
+		requested = append(requested, strconv.Itoa(tx)+"/"+strconv.Itoa(ty))
+		return gradientTile(tx, ty), nil
+	})
+
+	// the gradient is linear across the boundary of tiles 0 and 1 at u = 256,
+	// so the interpolating kernels return it exactly, the pixel centers are at i + 0.5
+	for _, u := range []float64{200.5, 255.25, 255.75, 256, 256.3, 300.9} {
+		for _, kernel := range []Resampling{ResamplingBilinear, ResamplingBicubic} {
+			c, err := sampler.sample(kernel, u, 10.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := uint8(u - 0.5 - 128 + 0.5)
+			if c.R != expected || c.G != 50 || c.B != 100 || c.A != 255 {
+				t.Errorf("%s at %v: expected red %d, got %v", kernel, u, expected, c)
+			}
+		}
+		if c, _ := sampler.sample(ResamplingNearest, u, 10.5); c.R != uint8(int(u)-128) {
+			t.Errorf("nearest at %v: expected red %d, got %v", u, int(u)-128, c)
+		}
+	}
+	if strings.Join(requested, ",") != "0/0,1/0" {
+		t.Errorf("unexpected source tiles %v", requested)
+	}
+
+	// taps of zero weight do not request tiles, at a pixel center bicubic reads the pixel only
+	requested = nil
+	if _, err := sampler.sample(ResamplingBicubic, 1024.5, 1024.5); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(requested, ",") != "4/4" {
+		t.Errorf("unexpected source tiles %v", requested)
+	}
+
+	// nil tiles are transparent and blend into the edge, errors fail the sample
+	sampler = newTileSampler(func(tx, ty int) (image.Image, error) {
+		switch {
+		case tx < 0:
+			return nil, nil
+		case ty < 0:
+			return nil, errors.New("fetch failed")
+		}
+		return gradientTile(tx, ty), nil
+	})
+	if c, err := sampler.sample(ResamplingBilinear, 0, 10.5); err != nil || c.A != 128 {
+		t.Errorf("expected half transparent pixel at the edge, got %v, %v", c, err)
+	}
+	if _, err := sampler.sample(ResamplingBicubic, 10.5, 1); err == nil {
+		t.Error("expected source tile error")
+	}
+}
+
+// syntheticTile is a source tile of diagonal 2px lines on a background tinted by the tile position
+func syntheticTile(tx, ty int) *image.RGBA {
+	tile := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	background := color.RGBA{R: 220, G: uint8(120 + tx%2*80), B: uint8(120 + ty%2*80), A: 255}
+	for sy := 0; sy < 256; sy++ {
+		for sx := 0; sx < 256; sx++ {
+			if (tx*256+sx+ty*256+sy)%12 < 2 {
+				tile.SetRGBA(sx, sy, color.RGBA{R: 20, G: 20, B: 20, A: 255})
+			} else {
+				tile.SetRGBA(sx, sy, background)
+			}
+		}
+	}
+	return tile
+}
+
+func TestResamplingGolden(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		x, _ := strconv.Atoi(r.URL.Query().Get("x"))
+		y, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		w.Header().Set("Content-Type", "image/png")
+		_ = png.Encode(w, syntheticTile(x, y))
+	}))
+	defer server.Close()
+
+	for _, kernel := range []Resampling{ResamplingNearest, ResamplingBilinear, ResamplingBicubic} {
+		provider := &GCJ02MapProvider{
+			TileMapMetadata: &TileMapMetadata{Name: "Synthetic", ID: "synthetic", ContentType: MapContentTypePNG},
+			Name:            "Synthetic",
+			BaseURL:         server.URL + "/?x={x}&y={y}&z={z}",
+			CoordinateType:  "GCJ02",
+			Resampling:      kernel,
+		}
+		// 10/843/388 covers Beijing, the tile is warped from the source tiles 842 and 843
+		response, err := provider.GetMapPic(843, 388, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tile, err := png.Decode(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join("testdata", "gcj02_"+string(kernel)+".png")
+		if *updateGolden {
+			if err := os.MkdirAll("testdata", 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(golden, encodePNG(tile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		file, err := os.Open(golden)
+		if err != nil {
+			t.Fatalf("%v, run the test with -update to create it", err)
+		}
+		expected, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// one level of tolerance for the floating point differences between platforms
+		if x, y, ok := imagesEqual(tile, expected, 1); !ok {
+			t.Errorf("%s: pixel (%d, %d) is %v, expected %v", kernel, x, y, tile.At(x, y), expected.At(x, y))
+		}
+	}
+}
+
+// imagesEqual compares the images channel by channel, returning the first pixel differing by more than tolerance
+func imagesEqual(a, b image.Image, tolerance int) (int, int, bool) {
+	if a.Bounds() != b.Bounds() {
+		return 0, 0, false
+	}
+	for y := a.Bounds().Min.Y; y < a.Bounds().Max.Y; y++ {
+		for x := a.Bounds().Min.X; x < a.Bounds().Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			for _, diff := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8), int(a1>>8) - int(a2>>8)} {
+				if diff > tolerance || diff < -tolerance {
+					return x, y, false
+				}
+			}
+		}
+	}
+	return 0, 0, true
+}
+
+func TestRegistryResampling(t *testing.T) {
+	registry, err := NewRegistry([]ProviderDefinition{
+		{ID: "baidu_custom", Kind: ProviderKindBaidu, URL: "https://tiles.example.com/{z}/{x}/{y}.png", Resampling: ResamplingBilinear},
+	}, LayerOptions{Resampling: []ResamplingDefinition{{ID: "amap_road", Kernel: ResamplingBicubic}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider := registry.MapSourceIndex["amap_road"].(*GCJ02MapProvider); provider.Resampling != ResamplingBicubic || provider == AmapRoadMap {
+		t.Errorf("amap_road is not resampled with bicubic")
+	}
+	if AmapRoadMap.Resampling != "" {
+		t.Errorf("built-in amap_road is modified")
+	}
+	if provider := registry.MapSourceIndex["baidu_custom"].(*GCJ02MapProvider); provider.Resampling != ResamplingBilinear {
+		t.Errorf("baidu_custom is not resampled with bilinear")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		defs    []ProviderDefinition
+		options LayerOptions
+		want    string
+	}{
+		{"kernel", nil, LayerOptions{Resampling: []ResamplingDefinition{{ID: "amap_road", Kernel: "lanczos"}}}, `resampling "lanczos" is invalid`},
+		{"not corrected", nil, LayerOptions{Resampling: []ResamplingDefinition{{ID: "google_satellite", Kernel: ResamplingBilinear}}}, "not a GCJ02/BD09 corrected map"},
+		{"definition kind", []ProviderDefinition{
+			{ID: "custom", Kind: ProviderKindXYZ, URL: "https://tiles.example.com/{z}/{x}/{y}.png", Resampling: ResamplingBilinear},
+		}, LayerOptions{}, "resampling only applies to kinds"},
+		{"reproject", nil, LayerOptions{Reproject: []ReprojectDefinition{{ID: "arcgis_satelite", CoordinateType: CoordinateTypeGCJ02, Resampling: "cubic"}}}, `resampling "cubic" is invalid`},
+	} {
+		if _, err := NewRegistry(tc.defs, tc.options); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
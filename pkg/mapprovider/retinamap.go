@@ -0,0 +1,154 @@
+package mapprovider
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"sync"
+
+	// WebP tiles can be decoded, but not encoded with the standard library
+	_ "golang.org/x/image/webp"
+)
+
+// RetinaSuffix is the id suffix of the virtual @2x layer of a 256px provider, e.g. `arcgis_satelite@2x`
+// RetinaSuffix 是 256 像素地图源虚拟 @2x 图层的 id 后缀
+const RetinaSuffix = "@2x"
+
+// RetinaProvider is the virtual @2x layer of a 256px provider: the 512px tile z/x/y is composed from
+// the four 256px children at z+1, so it covers the area of the 256px tile z/x/y with twice the pixels
+// RetinaProvider 是 256 像素地图源的虚拟 @2x 图层：512 像素瓦片 z/x/y 由 z+1 级别的四个 256 像素子瓦片合成，
+// 覆盖范围与 256 像素瓦片 z/x/y 相同，像素为其两倍
+type RetinaProvider struct {
+	*TileMapMetadata
+	base TileMapProvider
+}
+
+// NewRetinaProvider returns the @2x layer of the 256px provider, the zoom range is one level below the base
+// NewRetinaProvider 返回 256 像素地图源的 @2x 图层，缩放范围比原图层低一级
+func NewRetinaProvider(base TileMapProvider) *RetinaProvider {
+	baseMetadata := base.GetMapMetadata().GetMetadataWithDefaults()
+	metadata := *baseMetadata
+	metadata.Name = baseMetadata.Name + " " + RetinaSuffix
+	metadata.ID = baseMetadata.ID + RetinaSuffix
+	metadata.MapSize = MapSize512
+	metadata.MinZoom = max(baseMetadata.MinZoom-1, 0)
+	metadata.MaxZoom = baseMetadata.MaxZoom - 1
+	metadata.ContentType = encodableContentType(baseMetadata.ContentType)
+	return &RetinaProvider{TileMapMetadata: &metadata, base: base}
+}
+
+// hasRetinaLayer reports whether the provider has a virtual @2x layer:
+// 256px raster tiles, with a max zoom above 1 since max zoom 0 means the default 18
+func hasRetinaLayer(provider TileMapProvider) bool {
+	metadata := provider.GetMapMetadata().GetMetadataWithDefaults()
+	return metadata.MapSize == MapSize256 && metadata.MapType != MapTypeVector && metadata.MaxZoom > 1
+}
+
+func (provider *RetinaProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+// GetMapPic fetches the four children concurrently and composes them, the tile fails if any child fails
+// GetMapPic 并发获取四个子瓦片并合成，任一子瓦片失败则整个瓦片失败
+func (provider *RetinaProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	return provider.GetMemberTile(x, y, z, readTileResponse)
+}
+
+// GetMemberTile fetches the four children of the base provider concurrently with fetch and composes them,
+// the tile fails if any child fails
+// GetMemberTile 使用 fetch 并发获取原地图源的四个子瓦片并合成，任一子瓦片失败则整个瓦片失败
+func (provider *RetinaProvider) GetMemberTile(x, y, z int, fetch MemberTileFetcher) (*http.Response, error) {
+	if z < provider.MinZoom || z > provider.MaxZoom {
+		return nil, fmt.Errorf("map: %s zoom level %d is out of range [%d, %d]", provider.Name, z, provider.MinZoom, provider.MaxZoom)
+	}
+	if x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return nil, fmt.Errorf("map: %s tile %d/%d/%d is out of the world", provider.Name, z, x, y)
+	}
+
+	tile := image.NewRGBA(image.Rect(0, 0, int(MapSize512), int(MapSize512)))
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range 4 {
+		dx, dy := i%2, i/2
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			childX, childY := 2*x+dx, 2*y+dy
+			response, err := fetch(provider.base, childX, childY, z+1)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer response.Body.Close()
+			child, _, err := image.Decode(response.Body)
+			if err != nil {
+				errs[i] = fmt.Errorf("decode tile %d/%d/%d error: %w", z+1, childX, childY, err)
+				return
+			}
+			// children are drawn to disjoint quadrants
+			offset := image.Pt(dx*int(MapSize256), dy*int(MapSize256))
+			draw.Draw(tile, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(int(MapSize256), int(MapSize256)))}, child, child.Bounds().Min, draw.Src)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encodeTileResponse(tile, provider.ContentType, DefaultJPEGQuality)
+}
+
+// fetchTileImage gets the tile from the provider and decodes it, a non-200 response is an error
+func fetchTileImage(provider TileMapProvider, x, y, z int) (image.Image, error) {
+	resp, err := provider.GetMapPic(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tile %d/%d/%d returns status %d", z, x, y, resp.StatusCode)
+	}
+	tile, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decode tile %d/%d/%d error: %w", z, x, y, err)
+	}
+	return tile, nil
+}
+
+// encodableContentType returns the content type of the tiles encoded by the proxy from tiles of `contentType`,
+// WebP tiles become PNG since the standard library has no WebP encoder
+// encodableContentType 返回由 `contentType` 瓦片生成的瓦片的内容类型，标准库无 WebP 编码器，WebP 瓦片转为 PNG
+func encodableContentType(contentType MapContentType) MapContentType {
+	if contentType == MapContentTypeWebP {
+		return MapContentTypePNG
+	}
+	return contentType
+}
+
+// encodeTileResponse encodes the composed tile as a 200 response, JPEG of `quality` if the content type is JPEG, otherwise PNG
+func encodeTileResponse(tile image.Image, contentType MapContentType, quality int) (*http.Response, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == MapContentTypeJPEG {
+		err = jpeg.Encode(&buf, tile, &jpeg.Options{Quality: quality})
+	} else {
+		contentType = MapContentTypePNG
+		err = png.Encode(&buf, tile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode tile error: %w", err)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": []string{string(contentType)}},
+		Body:          io.NopCloser(&buf),
+		ContentLength: int64(buf.Len()),
+	}, nil
+}
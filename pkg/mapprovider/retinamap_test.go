@@ -0,0 +1,119 @@
+package mapprovider
+
+import (
+	"fmt"
+	"image/color"
+	"image/png"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestRetinaProvider(t *testing.T) {
+	base := newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors", MinZoom: 2, MaxZoom: 6, ContentType: MapContentTypeWebP})
+	retina := NewRetinaProvider(base)
+
+	metadata := retina.GetMapMetadata()
+	if metadata.ID != "colors@2x" || metadata.MapSize != MapSize512 || metadata.MinZoom != 1 || metadata.MaxZoom != 5 || metadata.ContentType != MapContentTypePNG {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if base.metadata.MapSize != MapSize256 || base.metadata.ID != "colors" {
+		t.Errorf("base metadata is modified: %+v", base.metadata)
+	}
+
+	response, err := retina.GetMapPic(0, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := png.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile.Bounds().Dx() != 512 || tile.Bounds().Dy() != 512 {
+		t.Fatalf("unexpected tile size %v", tile.Bounds())
+	}
+	// children of 2/0/1 are 3/0/2, 3/1/2, 3/0/3 and 3/1/3
+	for _, tc := range []struct {
+		px, py  int
+		x, y, z int
+	}{
+		{10, 10, 0, 2, 3},
+		{300, 10, 1, 2, 3},
+		{10, 300, 0, 3, 3},
+		{511, 511, 1, 3, 3},
+	} {
+		if got, expected := color.RGBAModel.Convert(tile.At(tc.px, tc.py)), colorOf(tc.x, tc.y, tc.z); got != expected {
+			t.Errorf("pixel (%d, %d): expected %v of tile %d/%d/%d, got %v", tc.px, tc.py, expected, tc.z, tc.x, tc.y, got)
+		}
+	}
+
+	for _, xyz := range [][3]int{
+		{1, 0, 2}, // child 3/3/0 fails
+		{0, 0, 6}, // above the max zoom
+		{4, 0, 2}, // out of the world
+	} {
+		if _, err := retina.GetMapPic(xyz[0], xyz[1], xyz[2]); err == nil {
+			t.Errorf("tile %v: expected error", xyz)
+		}
+	}
+}
+
+func TestRetinaProviderMemberTile(t *testing.T) {
+	base := newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors", MaxZoom: 6})
+	retina := NewRetinaProvider(base)
+
+	var mu sync.Mutex
+	var fetched []string
+	response, err := retina.GetMemberTile(0, 1, 2, func(member TileMapProvider, x, y, z int) (*http.Response, error) {
+		mu.Lock()
+		fetched = append(fetched, fmt.Sprintf("%s/%d/%d/%d", member.GetMapMetadata().ID, z, x, y))
+		mu.Unlock()
+		return readTileResponse(member, x, y, z)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	slices.Sort(fetched)
+	if expected := []string{"colors/3/0/2", "colors/3/0/3", "colors/3/1/2", "colors/3/1/3"}; !slices.Equal(fetched, expected) {
+		t.Errorf("expected children %v, got %v", expected, fetched)
+	}
+}
+
+func TestRegistryRetinaLayer(t *testing.T) {
+	registry := &Registry{MapSourceIndex: map[string]TileMapProvider{}}
+	for _, metadata := range []*TileMapMetadata{
+		{Name: "Colors", ID: "colors", MaxZoom: 6},
+		{Name: "Large", ID: "large", MaxZoom: 6, MapSize: MapSize512},
+		{Name: "Shallow", ID: "shallow", MaxZoom: 1},
+	} {
+		registry.add(newColorTileProvider(metadata))
+	}
+	registry.addRetinaLayers()
+
+	var ids []string
+	for _, kv := range registry.MapSourceSlice {
+		ids = append(ids, kv.Key)
+	}
+	if expected := []string{"colors", "colors@2x", "large", "shallow"}; !slices.Equal(ids, expected) {
+		t.Errorf("expected providers %v, got %v", expected, ids)
+	}
+
+	for id, expected := range map[string]bool{
+		"colors":       true,
+		"colors@2x":    true,
+		"large@2x":     false,
+		"shallow@2x":   false,
+		"colors@2x@2x": false,
+		"missing@2x":   false,
+	} {
+		provider, ok := registry.GetProvider(id)
+		if ok != expected {
+			t.Errorf("%s: expected found %v", id, expected)
+		}
+		if ok && provider.GetMapMetadata().ID != id {
+			t.Errorf("%s: unexpected provider %s", id, provider.GetMapMetadata().ID)
+		}
+	}
+}
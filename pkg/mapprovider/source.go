@@ -1,6 +1,14 @@
 package mapprovider
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 // map source
 
@@ -34,6 +42,10 @@ var MapSourceProviders = []TileMapProvider{
 	TianDiTuSatellite,
 	TianDiTuRoad,
 
+	// Baidu 百度地图 (BD09, Baidu tile grid, coordinate calibration)
+	BaiduRoadMap,
+	BaiduSatelliteMap,
+
 	// Tencent 腾讯地图 (GCJ02)
 	TencentMapRoad,
 	TencentMapSatellite,
@@ -53,20 +65,343 @@ type MapSourceMappingKV struct {
 	Value TileMapProvider
 }
 
-var (
-	MapSourceSlice []MapSourceMappingKV               // 保序 prevent order of map source
-	MapSourceIndex = make(map[string]TileMapProvider) // 快速查找 by ID for quick lookup by ID
-)
+// Registry is an immutable snapshot of the registered map sources.
+// A new registry is built and swapped atomically on config reload,
+// so in-flight requests keep using a consistent snapshot.
+// Users reading tiles take a reference with AcquireRegistry, a replaced registry is closed after the last one is released.
+// Registry 是已注册地图源的不可变快照，配置重载时构建新快照并原子替换，进行中的请求仍使用一致的旧快照。
+// 读取瓦片的调用方通过 AcquireRegistry 持有引用，被替换的注册表在最后一个引用释放后关闭
+type Registry struct {
+	MapSourceSlice []MapSourceMappingKV       // 保序 prevent order of map source
+	MapSourceIndex map[string]TileMapProvider // 快速查找 by ID for quick lookup by ID
+
+	refMu   sync.Mutex
+	refs    int
+	retired bool // replaced, closed when refs drops to 0
+	closed  bool
+}
+
+var currentRegistry atomic.Pointer[Registry]
 
 func init() {
-	for _, provider := range MapSourceProviders {
-		mapMetadata := provider.GetMapMetadata()
-		MapSourceSlice = append(MapSourceSlice, MapSourceMappingKV{
-			Key:   mapMetadata.ID,
-			Value: provider,
-		})
-		MapSourceIndex[mapMetadata.ID] = provider
+	registry, err := NewRegistry(nil, LayerOptions{})
+	if err != nil {
+		panic(err)
+	}
+	currentRegistry.Store(registry)
 
+	for _, kv := range registry.MapSourceSlice {
+		mapMetadata := kv.Value.GetMapMetadata()
 		log.Printf("Map ID: %s, Name: %s is registered\n", mapMetadata.ID, mapMetadata.Name)
 	}
 }
+
+// LayerOptions adjusts the registered providers in place, keeping their ids
+// LayerOptions 原地调整已注册的地图源，保持其 id 不变
+type LayerOptions struct {
+	// kernels of corrected providers, applied before the other options
+	Resampling []ResamplingDefinition
+	// 512px providers replaced by their 256px view
+	NormalizeTileSize []string
+	// providers served beyond their max zoom, applied after the normalization
+	Overzoom []OverzoomDefinition
+	// WGS84 providers also served in the GCJ02 or BD09 tile grid as `<id>@gcj02` or `<id>@bd09`,
+	// registered next to the provider once the other options are applied
+	Reproject []ReprojectDefinition
+}
+
+// NewRegistry builds a registry with the built-in providers followed by the providers declared in `defs`,
+// then applies the layer options, resolves the members of the composite maps and fallback chains and registers the @2x layers
+// NewRegistry 构建注册表：内置地图源在前，配置声明的地图源在后，然后应用图层选项，解析组合图层和回退链的成员并注册 @2x 图层
+func NewRegistry(defs []ProviderDefinition, options LayerOptions) (*Registry, error) {
+	if err := ValidateProviderDefinitions(defs, BuiltinProviderIDs()); err != nil {
+		return nil, err
+	}
+
+	registry := &Registry{
+		MapSourceSlice: make([]MapSourceMappingKV, 0, len(MapSourceProviders)+len(defs)),
+		MapSourceIndex: make(map[string]TileMapProvider, len(MapSourceProviders)+len(defs)),
+	}
+
+	for _, provider := range MapSourceProviders {
+		registry.add(provider)
+	}
+
+	for i := range defs {
+		provider, err := NewProviderFromDefinition(&defs[i])
+		if err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("providers[%d] (%s): %w", i, defs[i].ID, err)
+		}
+		registry.add(provider)
+	}
+
+	for i, def := range options.Resampling {
+		if err := registry.replace(def.ID, func(provider TileMapProvider) (TileMapProvider, error) {
+			return withResampling(provider, def.Kernel)
+		}); err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("resampling[%d] (%s): %w", i, def.ID, err)
+		}
+	}
+	for _, id := range options.NormalizeTileSize {
+		if err := registry.replace(id, func(provider TileMapProvider) (TileMapProvider, error) {
+			if members, ok := provider.(memberProvider); ok {
+				return nil, fmt.Errorf("map %s is a %s map, set the option on its members", id, members.kind())
+			}
+			if _, ok := provider.(*NormalizedProvider); ok {
+				return provider, nil
+			}
+			return NewNormalizedProvider(provider)
+		}); err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("normalize_tile_size: %w", err)
+		}
+	}
+	for i, def := range options.Overzoom {
+		if err := registry.replace(def.ID, func(provider TileMapProvider) (TileMapProvider, error) {
+			if members, ok := provider.(memberProvider); ok {
+				return nil, fmt.Errorf("map %s is a %s map, set the option on its members", def.ID, members.kind())
+			}
+			return NewOverzoomProvider(provider, def.MaxZoom)
+		}); err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("overzoom[%d] (%s): %w", i, def.ID, err)
+		}
+	}
+
+	// members are resolved with the layer options applied, fallback chains before the composites containing them
+	// 成员在应用图层选项后解析，回退链先于包含它们的组合图层解析
+	for _, kind := range []ProviderKind{ProviderKindFallback, ProviderKindComposite} {
+		for i := range defs {
+			provider, ok := registry.MapSourceIndex[defs[i].ID].(memberProvider)
+			if !ok || provider.kind() != kind {
+				continue
+			}
+			if err := provider.resolve(registry.MapSourceIndex); err != nil {
+				registry.Close()
+				return nil, fmt.Errorf("providers[%d] (%s): %w", i, defs[i].ID, err)
+			}
+		}
+	}
+
+	for i, def := range options.Reproject {
+		if err := registry.addReprojected(def); err != nil {
+			registry.Close()
+			return nil, fmt.Errorf("reproject[%d] (%s): %w", i, def.ID, err)
+		}
+	}
+
+	registry.addRetinaLayers()
+
+	return registry, nil
+}
+
+// addRetinaLayers registers the @2x layer of every 256px raster provider right after the provider,
+// declared ids can not contain '@', so the generated ids do not collide with them
+func (registry *Registry) addRetinaLayers() {
+	sources := registry.MapSourceSlice
+	registry.MapSourceSlice = make([]MapSourceMappingKV, 0, 2*len(sources))
+	for _, kv := range sources {
+		registry.MapSourceSlice = append(registry.MapSourceSlice, kv)
+		if !hasRetinaLayer(kv.Value) {
+			continue
+		}
+		provider := NewRetinaProvider(kv.Value)
+		id := provider.GetMapMetadata().ID
+		registry.MapSourceSlice = append(registry.MapSourceSlice, MapSourceMappingKV{Key: id, Value: provider})
+		registry.MapSourceIndex[id] = provider
+	}
+}
+
+// addReprojected registers the reprojected layer of the provider right after the provider and its other layers
+func (registry *Registry) addReprojected(def ReprojectDefinition) error {
+	base, ok := registry.MapSourceIndex[def.ID]
+	if !ok {
+		return fmt.Errorf("map %s not found", def.ID)
+	}
+	provider, err := NewReprojectedProvider(base, def.CoordinateType, def.Resampling)
+	if err != nil {
+		return err
+	}
+	id := provider.GetMapMetadata().ID
+	if _, ok := registry.MapSourceIndex[id]; ok {
+		return fmt.Errorf("map %s is already registered", id)
+	}
+
+	position := len(registry.MapSourceSlice)
+	for i, kv := range registry.MapSourceSlice {
+		if kv.Key == def.ID || strings.HasPrefix(kv.Key, def.ID+"@") {
+			position = i + 1
+		}
+	}
+	registry.MapSourceSlice = slices.Insert(registry.MapSourceSlice, position, MapSourceMappingKV{Key: id, Value: provider})
+	registry.MapSourceIndex[id] = provider
+	return nil
+}
+
+// memberProvider is implemented by the providers built from registered member providers (composite, fallback)
+type memberProvider interface {
+	TileMapProvider
+	kind() ProviderKind
+	// resolve looks up the members in the registered providers and completes the metadata
+	resolve(index map[string]TileMapProvider) error
+}
+
+// add provider to `MapSourceSlice` and `MapSourceIndex`
+func (registry *Registry) add(provider TileMapProvider) {
+	mapMetadata := provider.GetMapMetadata()
+	registry.MapSourceSlice = append(registry.MapSourceSlice, MapSourceMappingKV{
+		Key:   mapMetadata.ID,
+		Value: provider,
+	})
+	registry.MapSourceIndex[mapMetadata.ID] = provider
+}
+
+// replace wraps the provider in place, keeping the order
+func (registry *Registry) replace(id string, wrap func(TileMapProvider) (TileMapProvider, error)) error {
+	provider, ok := registry.MapSourceIndex[id]
+	if !ok {
+		return fmt.Errorf("map %s not found", id)
+	}
+	wrapped, err := wrap(provider)
+	if err != nil {
+		return err
+	}
+	registry.MapSourceIndex[id] = wrapped
+	for i := range registry.MapSourceSlice {
+		if registry.MapSourceSlice[i].Key == id {
+			registry.MapSourceSlice[i].Value = wrapped
+		}
+	}
+	return nil
+}
+
+// Close releases the providers holding resources, e.g. opened MBTiles files.
+// The registry must not be used afterwards.
+// Close 释放持有资源的地图源，例如已打开的 MBTiles 文件，之后不能再使用该注册表
+func (registry *Registry) Close() {
+	for _, kv := range registry.MapSourceSlice {
+		provider := kv.Value
+		for derived, ok := provider.(DerivedTileProvider); ok; derived, ok = provider.(DerivedTileProvider) {
+			provider = derived.Source()
+		}
+		if closer, ok := provider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Close map %s error: %v\n", kv.Key, err)
+			}
+		}
+	}
+}
+
+// GetProvider finds the provider by id, including the @2x layers
+// GetProvider 按 id 查找地图源，包括 @2x 图层
+func (registry *Registry) GetProvider(id string) (TileMapProvider, bool) {
+	provider, ok := registry.MapSourceIndex[id]
+	return provider, ok
+}
+
+// GetRegistry returns the current registry snapshot
+// GetRegistry 返回当前注册表快照
+func GetRegistry() *Registry {
+	return currentRegistry.Load()
+}
+
+// SetRegistry atomically replaces the current registry and returns the old one, which is left open
+// SetRegistry 原子替换当前注册表并返回旧注册表，旧注册表不会被关闭
+func SetRegistry(registry *Registry) *Registry {
+	return currentRegistry.Swap(registry)
+}
+
+// ReplaceRegistry atomically replaces the current registry and closes the old one once its last user is done
+// ReplaceRegistry 原子替换当前注册表，旧注册表在最后一个使用者结束后关闭
+func ReplaceRegistry(registry *Registry) {
+	if old := currentRegistry.Swap(registry); old != nil && old != registry {
+		old.retire()
+	}
+}
+
+// AcquireRegistry returns the current registry and keeps its providers open until release is called,
+// for users reading tiles after the registry may be replaced, e.g. requests and seeding
+// AcquireRegistry 返回当前注册表，并在调用 release 前保持其地图源打开，供注册表可能被替换后仍读取瓦片的调用方使用
+func AcquireRegistry() (registry *Registry, release func()) {
+	for {
+		registry := currentRegistry.Load()
+		if registry.acquire() {
+			return registry, sync.OnceFunc(registry.release)
+		}
+		// closed after it was loaded, the current registry has been replaced
+		// 加载后被关闭，说明当前注册表已被替换
+	}
+}
+
+func (registry *Registry) acquire() bool {
+	registry.refMu.Lock()
+	defer registry.refMu.Unlock()
+	if registry.closed {
+		return false
+	}
+	registry.refs++
+	return true
+}
+
+func (registry *Registry) release() {
+	registry.refMu.Lock()
+	registry.refs--
+	closing := registry.retired && registry.refs == 0 && !registry.closed
+	registry.closed = registry.closed || closing
+	registry.refMu.Unlock()
+	if closing {
+		registry.Close()
+	}
+}
+
+// retire marks the replaced registry to be closed, now if it has no users
+func (registry *Registry) retire() {
+	registry.refMu.Lock()
+	registry.retired = true
+	closing := registry.refs == 0 && !registry.closed
+	registry.closed = registry.closed || closing
+	registry.refMu.Unlock()
+	if closing {
+		registry.Close()
+	}
+}
+
+// BuiltinProviderIDs returns the ids of the providers in `MapSourceProviders`
+func BuiltinProviderIDs() []string {
+	ids := make([]string, 0, len(MapSourceProviders))
+	for _, provider := range MapSourceProviders {
+		ids = append(ids, provider.GetMapMetadata().ID)
+	}
+	return ids
+}
+
+// RegisterProviderDefinitions builds providers declared in config and registers them after the built-in providers,
+// then applies the layer options
+// RegisterProviderDefinitions 构建配置中声明的地图源，并注册在内置地图源之后，然后应用图层选项
+func RegisterProviderDefinitions(defs []ProviderDefinition, options LayerOptions) error {
+	registry, err := NewRegistry(defs, options)
+	if err != nil {
+		return err
+	}
+
+	for _, def := range defs {
+		log.Printf("Map ID: %s, Name: %s is registered\n", def.ID, registry.MapSourceIndex[def.ID].GetMapMetadata().Name)
+	}
+	for _, def := range options.Resampling {
+		log.Printf("Map ID: %s is resampled with %s\n", def.ID, def.Kernel)
+	}
+	for _, id := range options.NormalizeTileSize {
+		log.Printf("Map ID: %s is served as 256px tiles\n", id)
+	}
+	for _, def := range options.Overzoom {
+		log.Printf("Map ID: %s is overzoomed to zoom level %d\n", def.ID, def.MaxZoom)
+	}
+	for _, def := range options.Reproject {
+		log.Printf("Map ID: %s%s is registered\n", def.ID, reprojectedSuffix(def.CoordinateType))
+	}
+
+	ReplaceRegistry(registry)
+	return nil
+}
@@ -0,0 +1,101 @@
+// Web Mercator tile math shared by seeding, exports and tile services
+// Web 墨卡托瓦片计算，供预热、导出和瓦片服务共用
+
+package mapprovider
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// latitude limit of the Web Mercator projection
+// Web 墨卡托投影的纬度范围
+const MaxMercatorLat = 85.05112877980659
+
+// LonLatToTileXY returns the tile containing the WGS84 coordinate at zoom z,
+// coordinates outside the Web Mercator range are clamped
+// LonLatToTileXY 返回 z 级别下包含该 WGS84 坐标的瓦片，超出 Web 墨卡托范围的坐标会被截断
+func LonLatToTileXY(lon, lat float64, z int) (x, y int) {
+	lon = math.Max(-180, math.Min(180, lon))
+	lat = math.Max(-MaxMercatorLat, math.Min(MaxMercatorLat, lat))
+
+	px, py := lonLatToPixelXY(lon, lat, z)
+	maxIndex := (1 << z) - 1
+	x = min(max(px/256, 0), maxIndex)
+	y = min(max(py/256, 0), maxIndex)
+	return x, y
+}
+
+// TileXYToLonLat returns the WGS84 coordinate of the top-left corner of the tile
+func TileXYToLonLat(x, y, z int) (lon, lat float64) {
+	return pixelXYToLonLat(x*256, y*256, z)
+}
+
+// TileBounds returns the WGS84 bounds of the tile
+// TileBounds 返回瓦片的 WGS84 范围
+func TileBounds(x, y, z int) (minLon, minLat, maxLon, maxLat float64) {
+	minLon, maxLat = TileXYToLonLat(x, y, z)
+	maxLon, minLat = TileXYToLonLat(x+1, y+1, z)
+	return minLon, minLat, maxLon, maxLat
+}
+
+// BBox is a WGS84 bounding box `[minLon, minLat, maxLon, maxLat]`
+type BBox [4]float64
+
+func (bbox BBox) Validate() error {
+	minLon, minLat, maxLon, maxLat := bbox[0], bbox[1], bbox[2], bbox[3]
+	if minLon < -180 || maxLon > 180 || minLat < -90 || maxLat > 90 {
+		return fmt.Errorf("bbox %v is out of WGS84 range", bbox)
+	}
+	if minLon >= maxLon || minLat >= maxLat {
+		return fmt.Errorf("bbox %v must be [minLon, minLat, maxLon, maxLat]", bbox)
+	}
+	return nil
+}
+
+// ParseBBox parses `minLon,minLat,maxLon,maxLat`
+func ParseBBox(str string) (BBox, error) {
+	var bbox BBox
+	parts := strings.Split(str, ",")
+	if len(parts) != 4 {
+		return bbox, fmt.Errorf("bbox %q must be minLon,minLat,maxLon,maxLat", str)
+	}
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox, fmt.Errorf("bbox %q: %w", str, err)
+		}
+		bbox[i] = value
+	}
+	return bbox, bbox.Validate()
+}
+
+func (bbox BBox) Intersects(other BBox) bool {
+	return bbox[0] <= other[2] && other[0] <= bbox[2] && bbox[1] <= other[3] && other[1] <= bbox[3]
+}
+
+// Contains reports whether the `[lon, lat]` point is inside the box
+func (bbox BBox) Contains(point [2]float64) bool {
+	return point[0] >= bbox[0] && point[0] <= bbox[2] && point[1] >= bbox[1] && point[1] <= bbox[3]
+}
+
+// Union returns the smallest box containing both boxes
+func (bbox BBox) Union(other BBox) BBox {
+	return BBox{math.Min(bbox[0], other[0]), math.Min(bbox[1], other[1]), math.Max(bbox[2], other[2]), math.Max(bbox[3], other[3])}
+}
+
+// TileRange returns the XYZ tiles covering the box at zoom z, inclusive
+// TileRange 返回 z 级别下覆盖该范围的 XYZ 瓦片，包含边界
+func (bbox BBox) TileRange(z int) (minX, minY, maxX, maxY int) {
+	minX, minY = LonLatToTileXY(bbox[0], bbox[3], z)
+	maxX, maxY = LonLatToTileXY(bbox[2], bbox[1], z)
+	return minX, minY, maxX, maxY
+}
+
+// TileBBox returns the WGS84 bounds of the tile as a BBox
+func TileBBox(x, y, z int) BBox {
+	minLon, minLat, maxLon, maxLat := TileBounds(x, y, z)
+	return BBox{minLon, minLat, maxLon, maxLat}
+}
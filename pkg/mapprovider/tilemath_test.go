@@ -0,0 +1,27 @@
+package mapprovider
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTileMath(t *testing.T) {
+	// Guangzhou at z10
+	x, y := LonLatToTileXY(113.2644, 23.1291, 10)
+	if x != 834 || y != 444 {
+		t.Errorf("expected tile 834/444, got %d/%d", x, y)
+	}
+
+	minLon, minLat, maxLon, maxLat := TileBounds(x, y, 10)
+	if 113.2644 < minLon || 113.2644 > maxLon || 23.1291 < minLat || 23.1291 > maxLat {
+		t.Errorf("tile bounds %f,%f,%f,%f do not contain the coordinate", minLon, minLat, maxLon, maxLat)
+	}
+
+	// clamped at the world edges
+	if x, y := LonLatToTileXY(180, -90, 3); x != 7 || y != 7 {
+		t.Errorf("expected clamped tile 7/7, got %d/%d", x, y)
+	}
+	if lon, lat := TileXYToLonLat(0, 0, 0); lon != -180 || math.Abs(lat-MaxMercatorLat) > 1e-9 {
+		t.Errorf("unexpected top-left of z0: %f,%f", lon, lat)
+	}
+}
@@ -0,0 +1,124 @@
+package mapprovider
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/http"
+	"strings"
+)
+
+// DefaultJPEGQuality is the quality of JPEG tiles encoded by the proxy
+const DefaultJPEGQuality = 90
+
+// TranscodedProvider serves the tiles of a provider in another image format: the native tile is decoded
+// and encoded as PNG or JPEG, transparent pixels of JPEG tiles are flattened onto white.
+// WebP can be decoded but not encoded.
+// TranscodedProvider 以另一种图片格式提供地图源瓦片：原生瓦片解码后编码为 PNG 或 JPEG，
+// JPEG 瓦片的透明像素铺在白色背景上。WebP 只能解码不能编码
+type TranscodedProvider struct {
+	*TileMapMetadata
+	base TileMapProvider
+	// JPEG quality 1-100, 0 means DefaultJPEGQuality
+	quality int
+}
+
+// NewTranscodedProvider returns the provider serving PNG or JPEG tiles, `quality` only applies to JPEG
+// NewTranscodedProvider 返回提供 PNG 或 JPEG 瓦片的地图源，`quality` 仅对 JPEG 有效
+func NewTranscodedProvider(base TileMapProvider, contentType MapContentType, quality int) (*TranscodedProvider, error) {
+	if contentType != MapContentTypePNG && contentType != MapContentTypeJPEG {
+		return nil, fmt.Errorf("can not encode %s tiles, expected image/png or image/jpeg", contentType)
+	}
+	if quality < 0 || quality > 100 {
+		return nil, fmt.Errorf("quality %d is out of range [1, 100]", quality)
+	}
+	if quality == 0 || contentType != MapContentTypeJPEG {
+		quality = DefaultJPEGQuality
+	}
+
+	metadata := *base.GetMapMetadata().GetMetadataWithDefaults()
+	metadata.ContentType = contentType
+	return &TranscodedProvider{TileMapMetadata: &metadata, base: base, quality: quality}, nil
+}
+
+func (provider *TranscodedProvider) GetMapMetadata() *TileMapMetadata {
+	return provider.TileMapMetadata
+}
+
+// Source returns the provider with the native tiles
+func (provider *TranscodedProvider) Source() TileMapProvider {
+	return provider.base
+}
+
+// CacheID keeps the variants next to the native tiles, they differ in the extension
+func (provider *TranscodedProvider) CacheID() string {
+	return CacheID(provider.base)
+}
+
+// CacheExtension is the extension of the content type, JPEG tiles of another quality than
+// DefaultJPEGQuality have the quality in the extension, e.g. `q60.jpeg`
+// CacheExtension 为内容类型的扩展名，质量不是 DefaultJPEGQuality 的 JPEG 瓦片扩展名中带有质量，例如 `q60.jpeg`
+func (provider *TranscodedProvider) CacheExtension() string {
+	extension := ContentTypeExtension(provider.ContentType)
+	if provider.ContentType == MapContentTypeJPEG && provider.quality != DefaultJPEGQuality {
+		return fmt.Sprintf("q%d.%s", provider.quality, extension)
+	}
+	return extension
+}
+
+// SourceTile is the native tile at the same coordinates
+func (provider *TranscodedProvider) SourceTile(x, y, z int) (sourceX, sourceY, sourceZ int, derived bool) {
+	return x, y, z, true
+}
+
+// GetMapPic fetches the native tile and transcodes it
+// GetMapPic 获取原生瓦片并转码
+func (provider *TranscodedProvider) GetMapPic(x, y, z int) (*http.Response, error) {
+	source, err := fetchTileImage(provider.base, x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return provider.encode(source)
+}
+
+// TileFromSource transcodes the encoded native tile, e.g. a cached one
+// TileFromSource 转码已编码的原生瓦片（例如缓存中的瓦片）
+func (provider *TranscodedProvider) TileFromSource(sourceBytes []byte, x, y, z int) (*http.Response, error) {
+	source, _, err := image.Decode(bytes.NewReader(sourceBytes))
+	if err != nil {
+		return nil, fmt.Errorf("decode native tile %d/%d/%d error: %w", z, x, y, err)
+	}
+	return provider.encode(source)
+}
+
+func (provider *TranscodedProvider) encode(source image.Image) (*http.Response, error) {
+	if provider.ContentType != MapContentTypeJPEG {
+		return encodeTileResponse(source, provider.ContentType, provider.quality)
+	}
+	flat := image.NewRGBA(source.Bounds())
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), source, source.Bounds().Min, draw.Over)
+	return encodeTileResponse(flat, provider.ContentType, provider.quality)
+}
+
+// cacheExtensioner is implemented by providers whose cache extension is not the one of the content type
+type cacheExtensioner interface {
+	CacheExtension() string
+}
+
+// CacheExtension returns the extension of the provider tiles in the cache, e.g. `png`
+// CacheExtension 返回地图源瓦片在缓存中的扩展名，例如 `png`
+func CacheExtension(provider TileMapProvider) string {
+	if extensioner, ok := provider.(cacheExtensioner); ok {
+		return extensioner.CacheExtension()
+	}
+	return ContentTypeExtension(provider.GetMapMetadata().GetMetadataWithDefaults().ContentType)
+}
+
+// ContentTypeExtension returns the extension of the content type, `image/png` -> `png`
+func ContentTypeExtension(contentType MapContentType) string {
+	_, extension, _ := strings.Cut(string(contentType), "/")
+	return extension
+}
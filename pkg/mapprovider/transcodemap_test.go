@@ -0,0 +1,90 @@
+package mapprovider
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestTranscodedProvider(t *testing.T) {
+	base := newQuadrantTileProvider(&TileMapMetadata{Name: "Large", ID: "large", MapSize: MapSize512})
+
+	transcoded, err := NewTranscodedProvider(base, MapContentTypeJPEG, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata := transcoded.GetMapMetadata(); metadata.ID != "large" || metadata.ContentType != MapContentTypeJPEG {
+		t.Errorf("unexpected metadata: %+v", metadata)
+	}
+	if base.metadata.ContentType != MapContentTypePNG {
+		t.Errorf("base metadata is modified: %+v", base.metadata)
+	}
+	if CacheID(transcoded) != "large" || CacheExtension(transcoded) != "jpeg" || CacheExtension(base) != "png" {
+		t.Errorf("unexpected cache key parts %s, %s", CacheID(transcoded), CacheExtension(transcoded))
+	}
+
+	response, err := transcoded.GetMapPic(1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "image/jpeg" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	tile, err := jpeg.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile.Bounds().Dx() != 512 {
+		t.Errorf("unexpected tile size %v", tile.Bounds())
+	}
+	// lossy, the color is close to the top-left quadrant
+	r, g, b, _ := tile.At(100, 100).RGBA()
+	expected := colorOf(0, 2, 3)
+	for _, diff := range []int{int(r>>8) - int(expected.R), int(g>>8) - int(expected.G), int(b>>8) - int(expected.B)} {
+		if diff < -8 || diff > 8 {
+			t.Errorf("pixel %v %v %v is far from %v", r>>8, g>>8, b>>8, expected)
+		}
+	}
+
+	for _, tc := range []struct {
+		contentType MapContentType
+		quality     int
+	}{
+		{MapContentTypeWebP, 0},
+		{MapContentTypeJPEG, 101},
+	} {
+		if _, err := NewTranscodedProvider(base, tc.contentType, tc.quality); err == nil {
+			t.Errorf("%s quality %d: expected error", tc.contentType, tc.quality)
+		}
+	}
+	if low, _ := NewTranscodedProvider(base, MapContentTypeJPEG, 60); CacheExtension(low) != "q60.jpeg" {
+		t.Errorf("unexpected extension %s", CacheExtension(low))
+	}
+}
+
+func TestTranscodedProviderFlattensAlpha(t *testing.T) {
+	transcoded, err := NewTranscodedProvider(newColorTileProvider(&TileMapMetadata{Name: "Colors", ID: "colors"}), MapContentTypeJPEG, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a transparent PNG becomes white
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	sourceBytes := encodePNG(img)
+	response, err := transcoded.TileFromSource(sourceBytes, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile, err := jpeg.Decode(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := color.GrayModel.Convert(tile.At(8, 8)).(color.Gray); got.Y < 250 {
+		t.Errorf("transparent pixel is not flattened onto white: %v", got)
+	}
+
+	if _, err := transcoded.TileFromSource([]byte("not an image"), 0, 0, 0); err == nil {
+		t.Error("expected decode error")
+	}
+}
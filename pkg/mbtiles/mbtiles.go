@@ -0,0 +1,116 @@
+// MBTiles 1.3 file writer
+// ref: https://github.com/mapbox/mbtiles-spec/blob/master/1.3/spec.md
+
+package mbtiles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// `MPBX` application id recommended by the spec
+const applicationID = 0x4d504258
+
+var schema = []string{
+	`PRAGMA application_id = ` + fmt.Sprint(applicationID),
+	`CREATE TABLE metadata (name TEXT, value TEXT)`,
+	`CREATE UNIQUE INDEX metadata_name ON metadata (name)`,
+	`CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)`,
+	`CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)`,
+}
+
+// FlipY converts between XYZ y and TMS tile_row, the conversion is symmetric
+// FlipY 在 XYZ 的 y 和 TMS 的 tile_row 之间转换，转换是对称的
+func FlipY(y, z int) int {
+	return (1 << z) - 1 - y
+}
+
+// Format returns the MBTiles `format` metadata of a tile content type
+func Format(contentType string) (string, error) {
+	switch contentType {
+	case "image/png":
+		return "png", nil
+	case "image/jpeg":
+		return "jpg", nil
+	case "image/webp":
+		return "webp", nil
+	default:
+		return "", fmt.Errorf("content type %s is not supported by MBTiles", contentType)
+	}
+}
+
+// Writer writes tiles into a new MBTiles file in one transaction, the file is complete after Close
+// Writer 在一个事务中将瓦片写入新的 MBTiles 文件，Close 后文件才完整
+type Writer struct {
+	path string
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+// Create creates the MBTiles file, an existing file is replaced
+func Create(path string) (*Writer, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// pragmas apply to the connection, keep the transaction on the same one
+	db.SetMaxOpenConns(1)
+	// the file is new, no need to protect it against crashes
+	for _, statement := range append([]string{`PRAGMA journal_mode = OFF`, `PRAGMA synchronous = OFF`}, schema...) {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("create mbtiles schema error: %w", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+
+	return &Writer{path: path, db: db, tx: tx, stmt: stmt}, nil
+}
+
+// SetMetadata sets a row of the metadata table
+func (writer *Writer) SetMetadata(name, value string) error {
+	_, err := writer.tx.Exec(`INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)`, name, value)
+	return err
+}
+
+// WriteTile writes the tile of XYZ coordinates, y is flipped to the TMS tile_row
+// WriteTile 写入 XYZ 坐标的瓦片，y 会翻转为 TMS 的 tile_row
+func (writer *Writer) WriteTile(z, x, y int, data []byte) error {
+	_, err := writer.stmt.Exec(z, x, FlipY(y, z), data)
+	return err
+}
+
+// Close commits the tiles and closes the file
+func (writer *Writer) Close() error {
+	writer.stmt.Close()
+	err := writer.tx.Commit()
+	return errors.Join(err, writer.db.Close())
+}
+
+// Abort discards the tiles and removes the file
+func (writer *Writer) Abort() error {
+	writer.stmt.Close()
+	writer.tx.Rollback()
+	writer.db.Close()
+	return os.Remove(writer.path)
+}
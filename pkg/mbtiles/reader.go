@@ -0,0 +1,105 @@
+package mbtiles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
+)
+
+// ErrTileNotFound is returned by Reader.Tile when the file has no such tile
+var ErrTileNotFound = errors.New("mbtiles: tile not found")
+
+// ErrClosed is returned by Reader.Tile after the reader is closed
+var ErrClosed = errors.New("mbtiles: reader is closed")
+
+// Reader reads tiles from an MBTiles file opened read-only, it is safe for concurrent use
+// Reader 以只读方式读取 MBTiles 文件中的瓦片，可并发使用
+type Reader struct {
+	path     string
+	db       *sql.DB
+	metadata map[string]string
+	closed   atomic.Bool
+}
+
+// Open opens the MBTiles file and loads its metadata table
+func Open(path string) (*Reader, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	dsn := (&url.URL{Scheme: "file", OmitHost: true, Path: path, RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &Reader{path: path, db: db, metadata: make(map[string]string)}
+	if err := reader.loadMetadata(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("read mbtiles %s error: %w", path, err)
+	}
+	return reader, nil
+}
+
+func (reader *Reader) loadMetadata() error {
+	rows, err := reader.db.Query(`SELECT name, value FROM metadata`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		reader.metadata[name] = value
+	}
+	return rows.Err()
+}
+
+// Metadata returns the rows of the metadata table, it must not be modified
+func (reader *Reader) Metadata() map[string]string {
+	return reader.metadata
+}
+
+// ZoomRange returns the min and max zoom level of the tiles table
+func (reader *Reader) ZoomRange() (minZoom, maxZoom int, err error) {
+	var minValue, maxValue sql.NullInt64
+	err = reader.db.QueryRow(`SELECT min(zoom_level), max(zoom_level) FROM tiles`).Scan(&minValue, &maxValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !minValue.Valid || !maxValue.Valid {
+		return 0, 0, errors.New("mbtiles: tiles table is empty")
+	}
+	return int(minValue.Int64), int(maxValue.Int64), nil
+}
+
+// Tile returns the tile of XYZ coordinates, y is flipped to the TMS tile_row
+// Tile 返回 XYZ 坐标的瓦片，y 会翻转为 TMS 的 tile_row
+func (reader *Reader) Tile(z, x, y int) ([]byte, error) {
+	if reader.closed.Load() {
+		return nil, ErrClosed
+	}
+	var data []byte
+	err := reader.db.QueryRow(`SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?`, z, x, FlipY(y, z)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %d/%d/%d", ErrTileNotFound, z, x, y)
+	}
+	if errors.Is(err, sql.ErrConnDone) || reader.closed.Load() {
+		return nil, ErrClosed
+	}
+	return data, err
+}
+
+// Close closes the file, Tile returns ErrClosed afterwards
+func (reader *Reader) Close() error {
+	if reader.closed.Swap(true) {
+		return nil
+	}
+	return reader.db.Close()
+}
@@ -0,0 +1,329 @@
+// PMTiles v3 archive format: header, Hilbert tile ids and directories
+// ref: https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+
+package pmtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	HeaderLength = 127
+	// header and root directory must fit in the first 16 KiB
+	rootSectionLength = 16384
+)
+
+type Compression uint8
+
+const (
+	CompressionUnknown Compression = 0
+	CompressionNone    Compression = 1
+	CompressionGzip    Compression = 2
+	CompressionBrotli  Compression = 3
+	CompressionZstd    Compression = 4
+)
+
+type TileType uint8
+
+const (
+	TileTypeUnknown TileType = 0
+	TileTypeMVT     TileType = 1
+	TileTypePNG     TileType = 2
+	TileTypeJPEG    TileType = 3
+	TileTypeWebP    TileType = 4
+	TileTypeAVIF    TileType = 5
+)
+
+// TileTypeOf returns the tile type of a content type
+func TileTypeOf(contentType string) (TileType, error) {
+	switch contentType {
+	case "image/png":
+		return TileTypePNG, nil
+	case "image/jpeg":
+		return TileTypeJPEG, nil
+	case "image/webp":
+		return TileTypeWebP, nil
+	default:
+		return TileTypeUnknown, fmt.Errorf("content type %s is not supported by PMTiles", contentType)
+	}
+}
+
+// ContentType returns the content type of a raster tile type
+func (tileType TileType) ContentType() (string, error) {
+	switch tileType {
+	case TileTypePNG:
+		return "image/png", nil
+	case TileTypeJPEG:
+		return "image/jpeg", nil
+	case TileTypeWebP:
+		return "image/webp", nil
+	case TileTypeAVIF:
+		return "image/avif", nil
+	default:
+		return "", fmt.Errorf("pmtiles tile type %d is not a supported raster type", tileType)
+	}
+}
+
+// Header is the fixed 127 bytes header of an archive, offsets are from the start of the file
+// Header 是归档文件开头固定 127 字节的头部，偏移量从文件起始处计算
+type Header struct {
+	RootOffset     uint64
+	RootLength     uint64
+	MetadataOffset uint64
+	MetadataLength uint64
+	LeafOffset     uint64
+	LeafLength     uint64
+	TileDataOffset uint64
+	TileDataLength uint64
+
+	AddressedTiles uint64
+	TileEntries    uint64
+	TileContents   uint64
+
+	Clustered           bool
+	InternalCompression Compression
+	TileCompression     Compression
+	TileType            TileType
+
+	MinZoom uint8
+	MaxZoom uint8
+	// WGS84 bounds `[minLon, minLat, maxLon, maxLat]`
+	Bounds     [4]float64
+	CenterZoom uint8
+	CenterLon  float64
+	CenterLat  float64
+}
+
+func toE7(value float64) uint32 {
+	return uint32(int32(math.Round(value * 1e7)))
+}
+
+func fromE7(value uint32) float64 {
+	return float64(int32(value)) / 1e7
+}
+
+// MarshalBinary serializes the header, little endian
+func (header *Header) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, HeaderLength)
+	copy(buf[0:7], "PMTiles")
+	buf[7] = 3
+
+	le := binary.LittleEndian
+	for i, value := range []uint64{
+		header.RootOffset, header.RootLength, header.MetadataOffset, header.MetadataLength,
+		header.LeafOffset, header.LeafLength, header.TileDataOffset, header.TileDataLength,
+		header.AddressedTiles, header.TileEntries, header.TileContents,
+	} {
+		le.PutUint64(buf[8+i*8:], value)
+	}
+	if header.Clustered {
+		buf[96] = 1
+	}
+	buf[97] = byte(header.InternalCompression)
+	buf[98] = byte(header.TileCompression)
+	buf[99] = byte(header.TileType)
+	buf[100] = header.MinZoom
+	buf[101] = header.MaxZoom
+	for i, value := range header.Bounds {
+		le.PutUint32(buf[102+i*4:], toE7(value))
+	}
+	buf[118] = header.CenterZoom
+	le.PutUint32(buf[119:], toE7(header.CenterLon))
+	le.PutUint32(buf[123:], toE7(header.CenterLat))
+	return buf, nil
+}
+
+// UnmarshalBinary parses the header
+func (header *Header) UnmarshalBinary(buf []byte) error {
+	if len(buf) < HeaderLength || string(buf[0:7]) != "PMTiles" {
+		return errors.New("pmtiles: invalid header magic")
+	}
+	if buf[7] != 3 {
+		return fmt.Errorf("pmtiles: unsupported spec version %d", buf[7])
+	}
+
+	le := binary.LittleEndian
+	for i, field := range []*uint64{
+		&header.RootOffset, &header.RootLength, &header.MetadataOffset, &header.MetadataLength,
+		&header.LeafOffset, &header.LeafLength, &header.TileDataOffset, &header.TileDataLength,
+		&header.AddressedTiles, &header.TileEntries, &header.TileContents,
+	} {
+		*field = le.Uint64(buf[8+i*8:])
+	}
+	header.Clustered = buf[96] == 1
+	header.InternalCompression = Compression(buf[97])
+	header.TileCompression = Compression(buf[98])
+	header.TileType = TileType(buf[99])
+	header.MinZoom = buf[100]
+	header.MaxZoom = buf[101]
+	for i := range header.Bounds {
+		header.Bounds[i] = fromE7(le.Uint32(buf[102+i*4:]))
+	}
+	header.CenterZoom = buf[118]
+	header.CenterLon = fromE7(le.Uint32(buf[119:]))
+	header.CenterLat = fromE7(le.Uint32(buf[123:]))
+	return nil
+}
+
+// ZxyToID returns the tile id: tiles of lower zooms first, then the Hilbert curve index at zoom z
+// ZxyToID 返回瓦片 ID：先计入更低缩放级别的瓦片数，再加上 z 级别下的希尔伯特曲线序号
+func ZxyToID(z uint8, x, y uint32) uint64 {
+	// (4^z - 1) / 3 tiles in zoom 0..z-1
+	id := ((uint64(1) << (2 * uint64(z))) - 1) / 3
+
+	n := uint32(1) << z
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		id += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x&(s-1)
+				y = s - 1 - y&(s-1)
+			}
+			x, y = y, x
+		}
+	}
+	return id
+}
+
+// Entry is a directory entry. RunLength 0 points to a leaf directory, otherwise the entry
+// covers tiles TileID to TileID+RunLength-1 sharing the same data
+// Entry 是目录项：RunLength 为 0 时指向叶子目录，否则覆盖从 TileID 开始连续 RunLength 个相同内容的瓦片
+type Entry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// serializeEntries encodes a directory and compresses it with gzip
+// serializeEntries 编码目录并使用 gzip 压缩
+func serializeEntries(entries []Entry) ([]byte, error) {
+	var raw []byte
+	raw = binary.AppendUvarint(raw, uint64(len(entries)))
+
+	var lastID uint64
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, entry.TileID-lastID)
+		lastID = entry.TileID
+	}
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, uint64(entry.RunLength))
+	}
+	for _, entry := range entries {
+		raw = binary.AppendUvarint(raw, uint64(entry.Length))
+	}
+	for i, entry := range entries {
+		// 0 means the data directly follows the previous entry
+		if i > 0 && entry.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			raw = binary.AppendUvarint(raw, 0)
+		} else {
+			raw = binary.AppendUvarint(raw, entry.Offset+1)
+		}
+	}
+	return compress(raw)
+}
+
+// deserializeEntries decompresses and decodes a directory
+func deserializeEntries(data []byte, compression Compression) ([]Entry, error) {
+	raw, err := decompress(data, compression)
+	if err != nil {
+		return nil, err
+	}
+	reader := bytes.NewReader(raw)
+
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("pmtiles: invalid directory: %w", err)
+	}
+	// every entry takes at least 4 bytes
+	if count > uint64(len(raw)) {
+		return nil, errors.New("pmtiles: invalid directory entry count")
+	}
+
+	entries := make([]Entry, count)
+	var lastID uint64
+	readColumn := func(set func(i int, value uint64)) error {
+		for i := range entries {
+			value, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return fmt.Errorf("pmtiles: invalid directory: %w", err)
+			}
+			set(i, value)
+		}
+		return nil
+	}
+	err = errors.Join(
+		readColumn(func(i int, value uint64) { lastID += value; entries[i].TileID = lastID }),
+		readColumn(func(i int, value uint64) { entries[i].RunLength = uint32(value) }),
+		readColumn(func(i int, value uint64) { entries[i].Length = uint32(value) }),
+		readColumn(func(i int, value uint64) {
+			if value == 0 && i > 0 {
+				entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+			} else {
+				entries[i].Offset = value - 1
+			}
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// findEntry returns the entry containing the tile id, or the leaf directory entry which may contain it
+// findEntry 返回包含该瓦片 ID 的目录项，或可能包含它的叶子目录项
+func findEntry(entries []Entry, tileID uint64) (Entry, bool) {
+	// last entry with TileID <= tileID
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].TileID > tileID }) - 1
+	if i < 0 {
+		return Entry{}, false
+	}
+	entry := entries[i]
+	if entry.RunLength == 0 || tileID < entry.TileID+uint64(entry.RunLength) {
+		return entry, true
+	}
+	return Entry{}, false
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte, compression Compression) ([]byte, error) {
+	switch compression {
+	case CompressionNone, CompressionUnknown:
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("pmtiles: compression %d is not supported", compression)
+	}
+}
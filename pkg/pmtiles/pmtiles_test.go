@@ -0,0 +1,223 @@
+package pmtiles
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestZxyToID(t *testing.T) {
+	for _, tc := range []struct {
+		z    uint8
+		x, y uint32
+		id   uint64
+	}{
+		{0, 0, 0, 0},
+		{1, 0, 0, 1},
+		{1, 0, 1, 2},
+		{1, 1, 1, 3},
+		{1, 1, 0, 4},
+		{2, 0, 0, 5},
+		{2, 1, 0, 6},
+		{2, 3, 0, 20},
+		// ids of zoom 12 start after (4^12 - 1) / 3 tiles
+		{12, 0, 0, 5592405},
+	} {
+		if id := ZxyToID(tc.z, tc.x, tc.y); id != tc.id {
+			t.Errorf("ZxyToID(%d, %d, %d) = %d, expected %d", tc.z, tc.x, tc.y, id, tc.id)
+		}
+	}
+
+	// every tile of a zoom has a distinct id in the zoom id range
+	seen := make(map[uint64]bool)
+	for x := range uint32(8) {
+		for y := range uint32(8) {
+			id := ZxyToID(3, x, y)
+			if id < 21 || id >= 85 || seen[id] {
+				t.Fatalf("invalid id %d of 3/%d/%d", id, x, y)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	header := Header{
+		RootOffset: 127, RootLength: 30, TileDataOffset: 1000, TileDataLength: 42,
+		AddressedTiles: 5, TileEntries: 3, TileContents: 2,
+		Clustered: true, InternalCompression: CompressionGzip, TileCompression: CompressionNone, TileType: TileTypePNG,
+		MinZoom: 1, MaxZoom: 14, Bounds: [4]float64{113.25, -22.5, 114, 23.5}, CenterZoom: 1, CenterLon: 113.625, CenterLat: 0.5,
+	}
+	data, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed Header
+	if err := parsed.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if parsed != header {
+		t.Errorf("header changed:\n%+v\n%+v", header, parsed)
+	}
+}
+
+type testTile struct {
+	z, x, y int
+	data    string
+}
+
+func writeArchive(t *testing.T, tiles []testTile) string {
+	t.Helper()
+	sort.Slice(tiles, func(i, j int) bool {
+		return ZxyToID(uint8(tiles[i].z), uint32(tiles[i].x), uint32(tiles[i].y)) < ZxyToID(uint8(tiles[j].z), uint32(tiles[j].x), uint32(tiles[j].y))
+	})
+
+	path := filepath.Join(t.TempDir(), "test.pmtiles")
+	writer, err := NewWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tile := range tiles {
+		if err := writer.WriteTile(tile.z, tile.x, tile.y, []byte(tile.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Finalize(Header{TileType: TileTypePNG, MaxZoom: 12}, map[string]any{"name": "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWriterReader(t *testing.T) {
+	tiles := []testTile{
+		{0, 0, 0, "world"},
+		// ids 1, 2, 3 share the same content and become one run
+		{1, 0, 0, "sea"},
+		{1, 0, 1, "sea"},
+		{1, 1, 1, "sea"},
+		{1, 1, 0, "land"},
+		// same content, not consecutive
+		{2, 3, 0, "sea"},
+	}
+	path := writeArchive(t, tiles)
+
+	reader, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	header := reader.Header()
+	if header.AddressedTiles != 6 || header.TileEntries != 4 || header.TileContents != 3 || !header.Clustered {
+		t.Errorf("unexpected header counts: %+v", header)
+	}
+	if header.LeafLength != 0 {
+		t.Errorf("expected no leaf directories, got %d bytes", header.LeafLength)
+	}
+	if reader.Metadata()["name"] != "Test" {
+		t.Errorf("unexpected metadata: %v", reader.Metadata())
+	}
+
+	for _, tile := range tiles {
+		data, err := reader.Tile(tile.z, tile.x, tile.y)
+		if err != nil || string(data) != tile.data {
+			t.Errorf("tile %d/%d/%d: expected %q, got %q %v", tile.z, tile.x, tile.y, tile.data, data, err)
+		}
+	}
+	for _, zxy := range [][3]int{{2, 0, 0}, {3, 1, 1}, {1, 2, 0}} {
+		if _, err := reader.Tile(zxy[0], zxy[1], zxy[2]); !errors.Is(err, ErrTileNotFound) {
+			t.Errorf("tile %v: expected not found, got %v", zxy, err)
+		}
+	}
+}
+
+func TestWriterLeafDirectories(t *testing.T) {
+	defer func(length int) { maxRootDirectoryLength = length }(maxRootDirectoryLength)
+	maxRootDirectoryLength = 128
+
+	var tiles []testTile
+	for x := range 128 {
+		for y := range 128 {
+			if (x+y)%3 != 0 {
+				tiles = append(tiles, testTile{7, x, y, fmt.Sprintf("7/%d/%d", x, y)})
+			}
+		}
+	}
+	path := writeArchive(t, tiles)
+
+	reader, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if reader.Header().LeafLength == 0 {
+		t.Fatal("expected leaf directories")
+	}
+	for _, tile := range tiles {
+		data, err := reader.Tile(tile.z, tile.x, tile.y)
+		if err != nil || string(data) != tile.data {
+			t.Fatalf("tile %d/%d/%d: expected %q, got %q %v", tile.z, tile.x, tile.y, tile.data, data, err)
+		}
+	}
+	if _, err := reader.Tile(7, 0, 0); !errors.Is(err, ErrTileNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestReaderLeafCacheLRU(t *testing.T) {
+	defer func(length int) { maxRootDirectoryLength = length }(maxRootDirectoryLength)
+	maxRootDirectoryLength = 128
+	defer func(size int) { leafCacheSize = size }(leafCacheSize)
+	leafCacheSize = 2
+
+	var tiles []testTile
+	for x := range 128 {
+		for y := range 128 {
+			tiles = append(tiles, testTile{7, x, y, fmt.Sprintf("7/%d/%d", x, y)})
+		}
+	}
+	reader, err := Open(writeArchive(t, tiles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var leaves []Entry
+	for _, entry := range reader.root {
+		if entry.RunLength == 0 {
+			leaves = append(leaves, entry)
+		}
+	}
+	if len(leaves) < 3 {
+		t.Fatalf("expected at least 3 leaf directories, got %d", len(leaves))
+	}
+	// reading leaf 0 again makes leaf 1 the least recently used one, which leaf 2 evicts
+	for _, i := range []int{0, 1, 0, 2} {
+		if _, err := reader.leaf(leaves[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, cached := range []bool{true, false, true} {
+		if _, ok := reader.leaves[reader.header.LeafOffset+leaves[i].Offset]; ok != cached {
+			t.Errorf("leaf %d: expected cached %v", i, cached)
+		}
+	}
+}
+
+func TestWriterOrder(t *testing.T) {
+	writer, err := NewWriter(filepath.Join(t.TempDir(), "test.pmtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Abort()
+
+	if err := writer.WriteTile(1, 1, 0, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteTile(1, 0, 0, []byte("b")); err == nil {
+		t.Error("expected error for tile out of order")
+	}
+}
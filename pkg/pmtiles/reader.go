@@ -0,0 +1,204 @@
+package pmtiles
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrTileNotFound is returned by Reader.Tile when the archive has no such tile
+var ErrTileNotFound = errors.New("pmtiles: tile not found")
+
+// ErrClosed is returned by Reader.Tile after the reader is closed
+var ErrClosed = errors.New("pmtiles: reader is closed")
+
+// max depth of leaf directories, the spec allows root -> leaf -> leaf ...
+const maxDirectoryDepth = 4
+
+// number of leaf directories kept in memory, a variable for tests
+var leafCacheSize = 64
+
+// Reader reads tiles from a PMTiles archive with range reads, it is safe for concurrent use.
+// The root directory is kept in memory, recently used leaf directories are cached.
+// Reader 通过范围读取从 PMTiles 归档读取瓦片，可并发使用；根目录常驻内存，最近使用的叶子目录会被缓存
+type Reader struct {
+	source  io.ReaderAt
+	closer  io.Closer
+	header  Header
+	root    []Entry
+	meta    map[string]any
+	leafMu  sync.Mutex
+	leaves  map[uint64]*list.Element
+	leafLRU *list.List // front is the most recently used
+	closed  atomic.Bool
+}
+
+// cached leaf directory
+type leafDirectory struct {
+	offset  uint64
+	entries []Entry
+}
+
+// Open opens the local archive
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read pmtiles %s error: %w", path, err)
+	}
+	reader.closer = file
+	return reader, nil
+}
+
+// NewReader reads the header, root directory and metadata of the archive
+func NewReader(source io.ReaderAt) (*Reader, error) {
+	reader := &Reader{source: source, leaves: make(map[uint64]*list.Element), leafLRU: list.New()}
+
+	headerBytes, err := reader.readRange(0, HeaderLength)
+	if err != nil {
+		return nil, err
+	}
+	if err := reader.header.UnmarshalBinary(headerBytes); err != nil {
+		return nil, err
+	}
+
+	rootBytes, err := reader.readRange(reader.header.RootOffset, reader.header.RootLength)
+	if err != nil {
+		return nil, err
+	}
+	if reader.root, err = deserializeEntries(rootBytes, reader.header.InternalCompression); err != nil {
+		return nil, err
+	}
+
+	reader.meta = make(map[string]any)
+	if reader.header.MetadataLength > 0 {
+		metadataBytes, err := reader.readRange(reader.header.MetadataOffset, reader.header.MetadataLength)
+		if err != nil {
+			return nil, err
+		}
+		if metadataBytes, err = decompress(metadataBytes, reader.header.InternalCompression); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metadataBytes, &reader.meta); err != nil {
+			return nil, fmt.Errorf("pmtiles: invalid metadata: %w", err)
+		}
+	}
+	return reader, nil
+}
+
+func (reader *Reader) readRange(offset, length uint64) ([]byte, error) {
+	if reader.closed.Load() {
+		return nil, ErrClosed
+	}
+	buf := make([]byte, length)
+	if _, err := reader.source.ReadAt(buf, int64(offset)); err != nil {
+		if reader.closed.Load() {
+			return nil, ErrClosed
+		}
+		return nil, fmt.Errorf("pmtiles: read %d bytes at %d error: %w", length, offset, err)
+	}
+	return buf, nil
+}
+
+// Header returns the archive header
+func (reader *Reader) Header() Header {
+	return reader.header
+}
+
+// Metadata returns the JSON metadata, it must not be modified
+func (reader *Reader) Metadata() map[string]any {
+	return reader.meta
+}
+
+// Tile returns the tile of XYZ coordinates, decompressed if the archive compresses tiles with gzip
+// Tile 返回 XYZ 坐标的瓦片，归档使用 gzip 压缩瓦片时会解压
+func (reader *Reader) Tile(z, x, y int) ([]byte, error) {
+	if z < 0 || z > 31 || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return nil, fmt.Errorf("%w: %d/%d/%d", ErrTileNotFound, z, x, y)
+	}
+	tileID := ZxyToID(uint8(z), uint32(x), uint32(y))
+
+	entries := reader.root
+	for range maxDirectoryDepth {
+		entry, ok := findEntry(entries, tileID)
+		if !ok {
+			return nil, fmt.Errorf("%w: %d/%d/%d", ErrTileNotFound, z, x, y)
+		}
+
+		if entry.RunLength > 0 {
+			data, err := reader.readRange(reader.header.TileDataOffset+entry.Offset, uint64(entry.Length))
+			if err != nil {
+				return nil, err
+			}
+			return decompress(data, reader.header.TileCompression)
+		}
+
+		var err error
+		if entries, err = reader.leaf(entry); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("pmtiles: leaf directories are nested too deep")
+}
+
+// leaf returns the leaf directory pointed by the entry
+func (reader *Reader) leaf(entry Entry) ([]Entry, error) {
+	offset := reader.header.LeafOffset + entry.Offset
+
+	if entries, ok := reader.cachedLeaf(offset); ok {
+		return entries, nil
+	}
+
+	data, err := reader.readRange(offset, uint64(entry.Length))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := deserializeEntries(data, reader.header.InternalCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	reader.leafMu.Lock()
+	defer reader.leafMu.Unlock()
+	if _, ok := reader.leaves[offset]; !ok {
+		if reader.leafLRU.Len() >= leafCacheSize {
+			back := reader.leafLRU.Back()
+			reader.leafLRU.Remove(back)
+			delete(reader.leaves, back.Value.(*leafDirectory).offset)
+		}
+		reader.leaves[offset] = reader.leafLRU.PushFront(&leafDirectory{offset: offset, entries: entries})
+	}
+	return entries, nil
+}
+
+// cachedLeaf returns the cached leaf directory at the offset and marks it as the most recently used
+func (reader *Reader) cachedLeaf(offset uint64) ([]Entry, bool) {
+	reader.leafMu.Lock()
+	defer reader.leafMu.Unlock()
+	element, ok := reader.leaves[offset]
+	if !ok {
+		return nil, false
+	}
+	reader.leafLRU.MoveToFront(element)
+	return element.Value.(*leafDirectory).entries, true
+}
+
+// Close closes the archive, Tile returns ErrClosed afterwards
+func (reader *Reader) Close() error {
+	if reader.closed.Swap(true) {
+		return nil
+	}
+	if reader.closer != nil {
+		return reader.closer.Close()
+	}
+	return nil
+}
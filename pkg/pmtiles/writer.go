@@ -0,0 +1,188 @@
+package pmtiles
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Writer builds a clustered PMTiles archive. Tiles must be written in ascending tile id order,
+// identical tiles are stored once and consecutive identical tiles share one run-length entry.
+// Tile data is buffered in a temporary file next to the output until Finalize.
+// Writer 构建聚簇的 PMTiles 归档：瓦片必须按瓦片 ID 升序写入，相同内容的瓦片只存储一次，
+// 连续相同的瓦片合并为一个游程目录项。瓦片数据在 Finalize 前暂存于输出文件旁的临时文件
+type Writer struct {
+	path     string
+	tileData *os.File
+	offset   uint64
+
+	entries  []Entry
+	contents map[[sha256.Size]byte]Entry
+	lastID   uint64
+	// number of tiles written
+	addressed uint64
+}
+
+// NewWriter creates the writer of the archive at path, an existing file is replaced on Finalize
+func NewWriter(path string) (*Writer, error) {
+	tileData, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		path:     path,
+		tileData: tileData,
+		contents: make(map[[sha256.Size]byte]Entry),
+	}, nil
+}
+
+// WriteTile adds the tile of XYZ coordinates
+func (writer *Writer) WriteTile(z, x, y int, data []byte) error {
+	tileID := ZxyToID(uint8(z), uint32(x), uint32(y))
+	if writer.addressed > 0 && tileID <= writer.lastID {
+		return fmt.Errorf("pmtiles: tile %d/%d/%d is not in ascending tile id order", z, x, y)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("pmtiles: tile %d/%d/%d is empty", z, x, y)
+	}
+	writer.lastID = tileID
+	writer.addressed++
+
+	hash := sha256.Sum256(data)
+	if content, ok := writer.contents[hash]; ok {
+		// extend the run of the previous entry if it is the same tile and directly precedes this one
+		// 如果上一个目录项是相同内容且紧邻当前瓦片，则延长其游程
+		last := &writer.entries[len(writer.entries)-1]
+		if last.Offset == content.Offset && last.TileID+uint64(last.RunLength) == tileID {
+			last.RunLength++
+			return nil
+		}
+		writer.entries = append(writer.entries, Entry{TileID: tileID, Offset: content.Offset, Length: content.Length, RunLength: 1})
+		return nil
+	}
+
+	if _, err := writer.tileData.Write(data); err != nil {
+		return err
+	}
+	entry := Entry{TileID: tileID, Offset: writer.offset, Length: uint32(len(data)), RunLength: 1}
+	writer.offset += uint64(len(data))
+	writer.contents[hash] = entry
+	writer.entries = append(writer.entries, entry)
+	return nil
+}
+
+// Finalize writes the archive with the header fields and JSON metadata, the temporary data is removed
+// Finalize 使用头部字段和 JSON 元数据写出归档，并删除临时数据
+func (writer *Writer) Finalize(header Header, metadata map[string]any) (err error) {
+	defer writer.Abort()
+	if writer.addressed == 0 {
+		return errors.New("pmtiles: no tile written")
+	}
+
+	rootDir, leafDirs, err := buildDirectories(writer.entries)
+	if err != nil {
+		return err
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	metadataBytes, err := compress(metadataJSON)
+	if err != nil {
+		return err
+	}
+
+	header.Clustered = true
+	header.InternalCompression = CompressionGzip
+	if header.TileCompression == CompressionUnknown {
+		header.TileCompression = CompressionNone
+	}
+	header.RootOffset = HeaderLength
+	header.RootLength = uint64(len(rootDir))
+	header.MetadataOffset = header.RootOffset + header.RootLength
+	header.MetadataLength = uint64(len(metadataBytes))
+	header.LeafOffset = header.MetadataOffset + header.MetadataLength
+	header.LeafLength = uint64(len(leafDirs))
+	header.TileDataOffset = header.LeafOffset + header.LeafLength
+	header.TileDataLength = writer.offset
+	header.AddressedTiles = writer.addressed
+	header.TileEntries = uint64(len(writer.entries))
+	header.TileContents = uint64(len(writer.contents))
+	headerBytes, err := header.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	output, err := os.Create(writer.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, output.Close())
+		if err != nil {
+			os.Remove(writer.path)
+		}
+	}()
+
+	for _, section := range [][]byte{headerBytes, rootDir, metadataBytes, leafDirs} {
+		if _, err := output.Write(section); err != nil {
+			return err
+		}
+	}
+	if _, err := writer.tileData.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(output, writer.tileData)
+	return err
+}
+
+// Abort removes the temporary tile data, the archive is not written
+func (writer *Writer) Abort() {
+	writer.tileData.Close()
+	os.Remove(writer.tileData.Name())
+}
+
+// max length of the root directory, the header and root directory must fit in the first 16 KiB
+var maxRootDirectoryLength = rootSectionLength - HeaderLength
+
+// buildDirectories returns the root directory, split into leaf directories if it does not fit
+// in the first 16 KiB. The leaf size grows until the root directory of leaf pointers fits.
+// buildDirectories 返回根目录；若无法放入前 16 KiB，则拆分为叶子目录，叶子大小逐步增大直到根目录能放下
+func buildDirectories(entries []Entry) (rootDir, leafDirs []byte, err error) {
+	rootDir, err = serializeEntries(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rootDir) <= maxRootDirectoryLength {
+		return rootDir, nil, nil
+	}
+
+	for leafSize := 4096; ; leafSize *= 2 {
+		var rootEntries []Entry
+		leafDirs = leafDirs[:0]
+		for start := 0; start < len(entries); start += leafSize {
+			leaf, err := serializeEntries(entries[start:min(start+leafSize, len(entries))])
+			if err != nil {
+				return nil, nil, err
+			}
+			rootEntries = append(rootEntries, Entry{
+				TileID: entries[start].TileID,
+				Offset: uint64(len(leafDirs)),
+				Length: uint32(len(leaf)),
+			})
+			leafDirs = append(leafDirs, leaf...)
+		}
+
+		rootDir, err = serializeEntries(rootEntries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rootDir) <= maxRootDirectoryLength {
+			return rootDir, leafDirs, nil
+		}
+	}
+}
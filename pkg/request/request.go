@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,15 +26,21 @@ type HTTPClientConfig struct {
 }
 
 var (
-	DefaultHTTPClient *http.Client = NewHTTPClient(&HTTPClientConfig{
-		Timeout:      10 * time.Second,
-		Proxy:        "",
-		FollowDirect: true,
-	})
+	// default http client shared by map providers, it can be swapped on config reload
+	// 地图源共享的默认 HTTP 客户端，配置重载时可原子替换
+	defaultHTTPClient atomic.Pointer[http.Client]
 
 	InitDefaultHTTPClientOnce sync.Once
 )
 
+func init() {
+	defaultHTTPClient.Store(NewHTTPClient(&HTTPClientConfig{
+		Timeout:      10 * time.Second,
+		Proxy:        "",
+		FollowDirect: true,
+	}))
+}
+
 var DefaultUserAgent = "Mozilla/5.0 (Linux; Android 10; Pixel 3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/88.0.4324.181 Mobile Safari/537.36"
 
 func NewHTTPClient(config *HTTPClientConfig) *http.Client {
@@ -110,10 +117,25 @@ func NewHTTPClient(config *HTTPClientConfig) *http.Client {
 
 func InitDefaultHTTPClient(cfg *HTTPClientConfig) *http.Client {
 	InitDefaultHTTPClientOnce.Do(func() {
-		DefaultHTTPClient = NewHTTPClient(cfg)
+		defaultHTTPClient.Store(NewHTTPClient(cfg))
 	})
 
-	return DefaultHTTPClient
+	return GetDefaultHTTPClient()
+}
+
+// GetDefaultHTTPClient returns the current default http client
+func GetDefaultHTTPClient() *http.Client {
+	return defaultHTTPClient.Load()
+}
+
+// SetDefaultHTTPClient atomically replaces the default http client and closes the idle connections of the old one.
+// Requests already using the old client are not affected.
+// SetDefaultHTTPClient 原子替换默认 HTTP 客户端并关闭旧客户端的空闲连接，正在使用旧客户端的请求不受影响
+func SetDefaultHTTPClient(client *http.Client) {
+	old := defaultHTTPClient.Swap(client)
+	if old != nil && old != client {
+		old.CloseIdleConnections()
+	}
 }
 
 func NewHTTPRequest(method, url string, body io.Reader, useragent ...string) (*http.Request, error) {